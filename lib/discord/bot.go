@@ -44,6 +44,7 @@ type InteractionReply struct {
 	Content   string
 	Ephemeral bool
 	Embeds    []*discordgo.MessageEmbed
+	Files     []*discordgo.File
 }
 
 // logger is a global instance of *zap.Logger used to log application events and errors. Initialized via logging.Get().
@@ -128,6 +129,7 @@ func (b *Bot) FollowupInteractionMessageAndForget(i *discordgo.Interaction, repl
 		Flags:   flags,
 		Content: reply.Content,
 		Embeds:  reply.Embeds,
+		Files:   reply.Files,
 	})
 
 	if err != nil {
@@ -21,6 +21,30 @@ func (r *ResolvedCommandOption) String() string {
 	return ""
 }
 
+// Int returns the integer value of the option, or 0 if the option was not provided.
+// Discord sends numbers as JSON, so integer options are decoded as float64.
+func (r *ResolvedCommandOption) Int() int {
+	if number, ok := r.Value.(float64); ok {
+		return int(number)
+	}
+
+	return 0
+}
+
+// Bool returns the boolean value of the option, or false if the option was not provided.
+func (r *ResolvedCommandOption) Bool() bool {
+	if value, ok := r.Value.(bool); ok {
+		return value
+	}
+
+	return false
+}
+
+// IsSet reports whether the user provided the option.
+func (r *ResolvedCommandOption) IsSet() bool {
+	return r.Value != nil
+}
+
 // CommandInteractionOptions represents a collection of resolved command options for interaction handling.
 // It enables retrieval and storage of options within a specified command execution context.
 // The struct integrates a map for quick lookup and a slice for sequential retention of interaction options.
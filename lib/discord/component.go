@@ -13,10 +13,48 @@ type ComponentInteractionHandler interface {
 	ShouldHandle(interaction *discordgo.InteractionCreate) bool
 }
 
+// ModalOpener is implemented by component handlers, that respond to some interactions with a modal.
+// Such interactions are not deferred, since the modal must be the first response to them.
+type ModalOpener interface {
+	OpensModal(interaction *discordgo.InteractionCreate) bool
+}
+
 func HandleComponentInteraction(handlers []ComponentInteractionHandler, bot *Bot, interaction *discordgo.InteractionCreate) {
 	ctx, cancel := NewInteractionContext(context.Background())
 	defer cancel()
 
+	if !opensModal(handlers, interaction) {
+		bot.RespondToInteractionAndForget(interaction.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseDeferredMessageUpdate,
+		})
+	}
+
+	for _, handler := range handlers {
+		if handler.ShouldHandle(interaction) {
+			err := handler.Handle(ctx, interaction, bot)
+			if err != nil {
+				bot.ReportErrorInteraction(interaction, err)
+			}
+		}
+	}
+}
+
+func opensModal(handlers []ComponentInteractionHandler, interaction *discordgo.InteractionCreate) bool {
+	for _, handler := range handlers {
+		opener, ok := handler.(ModalOpener)
+		if ok && handler.ShouldHandle(interaction) && opener.OpensModal(interaction) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// HandleModalSubmitInteraction passes submitted modal to handlers. Their ShouldHandle should check ModalSubmitData, instead of MessageComponentData.
+func HandleModalSubmitInteraction(handlers []ComponentInteractionHandler, bot *Bot, interaction *discordgo.InteractionCreate) {
+	ctx, cancel := NewInteractionContext(context.Background())
+	defer cancel()
+
 	bot.RespondToInteractionAndForget(interaction.Interaction, &discordgo.InteractionResponse{
 		Type: discordgo.InteractionResponseDeferredMessageUpdate,
 	})
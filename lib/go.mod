@@ -1,6 +1,6 @@
 module lib
 
-go 1.24
+go 1.24.0
 
 require (
 	github.com/aws/aws-sdk-go-v2 v1.36.3
@@ -15,6 +15,7 @@ require (
 	github.com/ollama/ollama v0.6.8
 	github.com/openai/openai-go v0.1.0-beta.10
 	github.com/stretchr/testify v1.10.0
+	go.etcd.io/bbolt v1.4.0
 	go.uber.org/zap v1.27.0
 )
 
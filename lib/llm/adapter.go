@@ -9,3 +9,32 @@ type Adapter interface {
 	// Chat sends a request with chat to the LLM
 	Chat(ctx context.Context, chat *Chat) (*ChatMessage, *ChatReplyMetadata, error)
 }
+
+// ChatStreamFn is called with every new part of the reply while it is being generated
+type ChatStreamFn func(delta string) error
+
+// StreamingAdapter is an Adapter that is able to stream chat replies while they are generated
+type StreamingAdapter interface {
+	Adapter
+
+	// ChatStream works like Chat, but calls onDelta with every new part of the reply as soon as it arrives
+	ChatStream(ctx context.Context, chat *Chat, onDelta ChatStreamFn) (*ChatMessage, *ChatReplyMetadata, error)
+}
+
+// EmbeddingAdapter is an Adapter that is able to turn texts into embedding vectors
+type EmbeddingAdapter interface {
+	Adapter
+
+	// Embed returns embedding vector of every input, in the same order
+	Embed(ctx context.Context, inputs []string) ([][]float32, error)
+}
+
+// HistoryAdapter is an Adapter that keeps history of chats on the server, e.g. in assistant threads
+type HistoryAdapter interface {
+	Adapter
+
+	// DeleteMessage removes the message from the history kept on the server
+	DeleteMessage(ctx context.Context, chat *Chat, messageID string) error
+	// ResetHistory drops the history kept on the server, so that it is created again from the chat with the next request
+	ResetHistory(ctx context.Context, chat *Chat) error
+}
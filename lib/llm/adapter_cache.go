@@ -0,0 +1,212 @@
+package llm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/goccy/go-json"
+	"go.uber.org/zap"
+	"lib/storage"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+const cacheBucketName = "llm_prompt_cache"
+
+// CacheOptions configures CachingAdapter
+type CacheOptions struct {
+	// Model identifies the wrapped model, so that replies of different models are not mixed
+	Model string
+	// TTL after which cached reply is no longer used
+	TTL time.Duration
+	// MaxEntries limits the size of the store, the oldest entries are evicted first
+	MaxEntries int
+}
+
+// CacheStats contains counters of the cache usage since start
+type CacheStats struct {
+	Hits   int64
+	Misses int64
+}
+
+type cacheEntry struct {
+	Reply     string               `json:"reply"`
+	Metadata  *PromptReplyMetadata `json:"metadata"`
+	CreatedAt time.Time            `json:"created_at"`
+}
+
+// CachingAdapter caches Prompt replies of the wrapped adapter on disk. Chats, and replies that fail validation of PromptJSON, are never cached.
+// It should be used only for prompts, where the same input always deserves the same reply, e.g. classification prompts.
+type CachingAdapter struct {
+	adapter Adapter
+	store   *storage.Bucket[cacheEntry]
+	options CacheOptions
+	hits    atomic.Int64
+	misses  atomic.Int64
+	log     *zap.Logger
+}
+
+func NewCachingAdapter(adapter Adapter, db *storage.DB, options CacheOptions) (*CachingAdapter, error) {
+	store, err := storage.NewBucket[cacheEntry](db, cacheBucketName)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CachingAdapter{
+		adapter: adapter,
+		store:   store,
+		options: options,
+		log:     logger.Named("cache").With(zap.String("model", options.Model)),
+	}, nil
+}
+
+func (c *CachingAdapter) Prompt(ctx context.Context, p Prompt) (string, *PromptReplyMetadata, error) {
+	key := c.key(ctx, p)
+
+	entry, err := c.store.Get(key)
+	if err != nil {
+		c.log.Error("failed to read cache entry", zap.Error(err))
+	}
+
+	if entry != nil && time.Since(entry.CreatedAt) < c.options.TTL {
+		c.hits.Add(1)
+		c.log.Debug("cache hit", zap.String("key", key), zap.Any("stats", c.Stats()))
+
+		// Cached reply did not use any tokens
+		metadata := entry.Metadata
+		if metadata == nil {
+			metadata = &PromptReplyMetadata{}
+		}
+		metadata.Usage = &TokenUsage{}
+
+		return entry.Reply, metadata, nil
+	}
+
+	c.misses.Add(1)
+	c.log.Debug("cache miss", zap.String("key", key), zap.Any("stats", c.Stats()))
+
+	reply, metadata, err := c.adapter.Prompt(ctx, p)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// Structured replies are cached only when they are valid, otherwise they would fail validation on every hit
+	err = validateReply(ctx, reply)
+	if err != nil {
+		c.log.Debug("invalid reply is not cached", zap.String("key", key), zap.Error(err))
+		return reply, metadata, nil
+	}
+
+	err = c.store.Put(key, cacheEntry{
+		Reply:     reply,
+		Metadata:  metadata,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		c.log.Error("failed to write cache entry", zap.Error(err))
+	} else {
+		c.evict()
+	}
+
+	return reply, metadata, nil
+}
+
+func (c *CachingAdapter) Chat(ctx context.Context, chat *Chat) (*ChatMessage, *ChatReplyMetadata, error) {
+	return c.adapter.Chat(ctx, chat)
+}
+
+// Embed is passed through to the wrapped adapter, embeddings are stored by the memory store anyway
+func (c *CachingAdapter) Embed(ctx context.Context, inputs []string) ([][]float32, error) {
+	embeddingAdapter, ok := c.adapter.(EmbeddingAdapter)
+	if !ok {
+		return nil, ErrEmbeddingsNotSupported
+	}
+
+	return embeddingAdapter.Embed(ctx, inputs)
+}
+
+// Stats returns hit and miss counters
+func (c *CachingAdapter) Stats() CacheStats {
+	return CacheStats{
+		Hits:   c.hits.Load(),
+		Misses: c.misses.Load(),
+	}
+}
+
+// key is a hash of everything that affects the reply, including the model and temperature of the route
+func (c *CachingAdapter) key(ctx context.Context, p Prompt) string {
+	options := modelOptionsFrom(ctx)
+	options.Model = options.model(c.options.Model)
+
+	return promptDigest(options.String(), p)
+}
+
+// promptDigest returns a hash of the model and everything in the prompt that affects the reply
+func promptDigest(model string, p Prompt) string {
+	hash := sha256.New()
+	for _, part := range []string{model, p.Traits, p.Phrase} {
+		hash.Write([]byte(part))
+		hash.Write([]byte{0})
+	}
+
+	if p.Schema != nil {
+		schemaJSON, _ := json.Marshal(p.Schema.Definition)
+		hash.Write(schemaJSON)
+	}
+
+	for _, file := range p.Files {
+		fileDigest := sha256.Sum256(file.Data)
+		hash.Write(fileDigest[:])
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// evict removes expired entries, and the oldest ones, once the store grows over MaxEntries
+func (c *CachingAdapter) evict() {
+	if c.options.MaxEntries <= 0 {
+		return
+	}
+
+	count, err := c.store.Count()
+	if err != nil || count <= c.options.MaxEntries {
+		return
+	}
+
+	type keyWithTime struct {
+		key       string
+		createdAt time.Time
+	}
+
+	var entries []keyWithTime
+	err = c.store.ForEach(func(key string, entry cacheEntry) error {
+		entries = append(entries, keyWithTime{key: key, createdAt: entry.CreatedAt})
+		return nil
+	})
+	if err != nil {
+		c.log.Error("failed to list cache entries", zap.Error(err))
+		return
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].createdAt.Before(entries[j].createdAt)
+	})
+
+	// Evict more than needed, so that the store is not scanned on every write
+	keep := c.options.MaxEntries * 9 / 10
+	var keysToDelete []string
+	for i, entry := range entries {
+		if i < len(entries)-keep || time.Since(entry.createdAt) >= c.options.TTL {
+			keysToDelete = append(keysToDelete, entry.key)
+		}
+	}
+
+	err = c.store.Delete(keysToDelete...)
+	if err != nil {
+		c.log.Error("failed to evict cache entries", zap.Error(err))
+		return
+	}
+
+	c.log.Info("evicted cache entries", zap.Int("count", len(keysToDelete)))
+}
@@ -0,0 +1,71 @@
+package llm_test
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"lib/llm"
+	"lib/storage"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCachingAdapter(t *testing.T) {
+	db, err := storage.Open(filepath.Join(t.TempDir(), "test.db"))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	ctx := context.Background()
+
+	t.Run("serves repeated prompts from the cache", func(t *testing.T) {
+		scripted := llm.NewScriptedAdapter(llm.ScriptedRule{Reply: "yes"})
+		adapter, err := llm.NewCachingAdapter(scripted, db, llm.CacheOptions{Model: "hits", TTL: time.Hour})
+		assert.NoError(t, err)
+
+		for range 2 {
+			reply, metadata, err := adapter.Prompt(ctx, llm.Prompt{Phrase: "is it cached?"})
+			assert.NoError(t, err)
+			assert.Equal(t, "yes", reply)
+			assert.NotNil(t, metadata)
+		}
+
+		_, _, err = adapter.Prompt(ctx, llm.Prompt{Phrase: "is it another prompt?"})
+		assert.NoError(t, err)
+
+		assert.Len(t, scripted.Requests(), 2)
+		assert.Equal(t, llm.CacheStats{Hits: 1, Misses: 2}, adapter.Stats())
+	})
+
+	t.Run("doesn't serve expired replies", func(t *testing.T) {
+		scripted := llm.NewScriptedAdapter(llm.ScriptedRule{Reply: "yes"})
+		adapter, err := llm.NewCachingAdapter(scripted, db, llm.CacheOptions{Model: "ttl", TTL: time.Nanosecond})
+		assert.NoError(t, err)
+
+		for range 2 {
+			_, _, err := adapter.Prompt(ctx, llm.Prompt{Phrase: "is it cached?"})
+			assert.NoError(t, err)
+		}
+
+		assert.Len(t, scripted.Requests(), 2)
+		assert.Equal(t, llm.CacheStats{Misses: 2}, adapter.Stats())
+	})
+
+	t.Run("caches only valid structured replies", func(t *testing.T) {
+		scripted := llm.NewScriptedAdapter(
+			llm.ScriptedRule{Reply: `{"answer": "yes", "score": 11}`, Times: 1},
+			llm.ScriptedRule{Reply: `{"answer": "yes", "score": 5}`},
+		)
+		adapter, err := llm.NewCachingAdapter(scripted, db, llm.CacheOptions{Model: "structured", TTL: time.Hour})
+		assert.NoError(t, err)
+		api := llm.NewAPI(adapter, "cached")
+
+		for range 2 {
+			reply, err := llm.PromptJSON[structuredReply](ctx, api, llm.Prompt{Phrase: "is it structured?"})
+			assert.NoError(t, err)
+			assert.Equal(t, &structuredReply{Answer: "yes", Score: 5}, reply)
+		}
+
+		// Invalid reply was not cached, so the second PromptJSON sends the original prompt again, and hits the valid reply of the retry
+		assert.Len(t, scripted.Requests(), 3)
+	})
+}
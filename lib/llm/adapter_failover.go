@@ -0,0 +1,286 @@
+package llm
+
+import (
+	"context"
+	goerrors "errors"
+	"go.uber.org/zap"
+	"lib/errors"
+	"sync/atomic"
+	"time"
+)
+
+const defaultFailureThreshold = 3
+const defaultBreakerCooldown = 5 * time.Minute
+
+var ErrAllBackendsFailed = goerrors.New("all llm backends failed")
+
+// FailoverBackend is a single backend used by FailoverAdapter
+type FailoverBackend struct {
+	// Name of the backend, reported in reply metadata
+	Name    string
+	Adapter Adapter
+	// Timeout for a single request, after which next backend is used. Zero means no timeout.
+	Timeout time.Duration
+	// HasReplyMetadata should be true for adapters that return ChatReplyMetadata on their own (e.g. assistant with JSON schema).
+	// For other adapters, metadata is resolved with an additional prompt.
+	HasReplyMetadata bool
+	// Scheduler optionally limits requests of the backend, e.g. when it is shared with another API. Nil means no limit.
+	Scheduler *Scheduler
+}
+
+type failoverBackend struct {
+	FailoverBackend
+	breaker *circuitBreaker
+}
+
+// acquire waits for a free slot in the scheduler of the backend, if it has one
+func (b *failoverBackend) acquire(ctx context.Context) (func(), error) {
+	if b.Scheduler == nil {
+		return func() {}, nil
+	}
+
+	return b.Scheduler.Acquire(ctx)
+}
+
+// FailoverAdapter sends requests to the first available backend, and fails over to the next ones on errors and timeouts.
+// Backends that fail repeatedly are skipped until their circuit breaker cools down.
+type FailoverAdapter struct {
+	backends []*failoverBackend
+	// usageTracker records usage of prompts, that resolve reply metadata
+	usageTracker *UsageTracker
+	log          *zap.Logger
+}
+
+func NewFailoverAdapter(backends ...FailoverBackend) *FailoverAdapter {
+	adapter := &FailoverAdapter{
+		log: logger.Named("failover"),
+	}
+
+	for _, backend := range backends {
+		adapter.backends = append(adapter.backends, &failoverBackend{
+			FailoverBackend: backend,
+			breaker:         newCircuitBreaker(defaultFailureThreshold, defaultBreakerCooldown),
+		})
+	}
+
+	return adapter
+}
+
+// WithUsageTracker records usage of additional prompts, that are sent to resolve reply metadata.
+// Usage of the chats themselves is recorded by the API.
+func (f *FailoverAdapter) WithUsageTracker(tracker *UsageTracker) {
+	f.usageTracker = tracker
+}
+
+// WithCircuitBreaker configures after how many consecutive failures backend is skipped, and for how long
+func (f *FailoverAdapter) WithCircuitBreaker(failureThreshold int, cooldown time.Duration) {
+	for _, backend := range f.backends {
+		backend.breaker = newCircuitBreaker(failureThreshold, cooldown)
+	}
+}
+
+func (f *FailoverAdapter) Prompt(ctx context.Context, p Prompt) (string, *PromptReplyMetadata, error) {
+	var reply string
+	var metadata *PromptReplyMetadata
+
+	backend, err := f.run(ctx, func(ctx context.Context, backend *failoverBackend) (bool, error) {
+		var err error
+		reply, metadata, err = backend.Adapter.Prompt(ctx, p)
+		return false, err
+	})
+	if err != nil {
+		return "", nil, err
+	}
+
+	if metadata == nil {
+		metadata = &PromptReplyMetadata{}
+	}
+	metadata.Backend = backend.Name
+
+	return reply, metadata, nil
+}
+
+func (f *FailoverAdapter) Chat(ctx context.Context, chat *Chat) (*ChatMessage, *ChatReplyMetadata, error) {
+	return f.ChatStream(ctx, chat, nil)
+}
+
+func (f *FailoverAdapter) ChatStream(ctx context.Context, chat *Chat, onDelta ChatStreamFn) (*ChatMessage, *ChatReplyMetadata, error) {
+	var reply *ChatMessage
+	var metadata *ChatReplyMetadata
+
+	backend, err := f.run(ctx, func(ctx context.Context, backend *failoverBackend) (bool, error) {
+		var err error
+
+		// Once a tool was called, or part of the reply was streamed, the next backend cannot take over,
+		// since it would replay side effects of tools, or duplicate the reply
+		var committed atomic.Bool
+		ctx = withToolCallObserver(ctx, func(string) {
+			committed.Store(true)
+		})
+
+		streamingAdapter, isStreaming := backend.Adapter.(StreamingAdapter)
+		if onDelta == nil || !isStreaming {
+			reply, metadata, err = backend.Adapter.Chat(ctx, chat)
+			if err == nil && onDelta != nil {
+				err = onDelta(reply.Contents)
+			}
+
+			return committed.Load(), err
+		}
+
+		reply, metadata, err = streamingAdapter.ChatStream(ctx, chat, func(delta string) error {
+			committed.Store(true)
+			return onDelta(delta)
+		})
+
+		return committed.Load(), err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if metadata == nil || !backend.HasReplyMetadata {
+		metadata = f.resolveReplyMetadata(ctx, backend, chat, metadata)
+	}
+	metadata.Backend = backend.Name
+
+	return reply, metadata, nil
+}
+
+// run calls fn with backends in order, until one of them succeeds. fn returns true if its failure cannot be recovered by the next backend.
+func (f *FailoverAdapter) run(ctx context.Context, fn func(ctx context.Context, backend *failoverBackend) (bool, error)) (*failoverBackend, error) {
+	var backendErrors []error
+
+	for _, backend := range f.backends {
+		log := f.log.With(zap.String("backend", backend.Name))
+
+		if !backend.breaker.Allow() {
+			log.Info("circuit breaker is open, skipping backend")
+			continue
+		}
+
+		backendCtx, cancel := f.backendContext(ctx, backend)
+		release, err := backend.acquire(backendCtx)
+		if err != nil {
+			cancel()
+
+			// Busy backend is not broken, so its circuit breaker is left as it is
+			log.Warn("no free slot, skipping backend", zap.Error(err))
+			backendErrors = append(backendErrors, errors.Wrap(err, backend.Name))
+			continue
+		}
+
+		unrecoverable, err := fn(backendCtx, backend)
+		release()
+		cancel()
+
+		if err == nil {
+			backend.breaker.Success()
+			return backend, nil
+		}
+
+		if isRequestError(err) {
+			// Request itself is the problem, other backends would fail the same way
+			return nil, err
+		}
+
+		if ctx.Err() != nil {
+			// Caller is no longer waiting for the reply, which doesn't mean that the backend is broken
+			return nil, ctx.Err()
+		}
+
+		log.Error("backend failed", zap.Error(err))
+		backendErrors = append(backendErrors, errors.Wrap(err, backend.Name))
+
+		if backend.breaker.Failure() {
+			log.Warn("backend failed too many times, opening circuit breaker")
+		}
+
+		if unrecoverable {
+			return nil, err
+		}
+	}
+
+	return nil, errors.Wrap(goerrors.Join(backendErrors...), ErrAllBackendsFailed.Error())
+}
+
+// DeleteMessage is passed to all backends that keep history on the server
+func (f *FailoverAdapter) DeleteMessage(ctx context.Context, chat *Chat, messageID string) error {
+	return f.forEachHistoryBackend(func(backend HistoryAdapter) error {
+		return backend.DeleteMessage(ctx, chat, messageID)
+	})
+}
+
+// ResetHistory is passed to all backends that keep history on the server
+func (f *FailoverAdapter) ResetHistory(ctx context.Context, chat *Chat) error {
+	return f.forEachHistoryBackend(func(backend HistoryAdapter) error {
+		return backend.ResetHistory(ctx, chat)
+	})
+}
+
+func (f *FailoverAdapter) forEachHistoryBackend(fn func(backend HistoryAdapter) error) error {
+	var backendErrors []error
+	for _, backend := range f.backends {
+		historyAdapter, ok := backend.Adapter.(HistoryAdapter)
+		if !ok {
+			continue
+		}
+
+		err := fn(historyAdapter)
+		if err != nil {
+			backendErrors = append(backendErrors, errors.Wrap(err, backend.Name))
+		}
+	}
+
+	return goerrors.Join(backendErrors...)
+}
+
+func (f *FailoverAdapter) backendContext(ctx context.Context, backend *failoverBackend) (context.Context, context.CancelFunc) {
+	if backend.Timeout > 0 {
+		return context.WithTimeout(ctx, backend.Timeout)
+	}
+
+	return context.WithCancel(ctx)
+}
+
+// resolveReplyMetadata resolves metadata for backends that do not return it, so that e.g. IsGoodbye keeps working.
+// What the backend did return in its metadata is kept.
+func (f *FailoverAdapter) resolveReplyMetadata(ctx context.Context, backend *failoverBackend, chat *Chat, replyMetadata *ChatReplyMetadata) *ChatReplyMetadata {
+	metadata := &ChatReplyMetadata{}
+	if replyMetadata != nil {
+		metadata.IsWorthRemembering = replyMetadata.IsWorthRemembering
+		metadata.Usage = replyMetadata.Usage
+	}
+
+	ctx = withUsageFeature(ctx, FeatureGoodbye)
+	backendCtx, cancel := f.backendContext(ctx, backend)
+	defer cancel()
+
+	release, err := backend.acquire(backendCtx)
+	if err != nil {
+		f.log.Error("failed to wait for a free slot to detect goodbye", zap.String("backend", backend.Name), zap.Error(err))
+		return metadata
+	}
+	defer release()
+
+	isGoodbye, usage, err := detectGoodbye(backendCtx, backend.Adapter, chat)
+	if f.usageTracker != nil && usage != nil {
+		f.usageTracker.Record(ctx, backend.Name, "", *usage)
+	}
+	if err != nil {
+		f.log.Error("failed to detect goodbye", zap.String("backend", backend.Name), zap.Error(err))
+		return metadata
+	}
+
+	metadata.IsGoodbye = isGoodbye
+
+	return metadata
+}
+
+// isRequestError returns true for errors caused by the request itself, not by the backend
+func isRequestError(err error) bool {
+	var tooLongError ErrPromptTooLong
+	var refusedError ErrRefusedToReply
+
+	return goerrors.As(err, &tooLongError) || goerrors.As(err, &refusedError)
+}
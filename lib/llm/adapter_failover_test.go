@@ -0,0 +1,149 @@
+package llm_test
+
+import (
+	"context"
+	"encoding/json"
+	goerrors "errors"
+	"github.com/stretchr/testify/assert"
+	"lib/llm"
+	"lib/storage"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// rememberingAdapter replies with metadata, that only tells the reply is worth remembering
+type rememberingAdapter struct {
+	llm.ScriptedAdapter
+}
+
+func (a *rememberingAdapter) Chat(ctx context.Context, chat *llm.Chat) (*llm.ChatMessage, *llm.ChatReplyMetadata, error) {
+	return llm.NewAssistantChatMessage("noted", ""), &llm.ChatReplyMetadata{IsWorthRemembering: true}, nil
+}
+
+func TestFailoverAdapter(t *testing.T) {
+	newChat := func(calls *int) *llm.Chat {
+		chat := llm.NewChat()
+		chat.AddMessages(llm.NewUserChatMessage("play something", "1", "Wojtek"))
+		chat.Tools = llm.NewToolRegistry(llm.Tool{
+			Name: "queue_song",
+			Handler: func(ctx context.Context, arguments json.RawMessage) (string, error) {
+				*calls++
+				return "queued", nil
+			},
+		})
+
+		return chat
+	}
+
+	t.Run("fails over to the next backend", func(t *testing.T) {
+		adapter := llm.NewFailoverAdapter(
+			llm.FailoverBackend{Name: "broken", Adapter: llm.NewScriptedAdapter(llm.ScriptedRule{Err: goerrors.New("connection reset")})},
+			llm.FailoverBackend{Name: "working", Adapter: llm.NewScriptedAdapter(llm.ScriptedRule{Reply: "hello"}), HasReplyMetadata: true},
+		)
+
+		calls := 0
+		reply, metadata, err := adapter.Chat(context.Background(), newChat(&calls))
+		assert.NoError(t, err)
+		assert.Equal(t, "hello", reply.Contents)
+		assert.Equal(t, "working", metadata.Backend)
+	})
+
+	t.Run("doesn't fail over after a tool was called", func(t *testing.T) {
+		// Backend calls the tool, and then fails before it replies
+		tools := llm.NewScriptedAdapter(
+			llm.ScriptedRule{ToolCalls: []llm.ToolCall{{Name: "queue_song"}}, Times: 1},
+			llm.ScriptedRule{Err: goerrors.New("connection reset")},
+		)
+		next := llm.NewScriptedAdapter(llm.ScriptedRule{Reply: "hello"})
+		adapter := llm.NewFailoverAdapter(
+			llm.FailoverBackend{Name: "tools", Adapter: tools},
+			llm.FailoverBackend{Name: "next", Adapter: next},
+		)
+
+		calls := 0
+		_, _, err := adapter.Chat(context.Background(), newChat(&calls))
+		assert.Error(t, err)
+		assert.Equal(t, 1, calls)
+		assert.Empty(t, next.Requests())
+	})
+
+	t.Run("doesn't count cancelled requests as failures of the backend", func(t *testing.T) {
+		backend := llm.NewScriptedAdapter(llm.ScriptedRule{Err: context.Canceled, Times: 1}, llm.ScriptedRule{Reply: "hello"})
+		adapter := llm.NewFailoverAdapter(llm.FailoverBackend{Name: "backend", Adapter: backend, HasReplyMetadata: true})
+		adapter.WithCircuitBreaker(1, time.Hour)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		calls := 0
+		_, _, err := adapter.Chat(ctx, newChat(&calls))
+		assert.ErrorIs(t, err, context.Canceled)
+
+		reply, _, err := adapter.Chat(context.Background(), newChat(&calls))
+		assert.NoError(t, err)
+		assert.Equal(t, "hello", reply.Contents)
+	})
+
+	t.Run("keeps metadata returned by the backend, while resolving the rest", func(t *testing.T) {
+		adapter := llm.NewFailoverAdapter(llm.FailoverBackend{Name: "backend", Adapter: &rememberingAdapter{}})
+
+		calls := 0
+		_, metadata, err := adapter.Chat(context.Background(), newChat(&calls))
+		assert.NoError(t, err)
+		assert.True(t, metadata.IsWorthRemembering)
+		assert.False(t, metadata.IsGoodbye)
+	})
+
+	t.Run("records usage of the goodbye prompt", func(t *testing.T) {
+		db, err := storage.Open(filepath.Join(t.TempDir(), "test.db"))
+		assert.NoError(t, err)
+		defer db.Close()
+
+		tracker, err := llm.NewUsageTracker(db, nil)
+		assert.NoError(t, err)
+
+		adapter := llm.NewFailoverAdapter(llm.FailoverBackend{
+			Name: "ollama",
+			Adapter: llm.NewScriptedAdapter(
+				llm.ScriptedRule{Contains: "goodbye", Reply: `{"is_goodbye": true}`},
+				llm.ScriptedRule{Reply: "bye"},
+			),
+		})
+		adapter.WithUsageTracker(tracker)
+
+		calls := 0
+		ctx := llm.WithUsageContext(context.Background(), llm.FeatureChat, "1")
+		_, metadata, err := adapter.Chat(ctx, newChat(&calls))
+		assert.NoError(t, err)
+		assert.True(t, metadata.IsGoodbye)
+
+		totals, err := tracker.Totals(time.Now(), time.Now())
+		assert.NoError(t, err)
+		assert.Len(t, totals, 1)
+		assert.Equal(t, llm.FeatureGoodbye, totals[0].Feature)
+		assert.Equal(t, "1", totals[0].UserID)
+		assert.Equal(t, "ollama", totals[0].Adapter)
+	})
+
+	t.Run("skips backends without a free slot", func(t *testing.T) {
+		scheduler := llm.NewScheduler("busy", llm.SchedulerOptions{MaxInFlight: 1, QueueTimeout: time.Millisecond})
+		release, err := scheduler.Acquire(context.Background())
+		assert.NoError(t, err)
+		defer release()
+
+		busy := llm.NewScriptedAdapter(llm.ScriptedRule{Reply: "busy"})
+		adapter := llm.NewFailoverAdapter(
+			llm.FailoverBackend{Name: "busy", Adapter: busy, Scheduler: scheduler, HasReplyMetadata: true},
+			llm.FailoverBackend{Name: "free", Adapter: llm.NewScriptedAdapter(llm.ScriptedRule{Reply: "free"}), HasReplyMetadata: true},
+		)
+
+		calls := 0
+		reply, metadata, err := adapter.Chat(context.Background(), newChat(&calls))
+		assert.NoError(t, err)
+		assert.Equal(t, "free", reply.Contents)
+		assert.Equal(t, "free", metadata.Backend)
+		assert.Empty(t, busy.Requests())
+		assert.Equal(t, int64(1), scheduler.Stats().Priorities[llm.PriorityCommand].Timeouts)
+	})
+}
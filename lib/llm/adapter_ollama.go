@@ -3,31 +3,41 @@ package llm
 import (
 	"context"
 	"fmt"
+	"github.com/goccy/go-json"
 	ollama "github.com/ollama/ollama/api"
+	ollamamodel "github.com/ollama/ollama/types/model"
 	"go.uber.org/zap"
+	"lib/errors"
 	"lib/logging"
+	"lib/util/arrayutil"
 	"net/http"
 	"net/url"
+	"slices"
 	"strings"
+	"sync"
 )
 
 var log = logging.Get().Named("llm").Named("AdapterOllama")
 
-const ThinkingStart = "<think>"
-const ThinkingEnd = "</think>"
-
 type OllamaAdapter struct {
 	client *ollama.Client
 	// an Ollama model to use
 	model string
-	// visionModel is an optional model for vision-related operations
-	visionModel *string
+	// visionModel is an optional model used to describe images, when the model does not support them
+	visionModel  *string
+	imageOptions ImageOptions
+	// supportsImages is resolved from model capabilities on first use
+	supportsImages *bool
+	// embeddingModel is an optional model used for embeddings, the chat model is used if it is not set
+	embeddingModel string
+	mu             sync.Mutex
 }
 
 func NewOllamaAdapter(model string, base *url.URL, http *http.Client) *OllamaAdapter {
 	return &OllamaAdapter{
-		client: ollama.NewClient(base, http),
-		model:  model,
+		client:       ollama.NewClient(base, http),
+		model:        model,
+		imageOptions: DefaultImageOptions,
 	}
 }
 
@@ -35,115 +45,269 @@ func (o *OllamaAdapter) WithVision(model string) {
 	o.visionModel = &model
 }
 
+// WithEmbeddingModel uses the model for embeddings, e.g. nomic-embed-text
+func (o *OllamaAdapter) WithEmbeddingModel(model string) {
+	o.embeddingModel = model
+}
+
+func (o *OllamaAdapter) WithImageOptions(options ImageOptions) {
+	o.imageOptions = options
+}
+
 func (o *OllamaAdapter) Chat(ctx context.Context, request *Chat) (*ChatMessage, *ChatReplyMetadata, error) {
+	return o.ChatStream(ctx, request, nil)
+}
+
+func (o *OllamaAdapter) ChatStream(ctx context.Context, request *Chat, onDelta ChatStreamFn) (*ChatMessage, *ChatReplyMetadata, error) {
 	stream := true
 
 	var messages []ollama.Message
 
 	for _, message := range request.Messages {
-		content := o.getMessageContent(ctx, message.Contents, message.Files)
+		content, images := o.getMessageContentAndImages(ctx, message.Contents, message.Files)
 
 		messages = append(messages, ollama.Message{
 			Content: content,
 			Role:    mapOllamaRole(message.Role),
-			// Currently used model does not support Images, so we pass them as base64 instead
-			/*Images: arrayutil.Map(message.Files, func(file File) ollama.ImageData {
-				return file.Data
-			}),*/
+			Images:  images,
 		})
 	}
 
+	tools, err := mapOllamaTools(request.Tools)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	options := modelOptionsFrom(ctx)
 	req := &ollama.ChatRequest{
-		Model:    o.model,
+		Model:    options.model(o.model),
 		Stream:   &stream,
 		Messages: messages,
+		Options:  mapOllamaOptions(options),
 	}
 
-	handler := newStreamHandler()
+	usage := &TokenUsage{}
+	// thinking contains reasoning from all iterations, including the ones that called tools
+	var thinking []string
 
-	err := o.client.Chat(ctx, req, func(response ollama.ChatResponse) error {
-		return handler.Handle(response.Message.Content)
-	})
+	for iteration := 0; ; iteration++ {
+		// After too many tool calls, tools are no longer passed so that model has to give the final answer
+		if iteration < MaxToolIterations {
+			req.Tools = tools
+		} else {
+			req.Tools = nil
+		}
 
-	if err != nil {
-		return nil, nil, err
-	}
+		handler := newStreamHandler()
+		handler.onMessagePart = onDelta
+
+		var toolCalls []ollama.ToolCall
+
+		err = o.client.Chat(ctx, req, func(response ollama.ChatResponse) error {
+			toolCalls = append(toolCalls, response.Message.ToolCalls...)
+			usage.Add(mapOllamaUsage(response.Metrics))
+
+			return handler.Handle(response.Message.Content)
+		})
+
+		if err == nil {
+			err = handler.Flush()
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		contents := strings.TrimSpace(strings.Join(handler.MessageParts, ""))
+		thinking = append(thinking, handler.ThinkingParts...)
+
+		log.Info("got response from llm", zap.Any("request", request), zap.String("model", req.Model), zap.Strings("response", handler.MessageParts), zap.Strings("thinking", handler.ThinkingParts), zap.Int("toolCalls", len(toolCalls)))
+
+		if len(toolCalls) > 0 && iteration >= MaxToolIterations {
+			return nil, nil, ErrTooManyToolCalls
+		}
+
+		if len(toolCalls) == 0 {
+			reply := NewChatMessage(contents, ChatRoleAssistant)
+			if reasoning := strings.TrimSpace(strings.Join(thinking, "")); reasoning != "" {
+				reply.AddMetadata(ThinkingMetadataKey, reasoning)
+			}
+
+			return reply, &ChatReplyMetadata{Usage: usage}, nil
+		}
 
-	log.Info("got response from llm", zap.Any("request", request), zap.String("model", o.model), zap.Strings("response", handler.MessageParts), zap.Strings("thinking", handler.ThinkingParts))
+		req.Messages = append(req.Messages, ollama.Message{
+			Role:      "assistant",
+			Content:   contents,
+			ToolCalls: toolCalls,
+		})
+
+		for _, toolCall := range toolCalls {
+			arguments, err := json.Marshal(toolCall.Function.Arguments)
+			if err != nil {
+				return nil, nil, errors.Wrap(err, "failed to marshal tool call arguments")
+			}
 
-	return &ChatMessage{
-		Role:     ChatRoleAssistant,
-		Contents: strings.TrimSpace(strings.Join(handler.MessageParts, "")),
-	}, nil, nil
+			req.Messages = append(req.Messages, ollama.Message{
+				Role:    "tool",
+				Content: request.Tools.Call(ctx, toolCall.Function.Name, string(arguments)),
+			})
+		}
+	}
 }
 
 func (o *OllamaAdapter) Prompt(ctx context.Context, p Prompt) (string, *PromptReplyMetadata, error) {
 	stream := true
 
+	prompt, images := o.getMessageContentAndImages(ctx, p.Phrase, p.Files)
+	options := modelOptionsFrom(ctx)
 	req := &ollama.GenerateRequest{
-		Prompt: o.getMessageContent(ctx, p.Phrase, p.Files),
-		Model:  o.model,
-		System: p.Traits,
-		Stream: &stream,
-		// Currently used model does not support Images, so we pass them as base64 instead
-		/*	Images: arrayutil.Map(p.Files, func(file File) ollama.ImageData {
-			b64 := make([]byte, base64.StdEncoding.EncodedLen(len(file.Data)))
-			base64.StdEncoding.Encode(b64, file.Data)
-			return b64
-		}),*/
+		Prompt:  prompt,
+		Model:   options.model(o.model),
+		System:  p.Traits,
+		Stream:  &stream,
+		Images:  images,
+		Options: mapOllamaOptions(options),
+	}
+
+	if p.Schema != nil {
+		format, err := json.Marshal(p.Schema.Definition)
+		if err != nil {
+			return "", nil, errors.Wrap(err, "failed to marshal schema")
+		}
+
+		req.Format = format
 	}
 
 	handler := newStreamHandler()
+	usage := &TokenUsage{}
 
 	err := o.client.Generate(ctx, req, func(response ollama.GenerateResponse) error {
+		usage.Add(mapOllamaUsage(response.Metrics))
 		return handler.Handle(response.Response)
 	})
 
+	if err == nil {
+		err = handler.Flush()
+	}
 	if err != nil {
 		return "", nil, err
 	}
 
-	log.Info("got response from llm", zap.String("prompt", p.Phrase), zap.String("model", o.model), zap.String("system", p.Traits), zap.Strings("response", handler.MessageParts), zap.Strings("thinking", handler.ThinkingParts))
+	log.Info("got response from llm", zap.String("prompt", p.Phrase), zap.String("model", req.Model), zap.String("system", p.Traits), zap.Strings("response", handler.MessageParts), zap.Strings("thinking", handler.ThinkingParts))
 
-	return strings.TrimSpace(strings.Join(handler.MessageParts, "")), nil, nil
+	return strings.TrimSpace(strings.Join(handler.MessageParts, "")), &PromptReplyMetadata{Usage: usage}, nil
+}
+
+func (o *OllamaAdapter) Embed(ctx context.Context, inputs []string) ([][]float32, error) {
+	model := o.embeddingModel
+	if model == "" {
+		model = o.model
+	}
+
+	response, err := o.client.Embed(ctx, &ollama.EmbedRequest{
+		Model: model,
+		Input: inputs,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to embed")
+	}
+
+	if len(response.Embeddings) != len(inputs) {
+		return nil, fmt.Errorf("got %d embeddings for %d inputs", len(response.Embeddings), len(inputs))
+	}
+
+	return response.Embeddings, nil
+}
+
+// mapOllamaUsage returns token usage reported with the last response of the stream
+func mapOllamaUsage(metrics ollama.Metrics) *TokenUsage {
+	return &TokenUsage{
+		InputTokens:  int64(metrics.PromptEvalCount),
+		OutputTokens: int64(metrics.EvalCount),
+	}
+}
+
+// mapOllamaOptions passes the temperature of the route, other options are left to the model defaults
+func mapOllamaOptions(options ModelOptions) map[string]any {
+	if options.Temperature == nil {
+		return nil
+	}
+
+	return map[string]any{"temperature": *options.Temperature}
 }
 
 type streamHandler struct {
 	MessageParts  []string `json:"message_parts"`
 	ThinkingParts []string `json:"thinking_parts"`
-	isThinking    bool
+	parser        ThinkingParser
+	// onMessagePart is optionally called with every message part that is not part of thinking
+	onMessagePart ChatStreamFn
 }
 
 func newStreamHandler() *streamHandler {
 	return &streamHandler{
 		MessageParts:  []string{},
 		ThinkingParts: []string{},
-		isThinking:    false,
 	}
 }
 
 func (h *streamHandler) Handle(contents string) error {
-	if contents == ThinkingStart {
-		log.Debug("llm started thinking")
-		h.isThinking = true
-		return nil
+	return h.add(h.parser.Feed(contents))
+}
+
+// Flush handles the end of the stream, that may have been held as a possible beginning of a thinking tag
+func (h *streamHandler) Flush() error {
+	return h.add(h.parser.Flush())
+}
+
+func (h *streamHandler) add(message string, thinking string) error {
+	if thinking != "" {
+		h.ThinkingParts = append(h.ThinkingParts, thinking)
 	}
 
-	if contents == ThinkingEnd {
-		log.Debug("llm stopped thinking")
-		h.isThinking = false
+	if message == "" {
 		return nil
 	}
 
-	if h.isThinking {
-		h.ThinkingParts = append(h.ThinkingParts, contents)
-	} else {
-		h.MessageParts = append(h.MessageParts, contents)
+	h.MessageParts = append(h.MessageParts, message)
+
+	if h.onMessagePart != nil {
+		return h.onMessagePart(message)
 	}
 
 	return nil
 }
 
+// mapOllamaTools converts tools from the registry into Ollama tools
+func mapOllamaTools(registry *ToolRegistry) (ollama.Tools, error) {
+	var tools ollama.Tools
+
+	for _, tool := range registry.List() {
+		// Ollama uses typed struct for parameters, so the JSON schema is converted using JSON
+		definition, err := json.Marshal(map[string]any{
+			"type": "function",
+			"function": map[string]any{
+				"name":        tool.Name,
+				"description": tool.Description,
+				"parameters":  tool.Parameters,
+			},
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to marshal tool definition")
+		}
+
+		var ollamaTool ollama.Tool
+		err = json.Unmarshal(definition, &ollamaTool)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to unmarshal tool definition")
+		}
+
+		tools = append(tools, ollamaTool)
+	}
+
+	return tools, nil
+}
+
 func mapOllamaRole(role ChatRole) string {
 	switch role {
 	case ChatRoleSystem:
@@ -159,6 +323,45 @@ func mapOllamaRole(role ChatRole) string {
 	return ""
 }
 
+// getMessageContentAndImages returns images, if the model supports them. Otherwise, images are described in the returned content.
+func (o *OllamaAdapter) getMessageContentAndImages(ctx context.Context, messageContent string, files []File) (string, []ollama.ImageData) {
+	images := limitImages(files, o.imageOptions)
+	if len(images) == 0 {
+		return messageContent, nil
+	}
+
+	if !o.modelSupportsImages(ctx) {
+		return o.getMessageContent(ctx, messageContent, images), nil
+	}
+
+	return messageContent, arrayutil.Map(images, func(image File) ollama.ImageData {
+		return image.Data
+	})
+}
+
+// modelSupportsImages checks if the model has vision capability
+func (o *OllamaAdapter) modelSupportsImages(ctx context.Context) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.supportsImages != nil {
+		return *o.supportsImages
+	}
+
+	model, err := o.client.Show(ctx, &ollama.ShowRequest{Model: o.model})
+	if err != nil {
+		// Not cached, so that it is checked again with the next message
+		log.Error("failed to get model capabilities", zap.Error(err), zap.String("model", o.model))
+		return false
+	}
+
+	supportsImages := slices.Contains(model.Capabilities, ollamamodel.CapabilityVision)
+	o.supportsImages = &supportsImages
+	log.Info("resolved model capabilities", zap.String("model", o.model), zap.Bool("supportsImages", supportsImages))
+
+	return supportsImages
+}
+
 func (o *OllamaAdapter) getMessageContent(ctx context.Context, messageContent string, files []File) string {
 	content := messageContent
 
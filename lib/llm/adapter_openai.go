@@ -3,10 +3,13 @@ package llm
 import (
 	"context"
 	goerrors "errors"
+	"fmt"
 	"github.com/openai/openai-go"
 	"github.com/openai/openai-go/responses"
+	"github.com/openai/openai-go/shared"
 	errors2 "lib/errors"
 	"lib/util/arrayutil"
+	"strings"
 	openai2 "wojciech-bot/openai"
 )
 
@@ -16,6 +19,8 @@ type OpenAIModelDefinition struct {
 	Model         openai.ChatModel
 	ContextWindow int32
 	Encoding      string
+	// SupportsImages should be true for models with vision capability
+	SupportsImages bool
 }
 
 type OpenAIAdapter struct {
@@ -23,6 +28,7 @@ type OpenAIAdapter struct {
 	// openAI model to use
 	model         OpenAIModelDefinition
 	vectorStoreID string
+	imageOptions  ImageOptions
 }
 
 // NewOpenAIAdapter creates a new instance of OpenAIAdapter with the provided client and model.
@@ -31,32 +37,41 @@ func NewOpenAIAdapter(client *openai.Client, model OpenAIModelDefinition, vector
 		client:        client,
 		model:         model,
 		vectorStoreID: vectorStoreID,
+		imageOptions:  DefaultImageOptions,
 	}
 }
 
+func (o *OpenAIAdapter) WithImageOptions(options ImageOptions) {
+	o.imageOptions = options
+}
+
 func (o *OpenAIAdapter) Prompt(ctx context.Context, p Prompt) (string, *PromptReplyMetadata, error) {
-	res, err := o.client.Responses.New(ctx, responses.ResponseNewParams{
-		Model: o.model.Model,
+	options := modelOptionsFrom(ctx)
+	params := responses.ResponseNewParams{
+		Model: options.model(o.model.Model),
 		Input: responses.ResponseNewParamsInputUnion{
 			OfString: openai.String(p.Phrase),
 		},
 		Instructions: openai.String(p.Traits),
-		Tools: []responses.ToolUnionParam{
-			{
-				OfFileSearch: &responses.FileSearchToolParam{
-					VectorStoreIDs: []string{
-						o.vectorStoreID,
-					},
-				},
-			},
-		},
-	})
+		Text:         mapOpenAITextConfig(p.Schema),
+		Tools:        o.promptTools(),
+	}
+	if options.Temperature != nil {
+		params.Temperature = openai.Float(*options.Temperature)
+	}
+
+	res, err := o.client.Responses.New(ctx, params)
 
 	if err != nil {
 		return "", nil, errors2.Wrap(err, "failed to create response")
 	}
 
-	metadata := PromptReplyMetadata{}
+	metadata := PromptReplyMetadata{
+		Usage: &TokenUsage{
+			InputTokens:  res.Usage.InputTokens,
+			OutputTokens: res.Usage.OutputTokens,
+		},
+	}
 
 	for _, out := range res.Output {
 		asMessage := out.AsMessage()
@@ -84,13 +99,151 @@ func (o *OpenAIAdapter) Prompt(ctx context.Context, p Prompt) (string, *PromptRe
 	return "", nil, ErrAssistantDidNotReply
 }
 
+// promptTools searches memories in the vector store, if there is one. Without it memories are kept in MemoryStore.
+func (o *OpenAIAdapter) promptTools() []responses.ToolUnionParam {
+	if o.vectorStoreID == "" {
+		return nil
+	}
+
+	return []responses.ToolUnionParam{
+		{
+			OfFileSearch: &responses.FileSearchToolParam{
+				VectorStoreIDs: []string{
+					o.vectorStoreID,
+				},
+			},
+		},
+	}
+}
+
+// mapOpenAITextConfig enables structured output, if the prompt has a schema
+func mapOpenAITextConfig(schema *Schema) responses.ResponseTextConfigParam {
+	if schema == nil {
+		return responses.ResponseTextConfigParam{}
+	}
+
+	return responses.ResponseTextConfigParam{
+		Format: responses.ResponseFormatTextConfigUnionParam{
+			OfJSONSchema: &responses.ResponseFormatTextJSONSchemaConfigParam{
+				Name:   schema.Name,
+				Schema: schema.Definition,
+				Strict: openai.Bool(true),
+			},
+		},
+	}
+}
+
 func (o *OpenAIAdapter) Chat(ctx context.Context, chat *Chat) (*ChatMessage, *ChatReplyMetadata, error) {
+	return o.complete(ctx, chat, nil)
+}
+
+func (o *OpenAIAdapter) ChatStream(ctx context.Context, chat *Chat, onDelta ChatStreamFn) (*ChatMessage, *ChatReplyMetadata, error) {
+	return o.complete(ctx, chat, onDelta)
+}
+
+// complete sends the chat to the model, and calls tools requested by it until the final answer is given
+func (o *OpenAIAdapter) complete(ctx context.Context, chat *Chat, onDelta ChatStreamFn) (*ChatMessage, *ChatReplyMetadata, error) {
+	param, err := o.chatParams(chat)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return runToolLoop(ctx, chat, func(ctx context.Context, results []toolResult, forceAnswer bool) (*toolCompletion, error) {
+		for _, result := range results {
+			param.Messages = append(param.Messages, openai.ToolMessage(result.Result, result.Call.ID))
+		}
+
+		if forceAnswer {
+			// Model called tools too many times, force it to give the final answer
+			param.ToolChoice = openai.ChatCompletionToolChoiceOptionUnionParam{
+				OfAuto: openai.String(string(openai.ChatCompletionToolChoiceOptionAutoNone)),
+			}
+		}
+
+		message, usage, err := o.sendCompletion(ctx, param, onDelta)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(message.ToolCalls) > 0 {
+			// Results of the calls are sent after the message that requested them
+			param.Messages = append(param.Messages, message.ToParam())
+		}
+
+		return &toolCompletion{
+			Content: message.Content,
+			Refusal: message.Refusal,
+			ToolCalls: arrayutil.Map(message.ToolCalls, func(toolCall openai.ChatCompletionMessageToolCall) ToolCall {
+				return ToolCall{ID: toolCall.ID, Name: toolCall.Function.Name, Arguments: toolCall.Function.Arguments}
+			}),
+			Usage: usage,
+		}, nil
+	})
+}
+
+// sendCompletion sends single completion request. If onDelta is set, the completion is streamed.
+func (o *OpenAIAdapter) sendCompletion(ctx context.Context, param openai.ChatCompletionNewParams, onDelta ChatStreamFn) (*openai.ChatCompletionMessage, *TokenUsage, error) {
+	options := modelOptionsFrom(ctx)
+	param.Model = options.model(param.Model)
+	if options.Temperature != nil {
+		param.Temperature = openai.Float(*options.Temperature)
+	}
+
+	if onDelta == nil {
+		completion, err := o.client.Chat.Completions.New(ctx, param)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return &completion.Choices[0].Message, mapCompletionUsage(completion.Usage), nil
+	}
+
+	param.StreamOptions = openai.ChatCompletionStreamOptionsParam{
+		IncludeUsage: openai.Bool(true),
+	}
+	stream := o.client.Chat.Completions.NewStreaming(ctx, param)
+	defer stream.Close()
+
+	accumulator := openai.ChatCompletionAccumulator{}
+	for stream.Next() {
+		chunk := stream.Current()
+		accumulator.AddChunk(chunk)
+
+		if len(chunk.Choices) > 0 && chunk.Choices[0].Delta.Content != "" {
+			err := onDelta(chunk.Choices[0].Delta.Content)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	err := stream.Err()
+	if err != nil {
+		return nil, nil, errors2.Wrap(err, "stream error")
+	}
+
+	if len(accumulator.Choices) == 0 {
+		return nil, nil, ErrAssistantDidNotReply
+	}
+
+	return &accumulator.Choices[0].Message, mapCompletionUsage(accumulator.Usage), nil
+}
+
+func mapCompletionUsage(usage openai.CompletionUsage) *TokenUsage {
+	return &TokenUsage{
+		InputTokens:  usage.PromptTokens,
+		OutputTokens: usage.CompletionTokens,
+	}
+}
+
+// chatParams converts given chat into completion params, making sure that it fits into the model context window
+func (o *OpenAIAdapter) chatParams(chat *Chat) (openai.ChatCompletionNewParams, error) {
 	var messages []openai.ChatCompletionMessageParamUnion
 
 	for _, chatMessage := range chat.Messages {
 		switch chatMessage.Role {
 		case ChatRoleUser:
-			messages = append(messages, openai.UserMessage(chatMessage.ChatMessage()))
+			messages = append(messages, o.userMessage(chatMessage))
 
 		case ChatRoleAssistant:
 			messages = append(messages, openai.AssistantMessage(chatMessage.ChatMessage()))
@@ -103,36 +256,52 @@ func (o *OpenAIAdapter) Chat(ctx context.Context, chat *Chat) (*ChatMessage, *Ch
 	param := openai.ChatCompletionNewParams{
 		Messages: messages,
 		Model:    o.model.Model,
-		Tools:    []openai.ChatCompletionToolParam{},
+		Tools:    mapOpenAITools(chat.Tools),
+	}
+
+	// Context window is unknown, e.g. for some OpenAI-compatible servers, the server will reject too long chats on its own
+	if o.model.ContextWindow == 0 {
+		return param, nil
 	}
 
 	tokens, err := o.countTokens(chat)
 	if err != nil {
-		return nil, nil, errors2.Wrap(err, "failed to count tokens")
+		return param, errors2.Wrap(err, "failed to count tokens")
 	}
 
 	if tokens > o.model.ContextWindow {
-		return nil, nil, NewPromptTooLongError(tokens)
+		return param, NewPromptTooLongError(tokens)
 	}
 
-	completion, err := o.client.Chat.Completions.New(ctx, param)
-	if err != nil {
-		return nil, nil, err
+	return param, nil
+}
+
+// userMessage converts the message into user message, with images attached if the model supports them
+func (o *OpenAIAdapter) userMessage(message *ChatMessage) openai.ChatCompletionMessageParamUnion {
+	images := limitImages(message.Files, o.imageOptions)
+	if len(images) == 0 {
+		return openai.UserMessage(message.ChatMessage())
 	}
 
-	message := completion.Choices[0].Message
-	if message.Refusal != "" {
-		var prompt string
+	if !o.model.SupportsImages {
+		// Model cannot see the images, but it should know that they were sent
+		imageNames := arrayutil.Map(images, func(image File) string {
+			return image.Name
+		})
 
-		lastMessage, lastMessageOk := arrayutil.Last(chat.Messages)
-		if lastMessageOk {
-			prompt = lastMessage.Contents
-		}
+		return openai.UserMessage(fmt.Sprintf("%s\n\nThis message contains images that you cannot see: %s", message.ChatMessage(), strings.Join(imageNames, ", ")))
+	}
 
-		return nil, nil, NewRefusedToReplyError(message.Refusal, prompt)
+	parts := []openai.ChatCompletionContentPartUnionParam{
+		openai.TextContentPart(message.ChatMessage()),
+	}
+	for _, image := range images {
+		parts = append(parts, openai.ImageContentPart(openai.ChatCompletionContentPartImageImageURLParam{
+			URL: image.DataURL(),
+		}))
 	}
 
-	return NewChatMessage(message.Content, ChatRoleUser), nil, nil
+	return openai.UserMessage(parts)
 }
 
 func (o *OpenAIAdapter) countTokens(chat *Chat) (int32, error) {
@@ -143,3 +312,30 @@ func (o *OpenAIAdapter) countTokens(chat *Chat) (int32, error) {
 
 	return openai2.CountTokens(contents, o.model.Encoding)
 }
+
+// mapOpenAITools converts tools from the registry into chat completion tools
+func mapOpenAITools(registry *ToolRegistry) []openai.ChatCompletionToolParam {
+	return arrayutil.Map(registry.List(), func(tool Tool) openai.ChatCompletionToolParam {
+		return openai.ChatCompletionToolParam{
+			Function: mapOpenAIFunctionDefinition(tool),
+		}
+	})
+}
+
+func mapOpenAIFunctionDefinition(tool Tool) shared.FunctionDefinitionParam {
+	return shared.FunctionDefinitionParam{
+		Name:        tool.Name,
+		Description: openai.String(tool.Description),
+		Parameters:  tool.Parameters,
+	}
+}
+
+// lastMessageContents returns contents of the last chat message, used as a prompt in ErrRefusedToReply
+func lastMessageContents(chat *Chat) string {
+	lastMessage, ok := arrayutil.Last(chat.Messages)
+	if !ok {
+		return ""
+	}
+
+	return lastMessage.Contents
+}
@@ -16,6 +16,8 @@ import (
 )
 
 const threadCompletedEvent = "thread.message.completed"
+const threadMessageDeltaEvent = "thread.message.delta"
+const threadRunRequiresActionEvent = "thread.run.requires_action"
 
 //go:embed assistant_json_schema.json
 var schema []byte
@@ -51,6 +53,7 @@ type OpenAIAssistantAdapter struct {
 	// openAI assistant to use
 	assistant     OpenAIAssistantDefinition
 	vectorStoreID string
+	imageOptions  ImageOptions
 }
 
 // NewOpenAIAssistantAdapter creates a new instance of OpenAIAssistantAdapter with the provided client and assistant.
@@ -59,10 +62,15 @@ func NewOpenAIAssistantAdapter(client *openai.Client, assistant OpenAIAssistantD
 		client:        client,
 		assistant:     assistant,
 		vectorStoreID: vectorStoreID,
+		imageOptions:  DefaultImageOptions,
 	}
 }
 
-// TODO Add support for attachments
+func (o *OpenAIAssistantAdapter) WithImageOptions(options ImageOptions) {
+	o.imageOptions = options
+}
+
+// Prompt sends the prompt as a new thread. Images from the prompt files are attached to the message.
 func (o *OpenAIAssistantAdapter) Prompt(ctx context.Context, p Prompt) (string, *PromptReplyMetadata, error) {
 	var messages []*ChatMessage
 
@@ -76,6 +84,7 @@ func (o *OpenAIAssistantAdapter) Prompt(ctx context.Context, p Prompt) (string,
 	messages = append(messages, &ChatMessage{
 		Role:     ChatRoleUser,
 		Contents: p.Phrase,
+		Files:    p.Files,
 	})
 
 	chat := NewChat()
@@ -91,6 +100,11 @@ func (o *OpenAIAssistantAdapter) Prompt(ctx context.Context, p Prompt) (string,
 }
 
 func (o *OpenAIAssistantAdapter) Chat(ctx context.Context, chat *Chat) (*ChatMessage, *ChatReplyMetadata, error) {
+	return o.ChatStream(ctx, chat, nil)
+}
+
+// ChatStream sends the chat to the assistant thread. Since assistant replies with JSON, only the "response" field is passed to onDelta.
+func (o *OpenAIAssistantAdapter) ChatStream(ctx context.Context, chat *Chat, onDelta ChatStreamFn) (*ChatMessage, *ChatReplyMetadata, error) {
 	log := logging.Get().Named("AdapterOpenAIAssistant").Named("Chat")
 
 	var thread *openai.Thread
@@ -164,7 +178,7 @@ func (o *OpenAIAssistantAdapter) Chat(ctx context.Context, chat *Chat) (*ChatMes
 		additionalInstructions = strings.Join(systemPrompts, ", ")
 	}
 
-	stream := o.client.Beta.Threads.Runs.NewStreaming(ctx, thread.ID, openai.BetaThreadRunNewParams{
+	runParams := openai.BetaThreadRunNewParams{
 		AssistantID: o.assistant.ID,
 		ResponseFormat: openai.AssistantResponseFormatOptionParamOfJSONSchema(openai.ResponseFormatJSONSchemaJSONSchemaParam{
 			Name:   "bot_response",
@@ -172,15 +186,25 @@ func (o *OpenAIAssistantAdapter) Chat(ctx context.Context, chat *Chat) (*ChatMes
 			Schema: schema,
 		}),
 		AdditionalInstructions: openai.String(additionalInstructions),
-		Tools: []openai.AssistantToolUnionParam{
-			{
-				OfFileSearch: &openai.FileSearchToolParam{
-					FileSearch: openai.FileSearchToolFileSearchParam{},
-				},
-			},
-		},
-	})
-	defer stream.Close()
+		Tools:                  o.mapTools(chat.Tools),
+	}
+	// Model of the assistant is used, unless the route overrides it
+	options := modelOptionsFrom(ctx)
+	runParams.Model = options.model("")
+	if options.Temperature != nil {
+		runParams.Temperature = openai.Float(*options.Temperature)
+	}
+
+	stream := o.client.Beta.Threads.Runs.NewStreaming(ctx, thread.ID, runParams)
+	defer func() {
+		stream.Close()
+	}()
+
+	// rawReply contains JSON streamed so far, and streamedResponse the part of "response" field that was passed to onDelta
+	var rawReply strings.Builder
+	var streamedResponse string
+	toolIterations := 0
+
 	for stream.Next() {
 		err = stream.Err()
 		if err != nil {
@@ -190,6 +214,47 @@ func (o *OpenAIAssistantAdapter) Chat(ctx context.Context, chat *Chat) (*ChatMes
 
 		streamHistory = append(streamHistory, v)
 
+		if v.Event == threadRunRequiresActionEvent {
+			// Run is paused until we submit results of the tool calls, which continues the run in a new stream
+			toolIterations++
+			run := v.AsThreadRunRequiresAction().Data
+
+			// The assistant was already told to stop calling tools, so the run is cancelled instead of waiting forever
+			if toolIterations > MaxToolIterations+1 {
+				_, err = o.client.Beta.Threads.Runs.Cancel(ctx, run.ThreadID, run.ID)
+				if err != nil {
+					log.Error("failed to cancel run", zap.Error(err), zap.String("runID", run.ID))
+				}
+
+				return nil, nil, ErrTooManyToolCalls
+			}
+
+			toolOutputs := o.callTools(ctx, chat, run.RequiredAction.SubmitToolOutputs.ToolCalls, toolIterations > MaxToolIterations)
+
+			stream.Close()
+			stream = o.client.Beta.Threads.Runs.SubmitToolOutputsStreaming(ctx, run.ThreadID, run.ID, openai.BetaThreadRunSubmitToolOutputsParams{
+				ToolOutputs: toolOutputs,
+			})
+
+			continue
+		}
+
+		if v.Event == threadMessageDeltaEvent && onDelta != nil {
+			for _, content := range v.AsThreadMessageDelta().Data.Delta.Content {
+				rawReply.WriteString(content.Text.Value)
+			}
+
+			response := partialJSONStringField(rawReply.String(), "response")
+			if len(response) > len(streamedResponse) {
+				err = onDelta(response[len(streamedResponse):])
+				if err != nil {
+					return nil, nil, err
+				}
+
+				streamedResponse = response
+			}
+		}
+
 		if v.Event == threadCompletedEvent {
 			if len(v.Data.Content) > 0 {
 				log.Debug("stream content", zap.Any("contents", v.Data.Content))
@@ -240,6 +305,45 @@ func (o *OpenAIAssistantAdapter) Chat(ctx context.Context, chat *Chat) (*ChatMes
 	return nil, nil, goerrors.New("assistant didn't reply")
 }
 
+// mapTools returns tools available in the run: file search for the memory, if it's kept in the vector store, and tools declared in the chat
+func (o *OpenAIAssistantAdapter) mapTools(registry *ToolRegistry) []openai.AssistantToolUnionParam {
+	var tools []openai.AssistantToolUnionParam
+	if o.vectorStoreID != "" {
+		tools = append(tools, openai.AssistantToolUnionParam{
+			OfFileSearch: &openai.FileSearchToolParam{
+				FileSearch: openai.FileSearchToolFileSearchParam{},
+			},
+		})
+	}
+
+	for _, tool := range registry.List() {
+		tools = append(tools, openai.AssistantToolUnionParam{
+			OfFunction: &openai.FunctionToolParam{
+				Function: mapOpenAIFunctionDefinition(tool),
+			},
+		})
+	}
+
+	return tools
+}
+
+// callTools calls tools requested by the run. If limitReached is true, tools are not called and the assistant is asked to reply instead.
+func (o *OpenAIAssistantAdapter) callTools(ctx context.Context, chat *Chat, toolCalls []openai.RequiredActionFunctionToolCall, limitReached bool) []openai.BetaThreadRunSubmitToolOutputsParamsToolOutput {
+	return arrayutil.Map(toolCalls, func(toolCall openai.RequiredActionFunctionToolCall) openai.BetaThreadRunSubmitToolOutputsParamsToolOutput {
+		var output string
+		if limitReached {
+			output = "error: too many tool calls, reply without calling any more tools"
+		} else {
+			output = chat.Tools.Call(ctx, toolCall.Function.Name, toolCall.Function.Arguments)
+		}
+
+		return openai.BetaThreadRunSubmitToolOutputsParamsToolOutput{
+			ToolCallID: openai.String(toolCall.ID),
+			Output:     openai.String(output),
+		}
+	})
+}
+
 // sendMessageToThread sends a message to an existing thread and returns the response message or an error if it fails.
 func (o *OpenAIAssistantAdapter) sendMessageToThread(ctx context.Context, message *ChatMessage, threadId string) error {
 	fileIds, err := o.handleAttachments(ctx, message)
@@ -264,10 +368,12 @@ func (o *OpenAIAssistantAdapter) sendMessageToThread(ctx context.Context, messag
 	return nil
 }
 
+// handleAttachments uploads images of the message, and returns their file IDs. Other files are not supported by the assistant.
 func (o *OpenAIAssistantAdapter) handleAttachments(ctx context.Context, message *ChatMessage) ([]string, error) {
 	var fileIds []string
-	if len(message.Files) > 0 {
-		for _, file := range message.Files {
+	images := limitImages(message.Files, o.imageOptions)
+	if len(images) > 0 {
+		for _, file := range images {
 			// TODO In next release, search existing files first to avoid duplicates
 			file := openai.File(bytes.NewBuffer(file.Data), file.Name, file.ContentType)
 			uploadedFile, err := o.client.Files.New(ctx, openai.FileNewParams{
@@ -301,6 +407,50 @@ func (o *OpenAIAssistantAdapter) listMessageIds(ctx context.Context, threadId st
 	return result, nil
 }
 
+// DeleteMessage deletes the message from the thread of the chat. Thread messages are matched by the ID in their metadata.
+func (o *OpenAIAssistantAdapter) DeleteMessage(ctx context.Context, chat *Chat, messageID string) error {
+	chat.mu.Lock()
+	threadId, ok := chat.Metadata[threadIdMetadataKey]
+	chat.mu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	messages := o.client.Beta.Threads.Messages.ListAutoPaging(ctx, threadId, openai.BetaThreadMessageListParams{})
+	for messages.Next() {
+		threadMessage := messages.Current()
+		if threadMessage.Metadata["id"] != messageID {
+			continue
+		}
+
+		_, err := o.client.Beta.Threads.Messages.Delete(ctx, threadId, threadMessage.ID)
+		if err != nil {
+			return errors2.Wrap(err, "failed to delete thread message")
+		}
+	}
+
+	return messages.Err()
+}
+
+// ResetHistory deletes the thread of the chat, it is created again from the chat with the next message.
+// Messages of a thread can't be edited, and sending the edited message again would put it at the end.
+func (o *OpenAIAssistantAdapter) ResetHistory(ctx context.Context, chat *Chat) error {
+	chat.mu.Lock()
+	threadId, ok := chat.Metadata[threadIdMetadataKey]
+	delete(chat.Metadata, threadIdMetadataKey)
+	chat.mu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	_, err := o.client.Beta.Threads.Delete(ctx, threadId)
+	if err != nil {
+		return errors2.Wrap(err, "failed to delete thread")
+	}
+
+	return nil
+}
+
 func (o *OpenAIAssistantAdapter) createThread(ctx context.Context, chat *Chat) (*openai.Thread, error) {
 	metadata := openai.MetadataParam{}
 	for k, v := range chat.Metadata {
@@ -313,15 +463,19 @@ func (o *OpenAIAssistantAdapter) createThread(ctx context.Context, chat *Chat) (
 	messages := arrayutil.Map(chatMessages, func(m *ChatMessage) openai.BetaThreadNewParamsMessage {
 		return o.chatMessageToThreadMessage(ctx, m)
 	})
-	createdThread, err := o.client.Beta.Threads.New(ctx, openai.BetaThreadNewParams{
+	params := openai.BetaThreadNewParams{
 		Metadata: metadata,
 		Messages: messages,
-		ToolResources: openai.BetaThreadNewParamsToolResources{
+	}
+	// Without the vector store memories are kept in MemoryStore, and injected into the chat instead
+	if o.vectorStoreID != "" {
+		params.ToolResources = openai.BetaThreadNewParamsToolResources{
 			FileSearch: openai.BetaThreadNewParamsToolResourcesFileSearch{
 				VectorStoreIDs: []string{o.vectorStoreID},
 			},
-		},
-	})
+		}
+	}
+	createdThread, err := o.client.Beta.Threads.New(ctx, params)
 
 	return createdThread, err
 }
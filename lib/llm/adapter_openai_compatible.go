@@ -0,0 +1,153 @@
+package llm
+
+import (
+	"context"
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+	"github.com/openai/openai-go/shared"
+	errors2 "lib/errors"
+	"net/http"
+)
+
+// OpenAICompatibleDefinition describes a server that implements OpenAI chat completions API,
+// such as llama.cpp server, vLLM or LocalAI
+type OpenAICompatibleDefinition struct {
+	// BaseURL of the API, usually ending with /v1
+	BaseURL string
+	// APIKey is sent as a bearer token. Most local servers don't require it.
+	APIKey string
+	// Headers are sent with every request, e.g. for authorization by a proxy
+	Headers map[string]string
+	Model   string
+	// ContextWindow of the model. Requests that exceed it fail with ErrPromptTooLong. Zero disables the check.
+	ContextWindow int32
+	// Encoding is used to estimate number of tokens, defaults to o200k_base
+	Encoding       string
+	SupportsImages bool
+}
+
+// OpenAICompatibleAdapter sends prompts and chats to any OpenAI-compatible chat completions server.
+// Unlike OpenAIAdapter, it doesn't use Responses API nor file search, that are specific to OpenAI.
+type OpenAICompatibleAdapter struct {
+	// completions is used only for chat completions, that are shared with OpenAIAdapter
+	completions *OpenAIAdapter
+}
+
+// NewOpenAICompatibleAdapter creates a new instance of OpenAICompatibleAdapter. If httpClient is nil, the default one is used.
+func NewOpenAICompatibleAdapter(definition OpenAICompatibleDefinition, httpClient *http.Client) *OpenAICompatibleAdapter {
+	options := []option.RequestOption{
+		option.WithBaseURL(definition.BaseURL),
+		// Always set, so that OPENAI_API_KEY from the environment is never sent to other servers
+		option.WithAPIKey(definition.APIKey),
+	}
+	for key, value := range definition.Headers {
+		options = append(options, option.WithHeader(key, value))
+	}
+	if httpClient != nil {
+		options = append(options, option.WithHTTPClient(httpClient))
+	}
+
+	encoding := definition.Encoding
+	if encoding == "" {
+		encoding = usageEstimateEncoding
+	}
+
+	client := openai.NewClient(options...)
+
+	return &OpenAICompatibleAdapter{
+		completions: NewOpenAIAdapter(&client, OpenAIModelDefinition{
+			Model:          definition.Model,
+			ContextWindow:  definition.ContextWindow,
+			Encoding:       encoding,
+			SupportsImages: definition.SupportsImages,
+		}, ""),
+	}
+}
+
+func (o *OpenAICompatibleAdapter) WithImageOptions(options ImageOptions) {
+	o.completions.WithImageOptions(options)
+}
+
+func (o *OpenAICompatibleAdapter) Prompt(ctx context.Context, p Prompt) (string, *PromptReplyMetadata, error) {
+	message := NewChatMessage(p.Phrase, ChatRoleUser)
+	message.Files = p.Files
+
+	var messages []openai.ChatCompletionMessageParamUnion
+	if p.Traits != "" {
+		messages = append(messages, openai.SystemMessage(p.Traits))
+	}
+	messages = append(messages, o.completions.userMessage(message))
+
+	reply, usage, err := o.completions.sendCompletion(ctx, openai.ChatCompletionNewParams{
+		Model:          o.completions.model.Model,
+		Messages:       messages,
+		ResponseFormat: mapOpenAIResponseFormat(p.Schema),
+	}, nil)
+	if err != nil {
+		return "", nil, errors2.Wrap(err, "failed to create completion")
+	}
+
+	if reply.Refusal != "" {
+		return "", nil, NewRefusedToReplyError(reply.Refusal, p.Phrase)
+	}
+
+	// Reasoning models served locally often return their reasoning in the reply
+	contents, _ := SplitThinking(reply.Content)
+
+	return contents, &PromptReplyMetadata{Usage: reportedUsage(usage)}, nil
+}
+
+func (o *OpenAICompatibleAdapter) Chat(ctx context.Context, chat *Chat) (*ChatMessage, *ChatReplyMetadata, error) {
+	return o.ChatStream(ctx, chat, nil)
+}
+
+// ChatStream hides reasoning of the model from the streamed reply, and keeps it in the reply metadata instead
+func (o *OpenAICompatibleAdapter) ChatStream(ctx context.Context, chat *Chat, onDelta ChatStreamFn) (*ChatMessage, *ChatReplyMetadata, error) {
+	var onReplyDelta ChatStreamFn
+	if onDelta != nil {
+		handler := newStreamHandler()
+		handler.onMessagePart = onDelta
+		onReplyDelta = handler.Handle
+	}
+
+	reply, metadata, err := o.completions.complete(ctx, chat, onReplyDelta)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	contents, thinking := SplitThinking(reply.Contents)
+	reply.Contents = contents
+	if thinking != "" {
+		reply.AddMetadata(ThinkingMetadataKey, thinking)
+	}
+
+	metadata.Usage = reportedUsage(metadata.Usage)
+
+	return reply, metadata, nil
+}
+
+// mapOpenAIResponseFormat enables structured output of chat completion, if the prompt has a schema
+func mapOpenAIResponseFormat(schema *Schema) openai.ChatCompletionNewParamsResponseFormatUnion {
+	if schema == nil {
+		return openai.ChatCompletionNewParamsResponseFormatUnion{}
+	}
+
+	return openai.ChatCompletionNewParamsResponseFormatUnion{
+		OfJSONSchema: &shared.ResponseFormatJSONSchemaParam{
+			JSONSchema: shared.ResponseFormatJSONSchemaJSONSchemaParam{
+				Name:   schema.Name,
+				Schema: schema.Definition,
+				Strict: openai.Bool(true),
+			},
+		},
+	}
+}
+
+// reportedUsage returns nil if the server did not report usage, so that it is estimated instead of recorded as zero
+func reportedUsage(usage *TokenUsage) *TokenUsage {
+	if usage == nil || (usage.InputTokens == 0 && usage.OutputTokens == 0) {
+		return nil
+	}
+
+	return usage
+}
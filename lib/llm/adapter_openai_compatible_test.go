@@ -0,0 +1,107 @@
+package llm_test
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"lib/llm"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type stubCompletionRequest struct {
+	Model    string `json:"model"`
+	Messages []struct {
+		Role    string `json:"role"`
+		Content any    `json:"content"`
+	} `json:"messages"`
+	ResponseFormat *struct {
+		Type string `json:"type"`
+	} `json:"response_format"`
+}
+
+func TestOpenAICompatibleAdapter(t *testing.T) {
+	var requests []stubCompletionRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/chat/completions", r.URL.Path)
+		assert.Equal(t, "secret", r.Header.Get("X-Proxy-Token"))
+		assert.Equal(t, "Bearer local", r.Header.Get("Authorization"))
+
+		var request stubCompletionRequest
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		requests = append(requests, request)
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{
+			"id": "1",
+			"object": "chat.completion",
+			"model": "qwen3",
+			"choices": [{"index": 0, "finish_reason": "stop", "message": {"role": "assistant", "content": "{\"is_goodbye\": true}"}}],
+			"usage": {"prompt_tokens": 12, "completion_tokens": 5, "total_tokens": 17}
+		}`))
+	}))
+	defer server.Close()
+
+	adapter := llm.NewOpenAICompatibleAdapter(llm.OpenAICompatibleDefinition{
+		BaseURL: server.URL + "/v1",
+		APIKey:  "local",
+		Headers: map[string]string{"X-Proxy-Token": "secret"},
+		Model:   "qwen3",
+	}, server.Client())
+
+	t.Run("sends prompt with traits and schema", func(t *testing.T) {
+		reply, err := llm.PromptJSON[scriptedReply](context.Background(), llm.NewAPI(adapter, "local"), llm.Prompt{Traits: "be brief", Phrase: "bye"})
+		assert.NoError(t, err)
+		assert.True(t, reply.IsGoodbye)
+
+		request := requests[len(requests)-1]
+		assert.Equal(t, "qwen3", request.Model)
+		assert.Equal(t, "system", request.Messages[0].Role)
+		assert.Equal(t, "json_schema", request.ResponseFormat.Type)
+	})
+
+	t.Run("sends chat and reports usage", func(t *testing.T) {
+		chat := llm.NewChat()
+		chat.AddMessages(llm.NewUserChatMessage("bye", "1", "Wojtek"))
+
+		reply, metadata, err := adapter.Chat(context.Background(), chat)
+		assert.NoError(t, err)
+		assert.Equal(t, `{"is_goodbye": true}`, reply.Contents)
+		assert.Equal(t, &llm.TokenUsage{InputTokens: 12, OutputTokens: 5}, metadata.Usage)
+		assert.Equal(t, "Wojtek: bye", requests[len(requests)-1].Messages[0].Content)
+	})
+}
+
+func TestOpenAICompatibleAdapterToolLimit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{
+			"id": "1",
+			"object": "chat.completion",
+			"model": "qwen3",
+			"choices": [{"index": 0, "finish_reason": "tool_calls", "message": {"role": "assistant", "content": "", "tool_calls": [
+				{"id": "call", "type": "function", "function": {"name": "ping", "arguments": "{}"}}
+			]}}]
+		}`))
+	}))
+	defer server.Close()
+
+	adapter := llm.NewOpenAICompatibleAdapter(llm.OpenAICompatibleDefinition{BaseURL: server.URL + "/v1", Model: "qwen3"}, server.Client())
+
+	calls := 0
+	chat := llm.NewChat()
+	chat.AddMessages(llm.NewUserChatMessage("ping forever", "1", "Wojtek"))
+	chat.Tools = llm.NewToolRegistry(llm.Tool{
+		Name:       "ping",
+		Parameters: map[string]any{"type": "object"},
+		Handler: func(ctx context.Context, arguments json.RawMessage) (string, error) {
+			calls++
+			return "pong", nil
+		},
+	})
+
+	_, _, err := adapter.Chat(context.Background(), chat)
+	assert.ErrorIs(t, err, llm.ErrTooManyToolCalls)
+	assert.Equal(t, llm.MaxToolIterations, calls)
+}
@@ -0,0 +1,218 @@
+package llm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	goerrors "errors"
+	"github.com/goccy/go-json"
+	"go.uber.org/zap"
+	"lib/errors"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const fixtureVersion = 1
+
+var ErrNoRecordedReply = goerrors.New("no recorded reply for the request")
+
+// fixtureInteraction is a single request and its reply, saved in the fixture file
+type fixtureInteraction struct {
+	Key string `json:"key"`
+	// Request is saved only to make fixtures readable, Key is used for matching
+	Request        string               `json:"request"`
+	Reply          string               `json:"reply"`
+	ChatMetadata   *ChatReplyMetadata   `json:"chat_metadata,omitempty"`
+	PromptMetadata *PromptReplyMetadata `json:"prompt_metadata,omitempty"`
+	Error          string               `json:"error,omitempty"`
+}
+
+type fixtureFile struct {
+	Version      int                  `json:"version"`
+	Interactions []fixtureInteraction `json:"interactions"`
+}
+
+// RecordingAdapter records requests sent to the wrapped adapter and their replies in a fixture file,
+// or replays them without the wrapped adapter. Replayed requests are matched by their contents, and
+// requests that were recorded more than once are replayed in the recorded order.
+// Tools are not called during replay, only the final replies are recorded.
+type RecordingAdapter struct {
+	mu sync.Mutex
+	// adapter is nil in replay mode
+	adapter Adapter
+	path    string
+	fixture fixtureFile
+	// replayed counts how many times each key was replayed
+	replayed map[string]int
+}
+
+// NewRecordingAdapter records interactions with the adapter into the fixture file at path, overwriting it
+func NewRecordingAdapter(adapter Adapter, path string) *RecordingAdapter {
+	return &RecordingAdapter{
+		adapter: adapter,
+		path:    path,
+		fixture: fixtureFile{Version: fixtureVersion},
+	}
+}
+
+// NewReplayAdapter replays interactions from the fixture file at path
+func NewReplayAdapter(path string) (*RecordingAdapter, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read fixture")
+	}
+
+	var fixture fixtureFile
+	err = json.Unmarshal(data, &fixture)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode fixture")
+	}
+
+	if fixture.Version != fixtureVersion {
+		return nil, goerrors.New("unsupported fixture version")
+	}
+
+	return &RecordingAdapter{
+		path:     path,
+		fixture:  fixture,
+		replayed: make(map[string]int),
+	}, nil
+}
+
+func (r *RecordingAdapter) Prompt(ctx context.Context, p Prompt) (string, *PromptReplyMetadata, error) {
+	key := promptDigest("", p)
+
+	if r.adapter == nil {
+		interaction, err := r.replay(key)
+		if err != nil {
+			return "", nil, err
+		}
+
+		return interaction.Reply, interaction.PromptMetadata, nil
+	}
+
+	reply, metadata, err := r.adapter.Prompt(ctx, p)
+	r.record(fixtureInteraction{
+		Key:            key,
+		Request:        p.Traits + "\n" + p.Phrase,
+		Reply:          reply,
+		PromptMetadata: metadata,
+	}, err)
+
+	return reply, metadata, err
+}
+
+func (r *RecordingAdapter) Chat(ctx context.Context, chat *Chat) (*ChatMessage, *ChatReplyMetadata, error) {
+	return r.ChatStream(ctx, chat, nil)
+}
+
+// ChatStream passes the whole recorded reply to onDelta at once during replay
+func (r *RecordingAdapter) ChatStream(ctx context.Context, chat *Chat, onDelta ChatStreamFn) (*ChatMessage, *ChatReplyMetadata, error) {
+	key := chatDigest(chat)
+
+	if r.adapter == nil {
+		interaction, err := r.replay(key)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if onDelta != nil {
+			err = onDelta(interaction.Reply)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+
+		return NewChatMessage(interaction.Reply, ChatRoleAssistant), interaction.ChatMetadata, nil
+	}
+
+	var reply *ChatMessage
+	var metadata *ChatReplyMetadata
+	var err error
+	if streamingAdapter, ok := r.adapter.(StreamingAdapter); ok && onDelta != nil {
+		reply, metadata, err = streamingAdapter.ChatStream(ctx, chat, onDelta)
+	} else {
+		reply, metadata, err = r.adapter.Chat(ctx, chat)
+		if err == nil && onDelta != nil {
+			err = onDelta(reply.Contents)
+		}
+	}
+
+	interaction := fixtureInteraction{
+		Key:          key,
+		Request:      lastMessageContents(chat),
+		ChatMetadata: metadata,
+	}
+	if reply != nil {
+		interaction.Reply = reply.Contents
+	}
+	r.record(interaction, err)
+
+	return reply, metadata, err
+}
+
+// record appends the interaction, and saves the fixture right away, so that it is not lost when the test fails
+func (r *RecordingAdapter) record(interaction fixtureInteraction, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err != nil {
+		interaction.Error = err.Error()
+	}
+
+	r.fixture.Interactions = append(r.fixture.Interactions, interaction)
+
+	data, err := json.MarshalIndent(r.fixture, "", "  ")
+	if err != nil {
+		logger.Named("recording").Error("failed to encode fixture", zap.Error(err))
+		return
+	}
+
+	err = os.MkdirAll(filepath.Dir(r.path), 0o755)
+	if err == nil {
+		err = os.WriteFile(r.path, data, 0o644)
+	}
+	if err != nil {
+		logger.Named("recording").Error("failed to save fixture", zap.Error(err), zap.String("path", r.path))
+	}
+}
+
+// replay returns the next recorded interaction with the given key. Once all of them were replayed, the last one is repeated.
+func (r *RecordingAdapter) replay(key string) (*fixtureInteraction, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var matching []fixtureInteraction
+	for _, interaction := range r.fixture.Interactions {
+		if interaction.Key == key {
+			matching = append(matching, interaction)
+		}
+	}
+
+	if len(matching) == 0 {
+		return nil, ErrNoRecordedReply
+	}
+
+	interaction := matching[min(r.replayed[key], len(matching)-1)]
+	r.replayed[key]++
+
+	if interaction.Error != "" {
+		return nil, goerrors.New(interaction.Error)
+	}
+
+	return &interaction, nil
+}
+
+// chatDigest returns a hash of roles and contents of the chat messages
+func chatDigest(chat *Chat) string {
+	hash := sha256.New()
+	for _, message := range chat.Messages {
+		hash.Write([]byte(message.Role))
+		hash.Write([]byte{0})
+		hash.Write([]byte(message.ChatMessage()))
+		hash.Write([]byte{0})
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
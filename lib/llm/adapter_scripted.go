@@ -0,0 +1,185 @@
+package llm
+
+import (
+	"context"
+	goerrors "errors"
+	"hash/fnv"
+	"lib/util/arrayutil"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// scriptedEmbeddingSize is a number of dimensions of embeddings returned by ScriptedAdapter
+const scriptedEmbeddingSize = 64
+
+var ErrNoScriptedReply = goerrors.New("no scripted reply matches the request")
+
+// ScriptedRule defines a canned reply of ScriptedAdapter
+type ScriptedRule struct {
+	// Contains matches requests that contain the text. Empty text matches every request.
+	Contains string
+	// Match optionally matches requests with custom logic, in addition to Contains
+	Match func(request string) bool
+	// Reply is returned for matching requests
+	Reply string
+	// IsGoodbye is returned in ChatReplyMetadata
+	IsGoodbye bool
+	// Err is returned instead of the reply, if set
+	Err error
+	// Times limits how many times the rule can be used. Zero means no limit.
+	Times int
+	// ToolCalls are requested from chats instead of the reply. Results of the calls are matched against rules as the next request.
+	ToolCalls []ToolCall
+	// IgnoresToolChoice keeps the rule calling tools, even when the final answer is forced, like misbehaving models do
+	IgnoresToolChoice bool
+}
+
+func (r *ScriptedRule) matches(request string) bool {
+	if !strings.Contains(request, r.Contains) {
+		return false
+	}
+
+	return r.Match == nil || r.Match(request)
+}
+
+// ScriptedAdapter replies with canned replies, using the first rule that matches the request. It is meant for tests.
+// Prompts are matched against traits and phrase, and chats against the last message, or results of tools called before.
+type ScriptedAdapter struct {
+	mu       sync.Mutex
+	rules    []*ScriptedRule
+	requests []string
+}
+
+func NewScriptedAdapter(rules ...ScriptedRule) *ScriptedAdapter {
+	adapter := &ScriptedAdapter{}
+	for _, rule := range rules {
+		adapter.AddRule(rule)
+	}
+
+	return adapter
+}
+
+// AddRule adds the rule after the existing ones
+func (s *ScriptedAdapter) AddRule(rule ScriptedRule) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.rules = append(s.rules, &rule)
+}
+
+// Requests returns all requests received so far, in the form they were matched against the rules
+func (s *ScriptedAdapter) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string{}, s.requests...)
+}
+
+func (s *ScriptedAdapter) Prompt(ctx context.Context, p Prompt) (string, *PromptReplyMetadata, error) {
+	rule, err := s.reply(p.Traits+"\n"+p.Phrase, false)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return rule.Reply, &PromptReplyMetadata{Usage: &TokenUsage{}}, nil
+}
+
+func (s *ScriptedAdapter) Chat(ctx context.Context, chat *Chat) (*ChatMessage, *ChatReplyMetadata, error) {
+	return s.ChatStream(ctx, chat, nil)
+}
+
+// ChatStream streams the scripted reply word by word. Tools are called the same way real adapters call them.
+func (s *ScriptedAdapter) ChatStream(ctx context.Context, chat *Chat, onDelta ChatStreamFn) (*ChatMessage, *ChatReplyMetadata, error) {
+	request := lastMessageContents(chat)
+	isGoodbye := false
+
+	reply, metadata, err := runToolLoop(ctx, chat, func(ctx context.Context, results []toolResult, forceAnswer bool) (*toolCompletion, error) {
+		if len(results) > 0 {
+			request = strings.Join(arrayutil.Map(results, func(result toolResult) string {
+				return result.Result
+			}), "\n")
+		}
+
+		rule, err := s.reply(request, forceAnswer)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(rule.ToolCalls) > 0 {
+			return &toolCompletion{ToolCalls: rule.ToolCalls, Usage: &TokenUsage{}}, nil
+		}
+
+		if onDelta != nil {
+			for _, word := range strings.SplitAfter(rule.Reply, " ") {
+				err = onDelta(word)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+		isGoodbye = rule.IsGoodbye
+
+		return &toolCompletion{Content: rule.Reply, Usage: &TokenUsage{}}, nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	metadata.IsGoodbye = isGoodbye
+
+	return reply, metadata, nil
+}
+
+// Embed returns bag of words embeddings, so that texts sharing words are similar. Embedding requests are not recorded.
+func (s *ScriptedAdapter) Embed(ctx context.Context, inputs []string) ([][]float32, error) {
+	embeddings := make([][]float32, 0, len(inputs))
+	for _, input := range inputs {
+		embedding := make([]float32, scriptedEmbeddingSize)
+		words := strings.FieldsFunc(strings.ToLower(input), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+		})
+		for _, word := range words {
+			hash := fnv.New32a()
+			hash.Write([]byte(word))
+			embedding[hash.Sum32()%scriptedEmbeddingSize]++
+		}
+
+		embeddings = append(embeddings, embedding)
+	}
+
+	return embeddings, nil
+}
+
+// reply records the request, and returns the first rule matching it. Rules calling tools are skipped, when the final answer is forced.
+func (s *ScriptedAdapter) reply(request string, forceAnswer bool) (*ScriptedRule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests = append(s.requests, request)
+
+	for i, rule := range s.rules {
+		if !rule.matches(request) {
+			continue
+		}
+
+		if forceAnswer && len(rule.ToolCalls) > 0 && !rule.IgnoresToolChoice {
+			continue
+		}
+
+		if rule.Times > 0 {
+			rule.Times--
+			if rule.Times == 0 {
+				s.rules = append(s.rules[:i], s.rules[i+1:]...)
+			}
+		}
+
+		if rule.Err != nil {
+			return nil, rule.Err
+		}
+
+		return rule, nil
+	}
+
+	return nil, ErrNoScriptedReply
+}
@@ -0,0 +1,117 @@
+package llm_test
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"lib/llm"
+	"path/filepath"
+	"testing"
+)
+
+type scriptedReply struct {
+	IsGoodbye bool `json:"is_goodbye"`
+}
+
+func TestScriptedAdapter(t *testing.T) {
+	t.Run("replies with the first matching rule", func(t *testing.T) {
+		adapter := llm.NewScriptedAdapter(
+			llm.ScriptedRule{Contains: "hello", Reply: "hi"},
+			llm.ScriptedRule{Reply: "what?"},
+		)
+
+		reply, _, err := adapter.Prompt(context.Background(), llm.Prompt{Phrase: "hello there"})
+		assert.NoError(t, err)
+		assert.Equal(t, "hi", reply)
+
+		reply, _, err = adapter.Prompt(context.Background(), llm.Prompt{Phrase: "bye"})
+		assert.NoError(t, err)
+		assert.Equal(t, "what?", reply)
+	})
+
+	t.Run("streams chat reply", func(t *testing.T) {
+		api := llm.NewSingleAdapterRouter(llm.NewScriptedAdapter(llm.ScriptedRule{Reply: "see you later", IsGoodbye: true})).API(llm.TaskChat)
+		chat := llm.NewChat()
+		chat.AddMessages(llm.NewUserChatMessage("bye", "1", "Wojtek"))
+
+		var streamed string
+		_, reply, metadata, err := api.ChatStream(context.Background(), chat, func(delta string) error {
+			streamed += delta
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, "see you later", reply.Contents)
+		assert.Equal(t, "see you later", streamed)
+		assert.True(t, metadata.IsGoodbye)
+		assert.Len(t, chat.Messages, 2)
+	})
+
+	t.Run("limits rule usage", func(t *testing.T) {
+		adapter := llm.NewScriptedAdapter(llm.ScriptedRule{Reply: "once", Times: 1})
+
+		_, _, err := adapter.Prompt(context.Background(), llm.Prompt{Phrase: "first"})
+		assert.NoError(t, err)
+
+		_, _, err = adapter.Prompt(context.Background(), llm.Prompt{Phrase: "second"})
+		assert.ErrorIs(t, err, llm.ErrNoScriptedReply)
+		assert.Len(t, adapter.Requests(), 2)
+	})
+}
+
+func TestPromptJSON(t *testing.T) {
+	t.Run("decodes reply", func(t *testing.T) {
+		api := llm.NewAPI(llm.NewScriptedAdapter(llm.ScriptedRule{Reply: "```json\n{\"is_goodbye\": true}\n```"}), "scripted")
+
+		reply, err := llm.PromptJSON[scriptedReply](context.Background(), api, llm.Prompt{Phrase: "bye"})
+		assert.NoError(t, err)
+		assert.True(t, reply.IsGoodbye)
+	})
+
+	t.Run("retries invalid reply with the validation error", func(t *testing.T) {
+		adapter := llm.NewScriptedAdapter(
+			llm.ScriptedRule{Reply: "true", Times: 1},
+			llm.ScriptedRule{Contains: "Your previous reply was invalid", Reply: "{\"is_goodbye\": false}"},
+		)
+		api := llm.NewAPI(adapter, "scripted")
+
+		reply, err := llm.PromptJSON[scriptedReply](context.Background(), api, llm.Prompt{Phrase: "hello"})
+		assert.NoError(t, err)
+		assert.False(t, reply.IsGoodbye)
+		assert.Len(t, adapter.Requests(), 2)
+	})
+
+	t.Run("gives up after bounded retries", func(t *testing.T) {
+		adapter := llm.NewScriptedAdapter(llm.ScriptedRule{Reply: "{}"})
+		api := llm.NewAPI(adapter, "scripted")
+
+		_, err := llm.PromptJSON[scriptedReply](context.Background(), api, llm.Prompt{Phrase: "hello"})
+		assert.ErrorIs(t, err, llm.ErrInvalidStructuredOutput)
+		assert.Len(t, adapter.Requests(), llm.MaxStructuredOutputRetries+1)
+	})
+}
+
+func TestRecordingAdapter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fixture.json")
+	prompt := llm.Prompt{Phrase: "hello"}
+
+	recorder := llm.NewRecordingAdapter(llm.NewScriptedAdapter(
+		llm.ScriptedRule{Reply: "first", Times: 1},
+		llm.ScriptedRule{Reply: "second"},
+	), path)
+	for _, expected := range []string{"first", "second"} {
+		reply, _, err := recorder.Prompt(context.Background(), prompt)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, reply)
+	}
+
+	replayer, err := llm.NewReplayAdapter(path)
+	assert.NoError(t, err)
+
+	for _, expected := range []string{"first", "second", "second"} {
+		reply, _, err := replayer.Prompt(context.Background(), prompt)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, reply)
+	}
+
+	_, _, err = replayer.Prompt(context.Background(), llm.Prompt{Phrase: "unknown"})
+	assert.ErrorIs(t, err, llm.ErrNoRecordedReply)
+}
@@ -2,17 +2,36 @@ package llm
 
 import (
 	"context"
+	goerrors "errors"
 	"go.uber.org/zap"
 	"lib/logging"
 	"lib/metrics"
+	"maps"
+	"slices"
 )
 
 var logger = logging.Get().Named("llm").Named("client")
 
+var ErrEmbeddingsNotSupported = goerrors.New("adapter does not support embeddings")
+
 type API struct {
 	adapter Adapter
 	name    string
 	logger  *zap.Logger
+	// usageTracker optionally records token usage of requests
+	usageTracker *UsageTracker
+	// compactor optionally keeps chats within the token budget
+	compactor *Compactor
+	// scheduler optionally limits number of concurrent requests by priority
+	scheduler *Scheduler
+	// memoryStore optionally injects relevant memories into chats
+	memoryStore *MemoryStore
+	// requestFilters change chats and prompts before they are sent, e.g. to redact personal data
+	requestFilters []TextFilter
+	// replyFilters change replies before they are returned, e.g. to block mentions
+	replyFilters []TextFilter
+	// route optionally overrides the model and timeout, for APIs returned by Router
+	route *Route
 }
 
 type PromptResponse struct {
@@ -28,13 +47,100 @@ func NewAPI(adapter Adapter, name string) *API {
 	}
 }
 
+// WithUsageTracker records token usage of all requests sent through the API
+func (api *API) WithUsageTracker(tracker *UsageTracker) {
+	api.usageTracker = tracker
+}
+
+// WithCompaction summarizes the oldest messages of chats that exceed the token budget, instead of failing with ErrPromptTooLong
+func (api *API) WithCompaction(compactor *Compactor) {
+	api.compactor = compactor
+}
+
+// WithScheduler queues requests sent through the API in the scheduler, that may be shared with other APIs using the same backend
+func (api *API) WithScheduler(scheduler *Scheduler) {
+	api.scheduler = scheduler
+}
+
+// WithMemory injects memories relevant to the last user message into chats, before they are sent
+func (api *API) WithMemory(store *MemoryStore) {
+	api.memoryStore = store
+}
+
+// WithRequestFilters filters messages and prompts before they are sent. Chats keep original messages.
+func (api *API) WithRequestFilters(filters ...TextFilter) {
+	api.requestFilters = append(api.requestFilters, filters...)
+}
+
+// WithReplyFilters filters replies, including streamed ones, before they are returned
+func (api *API) WithReplyFilters(filters ...TextFilter) {
+	api.replyFilters = append(api.replyFilters, filters...)
+}
+
 // Chat creates, or continues given chat discussion between user and the assistant (llm model)
 func (api *API) Chat(ctx context.Context, chat *Chat) (*Chat, *ChatMessage, *ChatReplyMetadata, error) {
-	api.logger.Info("sending chat request", zap.Any("chat", chat))
+	return api.chat(ctx, chat, nil)
+}
+
+// ChatStream works like Chat, but calls onDelta with new parts of the reply as soon as they are generated.
+// If the adapter is not able to stream, onDelta is called once with the whole reply.
+func (api *API) ChatStream(ctx context.Context, chat *Chat, onDelta ChatStreamFn) (*Chat, *ChatMessage, *ChatReplyMetadata, error) {
+	return api.chat(ctx, chat, onDelta)
+}
+
+func (api *API) chat(ctx context.Context, chat *Chat, onDelta ChatStreamFn) (*Chat, *ChatMessage, *ChatReplyMetadata, error) {
+	api.logger.Info("sending chat request", zap.Any("chat", chat), zap.Bool("stream", onDelta != nil))
+
+	ctx, cancel := api.routeContext(ctx)
+	defer cancel()
+
+	api.compactChat(ctx, chat)
+
+	release, err := api.acquire(ctx)
+	if err != nil {
+		api.logger.Error("failed to wait for a free slot", zap.Error(err))
+
+		return nil, nil, nil, err
+	}
+	// Slot may be released and acquired again, while the chat is compacted
+	defer func() {
+		release()
+	}()
+
+	var stream *filteredStream
+	if onDelta != nil && len(api.replyFilters) > 0 {
+		stream = &filteredStream{filters: api.replyFilters, onDelta: onDelta}
+		onDelta = stream.Handle
+	}
 
 	measure := metrics.NewMeasure()
 	measure.Start()
-	response, metadata, err := api.adapter.Chat(ctx, chat)
+	request, syncMetadata := api.requestChat(ctx, chat)
+	response, metadata, err := api.doChat(ctx, request, onDelta)
+	syncMetadata()
+	var tooLongError ErrPromptTooLong
+	if api.compactor != nil && goerrors.As(err, &tooLongError) {
+		// Budget is larger than the model context window, compact regardless of it and try again
+		api.logger.Warn("prompt too long, compacting chat", zap.Error(err))
+
+		// Summary may be sent through the same scheduler, so the slot is not held while waiting for it
+		release()
+		release = func() {}
+		compacted, compactErr := api.compactor.compact(ctx, chat, api.adapter)
+		if compactErr != nil {
+			api.logger.Error("failed to compact chat", zap.Error(compactErr))
+		} else if compacted {
+			release, err = api.acquire(ctx)
+			if err == nil {
+				request, syncMetadata = api.requestChat(ctx, chat)
+				response, metadata, err = api.doChat(ctx, request, onDelta)
+				syncMetadata()
+			}
+		}
+	}
+	if err == nil && stream != nil {
+		err = stream.Flush()
+	}
 	measure.End()
 
 	api.logger.Info("chat request finished", zap.Duration("duration", measure.Duration()), zap.Any("response", response))
@@ -45,16 +151,168 @@ func (api *API) Chat(ctx context.Context, chat *Chat) (*Chat, *ChatMessage, *Cha
 		return nil, nil, nil, err
 	}
 
+	var backend string
+	var usage *TokenUsage
+	if metadata != nil {
+		backend, usage = metadata.Backend, metadata.Usage
+	}
+	api.recordUsage(ctx, backend, usage, func() TokenUsage {
+		return estimateChatUsage(chat, response)
+	})
+
+	api.filterReply(response)
 	chat.AddMessages(response)
 
 	return chat, response, metadata, nil
 }
 
+// requestChat returns a copy of the chat, that is sent to the adapter, so that the chat itself keeps the original messages.
+// Relevant memories are injected into the copy, and its messages are filtered. Metadata is copied as well,
+// and returned function applies changes made by the adapter, e.g. the thread ID, to the chat.
+func (api *API) requestChat(ctx context.Context, chat *Chat) (*Chat, func()) {
+	chat.mu.Lock()
+	snapshot := maps.Clone(chat.Metadata)
+	request := &Chat{
+		Messages:   make([]*ChatMessage, 0, len(chat.Messages)),
+		Metadata:   maps.Clone(chat.Metadata),
+		Tools:      chat.Tools,
+		messageIds: slices.Clone(chat.messageIds),
+	}
+	for _, message := range chat.Messages {
+		requestMessage := *message
+		request.Messages = append(request.Messages, &requestMessage)
+	}
+	chat.mu.Unlock()
+
+	// Memories are injected before filtering, so that filters apply to them as well
+	api.injectMemories(ctx, request)
+	if len(api.requestFilters) > 0 {
+		for _, message := range request.Messages {
+			message.Contents = api.filterText(api.requestFilters, message.Contents, "request")
+			message.Files = api.filterFiles(message.Files)
+		}
+	}
+
+	return request, func() {
+		request.mu.Lock()
+		defer request.mu.Unlock()
+		chat.mu.Lock()
+		defer chat.mu.Unlock()
+
+		// Only keys changed by the adapter are applied, so that changes made to the chat in the meantime are kept
+		for key, value := range request.Metadata {
+			if previous, ok := snapshot[key]; !ok || previous != value {
+				chat.Metadata[key] = value
+			}
+		}
+		for key := range snapshot {
+			if _, ok := request.Metadata[key]; !ok {
+				delete(chat.Metadata, key)
+			}
+		}
+	}
+}
+
+// filterFiles filters contents of text files, other files are sent as they are
+func (api *API) filterFiles(files []File) []File {
+	if len(files) == 0 || len(api.requestFilters) == 0 {
+		return files
+	}
+
+	filtered := make([]File, 0, len(files))
+	for _, file := range files {
+		if isTextFile(file) {
+			file.Data = []byte(api.filterText(api.requestFilters, string(file.Data), "request"))
+		}
+		filtered = append(filtered, file)
+	}
+
+	return filtered
+}
+
+// filterReply filters contents and reasoning of the reply, that are both shown to users
+func (api *API) filterReply(reply *ChatMessage) {
+	if len(api.replyFilters) == 0 {
+		return
+	}
+
+	reply.Contents = api.filterText(api.replyFilters, reply.Contents, "reply")
+	if thinking, ok := reply.Metadata[ThinkingMetadataKey]; ok {
+		reply.Metadata[ThinkingMetadataKey] = api.filterText(api.replyFilters, thinking, "reply")
+	}
+}
+
+// filterText applies the filters and logs which of them changed the text. Filtered text itself is never logged.
+func (api *API) filterText(filters []TextFilter, text string, direction string) string {
+	return applyFilters(filters, text, func(filter TextFilter, changes int) {
+		api.logger.Info("filter changed text", zap.String("filter", filter.Name()), zap.String("direction", direction), zap.Int("changes", changes))
+	})
+}
+
+// injectMemories adds relevant memories to the request chat. Chat is sent without them, if they can't be found.
+func (api *API) injectMemories(ctx context.Context, chat *Chat) {
+	if api.memoryStore == nil {
+		return
+	}
+
+	err := api.memoryStore.InjectMemories(ctx, chat)
+	if err != nil {
+		api.logger.Error("failed to inject memories", zap.Error(err))
+	}
+}
+
+// compactChat compacts the chat if it exceeds the token budget. Failed compaction does not prevent sending the chat.
+func (api *API) compactChat(ctx context.Context, chat *Chat) {
+	if api.compactor == nil {
+		return
+	}
+
+	_, err := api.compactor.Compact(ctx, chat, api.adapter)
+	if err != nil {
+		api.logger.Error("failed to compact chat", zap.Error(err))
+	}
+}
+
+// doChat sends the chat using streaming, if both caller and the adapter support it
+func (api *API) doChat(ctx context.Context, chat *Chat, onDelta ChatStreamFn) (*ChatMessage, *ChatReplyMetadata, error) {
+	if onDelta == nil {
+		return api.adapter.Chat(ctx, chat)
+	}
+
+	if streamingAdapter, ok := api.adapter.(StreamingAdapter); ok {
+		return streamingAdapter.ChatStream(ctx, chat, onDelta)
+	}
+
+	response, metadata, err := api.adapter.Chat(ctx, chat)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = onDelta(response.Contents)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return response, metadata, nil
+}
+
 // Prompt sends a request to the LLM with the given prompt
 func (api *API) Prompt(ctx context.Context, prompt Prompt) (*PromptResponse, *PromptReplyMetadata, error) {
-	log := api.logger.With(zap.String("prompt", prompt.Phrase), zap.String("traits", prompt.Traits), zap.Bool("hasFiles", len(prompt.Files) > 0))
+	log := api.logger.With(zap.String("prompt", prompt.Phrase), zap.String("traits", prompt.Traits), zap.String("template", prompt.Template), zap.Bool("hasFiles", len(prompt.Files) > 0))
 	log.Info("sending prompt request")
 
+	ctx, cancel := api.routeContext(ctx)
+	defer cancel()
+
+	release, err := api.acquire(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer release()
+
+	prompt.Phrase = api.filterText(api.requestFilters, prompt.Phrase, "request")
+	prompt.Files = api.filterFiles(prompt.Files)
+
 	measure := metrics.NewMeasure()
 	measure.Start()
 	response, metadata, err := api.adapter.Prompt(ctx, prompt)
@@ -66,10 +324,91 @@ func (api *API) Prompt(ctx context.Context, prompt Prompt) (*PromptResponse, *Pr
 		return nil, metadata, err
 	}
 
+	var backend string
+	var usage *TokenUsage
+	if metadata != nil {
+		backend, usage = metadata.Backend, metadata.Usage
+	}
+	api.recordUsage(ctx, backend, usage, func() TokenUsage {
+		return estimatePromptUsage(prompt, response)
+	})
+
 	promptResponse := &PromptResponse{
-		Reply:    response,
+		Reply:    api.filterText(api.replyFilters, response, "reply"),
 		Duration: measure.Duration().Seconds(),
 	}
 
 	return promptResponse, metadata, nil
 }
+
+// UpdateMessage changes contents of the message, e.g. after it was edited. Server-side history of the chat is reset,
+// so that the edited message is sent again in its place. Returns false if the chat has no such message.
+func (api *API) UpdateMessage(ctx context.Context, chat *Chat, messageID string, contents string) (bool, error) {
+	if !chat.UpdateMessage(messageID, contents) {
+		return false, nil
+	}
+
+	api.logger.Info("updated chat message", zap.String("messageID", messageID))
+
+	historyAdapter, ok := api.adapter.(HistoryAdapter)
+	if !ok {
+		return true, nil
+	}
+
+	return true, historyAdapter.ResetHistory(ctx, chat)
+}
+
+// DeleteMessage removes the message from the chat, and from its server-side history. Returns false if the chat has no such message.
+func (api *API) DeleteMessage(ctx context.Context, chat *Chat, messageID string) (bool, error) {
+	if !chat.DeleteMessage(messageID) {
+		return false, nil
+	}
+
+	api.logger.Info("deleted chat message", zap.String("messageID", messageID))
+
+	historyAdapter, ok := api.adapter.(HistoryAdapter)
+	if !ok {
+		return true, nil
+	}
+
+	return true, historyAdapter.DeleteMessage(ctx, chat, messageID)
+}
+
+// Embed returns embedding vector of every input, if the adapter supports embeddings
+func (api *API) Embed(ctx context.Context, inputs []string) ([][]float32, error) {
+	embeddingAdapter, ok := api.adapter.(EmbeddingAdapter)
+	if !ok {
+		return nil, ErrEmbeddingsNotSupported
+	}
+
+	release, err := api.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	return embeddingAdapter.Embed(ctx, inputs)
+}
+
+// recordUsage records usage reported by the adapter, or estimated one if the adapter did not report it
+func (api *API) recordUsage(ctx context.Context, backend string, usage *TokenUsage, estimate func() TokenUsage) {
+	if api.usageTracker == nil {
+		return
+	}
+
+	if usage == nil {
+		estimatedUsage := estimate()
+		usage = &estimatedUsage
+	}
+
+	api.usageTracker.Record(ctx, api.name, backend, *usage)
+}
+
+// acquire waits for a free slot in the scheduler, if the API has one
+func (api *API) acquire(ctx context.Context) (func(), error) {
+	if api.scheduler == nil {
+		return func() {}, nil
+	}
+
+	return api.scheduler.Acquire(ctx)
+}
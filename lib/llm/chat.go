@@ -1,10 +1,13 @@
 package llm
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/bwmarrin/discordgo"
 	"lib/discord"
 	"lib/util/arrayutil"
+	"maps"
+	"slices"
 	"sync"
 )
 
@@ -100,17 +103,27 @@ func (c *ChatMessage) ChatMessage() string {
 type ChatReplyMetadata struct {
 	IsWorthRemembering bool
 	IsGoodbye          bool
+	// Backend is a name of the backend that replied, set by FailoverAdapter
+	Backend string
+	// Usage is set by adapters that report token usage
+	Usage *TokenUsage
 }
 
 type PromptReplyMetadata struct {
 	// HasMemoryReference indicates whether the requested prompt triggered a memory reference.
 	HasMemoryReference *bool
+	// Backend is a name of the backend that replied, set by FailoverAdapter
+	Backend string
+	// Usage is set by adapters that report token usage
+	Usage *TokenUsage
 }
 
 type Chat struct {
 	mu       sync.Mutex
 	Messages []*ChatMessage    `json:"messages"`
 	Metadata map[string]string `json:"metadata"`
+	// Tools optionally contains tools that LLM can call while replying
+	Tools *ToolRegistry `json:"-"`
 
 	messageIds []string
 }
@@ -124,9 +137,64 @@ func NewChat() *Chat {
 }
 
 func (c *Chat) AddMetadata(key string, value string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	c.Metadata[key] = value
 }
 
+// Snapshot returns a copy of the chat, that can be e.g. persisted while the chat goes on. Files are left out,
+// since their data can be large, and they were already sent with the messages they are attached to.
+func (c *Chat) Snapshot() *Chat {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	snapshot := &Chat{
+		Messages:   make([]*ChatMessage, 0, len(c.Messages)),
+		Metadata:   maps.Clone(c.Metadata),
+		Tools:      c.Tools,
+		messageIds: slices.Clone(c.messageIds),
+	}
+	for _, message := range c.Messages {
+		copied := *message
+		copied.Metadata = maps.Clone(message.Metadata)
+		copied.Files = nil
+		snapshot.Messages = append(snapshot.Messages, &copied)
+	}
+
+	return snapshot
+}
+
+// UnmarshalJSON restores the chat, including IDs of its messages that prevent adding them twice
+func (c *Chat) UnmarshalJSON(data []byte) error {
+	var decoded struct {
+		Messages []*ChatMessage    `json:"messages"`
+		Metadata map[string]string `json:"metadata"`
+	}
+	err := json.Unmarshal(data, &decoded)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.Messages = make([]*ChatMessage, 0, len(decoded.Messages))
+	c.Metadata = decoded.Metadata
+	if c.Metadata == nil {
+		c.Metadata = make(map[string]string)
+	}
+	c.messageIds = make([]string, 0, len(decoded.Messages))
+	for _, message := range decoded.Messages {
+		c.Messages = append(c.Messages, message)
+		if message.ID != "" {
+			c.messageIds = append(c.messageIds, message.ID)
+		}
+	}
+
+	return nil
+}
+
 func (c *Chat) AddMessages(messages ...*ChatMessage) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -143,3 +211,54 @@ func (c *Chat) AddMessages(messages ...*ChatMessage) {
 		}
 	}
 }
+
+// FindMessage returns the message with the given ID
+func (c *Chat) FindMessage(ID string) (*ChatMessage, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return arrayutil.Find(c.Messages, func(message *ChatMessage) bool {
+		return message.ID == ID
+	})
+}
+
+// UpdateMessage replaces contents of the message with the given ID. Returns false if the chat has no such message,
+// or its contents did not change.
+func (c *Chat) UpdateMessage(ID string, contents string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	message, ok := arrayutil.Find(c.Messages, func(message *ChatMessage) bool {
+		return message.ID == ID
+	})
+	if !ok || message.Contents == contents {
+		return false
+	}
+
+	message.Contents = contents
+
+	return true
+}
+
+// DeleteMessage removes the message with the given ID. Returns false if the chat has no such message.
+func (c *Chat) DeleteMessage(ID string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	length := len(c.Messages)
+	c.Messages = slices.DeleteFunc(c.Messages, func(message *ChatMessage) bool {
+		return message.ID == ID
+	})
+	c.messageIds = slices.DeleteFunc(c.messageIds, func(messageID string) bool {
+		return messageID == ID
+	})
+
+	return len(c.Messages) < length
+}
+
+// LastUserMessage returns the most recent message sent by the user
+func (c *Chat) LastUserMessage() (*ChatMessage, bool) {
+	return arrayutil.FindLast(c.Messages, func(message *ChatMessage) bool {
+		return message.Role == ChatRoleUser
+	})
+}
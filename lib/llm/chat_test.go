@@ -0,0 +1,42 @@
+package llm_test
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"lib/llm"
+	"testing"
+)
+
+func TestAPISyncsEditedMessages(t *testing.T) {
+	ctx := context.Background()
+	adapter := llm.NewScriptedAdapter(llm.ScriptedRule{Reply: "ok"})
+	api := llm.NewAPI(adapter, "scripted")
+
+	chat := llm.NewChat()
+	chat.AddMessages(
+		llm.NewUserChatMessage("I lvoe pizza", "1", "Wojtek"),
+		llm.NewUserChatMessage("something embarrassing", "2", "Wojtek"),
+	)
+
+	t.Run("updates edited message", func(t *testing.T) {
+		updated, err := api.UpdateMessage(ctx, chat, "1", "I love pizza")
+		assert.NoError(t, err)
+		assert.True(t, updated)
+		assert.Equal(t, "I love pizza", chat.Messages[0].Contents)
+
+		updated, err = api.UpdateMessage(ctx, chat, "1", "I love pizza")
+		assert.NoError(t, err)
+		assert.False(t, updated)
+	})
+
+	t.Run("deletes message", func(t *testing.T) {
+		deleted, err := api.DeleteMessage(ctx, chat, "2")
+		assert.NoError(t, err)
+		assert.True(t, deleted)
+		assert.Len(t, chat.Messages, 1)
+
+		_, _, _, err = api.Chat(ctx, chat)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"I love pizza"}, adapter.Requests())
+	})
+}
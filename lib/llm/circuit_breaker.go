@@ -0,0 +1,79 @@
+package llm
+
+import (
+	"sync"
+	"time"
+)
+
+// circuitBreaker stops sending requests to a backend after it failed too many times in a row.
+// After cooldown passes, one request is let through to check if the backend has recovered.
+type circuitBreaker struct {
+	mu sync.Mutex
+	// failureThreshold is a number of consecutive failures after which the breaker opens
+	failureThreshold int
+	// cooldown is a duration for which the breaker stays open
+	cooldown time.Duration
+	failures int
+	openedAt *time.Time
+}
+
+func newCircuitBreaker(failureThreshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		failureThreshold: failureThreshold,
+		cooldown:         cooldown,
+	}
+}
+
+// Allow returns true if a request can be sent to the backend
+func (b *circuitBreaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.openedAt == nil {
+		return true
+	}
+
+	if time.Since(*b.openedAt) < b.cooldown {
+		return false
+	}
+
+	// Cooldown has passed, let the next request through. If it fails, the breaker is opened again.
+	now := time.Now()
+	b.openedAt = &now
+
+	return true
+}
+
+// Success closes the breaker
+func (b *circuitBreaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+	b.openedAt = nil
+}
+
+// Failure records a failure, and returns true if it caused the breaker to open
+func (b *circuitBreaker) Failure() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+
+	if b.failures >= b.failureThreshold {
+		now := time.Now()
+		b.openedAt = &now
+
+		return true
+	}
+
+	return false
+}
+
+// IsOpen returns true if requests are currently not sent to the backend
+func (b *circuitBreaker) IsOpen() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.openedAt != nil && time.Since(*b.openedAt) < b.cooldown
+}
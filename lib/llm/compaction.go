@@ -0,0 +1,132 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"go.uber.org/zap"
+	"lib/errors"
+	"strings"
+	openaiutil "wojciech-bot/openai"
+)
+
+// compactionSummaryMetadataKey marks the system message that contains summary of compacted messages
+const compactionSummaryMetadataKey = "compactionSummary"
+
+// CompactionOptions configures Compactor
+type CompactionOptions struct {
+	// TokenBudget is a number of tokens after which the oldest messages of the chat are summarized
+	TokenBudget int32
+	// KeepRecentMessages is a number of the most recent messages that are always kept verbatim
+	KeepRecentMessages int
+	// Encoding used to count tokens, e.g. o200k_base
+	Encoding string
+}
+
+type compactionVars struct {
+	Discussion string
+}
+
+// Compactor keeps long chats within the token budget, by replacing the oldest messages with their summary
+type Compactor struct {
+	// summarizer is an API used to summarize the oldest messages, so that summaries are scheduled and their usage is recorded
+	summarizer *API
+	options    CompactionOptions
+	log        *zap.Logger
+}
+
+func NewCompactor(summarizer *API, options CompactionOptions) *Compactor {
+	return &Compactor{
+		summarizer: summarizer,
+		options:    options,
+		log:        logger.Named("compactor"),
+	}
+}
+
+// Compact summarizes the oldest messages of the chat, if it exceeds the token budget. History of the chat kept on the
+// server by the adapter is reset afterwards. Returns true if the chat was compacted.
+func (c *Compactor) Compact(ctx context.Context, chat *Chat, adapter Adapter) (bool, error) {
+	tokens, err := openaiutil.CountTokens(chatContents(chat.Messages), c.options.Encoding)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to count tokens")
+	}
+
+	if tokens <= c.options.TokenBudget {
+		return false, nil
+	}
+
+	c.log.Info("chat exceeds token budget", zap.Int32("tokens", tokens), zap.Int32("budget", c.options.TokenBudget))
+
+	return c.compact(ctx, chat, adapter)
+}
+
+// compact summarizes all messages except the most recent ones, regardless of the token budget
+func (c *Compactor) compact(ctx context.Context, chat *Chat, adapter Adapter) (bool, error) {
+	ctx = withUsageFeature(ctx, FeatureCompaction)
+
+	chat.mu.Lock()
+	messages := append([]*ChatMessage{}, chat.Messages...)
+	chat.mu.Unlock()
+
+	var instructions, toSummarize, recent []*ChatMessage
+	recentStart := max(len(messages)-c.options.KeepRecentMessages, 0)
+	for i, message := range messages {
+		switch {
+		case i >= recentStart:
+			recent = append(recent, message)
+		case message.Role == ChatRoleSystem && message.Metadata[compactionSummaryMetadataKey] == "":
+			// Instructions are not part of the discussion, they are kept as they are
+			instructions = append(instructions, message)
+		default:
+			toSummarize = append(toSummarize, message)
+		}
+	}
+
+	// Previous summary alone is not worth summarizing again
+	if len(toSummarize) < 2 {
+		return false, nil
+	}
+
+	prompt, err := RenderPrompt("compaction", compactionVars{Discussion: chatContents(toSummarize)})
+	if err != nil {
+		return false, err
+	}
+
+	reply, _, err := c.summarizer.Prompt(ctx, prompt)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to summarize messages")
+	}
+
+	summary := NewChatMessage(fmt.Sprintf("Summary of the earlier part of the discussion: %s", reply.Reply), ChatRoleSystem)
+	summary.AddMetadata(compactionSummaryMetadataKey, "true")
+
+	chat.mu.Lock()
+	// Messages added while the summary was generated are kept as well
+	added := chat.Messages[len(messages):]
+	chat.Messages = append(append(append(instructions, summary), recent...), added...)
+	chat.mu.Unlock()
+
+	// Server-side assistant thread contains the whole history, so it is deleted and recreated from the compacted chat
+	if historyAdapter, ok := adapter.(HistoryAdapter); ok {
+		err = historyAdapter.ResetHistory(ctx, chat)
+		if err != nil {
+			c.log.Error("failed to reset history of the compacted chat", zap.Error(err))
+		}
+	}
+	chat.mu.Lock()
+	delete(chat.Metadata, threadIdMetadataKey)
+	chat.mu.Unlock()
+
+	c.log.Info("chat compacted", zap.Int("summarizedMessages", len(toSummarize)), zap.Int("keptMessages", len(recent)))
+
+	return true, nil
+}
+
+func chatContents(messages []*ChatMessage) string {
+	var contents strings.Builder
+	for _, message := range messages {
+		contents.WriteString(message.ChatMessage())
+		contents.WriteString("\n")
+	}
+
+	return contents.String()
+}
@@ -0,0 +1,69 @@
+package llm_test
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"lib/llm"
+	"lib/storage"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// historyAdapter records IDs of threads, whose history was reset
+type historyAdapter struct {
+	llm.ScriptedAdapter
+	resetThreadIDs []string
+}
+
+func (a *historyAdapter) DeleteMessage(ctx context.Context, chat *llm.Chat, messageID string) error {
+	return nil
+}
+
+func (a *historyAdapter) ResetHistory(ctx context.Context, chat *llm.Chat) error {
+	a.resetThreadIDs = append(a.resetThreadIDs, chat.Metadata[llm.ThreadIdMetadataKey])
+	return nil
+}
+
+func TestCompactor(t *testing.T) {
+	db, err := storage.Open(filepath.Join(t.TempDir(), "test.db"))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	tracker, err := llm.NewUsageTracker(db, nil)
+	assert.NoError(t, err)
+
+	summarizer := llm.NewAPI(llm.NewScriptedAdapter(llm.ScriptedRule{Reply: "Wojtek and Artur talked about guitars"}), "summarizer")
+	summarizer.WithUsageTracker(tracker)
+	compactor := llm.NewCompactor(summarizer, llm.CompactionOptions{
+		TokenBudget:        96_000,
+		KeepRecentMessages: 1,
+		Encoding:           "o200k_base",
+	})
+
+	chat := llm.NewChat()
+	chat.Metadata[llm.ThreadIdMetadataKey] = "thread_1"
+	chat.AddMessages(
+		llm.NewUserChatMessage("do you play the guitar?", "1", "Wojtek"),
+		llm.NewChatMessage("I do", llm.ChatRoleAssistant),
+		llm.NewUserChatMessage("what do you play?", "2", "Artur"),
+	)
+
+	ctx := llm.WithUsageContext(context.Background(), llm.FeatureChat, "2")
+	adapter := &historyAdapter{}
+	compacted, err := compactor.CompactNow(ctx, chat, adapter)
+	assert.NoError(t, err)
+	assert.True(t, compacted)
+
+	assert.Len(t, chat.Messages, 2)
+	assert.Contains(t, chat.Messages[0].Contents, "Wojtek and Artur talked about guitars")
+	assert.Equal(t, "what do you play?", chat.Messages[1].Contents)
+	assert.NotContains(t, chat.Metadata, llm.ThreadIdMetadataKey)
+	assert.Equal(t, []string{"thread_1"}, adapter.resetThreadIDs)
+
+	totals, err := tracker.Totals(time.Now(), time.Now())
+	assert.NoError(t, err)
+	assert.Len(t, totals, 1)
+	assert.Equal(t, llm.FeatureCompaction, totals[0].Feature)
+	assert.Equal(t, "2", totals[0].UserID)
+}
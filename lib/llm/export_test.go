@@ -0,0 +1,15 @@
+package llm
+
+import "context"
+
+// Unexported helpers exposed to tests in llm_test
+var PartialJSONStringField = partialJSONStringField
+
+const ThreadIdMetadataKey = threadIdMetadataKey
+
+// CompactNow compacts the chat regardless of the token budget, that can't be counted without downloading the encoding
+func (c *Compactor) CompactNow(ctx context.Context, chat *Chat, adapter Adapter) (bool, error) {
+	return c.compact(ctx, chat, adapter)
+}
+
+var ModelOptionsFrom = modelOptionsFrom
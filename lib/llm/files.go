@@ -1,5 +1,11 @@
 package llm
 
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
 type File struct {
 	Data        []byte
 	Name        string
@@ -13,3 +19,13 @@ func NewFile(data []byte, name string, contentType string) *File {
 		ContentType: contentType,
 	}
 }
+
+// IsImage returns true if the file is an image, based on its content type
+func (f *File) IsImage() bool {
+	return strings.HasPrefix(f.ContentType, "image/")
+}
+
+// DataURL returns the file encoded as a base64 data URL
+func (f *File) DataURL() string {
+	return fmt.Sprintf("data:%s;base64,%s", f.ContentType, base64.StdEncoding.EncodeToString(f.Data))
+}
@@ -0,0 +1,235 @@
+package llm
+
+import (
+	"fmt"
+	"lib/discord"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// TextFilter changes text sent to, or received from the LLM, e.g. to redact personal data
+type TextFilter interface {
+	// Name identifies the filter in logs
+	Name() string
+	// Filter returns the filtered text, and a number of changes made
+	Filter(text string) (string, int)
+}
+
+// filterRule replaces every match of the pattern with the replacement, that may refer to submatches like $1
+type filterRule struct {
+	pattern     *regexp.Regexp
+	replacement string
+}
+
+// RegexpFilter applies rules to the text in order
+type RegexpFilter struct {
+	name  string
+	rules []filterRule
+}
+
+func (f *RegexpFilter) Name() string {
+	return f.name
+}
+
+func (f *RegexpFilter) Filter(text string) (string, int) {
+	changes := 0
+	for _, rule := range f.rules {
+		matches := len(rule.pattern.FindAllStringIndex(text, -1))
+		if matches == 0 {
+			continue
+		}
+
+		text = rule.pattern.ReplaceAllString(text, rule.replacement)
+		changes += matches
+	}
+
+	return text, changes
+}
+
+// NewPIIFilter redacts e-mail addresses, phone, bank account and card numbers, and PESEL numbers
+func NewPIIFilter() *RegexpFilter {
+	return &RegexpFilter{
+		name: "pii",
+		rules: []filterRule{
+			{regexp.MustCompile(`[\w.+-]+@[\w-]+(\.[\w-]+)*\.[A-Za-z]{2,}`), "[email]"},
+			// Longer numbers first, so that they are not partially redacted as phone numbers
+			{regexp.MustCompile(`\b(PL ?)?\d{2}( ?\d{4}){6}\b`), "[account]"},
+			{regexp.MustCompile(`\b(\d{4}[ -]?){3}\d{4}\b`), "[card]"},
+			{regexp.MustCompile(`\b\d{11}\b`), "[pesel]"},
+			{regexp.MustCompile(`(\+\d{2} ?)?\b\d{3}[ -]?\d{3}[ -]?\d{3}\b`), "[phone]"},
+		},
+	}
+}
+
+// NewSecretFilter redacts API keys, tokens, private keys and passwords pasted by users
+func NewSecretFilter() *RegexpFilter {
+	return &RegexpFilter{
+		name: "secret",
+		rules: []filterRule{
+			{regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`), "[secret]"},
+			// OpenAI
+			{regexp.MustCompile(`\bsk-[\w-]{20,}`), "[secret]"},
+			// Discord bot token
+			{regexp.MustCompile(`\b[MNO][\w-]{23,27}\.[\w-]{6}\.[\w-]{27,}`), "[secret]"},
+			// GitHub
+			{regexp.MustCompile(`\b(gh[pousr]_[A-Za-z0-9]{36,}|github_pat_\w{22,})`), "[secret]"},
+			// AWS access key
+			{regexp.MustCompile(`\bAKIA[0-9A-Z]{16}\b`), "[secret]"},
+			// Google API key
+			{regexp.MustCompile(`\bAIza[\w-]{35}`), "[secret]"},
+			// Slack
+			{regexp.MustCompile(`\bxox[abprs]-[A-Za-z0-9-]{10,}`), "[secret]"},
+			// JWT
+			{regexp.MustCompile(`\beyJ[\w-]{10,}\.eyJ[\w-]{10,}\.[\w-]{10,}`), "[secret]"},
+			{regexp.MustCompile(`(?i)\b(password|passwd|hasło|token|api[_-]?key|secret)(\s*[:=]\s*)[^\s\[]\S*`), "$1$2[secret]"},
+		},
+	}
+}
+
+// NewSurnameFilter replaces surnames of the members with their first names. Surnames are matched only when capitalized,
+// in their inflected forms, e.g. Czarneckiego or Wieczorka, so that common words like "górny" are left as they are.
+func NewSurnameFilter(members map[string]discord.MemberDefinition) *RegexpFilter {
+	filter := &RegexpFilter{name: "surname"}
+	// Sorted, so that members are always replaced in the same order
+	for _, id := range slices.Sorted(maps.Keys(members)) {
+		member := members[id]
+		if member.LastName == "" || member.LastName == member.FirstName {
+			continue
+		}
+
+		var forms []string
+		for _, form := range surnameForms(member.LastName) {
+			forms = append(forms, regexp.QuoteMeta(form))
+		}
+
+		// \b matches only ASCII letters, so word boundaries are checked explicitly
+		firstName, lastName := regexp.QuoteMeta(member.FirstName), strings.Join(forms, "|")
+		filter.rules = append(filter.rules,
+			filterRule{regexp.MustCompile(fmt.Sprintf(`(^|[^\p{L}])(?i:%s)\s+(?:%s)([^\p{L}]|$)`, firstName, lastName)), "${1}" + member.FirstName + "${2}"},
+			filterRule{regexp.MustCompile(fmt.Sprintf(`(^|[^\p{L}])(?:%s)([^\p{L}]|$)`, lastName)), "${1}" + member.FirstName + "${2}"},
+		)
+	}
+
+	return filter
+}
+
+// surnameForms returns the surname with its inflected forms, the longest first. Adjectival surnames, like Czarnecki
+// or Górny, are inflected as adjectives of both genders, and the other ones as nouns, including the fleeting e of -ek.
+func surnameForms(lastName string) []string {
+	var stem string
+	var suffixes []string
+	switch {
+	case strings.HasSuffix(lastName, "i"):
+		stem = strings.TrimSuffix(lastName, "i")
+		suffixes = []string{"i", "iego", "iemu", "im", "ich", "imi", "a", "iej", "ą"}
+	case strings.HasSuffix(lastName, "y"):
+		stem = strings.TrimSuffix(lastName, "y")
+		suffixes = []string{"y", "ego", "emu", "ym", "ych", "ymi", "a", "ej", "ą"}
+	case strings.HasSuffix(lastName, "a"):
+		stem = strings.TrimSuffix(lastName, "a")
+		suffixes = []string{"a", "y", "i", "ie", "iej", "ej", "ę", "ą", "o"}
+	default:
+		stem = lastName
+		suffixes = []string{"", "a", "owi", "em", "iem", "u", "ie", "owie", "ów", "om", "ami"}
+	}
+
+	forms := make([]string, 0, 2*len(suffixes))
+	for _, suffix := range suffixes {
+		forms = append(forms, stem+suffix)
+	}
+	if strings.HasSuffix(lastName, "ek") {
+		fleetingStem := strings.TrimSuffix(lastName, "ek") + "k"
+		for _, suffix := range []string{"a", "owi", "iem", "u", "i", "ów", "om", "ami"} {
+			forms = append(forms, fleetingStem+suffix)
+		}
+	}
+
+	slices.SortFunc(forms, func(a, b string) int {
+		if len(a) != len(b) {
+			return len(b) - len(a)
+		}
+
+		return strings.Compare(a, b)
+	})
+
+	return slices.Compact(forms)
+}
+
+// NewMentionFilter prevents the LLM from mentioning @everyone, @here and roles
+func NewMentionFilter() *RegexpFilter {
+	return &RegexpFilter{
+		name: "mention",
+		rules: []filterRule{
+			// Zero width space keeps the text readable, but Discord no longer treats it as a mention
+			{regexp.MustCompile(`@(everyone|here)\b`), "@\u200b$1"},
+			{regexp.MustCompile(`<@&\d+>`), "[role]"},
+		},
+	}
+}
+
+// NewInviteLinkFilter removes Discord invite links from replies of the LLM
+func NewInviteLinkFilter() *RegexpFilter {
+	return &RegexpFilter{
+		name: "invite",
+		rules: []filterRule{
+			{regexp.MustCompile(`(?i)(https?://)?(www\.)?(discord\.gg|discord(app)?\.com/invite)/[\w-]+`), "[invite]"},
+		},
+	}
+}
+
+// applyFilters runs the text through all filters, calling onChange for every filter that changed it
+func applyFilters(filters []TextFilter, text string, onChange func(filter TextFilter, changes int)) string {
+	for _, filter := range filters {
+		var changes int
+		text, changes = filter.Filter(text)
+		if changes > 0 && onChange != nil {
+			onChange(filter, changes)
+		}
+	}
+
+	return text
+}
+
+// isTextFile returns true if the file contents can be filtered as text
+func isTextFile(file File) bool {
+	return strings.HasPrefix(file.ContentType, "text/") || strings.HasPrefix(file.ContentType, "application/json")
+}
+
+// filteredStream holds streamed text until the end of the current word, so that filters see whole mentions and links
+type filteredStream struct {
+	filters []TextFilter
+	onDelta ChatStreamFn
+	pending string
+}
+
+func (s *filteredStream) Handle(delta string) error {
+	text := s.pending + delta
+	end := strings.LastIndexFunc(text, unicode.IsSpace)
+	if end < 0 {
+		s.pending = text
+
+		return nil
+	}
+
+	_, size := utf8.DecodeRuneInString(text[end:])
+	end += size
+	s.pending = text[end:]
+
+	return s.onDelta(applyFilters(s.filters, text[:end], nil))
+}
+
+// Flush passes the rest of the text, once the stream has ended
+func (s *filteredStream) Flush() error {
+	if s.pending == "" {
+		return nil
+	}
+
+	pending := s.pending
+	s.pending = ""
+
+	return s.onDelta(applyFilters(s.filters, pending, nil))
+}
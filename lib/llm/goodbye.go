@@ -0,0 +1,51 @@
+package llm
+
+import "context"
+
+// GoodbyeReply is a reply to the goodbye prompt
+type GoodbyeReply struct {
+	IsGoodbye bool `json:"is_goodbye" description:"true if the message is a goodbye, or prompt to end the discussion"`
+}
+
+type goodbyeVars struct {
+	Message string
+}
+
+// NewGoodbyePrompt creates a prompt that checks if the message is a goodbye, or prompt to end the discussion.
+// It should be sent with PromptJSON, that decodes the reply into GoodbyeReply.
+func NewGoodbyePrompt(messageContent string) (Prompt, error) {
+	return RenderPrompt("goodbye", goodbyeVars{Message: messageContent})
+}
+
+// detectGoodbye asks the adapter if the last user message in the chat is a goodbye.
+// It is used to fill ChatReplyMetadata for adapters that do not return it. Returned usage is summed over all attempts,
+// and is nil if nothing was sent.
+func detectGoodbye(ctx context.Context, adapter Adapter, chat *Chat) (bool, *TokenUsage, error) {
+	lastUserMessage, ok := chat.LastUserMessage()
+	if !ok {
+		return false, nil, nil
+	}
+
+	prompt, err := NewGoodbyePrompt(lastUserMessage.Contents)
+	if err != nil {
+		return false, nil, err
+	}
+
+	usage := &TokenUsage{}
+	reply, err := promptJSON[GoodbyeReply](ctx, func(ctx context.Context, p Prompt) (string, error) {
+		reply, metadata, err := adapter.Prompt(ctx, p)
+		if metadata != nil && metadata.Usage != nil {
+			usage.Add(metadata.Usage)
+		} else if err == nil {
+			estimated := estimatePromptUsage(p, reply)
+			usage.Add(&estimated)
+		}
+
+		return reply, err
+	}, prompt)
+	if err != nil {
+		return false, usage, err
+	}
+
+	return reply.IsGoodbye, usage, nil
+}
@@ -0,0 +1,41 @@
+package llm
+
+import "go.uber.org/zap"
+
+// ImageOptions limit images sent to the LLM
+type ImageOptions struct {
+	// MaxImages is a maximum number of images in a single message, the rest is skipped
+	MaxImages int
+	// MaxImageBytes is a maximum size of a single image, larger images are skipped
+	MaxImageBytes int
+}
+
+var DefaultImageOptions = ImageOptions{
+	MaxImages:     4,
+	MaxImageBytes: 5 * 1024 * 1024,
+}
+
+// limitImages returns images from the files that fit into the limits
+func limitImages(files []File, options ImageOptions) []File {
+	var images []File
+
+	for _, file := range files {
+		if !file.IsImage() {
+			continue
+		}
+
+		if len(file.Data) > options.MaxImageBytes {
+			logger.Warn("image is too large, skipping", zap.String("filename", file.Name), zap.Int("size", len(file.Data)))
+			continue
+		}
+
+		if len(images) == options.MaxImages {
+			logger.Warn("too many images, skipping", zap.String("filename", file.Name))
+			continue
+		}
+
+		images = append(images, file)
+	}
+
+	return images
+}
@@ -0,0 +1,330 @@
+package llm
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"go.uber.org/zap"
+	"lib/errors"
+	"lib/storage"
+	"math"
+	"slices"
+	"strings"
+	"time"
+)
+
+const memoryBucketName = "llm_memories"
+
+// memoriesMetadataKey marks the system message with memories injected into the chat
+const memoriesMetadataKey = "memories"
+
+// MemoryProvenance describes where the memory comes from, and who it is about
+type MemoryProvenance struct {
+	// Source is an ID of the Discord thread the memory comes from
+	Source string `json:"source"`
+	// Subjects are IDs of users the memory is about
+	Subjects []string `json:"subjects"`
+	// Author is an ID of the user, who started the discussion the memory comes from, empty if unknown
+	Author string `json:"author,omitempty"`
+	// Confidence of the model, that extracted the memory, from 0 to 1
+	Confidence float64   `json:"confidence"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// IsAbout reports whether the user is one of subjects of the memory
+func (p MemoryProvenance) IsAbout(userID string) bool {
+	return slices.Contains(p.Subjects, userID)
+}
+
+// Memory is a single fact remembered from discussions
+type Memory struct {
+	ID        string    `json:"id"`
+	Content   string    `json:"content"`
+	Embedding []float32 `json:"embedding"`
+	MemoryProvenance
+}
+
+// ScoredMemory is a memory found by Search, with its cosine similarity to the query
+type ScoredMemory struct {
+	Memory
+	Score float32
+}
+
+// MemoryStoreOptions configures MemoryStore
+type MemoryStoreOptions struct {
+	// TopK is a number of the most relevant memories injected into chats
+	TopK int
+	// MinScore is a minimal cosine similarity of memories injected into chats
+	MinScore float32
+	// MaxChunkLength is a maximal number of characters of a single memory
+	MaxChunkLength int
+}
+
+// MemoryStore keeps memories with their embeddings in the local database, as a replacement of the OpenAI vector store.
+// Search compares the query with every memory, which is fast enough for thousands of them.
+type MemoryStore struct {
+	memories *storage.Bucket[Memory]
+	// embedder is an API used to embed memories and queries
+	embedder *API
+	options  MemoryStoreOptions
+	log      *zap.Logger
+}
+
+func NewMemoryStore(db *storage.DB, embedder *API, options MemoryStoreOptions) (*MemoryStore, error) {
+	memories, err := storage.NewBucket[Memory](db, memoryBucketName)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MemoryStore{
+		memories: memories,
+		embedder: embedder,
+		options:  options,
+		log:      logger.Named("memory"),
+	}, nil
+}
+
+// Remember splits the content into chunks, and stores them with their embeddings. Chunks share the provenance of the content.
+func (s *MemoryStore) Remember(ctx context.Context, content string, provenance MemoryProvenance) ([]Memory, error) {
+	chunks := chunkMemory(content, s.options.MaxChunkLength)
+	if len(chunks) == 0 {
+		return nil, nil
+	}
+
+	embeddings, err := s.embedder.Embed(ctx, chunks)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to embed memories")
+	}
+
+	now := time.Now()
+	if provenance.CreatedAt.IsZero() {
+		provenance.CreatedAt = now
+	}
+
+	memories := make([]Memory, 0, len(chunks))
+	for i, chunk := range chunks {
+		memory := Memory{
+			// IDs are ordered by time they were stored, so that ForEach lists the oldest memories first
+			ID:               fmt.Sprintf("%019d-%03d", now.UnixNano(), i),
+			Content:          chunk,
+			Embedding:        embeddings[i],
+			MemoryProvenance: provenance,
+		}
+
+		err = s.memories.Put(memory.ID, memory)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to store memory")
+		}
+
+		memories = append(memories, memory)
+	}
+
+	s.log.Info("remembered", zap.Int("memories", len(memories)), zap.String("source", provenance.Source), zap.Strings("subjects", provenance.Subjects))
+
+	return memories, nil
+}
+
+// Search returns up to k memories the most similar to the query
+func (s *MemoryStore) Search(ctx context.Context, query string, k int) ([]ScoredMemory, error) {
+	return s.search(ctx, query, k, func(Memory) bool {
+		return true
+	})
+}
+
+// SearchAbout returns up to k memories about the user, the most similar to the query
+func (s *MemoryStore) SearchAbout(ctx context.Context, query string, userID string, k int) ([]ScoredMemory, error) {
+	return s.search(ctx, query, k, func(memory Memory) bool {
+		return memory.IsAbout(userID)
+	})
+}
+
+func (s *MemoryStore) search(ctx context.Context, query string, k int, predicate func(memory Memory) bool) ([]ScoredMemory, error) {
+	if k <= 0 {
+		return nil, nil
+	}
+
+	count, err := s.memories.Count()
+	if err != nil || count == 0 {
+		return nil, err
+	}
+
+	embeddings, err := s.embedder.Embed(ctx, []string{query})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to embed query")
+	}
+
+	var found []ScoredMemory
+	err = s.memories.ForEach(func(_ string, memory Memory) error {
+		if !predicate(memory) {
+			return nil
+		}
+
+		found = append(found, ScoredMemory{
+			Memory: memory,
+			Score:  cosineSimilarity(embeddings[0], memory.Embedding),
+		})
+
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read memories")
+	}
+
+	slices.SortFunc(found, func(a, b ScoredMemory) int {
+		return cmp.Compare(b.Score, a.Score)
+	})
+
+	return found[:min(k, len(found))], nil
+}
+
+// List returns all memories, from the newest
+func (s *MemoryStore) List() ([]Memory, error) {
+	var memories []Memory
+	err := s.memories.ForEach(func(_ string, memory Memory) error {
+		memories = append(memories, memory)
+
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read memories")
+	}
+
+	slices.Reverse(memories)
+
+	return memories, nil
+}
+
+// Get returns nil, if there is no memory with the ID
+func (s *MemoryStore) Get(ID string) (*Memory, error) {
+	return s.memories.Get(ID)
+}
+
+// Edit replaces content of the memory, and embeds it again
+func (s *MemoryStore) Edit(ctx context.Context, ID string, content string) (*Memory, error) {
+	memory, err := s.memories.Get(ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if memory == nil {
+		return nil, fmt.Errorf("memory %s not found", ID)
+	}
+
+	embeddings, err := s.embedder.Embed(ctx, []string{content})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to embed memory")
+	}
+
+	memory.Content = content
+	memory.Embedding = embeddings[0]
+	err = s.memories.Put(ID, *memory)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to store memory")
+	}
+
+	s.log.Info("edited memory", zap.String("memoryID", ID))
+
+	return memory, nil
+}
+
+// Forget deletes memories with the given IDs
+func (s *MemoryStore) Forget(IDs ...string) error {
+	return s.memories.Delete(IDs...)
+}
+
+// InjectMemories adds memories relevant to the last user message as a system message, replacing the previously injected one.
+// API injects them into a copy of the chat sent with the request, so that they are not saved or compacted with the chat.
+func (s *MemoryStore) InjectMemories(ctx context.Context, chat *Chat) error {
+	lastUserMessage, ok := chat.LastUserMessage()
+	if !ok || strings.TrimSpace(lastUserMessage.Contents) == "" {
+		return nil
+	}
+
+	found, err := s.Search(ctx, lastUserMessage.Contents, s.options.TopK)
+	if err != nil {
+		return err
+	}
+
+	var relevant []string
+	for _, memory := range found {
+		if memory.Score >= s.options.MinScore {
+			relevant = append(relevant, "- "+memory.Content)
+		}
+	}
+
+	chat.mu.Lock()
+	defer chat.mu.Unlock()
+
+	chat.Messages = slices.DeleteFunc(chat.Messages, func(message *ChatMessage) bool {
+		return message.Metadata[memoriesMetadataKey] != ""
+	})
+
+	if len(relevant) == 0 {
+		return nil
+	}
+
+	memories := NewChatMessage(fmt.Sprintf("Things you remember, that may be relevant to the discussion:\n%s", strings.Join(relevant, "\n")), ChatRoleSystem)
+	memories.AddMetadata(memoriesMetadataKey, "true")
+
+	// Memories are placed right before the message they are relevant to
+	position := slices.Index(chat.Messages, lastUserMessage)
+	if position < 0 {
+		position = len(chat.Messages)
+	}
+	chat.Messages = slices.Insert(chat.Messages, position, memories)
+
+	s.log.Debug("injected memories", zap.Int("memories", len(relevant)))
+
+	return nil
+}
+
+// chunkMemory splits the content into facts, one per line. Lines longer than maxLength are split between words.
+func chunkMemory(content string, maxLength int) []string {
+	var chunks []string
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(line), "-*"))
+		if line == "" {
+			continue
+		}
+
+		if maxLength <= 0 || len(line) <= maxLength {
+			chunks = append(chunks, line)
+			continue
+		}
+
+		var chunk strings.Builder
+		for _, word := range strings.Fields(line) {
+			if chunk.Len() > 0 && chunk.Len()+len(word)+1 > maxLength {
+				chunks = append(chunks, chunk.String())
+				chunk.Reset()
+			}
+
+			if chunk.Len() > 0 {
+				chunk.WriteString(" ")
+			}
+			chunk.WriteString(word)
+		}
+		chunks = append(chunks, chunk.String())
+	}
+
+	return chunks
+}
+
+func cosineSimilarity(a []float32, b []float32) float32 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+
+	return float32(dot / (math.Sqrt(normA) * math.Sqrt(normB)))
+}
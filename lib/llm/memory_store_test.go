@@ -0,0 +1,103 @@
+package llm_test
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"lib/llm"
+	"lib/storage"
+	"path/filepath"
+	"testing"
+)
+
+// chatRecorder records messages of chats sent to the adapter
+type chatRecorder struct {
+	*llm.ScriptedAdapter
+	chats [][]llm.ChatMessage
+}
+
+func (r *chatRecorder) Chat(ctx context.Context, chat *llm.Chat) (*llm.ChatMessage, *llm.ChatReplyMetadata, error) {
+	var messages []llm.ChatMessage
+	for _, message := range chat.Messages {
+		messages = append(messages, *message)
+	}
+	r.chats = append(r.chats, messages)
+
+	return r.ScriptedAdapter.Chat(ctx, chat)
+}
+
+func TestMemoryStore(t *testing.T) {
+	db, err := storage.Open(filepath.Join(t.TempDir(), "test.db"))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	adapter := llm.NewScriptedAdapter(llm.ScriptedRule{Reply: "ok"})
+	store, err := llm.NewMemoryStore(db, llm.NewAPI(adapter, "scripted"), llm.MemoryStoreOptions{TopK: 2, MinScore: 0.3})
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+	memories, err := store.Remember(ctx, "- Artur plays the guitar\n- Wojtek likes pizza with pineapple\n\n- Kasia lives in Kraków", llm.MemoryProvenance{Source: "thread"})
+	assert.NoError(t, err)
+	assert.Len(t, memories, 3)
+
+	t.Run("finds the most similar memories", func(t *testing.T) {
+		found, err := store.Search(ctx, "what pizza does Wojtek like?", 1)
+		assert.NoError(t, err)
+		assert.Len(t, found, 1)
+		assert.Equal(t, "Wojtek likes pizza with pineapple", found[0].Content)
+
+		found, err = store.Search(ctx, "what pizza does Wojtek like?", -1)
+		assert.NoError(t, err)
+		assert.Empty(t, found)
+	})
+
+	t.Run("injects relevant memories before the last message of the request", func(t *testing.T) {
+		recorder := &chatRecorder{ScriptedAdapter: adapter}
+		api := llm.NewAPI(recorder, "scripted")
+		api.WithMemory(store)
+		chat := llm.NewChat()
+		chat.AddMessages(llm.NewUserChatMessage("does Artur play the guitar?", "1", "Kasia"))
+
+		_, _, _, err := api.Chat(ctx, chat)
+		assert.NoError(t, err)
+		assert.Len(t, recorder.chats[0], 2)
+		assert.Equal(t, llm.ChatRoleSystem, recorder.chats[0][0].Role)
+		assert.Contains(t, recorder.chats[0][0].Contents, "Artur plays the guitar")
+		assert.NotContains(t, recorder.chats[0][0].Contents, "Kraków")
+
+		// Chat itself is saved and compacted, so it never keeps memories
+		assert.Len(t, chat.Messages, 2)
+		assert.Equal(t, llm.ChatRoleUser, chat.Messages[0].Role)
+
+		// Memories are found again for every message
+		chat.AddMessages(llm.NewUserChatMessage("and where does Kasia live?", "2", "Kasia"))
+		_, _, _, err = api.Chat(ctx, chat)
+		assert.NoError(t, err)
+		assert.Len(t, recorder.chats[1], 4)
+		assert.Equal(t, llm.ChatRoleSystem, recorder.chats[1][2].Role)
+		assert.Contains(t, recorder.chats[1][2].Contents, "Kraków")
+		assert.Len(t, chat.Messages, 4)
+	})
+
+	t.Run("edits memories", func(t *testing.T) {
+		_, err := store.Edit(ctx, memories[1].ID, "Wojtek likes pizza with ham")
+		assert.NoError(t, err)
+
+		memory, err := store.Get(memories[1].ID)
+		assert.NoError(t, err)
+		assert.Equal(t, "Wojtek likes pizza with ham", memory.Content)
+
+		listed, err := store.List()
+		assert.NoError(t, err)
+		assert.Len(t, listed, 3)
+		assert.Equal(t, memories[2].ID, listed[0].ID)
+	})
+
+	t.Run("forgets memories", func(t *testing.T) {
+		assert.NoError(t, store.Forget(memories[0].ID))
+
+		found, err := store.Search(ctx, "Artur plays the guitar", 3)
+		assert.NoError(t, err)
+		assert.Len(t, found, 2)
+		assert.NotEqual(t, memories[0].ID, found[0].ID)
+	})
+}
@@ -0,0 +1,103 @@
+package llm
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// partialJSONStringField returns decoded value of the given string field from a JSON document that might still be incomplete,
+// e.g. because it is being streamed. Only the part of the value that was already received is returned.
+func partialJSONStringField(raw string, field string) string {
+	key := strconv.Quote(field)
+	keyIndex := strings.Index(raw, key)
+	if keyIndex == -1 {
+		return ""
+	}
+
+	rest := strings.TrimLeft(raw[keyIndex+len(key):], " \t\r\n")
+	if !strings.HasPrefix(rest, ":") {
+		return ""
+	}
+
+	rest = strings.TrimLeft(rest[1:], " \t\r\n")
+	if !strings.HasPrefix(rest, `"`) {
+		return ""
+	}
+	rest = rest[1:]
+
+	var result strings.Builder
+	for i := 0; i < len(rest); i++ {
+		char := rest[i]
+
+		switch char {
+		case '"':
+			return result.String()
+
+		case '\\':
+			if i+1 >= len(rest) {
+				// Escape sequence is not complete yet
+				return result.String()
+			}
+
+			i++
+			switch rest[i] {
+			case 'n':
+				result.WriteByte('\n')
+			case 't':
+				result.WriteByte('\t')
+			case 'r':
+				result.WriteByte('\r')
+			case 'b':
+				result.WriteByte('\b')
+			case 'f':
+				result.WriteByte('\f')
+			case 'u':
+				r, length, ok := decodeJSONUnicodeEscape(rest[i-1:])
+				if !ok {
+					return result.String()
+				}
+
+				result.WriteRune(r)
+				i += length - 2
+			default:
+				// Covers \", \\ and \/
+				result.WriteByte(rest[i])
+			}
+
+		default:
+			result.WriteByte(char)
+		}
+	}
+
+	return result.String()
+}
+
+// decodeJSONUnicodeEscape decodes \uXXXX escape (including surrogate pairs) from the start of given string.
+// Returns decoded rune, length of the escape sequence and false if the sequence is not complete yet.
+func decodeJSONUnicodeEscape(s string) (rune, int, bool) {
+	if len(s) < 6 {
+		return 0, 0, false
+	}
+
+	code, err := strconv.ParseUint(s[2:6], 16, 16)
+	if err != nil {
+		return 0, 0, false
+	}
+
+	r := rune(code)
+	if !utf16.IsSurrogate(r) {
+		return r, 6, true
+	}
+
+	if len(s) < 12 || s[6:8] != `\u` {
+		return 0, 0, false
+	}
+
+	low, err := strconv.ParseUint(s[8:12], 16, 16)
+	if err != nil {
+		return 0, 0, false
+	}
+
+	return utf16.DecodeRune(r, rune(low)), 12, true
+}
@@ -0,0 +1,33 @@
+package llm_test
+
+import (
+	"github.com/stretchr/testify/assert"
+	"lib/llm"
+	"testing"
+)
+
+func TestPartialJSONStringField(t *testing.T) {
+	tests := []struct {
+		name     string
+		raw      string
+		expected string
+	}{
+		{name: "complete value", raw: `{"reply": "hello", "other": 1}`, expected: "hello"},
+		{name: "unterminated string", raw: `{"reply": "hel`, expected: "hel"},
+		{name: "missing field", raw: `{"other": "hello"}`, expected: ""},
+		{name: "value not started yet", raw: `{"reply": `, expected: ""},
+		{name: "not a string", raw: `{"reply": 12}`, expected: ""},
+		{name: "escapes", raw: `{"reply": "a\"b\\c\/d\ne\tf"}`, expected: "a\"b\\c/d\ne\tf"},
+		{name: "incomplete escape", raw: `{"reply": "abc\`, expected: "abc"},
+		{name: "unicode escape", raw: `{"reply": "zażółć"}`, expected: "zażółć"},
+		{name: "incomplete unicode escape", raw: `{"reply": "za\u01`, expected: "za"},
+		{name: "surrogate pair", raw: `{"reply": "😀!"}`, expected: "😀!"},
+		{name: "incomplete surrogate pair", raw: `{"reply": "a\ud83d`, expected: "a"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, llm.PartialJSONStringField(test.raw, "reply"))
+		})
+	}
+}
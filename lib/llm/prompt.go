@@ -8,6 +8,13 @@ type Prompt struct {
 	Traits string
 
 	Files []File
+
+	// Schema optionally describes JSON that LLM must reply with, adapters that support structured output enforce it natively.
+	// Use PromptJSON instead of setting it directly.
+	Schema *Schema
+
+	// Template is an ID of the template revision that produced the prompt, if any
+	Template string
 }
 
 func NewPrompt(phrase string) *Prompt {
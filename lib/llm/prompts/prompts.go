@@ -2,34 +2,45 @@ package prompts
 
 import (
 	"context"
-	"fmt"
+	"embed"
 	"lib/llm"
-	"strconv"
 )
 
+//go:embed templates/*.tmpl
+var templateFiles embed.FS
+
+func init() {
+	llm.Templates.MustLoad(templateFiles, "templates")
+}
+
+type messageVars struct {
+	Message string
+}
+
 // SummarizeDiscordThread generates a short summary in Polish for a given Discord message using an LLM API.
 // Returns the summarized prompt response or an error if the operation fails.
 func SummarizeDiscordThread(ctx context.Context, llmAPI *llm.API, messageContent string) (*llm.PromptResponse, error) {
-	reply, _, err := llmAPI.Prompt(ctx, llm.Prompt{
-		Phrase: fmt.Sprintf("summarize this message in short sentence (up to 100 characters) in polish. Make it a neutral sentence, without any special discord syntax (such as mentions, etc.): %s", messageContent),
-	})
+	prompt, err := llm.RenderPrompt("thread-summary", messageVars{Message: messageContent})
+	if err != nil {
+		return nil, err
+	}
+
+	reply, _, err := llmAPI.Prompt(ctx, prompt)
 
 	return reply, err
 }
 
 // IsMessageGoodbye determines if the provided message indicates a goodbye or the end of a discussion session.
 func IsMessageGoodbye(ctx context.Context, llmAPI *llm.API, messageContent string) (bool, error) {
-	result, _, err := llmAPI.Prompt(ctx, llm.Prompt{
-		Phrase: fmt.Sprintf("check, if this message is a goodbye, or prompt to end the discussion. Return ONLY true, if it is, or false otherwise: \n %s", messageContent),
-	})
+	prompt, err := llm.NewGoodbyePrompt(messageContent)
 	if err != nil {
 		return false, err
 	}
 
-	b, err := strconv.ParseBool(result.Reply)
+	reply, err := llm.PromptJSON[llm.GoodbyeReply](ctx, llmAPI, prompt)
 	if err != nil {
 		return false, err
 	}
 
-	return b, nil
+	return reply.IsGoodbye, nil
 }
@@ -0,0 +1,240 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	goerrors "errors"
+	"fmt"
+	"go.uber.org/zap"
+	"lib/errors"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Tasks routed by Router
+const (
+	TaskWorthiness    = "worthiness"
+	TaskThreadTitle   = "thread-title"
+	TaskChat          = "chat"
+	TaskMemoryExtract = "memory-extract"
+	TaskMemoryFilter  = "memory-filter"
+	// TaskMemoryConsolidate merges similar memories in the periodic consolidation
+	TaskMemoryConsolidate = "memory-consolidate"
+)
+
+// Duration is time.Duration, that is written in config files as a string, e.g. "30s"
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	err := json.Unmarshal(data, &value)
+	if err != nil {
+		return errors.Wrap(err, "duration must be a string")
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return errors.Wrap(err, "invalid duration")
+	}
+
+	*d = Duration(duration)
+
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// ModelOptions override the model of the adapter, and its parameters
+type ModelOptions struct {
+	// Model replaces the default model of the adapter, if set
+	Model string
+	// Temperature of sampling, the default of the model is used if nil
+	Temperature *float64
+}
+
+type modelOptionsContextKey struct{}
+
+// WithModelOptions overrides the model for requests sent with the returned context.
+// FailoverAdapter passes the options to all of its backends, so the model should be set only for single backend adapters.
+func WithModelOptions(ctx context.Context, options ModelOptions) context.Context {
+	return context.WithValue(ctx, modelOptionsContextKey{}, options)
+}
+
+func modelOptionsFrom(ctx context.Context) ModelOptions {
+	options, _ := ctx.Value(modelOptionsContextKey{}).(ModelOptions)
+
+	return options
+}
+
+// model returns the overridden model, or the default one
+func (o ModelOptions) model(defaultModel string) string {
+	if o.Model == "" {
+		return defaultModel
+	}
+
+	return o.Model
+}
+
+// String identifies the options, e.g. in cache keys
+func (o ModelOptions) String() string {
+	if o.Temperature == nil {
+		return o.Model
+	}
+
+	return fmt.Sprintf("%s@%s", o.Model, strconv.FormatFloat(*o.Temperature, 'f', -1, 64))
+}
+
+// Route configures which adapter and model handle the task
+type Route struct {
+	// Adapter is a name of the API registered in the router
+	Adapter     string   `json:"adapter"`
+	Model       string   `json:"model"`
+	Temperature *float64 `json:"temperature"`
+	// Timeout of a single request, zero means no timeout
+	Timeout Duration `json:"timeout"`
+}
+
+// AdapterConfig configures the default model of the adapter
+type AdapterConfig struct {
+	Model         string `json:"model"`
+	ContextWindow int32  `json:"context_window"`
+}
+
+// RouterConfig is read from a config file, so that tasks can be moved to other models without a code change
+type RouterConfig struct {
+	Adapters map[string]AdapterConfig `json:"adapters"`
+	Routes   map[string]Route         `json:"routes"`
+	// Default is a name of the adapter used for tasks without a route
+	Default string `json:"default"`
+}
+
+// Adapter returns config of the adapter, that must be present with its context window
+func (c RouterConfig) Adapter(name string) (AdapterConfig, error) {
+	adapter, ok := c.Adapters[name]
+	if !ok {
+		return adapter, fmt.Errorf("adapter %q is not configured", name)
+	}
+
+	if adapter.ContextWindow <= 0 {
+		return adapter, fmt.Errorf("context window of adapter %q is not configured", name)
+	}
+
+	return adapter, nil
+}
+
+// ParseRouterConfig decodes the config from JSON
+func ParseRouterConfig(data []byte) (RouterConfig, error) {
+	var config RouterConfig
+	err := json.Unmarshal(data, &config)
+	if err != nil {
+		return config, errors.Wrap(err, "failed to parse router config")
+	}
+
+	return config, nil
+}
+
+// LoadRouterConfig reads the config from the JSON file
+func LoadRouterConfig(path string) (RouterConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return RouterConfig{}, errors.Wrap(err, "failed to read router config")
+	}
+
+	return ParseRouterConfig(data)
+}
+
+// Router returns API configured for the task. APIs are registered by the name of their adapter, that routes refer to.
+type Router struct {
+	routes       map[string]*API
+	defaultRoute *API
+}
+
+// NewRouter creates the router from the config. Routes copy the APIs, so they must be fully configured before.
+// Model of the adapter config is used by routes of the adapter, that don't set their own.
+func NewRouter(config RouterConfig, apis map[string]*API) (*Router, error) {
+	if config.Default == "" {
+		return nil, goerrors.New("default adapter is not configured")
+	}
+
+	defaultAPI, ok := apis[config.Default]
+	if !ok {
+		return nil, fmt.Errorf("default adapter %q is not registered", config.Default)
+	}
+
+	// Configs of unknown adapters would be silently ignored, e.g. after a typo in the name
+	for name := range config.Adapters {
+		if _, ok := apis[name]; !ok {
+			return nil, fmt.Errorf("configured adapter %q is not registered", name)
+		}
+	}
+
+	router := &Router{
+		routes:       make(map[string]*API, len(config.Routes)),
+		defaultRoute: defaultAPI,
+	}
+	for task, route := range config.Routes {
+		if route.Adapter == "" {
+			return nil, fmt.Errorf("adapter of task %q is not configured", task)
+		}
+
+		api, ok := apis[route.Adapter]
+		if !ok {
+			return nil, fmt.Errorf("adapter %q of task %q is not registered", route.Adapter, task)
+		}
+
+		if route.Model == "" {
+			route.Model = config.Adapters[route.Adapter].Model
+		}
+
+		router.routes[task] = api.withRoute(task, route)
+	}
+
+	return router, nil
+}
+
+// NewSingleAdapterRouter creates a router that uses the same adapter for all tasks, e.g. ScriptedAdapter in tests
+func NewSingleAdapterRouter(adapter Adapter) *Router {
+	return &Router{
+		routes:       make(map[string]*API),
+		defaultRoute: NewAPI(adapter, "default"),
+	}
+}
+
+// API returns API configured for the task, or the default one if the task has no route
+func (r *Router) API(task string) *API {
+	api, ok := r.routes[task]
+	if !ok {
+		return r.defaultRoute
+	}
+
+	return api
+}
+
+// withRoute returns a copy of the API, that sends requests with model options and timeout of the route
+func (api *API) withRoute(task string, route Route) *API {
+	routed := *api
+	routed.route = &route
+	routed.logger = api.logger.With(zap.String("task", task))
+
+	return &routed
+}
+
+// routeContext applies model options and timeout of the route to the request
+func (api *API) routeContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if api.route == nil {
+		return ctx, func() {}
+	}
+
+	ctx = WithModelOptions(ctx, ModelOptions{
+		Model:       api.route.Model,
+		Temperature: api.route.Temperature,
+	})
+	if api.route.Timeout <= 0 {
+		return ctx, func() {}
+	}
+
+	return context.WithTimeout(ctx, time.Duration(api.route.Timeout))
+}
@@ -0,0 +1,107 @@
+package llm_test
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"lib/llm"
+	"testing"
+)
+
+func TestRouter(t *testing.T) {
+	config, err := llm.ParseRouterConfig([]byte(`{
+		"routes": {
+			"worthiness": {"adapter": "free", "temperature": 0, "timeout": "30s"},
+			"chat": {"adapter": "assistant", "model": "gpt-4.1", "timeout": "5m"}
+		},
+		"default": "free"
+	}`))
+	assert.NoError(t, err)
+
+	apis := map[string]*llm.API{
+		"free":      llm.NewAPI(llm.NewScriptedAdapter(llm.ScriptedRule{Reply: "free"}), "free"),
+		"assistant": llm.NewAPI(llm.NewScriptedAdapter(llm.ScriptedRule{Reply: "assistant"}), "assistant"),
+	}
+	router, err := llm.NewRouter(config, apis)
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+	for task, expected := range map[string]string{
+		llm.TaskChat:        "assistant",
+		llm.TaskWorthiness:  "free",
+		llm.TaskThreadTitle: "free",
+	} {
+		t.Run("routes "+task, func(t *testing.T) {
+			response, _, err := router.API(task).Prompt(ctx, llm.Prompt{Phrase: "hi"})
+			assert.NoError(t, err)
+			assert.Equal(t, expected, response.Reply)
+		})
+	}
+
+	t.Run("fails on unknown adapter", func(t *testing.T) {
+		config.Routes[llm.TaskMemoryFilter] = llm.Route{Adapter: "expensive"}
+		_, err := llm.NewRouter(config, apis)
+		assert.ErrorContains(t, err, `adapter "expensive" of task "memory-filter"`)
+	})
+
+	t.Run("fails on invalid config", func(t *testing.T) {
+		for name, invalid := range map[string]llm.RouterConfig{
+			"no default":            {},
+			"unknown default":       {Default: "expensive"},
+			"unknown adapter":       {Default: "free", Adapters: map[string]llm.AdapterConfig{"fre": {Model: "qwen3"}}},
+			"route without adapter": {Default: "free", Routes: map[string]llm.Route{llm.TaskChat: {}}},
+		} {
+			_, err := llm.NewRouter(invalid, apis)
+			assert.Error(t, err, name)
+		}
+	})
+
+	t.Run("uses model of the adapter config", func(t *testing.T) {
+		adapter := &modelAdapter{}
+		router, err := llm.NewRouter(llm.RouterConfig{
+			Adapters: map[string]llm.AdapterConfig{"free": {Model: "qwen3"}},
+			Routes: map[string]llm.Route{
+				llm.TaskWorthiness:  {Adapter: "free"},
+				llm.TaskThreadTitle: {Adapter: "free", Model: "gemma3"},
+			},
+			Default: "free",
+		}, map[string]*llm.API{"free": llm.NewAPI(adapter, "free")})
+		assert.NoError(t, err)
+
+		for task, expected := range map[string]string{llm.TaskWorthiness: "qwen3", llm.TaskThreadTitle: "gemma3"} {
+			response, _, err := router.API(task).Prompt(ctx, llm.Prompt{Phrase: "hi"})
+			assert.NoError(t, err)
+			assert.Equal(t, expected, response.Reply)
+		}
+	})
+
+	t.Run("fails on invalid timeout", func(t *testing.T) {
+		_, err := llm.ParseRouterConfig([]byte(`{"routes": {"chat": {"adapter": "free", "timeout": "soon"}}}`))
+		assert.Error(t, err)
+	})
+}
+
+func TestRouterConfigAdapter(t *testing.T) {
+	config := llm.RouterConfig{Adapters: map[string]llm.AdapterConfig{
+		"openai":    {Model: "gpt-4.1-mini", ContextWindow: 128000},
+		"assistant": {},
+	}}
+
+	adapter, err := config.Adapter("openai")
+	assert.NoError(t, err)
+	assert.Equal(t, "gpt-4.1-mini", adapter.Model)
+
+	_, err = config.Adapter("assistant")
+	assert.ErrorContains(t, err, "context window")
+
+	_, err = config.Adapter("free")
+	assert.ErrorContains(t, err, "not configured")
+}
+
+// modelAdapter replies with the model requested by the route
+type modelAdapter struct {
+	llm.ScriptedAdapter
+}
+
+func (a *modelAdapter) Prompt(ctx context.Context, p llm.Prompt) (string, *llm.PromptReplyMetadata, error) {
+	return llm.ModelOptionsFrom(ctx).Model, nil, nil
+}
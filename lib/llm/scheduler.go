@@ -0,0 +1,263 @@
+package llm
+
+import (
+	"context"
+	goerrors "errors"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+// Priority of the request waiting for the LLM backend. Lower value is served first.
+type Priority int
+
+const (
+	// PriorityInteractive is used by requests someone is waiting for, e.g. replies in threads
+	PriorityInteractive Priority = iota
+	// PriorityCommand is used by slash commands and requests without a known feature
+	PriorityCommand
+	// PriorityBackground is used by work no one is waiting for, e.g. scanning channels
+	PriorityBackground
+)
+
+const priorityCount = 3
+
+var ErrQueueTimeout = goerrors.New("timed out waiting for the llm backend")
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityInteractive:
+		return "interactive"
+	case PriorityCommand:
+		return "command"
+	case PriorityBackground:
+		return "background"
+	}
+
+	return "unknown"
+}
+
+type priorityContextKey struct{}
+
+// WithPriority overrides priority of requests sent with the returned context
+func WithPriority(ctx context.Context, priority Priority) context.Context {
+	return context.WithValue(ctx, priorityContextKey{}, priority)
+}
+
+// priorityFromContext returns priority set with WithPriority, or derives it from the feature set with WithUsageContext
+func priorityFromContext(ctx context.Context) Priority {
+	if priority, ok := ctx.Value(priorityContextKey{}).(Priority); ok {
+		return priority
+	}
+
+	switch usageContextFrom(ctx).feature {
+	case FeatureChat, FeatureSummary, FeatureGoodbye, FeatureCompaction:
+		return PriorityInteractive
+	case FeatureScanner, FeatureMemory:
+		return PriorityBackground
+	}
+
+	return PriorityCommand
+}
+
+// SchedulerOptions configures Scheduler
+type SchedulerOptions struct {
+	// MaxInFlight is a number of requests sent to the backend at the same time
+	MaxInFlight int
+	// MaxBackgroundInFlight limits background requests, so that some slots are always left for interactive ones.
+	// Zero means background requests can use all slots.
+	MaxBackgroundInFlight int
+	// QueueTimeout is a maximum time a request waits for a free slot. Zero means no timeout.
+	QueueTimeout time.Duration
+}
+
+// PriorityStats contains metrics of requests of a single priority
+type PriorityStats struct {
+	Requests int64
+	// Timeouts is a number of requests that gave up waiting, because of QueueTimeout or cancelled context
+	Timeouts int64
+	Queued   int
+	InFlight int
+	// QueueTime is a total time requests spent waiting for a free slot
+	QueueTime    time.Duration
+	MaxQueueTime time.Duration
+}
+
+// SchedulerStats contains metrics of the scheduler by priority
+type SchedulerStats struct {
+	Name       string
+	Priorities map[Priority]PriorityStats
+}
+
+type schedulerWaiter struct {
+	priority Priority
+	ready    chan struct{}
+}
+
+// Scheduler limits number of requests sent to the LLM backend at the same time. Queued requests are served
+// by priority, then in order of arrival, so background work never delays requests someone is waiting for.
+// The same scheduler should be shared by all APIs that use the same backend.
+type Scheduler struct {
+	mu      sync.Mutex
+	name    string
+	options SchedulerOptions
+	queues  [priorityCount][]*schedulerWaiter
+	stats   [priorityCount]PriorityStats
+	log     *zap.Logger
+}
+
+func NewScheduler(name string, options SchedulerOptions) *Scheduler {
+	return &Scheduler{
+		name:    name,
+		options: options,
+		log:     logger.Named("scheduler").Named(name),
+	}
+}
+
+// Acquire waits for a free slot for the request with priority from the context. Returned function releases the slot.
+func (s *Scheduler) Acquire(ctx context.Context) (func(), error) {
+	priority := priorityFromContext(ctx)
+	start := time.Now()
+
+	s.mu.Lock()
+	if s.isQueueEmpty(priority) && s.canStart(priority) {
+		s.start(priority)
+		s.mu.Unlock()
+
+		return s.releaseFn(priority), nil
+	}
+
+	waiter := &schedulerWaiter{priority: priority, ready: make(chan struct{})}
+	s.queues[priority] = append(s.queues[priority], waiter)
+	s.stats[priority].Queued++
+	s.mu.Unlock()
+
+	var timeout <-chan time.Time
+	if s.options.QueueTimeout > 0 {
+		timer := time.NewTimer(s.options.QueueTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	var err error
+	select {
+	case <-waiter.ready:
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-timeout:
+		err = ErrQueueTimeout
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	select {
+	case <-waiter.ready:
+		// Slot was granted, even if the wait has just ended with an error
+		waited := time.Since(start)
+		s.recordQueueTime(priority, waited)
+		if waited > time.Second {
+			s.log.Info("request waited for a free slot", zap.Stringer("priority", priority), zap.Duration("waited", waited))
+		}
+
+		return s.releaseFn(priority), nil
+	default:
+	}
+
+	s.removeWaiter(waiter)
+	s.stats[priority].Timeouts++
+	s.log.Warn("request gave up waiting for a free slot", zap.Stringer("priority", priority), zap.Duration("waited", time.Since(start)), zap.Error(err))
+
+	return nil, err
+}
+
+// Stats returns current metrics of the scheduler
+func (s *Scheduler) Stats() SchedulerStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := SchedulerStats{Name: s.name, Priorities: make(map[Priority]PriorityStats, priorityCount)}
+	for priority, priorityStats := range s.stats {
+		stats.Priorities[Priority(priority)] = priorityStats
+	}
+
+	return stats
+}
+
+func (s *Scheduler) releaseFn(priority Priority) func() {
+	var once sync.Once
+
+	return func() {
+		once.Do(func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+
+			s.stats[priority].InFlight--
+			s.dispatch()
+		})
+	}
+}
+
+// dispatch starts queued requests while there are free slots. It must be called with the lock held.
+func (s *Scheduler) dispatch() {
+	for priority := range s.queues {
+		for len(s.queues[priority]) > 0 && s.canStart(Priority(priority)) {
+			waiter := s.queues[priority][0]
+			s.queues[priority] = s.queues[priority][1:]
+			s.stats[priority].Queued--
+			s.start(Priority(priority))
+			close(waiter.ready)
+		}
+	}
+}
+
+// start marks the request as in flight
+func (s *Scheduler) start(priority Priority) {
+	s.stats[priority].InFlight++
+	s.stats[priority].Requests++
+}
+
+func (s *Scheduler) canStart(priority Priority) bool {
+	inFlight := 0
+	for _, priorityStats := range s.stats {
+		inFlight += priorityStats.InFlight
+	}
+
+	if s.options.MaxInFlight > 0 && inFlight >= s.options.MaxInFlight {
+		return false
+	}
+
+	if priority == PriorityBackground && s.options.MaxBackgroundInFlight > 0 {
+		return s.stats[PriorityBackground].InFlight < s.options.MaxBackgroundInFlight
+	}
+
+	return true
+}
+
+// isQueueEmpty returns true if no request with the same, or higher priority is waiting
+func (s *Scheduler) isQueueEmpty(priority Priority) bool {
+	for higher := PriorityInteractive; higher <= priority; higher++ {
+		if len(s.queues[higher]) > 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (s *Scheduler) removeWaiter(waiter *schedulerWaiter) {
+	queue := s.queues[waiter.priority]
+	for i, queued := range queue {
+		if queued == waiter {
+			s.queues[waiter.priority] = append(queue[:i], queue[i+1:]...)
+			s.stats[waiter.priority].Queued--
+
+			return
+		}
+	}
+}
+
+func (s *Scheduler) recordQueueTime(priority Priority, waited time.Duration) {
+	s.stats[priority].QueueTime += waited
+	s.stats[priority].MaxQueueTime = max(s.stats[priority].MaxQueueTime, waited)
+}
@@ -0,0 +1,56 @@
+package llm_test
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"lib/llm"
+	"testing"
+	"time"
+)
+
+func TestScheduler(t *testing.T) {
+	interactiveCtx := llm.WithPriority(context.Background(), llm.PriorityInteractive)
+	backgroundCtx := llm.WithPriority(context.Background(), llm.PriorityBackground)
+
+	t.Run("serves queued requests by priority", func(t *testing.T) {
+		scheduler := llm.NewScheduler("test", llm.SchedulerOptions{MaxInFlight: 1})
+		release, err := scheduler.Acquire(interactiveCtx)
+		assert.NoError(t, err)
+
+		started := make(chan llm.Priority, 2)
+		acquire := func(ctx context.Context, priority llm.Priority) {
+			release, err := scheduler.Acquire(ctx)
+			assert.NoError(t, err)
+			started <- priority
+			release()
+		}
+
+		go acquire(backgroundCtx, llm.PriorityBackground)
+		assert.Eventually(t, func() bool { return scheduler.Stats().Priorities[llm.PriorityBackground].Queued == 1 }, time.Second, time.Millisecond)
+		go acquire(interactiveCtx, llm.PriorityInteractive)
+		assert.Eventually(t, func() bool { return scheduler.Stats().Priorities[llm.PriorityInteractive].Queued == 1 }, time.Second, time.Millisecond)
+
+		release()
+		assert.Equal(t, llm.PriorityInteractive, <-started)
+		assert.Equal(t, llm.PriorityBackground, <-started)
+	})
+
+	t.Run("keeps slots for interactive requests", func(t *testing.T) {
+		scheduler := llm.NewScheduler("test", llm.SchedulerOptions{MaxInFlight: 2, MaxBackgroundInFlight: 1, QueueTimeout: 10 * time.Millisecond})
+		release, err := scheduler.Acquire(backgroundCtx)
+		assert.NoError(t, err)
+		defer release()
+
+		_, err = scheduler.Acquire(backgroundCtx)
+		assert.ErrorIs(t, err, llm.ErrQueueTimeout)
+
+		interactiveRelease, err := scheduler.Acquire(interactiveCtx)
+		assert.NoError(t, err)
+		interactiveRelease()
+
+		stats := scheduler.Stats().Priorities[llm.PriorityBackground]
+		assert.Equal(t, int64(1), stats.Timeouts)
+		assert.Equal(t, 0, stats.Queued)
+		assert.Equal(t, 1, stats.InFlight)
+	})
+}
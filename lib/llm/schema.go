@@ -0,0 +1,102 @@
+package llm
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Schema is a JSON schema of the expected reply
+type Schema struct {
+	// Name of the schema, must match ^[a-zA-Z0-9_-]+$
+	Name       string
+	Definition map[string]any
+}
+
+// SchemaOf derives JSON schema from the Go type. Struct fields are named after their json tags, and can be described with description tag.
+// All fields are required, and no additional properties are allowed, so that the schema can be used in OpenAI strict mode.
+func SchemaOf[T any]() (*Schema, error) {
+	t := reflect.TypeFor[T]()
+
+	definition, err := schemaOfType(t)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Schema{
+		Name:       t.Name(),
+		Definition: definition,
+	}, nil
+}
+
+func schemaOfType(t reflect.Type) (map[string]any, error) {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return schemaOfType(t.Elem())
+
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}, nil
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}, nil
+
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}, nil
+
+	case reflect.String:
+		return map[string]any{"type": "string"}, nil
+
+	case reflect.Slice, reflect.Array:
+		items, err := schemaOfType(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+
+		return map[string]any{"type": "array", "items": items}, nil
+
+	case reflect.Struct:
+		return schemaOfStruct(t)
+	}
+
+	return nil, fmt.Errorf("type %s is not supported in JSON schema", t)
+}
+
+func schemaOfStruct(t reflect.Type) (map[string]any, error) {
+	properties := map[string]any{}
+	required := []string{}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		property, err := schemaOfType(field.Type)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", field.Name, err)
+		}
+
+		if description := field.Tag.Get("description"); description != "" {
+			property["description"] = description
+		}
+
+		properties[name] = property
+		required = append(required, name)
+	}
+
+	return map[string]any{
+		"type":                 "object",
+		"properties":           properties,
+		"required":             required,
+		"additionalProperties": false,
+	}, nil
+}
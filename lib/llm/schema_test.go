@@ -0,0 +1,48 @@
+package llm_test
+
+import (
+	"github.com/stretchr/testify/assert"
+	"lib/llm"
+	"testing"
+)
+
+type schemaReply struct {
+	IsWorthy bool     `json:"is_worthy" description:"true if the message deserves a reply"`
+	Score    float64  `json:"score"`
+	Count    int      `json:"count,omitempty"`
+	Topics   []string `json:"topics"`
+	Author   *schemaAuthor
+	Ignored  string `json:"-"`
+	internal string
+}
+
+type schemaAuthor struct {
+	Name string `json:"name"`
+}
+
+func TestSchemaOf(t *testing.T) {
+	schema, err := llm.SchemaOf[schemaReply]()
+	assert.NoError(t, err)
+
+	assert.Equal(t, "schemaReply", schema.Name)
+	assert.Equal(t, map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"is_worthy": map[string]any{"type": "boolean", "description": "true if the message deserves a reply"},
+			"score":     map[string]any{"type": "number"},
+			"count":     map[string]any{"type": "integer"},
+			"topics":    map[string]any{"type": "array", "items": map[string]any{"type": "string"}},
+			"Author": map[string]any{
+				"type":                 "object",
+				"properties":           map[string]any{"name": map[string]any{"type": "string"}},
+				"required":             []string{"name"},
+				"additionalProperties": false,
+			},
+		},
+		"required":             []string{"is_worthy", "score", "count", "topics", "Author"},
+		"additionalProperties": false,
+	}, schema.Definition)
+
+	_, err = llm.SchemaOf[map[string]string]()
+	assert.Error(t, err)
+}
@@ -0,0 +1,139 @@
+package llm
+
+import (
+	"context"
+	goerrors "errors"
+	"fmt"
+	"github.com/goccy/go-json"
+	"go.uber.org/zap"
+	"lib/errors"
+	"strings"
+)
+
+// MaxStructuredOutputRetries limits how many times the prompt is repeated after the reply failed validation
+const MaxStructuredOutputRetries = 2
+
+var ErrInvalidStructuredOutput = goerrors.New("llm reply does not match the schema")
+
+// Validator can be implemented by types passed to PromptJSON, to validate the reply beyond its schema
+type Validator interface {
+	Validate() error
+}
+
+type promptFn func(ctx context.Context, p Prompt) (string, error)
+
+type replyValidatorContextKey struct{}
+
+// withReplyValidator lets adapters check replies, before they keep them, e.g. CachingAdapter doesn't cache invalid replies
+func withReplyValidator(ctx context.Context, validate func(reply string) error) context.Context {
+	return context.WithValue(ctx, replyValidatorContextKey{}, validate)
+}
+
+// validateReply returns nil, if the reply is valid, or the request has no validator
+func validateReply(ctx context.Context, reply string) error {
+	validate, ok := ctx.Value(replyValidatorContextKey{}).(func(reply string) error)
+	if !ok {
+		return nil
+	}
+
+	return validate(reply)
+}
+
+// PromptJSON sends the prompt and decodes the reply into T. JSON schema of the reply is derived from T.
+// Adapters that support structured output enforce the schema natively, for others it is described in the prompt.
+// Replies that fail validation are re-prompted with the validation error, up to MaxStructuredOutputRetries times.
+func PromptJSON[T any](ctx context.Context, api *API, prompt Prompt) (*T, error) {
+	return promptJSON[T](ctx, func(ctx context.Context, p Prompt) (string, error) {
+		response, _, err := api.Prompt(ctx, p)
+		if err != nil {
+			return "", err
+		}
+
+		return response.Reply, nil
+	}, prompt)
+}
+
+func promptJSON[T any](ctx context.Context, send promptFn, prompt Prompt) (*T, error) {
+	schema, err := SchemaOf[T]()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to derive schema")
+	}
+
+	schemaJSON, err := json.Marshal(schema.Definition)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal schema")
+	}
+
+	phrase := fmt.Sprintf("%s\n\nReply ONLY with JSON matching this schema: %s", prompt.Phrase, schemaJSON)
+	prompt.Schema = schema
+	prompt.Phrase = phrase
+	ctx = withReplyValidator(ctx, func(reply string) error {
+		_, err := decodeStructuredReply[T](reply, schema)
+		return err
+	})
+
+	var lastErr error
+	for attempt := 0; attempt <= MaxStructuredOutputRetries; attempt++ {
+		reply, err := send(ctx, prompt)
+		if err != nil {
+			return nil, err
+		}
+
+		result, err := decodeStructuredReply[T](reply, schema)
+		if err == nil {
+			return result, nil
+		}
+
+		logger.Warn("invalid structured reply", zap.String("schema", schema.Name), zap.String("reply", reply), zap.Int("attempt", attempt), zap.Error(err))
+		lastErr = err
+
+		prompt.Phrase = fmt.Sprintf("%s\n\nYour previous reply was invalid: %s\nPrevious reply: %s", phrase, err.Error(), reply)
+	}
+
+	return nil, errors.Wrap(goerrors.Join(ErrInvalidStructuredOutput, lastErr), schema.Name)
+}
+
+// decodeStructuredReply decodes JSON from the reply, and validates it
+func decodeStructuredReply[T any](reply string, schema *Schema) (*T, error) {
+	data := []byte(extractJSON(reply))
+
+	// Missing fields would be silently decoded as zero values, so they are checked first
+	var fields map[string]json.RawMessage
+	err := json.Unmarshal(data, &fields)
+	if err != nil {
+		return nil, fmt.Errorf("reply is not a JSON object: %w", err)
+	}
+
+	required, _ := schema.Definition["required"].([]string)
+	for _, field := range required {
+		if _, ok := fields[field]; !ok {
+			return nil, fmt.Errorf("missing required field %q", field)
+		}
+	}
+
+	result := new(T)
+	err = json.Unmarshal(data, result)
+	if err != nil {
+		return nil, fmt.Errorf("reply does not match the schema: %w", err)
+	}
+
+	if validator, ok := any(result).(Validator); ok {
+		err = validator.Validate()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// extractJSON strips text that models sometimes add around JSON, e.g. markdown code blocks
+func extractJSON(reply string) string {
+	start := strings.Index(reply, "{")
+	end := strings.LastIndex(reply, "}")
+	if start == -1 || end < start {
+		return strings.TrimSpace(reply)
+	}
+
+	return reply[start : end+1]
+}
@@ -0,0 +1,77 @@
+package llm_test
+
+import (
+	"context"
+	goerrors "errors"
+	"github.com/stretchr/testify/assert"
+	"lib/llm"
+	"testing"
+)
+
+type structuredReply struct {
+	Answer string `json:"answer"`
+	Score  int    `json:"score"`
+}
+
+func (r structuredReply) Validate() error {
+	if r.Score < 0 || r.Score > 10 {
+		return goerrors.New("score must be between 0 and 10")
+	}
+
+	return nil
+}
+
+func TestPromptJSONValidation(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		rules    []llm.ScriptedRule
+		expected *structuredReply
+		requests int
+	}{
+		{
+			name:     "decodes JSON wrapped in markdown",
+			rules:    []llm.ScriptedRule{{Reply: "```json\n{\"answer\": \"yes\", \"score\": 7}\n```"}},
+			expected: &structuredReply{Answer: "yes", Score: 7},
+			requests: 1,
+		},
+		{
+			name: "retries replies with missing fields",
+			rules: []llm.ScriptedRule{
+				{Reply: `{"answer": "yes"}`, Times: 1},
+				{Reply: `{"answer": "yes", "score": 3}`},
+			},
+			expected: &structuredReply{Answer: "yes", Score: 3},
+			requests: 2,
+		},
+		{
+			name: "retries replies that fail validation",
+			rules: []llm.ScriptedRule{
+				{Reply: `{"answer": "yes", "score": 11}`, Times: 1},
+				{Contains: "score must be between 0 and 10", Reply: `{"answer": "yes", "score": 10}`},
+			},
+			expected: &structuredReply{Answer: "yes", Score: 10},
+			requests: 2,
+		},
+		{
+			name:     "fails after all retries",
+			rules:    []llm.ScriptedRule{{Reply: "I don't know"}},
+			requests: llm.MaxStructuredOutputRetries + 1,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			adapter := llm.NewScriptedAdapter(test.rules...)
+
+			reply, err := llm.PromptJSON[structuredReply](ctx, llm.NewAPI(adapter, "scripted"), llm.Prompt{Phrase: "is it structured?"})
+			if test.expected == nil {
+				assert.ErrorIs(t, err, llm.ErrInvalidStructuredOutput)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, test.expected, reply)
+			assert.Len(t, adapter.Requests(), test.requests)
+		})
+	}
+}
@@ -0,0 +1,243 @@
+package llm
+
+import (
+	"bytes"
+	"embed"
+	goerrors "errors"
+	"fmt"
+	"io/fs"
+	"lib/errors"
+	"path"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+const templateExtension = ".tmpl"
+const templateHeaderSeparator = "\n---\n"
+
+// Locale of the prompt template
+type Locale string
+
+const LocaleEnglish = Locale("en")
+const LocalePolish = Locale("pl")
+
+var ErrTemplateNotFound = goerrors.New("prompt template not found")
+
+//go:embed templates/*.tmpl
+var templateFiles embed.FS
+
+func init() {
+	Templates.MustLoad(templateFiles, "templates")
+}
+
+// Templates is a registry of prompt templates used by the app. Packages register their templates on init.
+var Templates = NewTemplateRegistry(LocaleEnglish)
+
+// PromptTemplate is a named, versioned template of a Prompt, in a single locale.
+//
+// Templates are loaded from <name>.<locale>.tmpl files, that start with a header, e.g.:
+//
+//	version: 2
+//	---
+//	{{define "traits"}}You are a helpful assistant{{end}}
+//	{{define "phrase"}}Summarize this message: {{.Message}}{{end}}
+//
+// Both traits and phrase are optional. Variables are passed as a struct, so that they are typed.
+type PromptTemplate struct {
+	Name     string
+	Locale   Locale
+	Version  string
+	template *template.Template
+}
+
+// ID identifies the template revision, and is recorded in Prompt.Template
+func (t *PromptTemplate) ID() string {
+	return fmt.Sprintf("%s@%s/%s", t.Name, t.Version, t.Locale)
+}
+
+func (t *PromptTemplate) execute(name string, vars any) (string, error) {
+	if t.template.Lookup(name) == nil {
+		return "", nil
+	}
+
+	var out bytes.Buffer
+	err := t.template.ExecuteTemplate(&out, name, vars)
+	if err != nil {
+		return "", errors.Wrap(err, fmt.Sprintf("failed to render %s of %s", name, t.ID()))
+	}
+
+	return strings.TrimSpace(out.String()), nil
+}
+
+// TemplateRegistry contains prompt templates by name and locale
+type TemplateRegistry struct {
+	mu            sync.RWMutex
+	templates     map[string]map[Locale]*PromptTemplate
+	defaultLocale Locale
+}
+
+func NewTemplateRegistry(defaultLocale Locale) *TemplateRegistry {
+	return &TemplateRegistry{
+		templates:     make(map[string]map[Locale]*PromptTemplate),
+		defaultLocale: defaultLocale,
+	}
+}
+
+// SetDefaultLocale changes the locale used by Render
+func (r *TemplateRegistry) SetDefaultLocale(locale Locale) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.defaultLocale = locale
+}
+
+// Load loads all templates from the directory of the given file system
+func (r *TemplateRegistry) Load(fsys fs.FS, dir string) error {
+	files, err := fs.Glob(fsys, path.Join(dir, "*"+templateExtension))
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		data, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return errors.Wrap(err, "failed to read template "+file)
+		}
+
+		promptTemplate, err := parsePromptTemplate(file, string(data))
+		if err != nil {
+			return err
+		}
+
+		err = r.register(promptTemplate)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MustLoad works like Load, but panics on error. It is meant for templates embedded in the binary.
+func (r *TemplateRegistry) MustLoad(fsys fs.FS, dir string) {
+	err := r.Load(fsys, dir)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func (r *TemplateRegistry) register(promptTemplate *PromptTemplate) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	locales, ok := r.templates[promptTemplate.Name]
+	if !ok {
+		locales = make(map[Locale]*PromptTemplate)
+		r.templates[promptTemplate.Name] = locales
+	}
+
+	if _, exists := locales[promptTemplate.Locale]; exists {
+		return fmt.Errorf("template %s is already registered in locale %s", promptTemplate.Name, promptTemplate.Locale)
+	}
+
+	locales[promptTemplate.Locale] = promptTemplate
+
+	return nil
+}
+
+// Get returns the template in the given locale, falling back to English
+func (r *TemplateRegistry) Get(name string, locale Locale) (*PromptTemplate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	locales, ok := r.templates[name]
+	if !ok {
+		return nil, errors.Wrap(ErrTemplateNotFound, name)
+	}
+
+	if promptTemplate, ok := locales[locale]; ok {
+		return promptTemplate, nil
+	}
+
+	if promptTemplate, ok := locales[LocaleEnglish]; ok {
+		return promptTemplate, nil
+	}
+
+	return nil, errors.Wrap(ErrTemplateNotFound, fmt.Sprintf("%s in locale %s", name, locale))
+}
+
+// Render renders the template in the default locale
+func (r *TemplateRegistry) Render(name string, vars any) (Prompt, error) {
+	r.mu.RLock()
+	locale := r.defaultLocale
+	r.mu.RUnlock()
+
+	return r.RenderLocale(name, locale, vars)
+}
+
+// RenderLocale renders the template in the given locale into a Prompt
+func (r *TemplateRegistry) RenderLocale(name string, locale Locale, vars any) (Prompt, error) {
+	promptTemplate, err := r.Get(name, locale)
+	if err != nil {
+		return Prompt{}, err
+	}
+
+	traits, err := promptTemplate.execute("traits", vars)
+	if err != nil {
+		return Prompt{}, err
+	}
+
+	phrase, err := promptTemplate.execute("phrase", vars)
+	if err != nil {
+		return Prompt{}, err
+	}
+
+	return Prompt{
+		Traits:   traits,
+		Phrase:   phrase,
+		Template: promptTemplate.ID(),
+	}, nil
+}
+
+// RenderPrompt renders the template from Templates registry in its default locale
+func RenderPrompt[V any](name string, vars V) (Prompt, error) {
+	return Templates.Render(name, vars)
+}
+
+// parsePromptTemplate parses the template file named <name>.<locale>.tmpl
+func parsePromptTemplate(file string, contents string) (*PromptTemplate, error) {
+	name, locale, ok := strings.Cut(strings.TrimSuffix(path.Base(file), templateExtension), ".")
+	if !ok {
+		return nil, fmt.Errorf("template %s has no locale in its name", file)
+	}
+
+	header, body, ok := strings.Cut(contents, templateHeaderSeparator)
+	if !ok {
+		return nil, fmt.Errorf("template %s has no header", file)
+	}
+
+	promptTemplate := &PromptTemplate{
+		Name:   name,
+		Locale: Locale(locale),
+	}
+
+	for _, line := range strings.Split(header, "\n") {
+		key, value, _ := strings.Cut(line, ":")
+		if strings.TrimSpace(key) == "version" {
+			promptTemplate.Version = strings.TrimSpace(value)
+		}
+	}
+
+	if promptTemplate.Version == "" {
+		return nil, fmt.Errorf("template %s has no version", file)
+	}
+
+	parsed, err := template.New(name).Option("missingkey=error").Parse(body)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse template "+file)
+	}
+	promptTemplate.template = parsed
+
+	return promptTemplate, nil
+}
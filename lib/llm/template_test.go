@@ -0,0 +1,47 @@
+package llm_test
+
+import (
+	"github.com/stretchr/testify/assert"
+	"lib/llm"
+	"testing"
+	"testing/fstest"
+)
+
+type templateVars struct {
+	Message string
+}
+
+func TestTemplateRegistry(t *testing.T) {
+	registry := llm.NewTemplateRegistry(llm.LocalePolish)
+	err := registry.Load(fstest.MapFS{
+		"templates/greeting.en.tmpl": {Data: []byte("version: 3\n---\n{{define \"traits\"}}Be nice{{end}}\n{{define \"phrase\"}}Greet: {{.Message}}{{end}}")},
+		"templates/greeting.pl.tmpl": {Data: []byte("version: 2\n---\n{{define \"phrase\"}}Przywitaj: {{.Message}}{{end}}")},
+		"templates/farewell.en.tmpl": {Data: []byte("version: 1\n---\n{{define \"phrase\"}}Say goodbye to {{.Message}}{{end}}")},
+	}, "templates")
+	assert.NoError(t, err)
+
+	t.Run("renders default locale", func(t *testing.T) {
+		prompt, err := registry.Render("greeting", templateVars{Message: "Wojtek"})
+		assert.NoError(t, err)
+		assert.Equal(t, "Przywitaj: Wojtek", prompt.Phrase)
+		assert.Empty(t, prompt.Traits)
+		assert.Equal(t, "greeting@2/pl", prompt.Template)
+	})
+
+	t.Run("falls back to english", func(t *testing.T) {
+		prompt, err := registry.Render("farewell", templateVars{Message: "Wojtek"})
+		assert.NoError(t, err)
+		assert.Equal(t, "Say goodbye to Wojtek", prompt.Phrase)
+		assert.Equal(t, "farewell@1/en", prompt.Template)
+	})
+
+	t.Run("fails on missing template", func(t *testing.T) {
+		_, err := registry.Render("unknown", templateVars{})
+		assert.ErrorIs(t, err, llm.ErrTemplateNotFound)
+	})
+
+	t.Run("fails on missing variable", func(t *testing.T) {
+		_, err := registry.RenderLocale("greeting", llm.LocaleEnglish, map[string]string{})
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,87 @@
+package llm
+
+import "strings"
+
+const ThinkingStart = "<think>"
+const ThinkingEnd = "</think>"
+
+// ThinkingMetadataKey is a key of ChatMessage.Metadata, that contains reasoning of the model hidden from the reply
+const ThinkingMetadataKey = "thinking"
+
+// ThinkingParser splits streamed text into the reply and reasoning enclosed in <think> tags.
+// Tags may be split between chunks, or surrounded by other text.
+type ThinkingParser struct {
+	isThinking bool
+	// pending contains end of the previous chunk, that may be the beginning of a tag
+	pending string
+}
+
+// Feed parses the next chunk of the stream. Text that may be the beginning of a tag is held until the next chunk.
+func (p *ThinkingParser) Feed(chunk string) (reply string, thinking string) {
+	var replyParts, thinkingParts strings.Builder
+	write := func(text string) {
+		if p.isThinking {
+			thinkingParts.WriteString(text)
+		} else {
+			replyParts.WriteString(text)
+		}
+	}
+
+	text := p.pending + chunk
+	p.pending = ""
+
+	for text != "" {
+		tag := ThinkingStart
+		if p.isThinking {
+			tag = ThinkingEnd
+		}
+
+		if i := strings.Index(text, tag); i >= 0 {
+			write(text[:i])
+			text = text[i+len(tag):]
+			p.isThinking = !p.isThinking
+
+			continue
+		}
+
+		keep := partialTagLength(text, tag)
+		write(text[:len(text)-keep])
+		p.pending = text[len(text)-keep:]
+
+		break
+	}
+
+	return replyParts.String(), thinkingParts.String()
+}
+
+// Flush returns text held as a possible beginning of a tag, once the stream has ended
+func (p *ThinkingParser) Flush() (reply string, thinking string) {
+	pending := p.pending
+	p.pending = ""
+
+	if p.isThinking {
+		return "", pending
+	}
+
+	return pending, ""
+}
+
+// partialTagLength returns length of the longest suffix of the text, that is the beginning of the tag
+func partialTagLength(text string, tag string) int {
+	for n := min(len(tag)-1, len(text)); n > 0; n-- {
+		if strings.HasSuffix(text, tag[:n]) {
+			return n
+		}
+	}
+
+	return 0
+}
+
+// SplitThinking splits the whole reply into its visible part and reasoning
+func SplitThinking(contents string) (reply string, thinking string) {
+	parser := &ThinkingParser{}
+	reply, thinking = parser.Feed(contents)
+	replyRest, thinkingRest := parser.Flush()
+
+	return strings.TrimSpace(reply + replyRest), strings.TrimSpace(thinking + thinkingRest)
+}
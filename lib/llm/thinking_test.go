@@ -0,0 +1,58 @@
+package llm_test
+
+import (
+	"github.com/stretchr/testify/assert"
+	"lib/llm"
+	"testing"
+)
+
+func TestThinkingParser(t *testing.T) {
+	tests := []struct {
+		name     string
+		chunks   []string
+		reply    string
+		thinking string
+	}{
+		{
+			name:     "tags in separate chunks",
+			chunks:   []string{"<think>", "hmm", "</think>", "hello"},
+			reply:    "hello",
+			thinking: "hmm",
+		},
+		{
+			name:     "tags split between chunks",
+			chunks:   []string{"<th", "ink>let me", " think</t", "hink", ">\n\nhel", "lo"},
+			reply:    "\n\nhello",
+			thinking: "let me think",
+		},
+		{
+			name:     "tags next to other text",
+			chunks:   []string{"<think>hmm</think>hello <b>world</b>"},
+			reply:    "hello <b>world</b>",
+			thinking: "hmm",
+		},
+		{
+			name:     "text that only looks like beginning of a tag",
+			chunks:   []string{"a <", "b", " <thin"},
+			reply:    "a <b <thin",
+			thinking: "",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			parser := &llm.ThinkingParser{}
+
+			var reply, thinking string
+			for _, chunk := range test.chunks {
+				replyPart, thinkingPart := parser.Feed(chunk)
+				reply += replyPart
+				thinking += thinkingPart
+			}
+			replyPart, thinkingPart := parser.Flush()
+
+			assert.Equal(t, test.reply, reply+replyPart)
+			assert.Equal(t, test.thinking, thinking+thinkingPart)
+		})
+	}
+}
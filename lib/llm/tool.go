@@ -0,0 +1,183 @@
+package llm
+
+import (
+	"context"
+	goerrors "errors"
+	"fmt"
+	"github.com/goccy/go-json"
+	"go.uber.org/zap"
+	"sync"
+)
+
+// MaxToolIterations limits how many times in a row LLM can call tools before it has to give the final answer
+const MaxToolIterations = 5
+
+// ErrTooManyToolCalls is returned, when LLM still calls tools after it was asked for the final answer
+var ErrTooManyToolCalls = goerrors.New("llm called tools too many times")
+
+// ToolHandler is called when LLM decides to call the tool. Arguments contain JSON that matches Tool.Parameters.
+// Returned string is passed back to the LLM as the result of the call.
+type ToolHandler func(ctx context.Context, arguments json.RawMessage) (string, error)
+
+// Tool is a function that LLM can call while generating the reply
+type Tool struct {
+	// Name of the tool, must match ^[a-zA-Z0-9_-]+$
+	Name string
+	// Description tells LLM what the tool does and when it should be called
+	Description string
+	// Parameters is a JSON schema of the tool arguments
+	Parameters map[string]any
+	Handler    ToolHandler
+}
+
+type toolCallObserverKey struct{}
+
+// withToolCallObserver makes ToolRegistry.Call notify fn before every tool call made with the context
+func withToolCallObserver(ctx context.Context, fn func(name string)) context.Context {
+	return context.WithValue(ctx, toolCallObserverKey{}, fn)
+}
+
+// ToolRegistry contains tools that can be called by the LLM in a Chat
+type ToolRegistry struct {
+	mu    sync.Mutex
+	tools []Tool
+}
+
+func NewToolRegistry(tools ...Tool) *ToolRegistry {
+	registry := &ToolRegistry{}
+	for _, tool := range tools {
+		registry.Register(tool)
+	}
+
+	return registry
+}
+
+// Register adds the tool to the registry, replacing the existing tool with the same name
+func (r *ToolRegistry) Register(tool Tool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, existing := range r.tools {
+		if existing.Name == tool.Name {
+			r.tools[i] = tool
+			return
+		}
+	}
+
+	r.tools = append(r.tools, tool)
+}
+
+// List returns all registered tools
+func (r *ToolRegistry) List() []Tool {
+	if r == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]Tool{}, r.tools...)
+}
+
+// Get returns the tool with the given name
+func (r *ToolRegistry) Get(name string) (Tool, bool) {
+	for _, tool := range r.List() {
+		if tool.Name == name {
+			return tool, true
+		}
+	}
+
+	return Tool{}, false
+}
+
+// Call calls the tool with the given name. Errors are returned as a result, so that LLM can react to them.
+func (r *ToolRegistry) Call(ctx context.Context, name string, arguments string) string {
+	log := logger.Named("tools").With(zap.String("tool", name), zap.String("arguments", arguments))
+
+	tool, ok := r.Get(name)
+	if !ok {
+		log.Error("unknown tool")
+		return fmt.Sprintf("error: unknown tool %s", name)
+	}
+
+	if arguments == "" {
+		arguments = "{}"
+	}
+
+	if observer, ok := ctx.Value(toolCallObserverKey{}).(func(name string)); ok {
+		observer(name)
+	}
+
+	log.Info("calling tool")
+	result, err := tool.Handler(ctx, json.RawMessage(arguments))
+	if err != nil {
+		log.Error("tool call failed", zap.Error(err))
+		return fmt.Sprintf("error: %s", err.Error())
+	}
+
+	log.Info("tool call finished", zap.String("result", result))
+
+	return result
+}
+
+// ToolCall is a call of the tool requested by LLM
+type ToolCall struct {
+	// ID connects the result of the call with the request, if the adapter needs it
+	ID        string
+	Name      string
+	Arguments string
+}
+
+// toolResult is a result of the tool call, sent back to LLM
+type toolResult struct {
+	Call   ToolCall
+	Result string
+}
+
+// toolCompletion is a single reply of LLM in the tool loop, either the final answer, or calls of tools
+type toolCompletion struct {
+	Content   string
+	Refusal   string
+	ToolCalls []ToolCall
+	Usage     *TokenUsage
+}
+
+// toolCompletionFn sends the chat together with results of tools called by the previous completion.
+// LLM must give the final answer without calling tools, when forceAnswer is set.
+type toolCompletionFn func(ctx context.Context, results []toolResult, forceAnswer bool) (*toolCompletion, error)
+
+// runToolLoop calls tools requested by LLM, until it gives the final answer. After MaxToolIterations LLM is forced
+// to answer, and ErrTooManyToolCalls is returned if it still calls tools. Usage is summed over all completions.
+func runToolLoop(ctx context.Context, chat *Chat, complete toolCompletionFn) (*ChatMessage, *ChatReplyMetadata, error) {
+	usage := &TokenUsage{}
+	var results []toolResult
+
+	for iteration := 0; ; iteration++ {
+		completion, err := complete(ctx, results, iteration >= MaxToolIterations)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		usage.Add(completion.Usage)
+
+		if completion.Refusal != "" {
+			return nil, nil, NewRefusedToReplyError(completion.Refusal, lastMessageContents(chat))
+		}
+
+		if len(completion.ToolCalls) == 0 {
+			return NewChatMessage(completion.Content, ChatRoleAssistant), &ChatReplyMetadata{Usage: usage}, nil
+		}
+
+		if iteration >= MaxToolIterations {
+			return nil, nil, ErrTooManyToolCalls
+		}
+
+		results = make([]toolResult, 0, len(completion.ToolCalls))
+		for _, toolCall := range completion.ToolCalls {
+			results = append(results, toolResult{
+				Call:   toolCall,
+				Result: chat.Tools.Call(ctx, toolCall.Name, toolCall.Arguments),
+			})
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package llm_test
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"lib/llm"
+	"testing"
+)
+
+func TestToolLoop(t *testing.T) {
+	newChat := func(handler llm.ToolHandler) *llm.Chat {
+		chat := llm.NewChat()
+		chat.AddMessages(llm.NewUserChatMessage("play barka", "1", "Wojtek"))
+		chat.Tools = llm.NewToolRegistry(llm.Tool{Name: "queue_song", Handler: handler})
+
+		return chat
+	}
+	queueSong := llm.ToolCall{ID: "call", Name: "queue_song", Arguments: `{"url": "barka"}`}
+
+	t.Run("calls tools, and replies to their results", func(t *testing.T) {
+		adapter := llm.NewScriptedAdapter(
+			llm.ScriptedRule{Contains: "play", ToolCalls: []llm.ToolCall{queueSong}},
+			llm.ScriptedRule{Contains: "queued", Reply: "Barka is playing"},
+		)
+
+		var arguments []string
+		reply, _, err := adapter.Chat(context.Background(), newChat(func(ctx context.Context, args json.RawMessage) (string, error) {
+			arguments = append(arguments, string(args))
+			return "queued barka", nil
+		}))
+		assert.NoError(t, err)
+		assert.Equal(t, "Barka is playing", reply.Contents)
+		assert.Equal(t, []string{`{"url": "barka"}`}, arguments)
+		assert.Equal(t, "queued barka", adapter.Requests()[1])
+	})
+
+	t.Run("forces the final answer after too many tool calls", func(t *testing.T) {
+		adapter := llm.NewScriptedAdapter(
+			llm.ScriptedRule{ToolCalls: []llm.ToolCall{queueSong}},
+			llm.ScriptedRule{Reply: "queue is full"},
+		)
+
+		calls := 0
+		reply, _, err := adapter.Chat(context.Background(), newChat(func(ctx context.Context, args json.RawMessage) (string, error) {
+			calls++
+			return "queued", nil
+		}))
+		assert.NoError(t, err)
+		assert.Equal(t, "queue is full", reply.Contents)
+		assert.Equal(t, llm.MaxToolIterations, calls)
+		assert.Len(t, adapter.Requests(), llm.MaxToolIterations+1)
+	})
+
+	t.Run("fails, when tools are still called after the final answer was forced", func(t *testing.T) {
+		adapter := llm.NewScriptedAdapter(llm.ScriptedRule{ToolCalls: []llm.ToolCall{queueSong}, IgnoresToolChoice: true})
+
+		calls := 0
+		_, _, err := adapter.Chat(context.Background(), newChat(func(ctx context.Context, args json.RawMessage) (string, error) {
+			calls++
+			return "queued", nil
+		}))
+		assert.ErrorIs(t, err, llm.ErrTooManyToolCalls)
+		assert.Equal(t, llm.MaxToolIterations, calls)
+	})
+
+	t.Run("passes errors of tools back to the model", func(t *testing.T) {
+		adapter := llm.NewScriptedAdapter(
+			llm.ScriptedRule{Contains: "play", ToolCalls: []llm.ToolCall{{Name: "skip_song"}}},
+			llm.ScriptedRule{Contains: "error: unknown tool skip_song", Reply: "I can't skip songs"},
+		)
+
+		reply, _, err := adapter.Chat(context.Background(), newChat(nil))
+		assert.NoError(t, err)
+		assert.Equal(t, "I can't skip songs", reply.Contents)
+	})
+}
@@ -0,0 +1,210 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"go.uber.org/zap"
+	"lib/storage"
+	"strings"
+	"time"
+	openaiutil "wojciech-bot/openai"
+)
+
+const usageBucketName = "llm_usage"
+const usageDayLayout = time.DateOnly
+
+// usageEstimateEncoding is used to count tokens for adapters that do not report usage on their own
+const usageEstimateEncoding = "o200k_base"
+
+// Feature is a label of the functionality that sends requests to the LLM
+type Feature string
+
+const FeatureChat = Feature("chat")
+const FeatureScanner = Feature("scanner")
+const FeatureMemory = Feature("memory")
+const FeatureSummary = Feature("summary")
+const FeatureGoodbye = Feature("goodbye")
+const FeatureCompaction = Feature("compaction")
+const FeatureUnknown = Feature("unknown")
+
+// TokenUsage contains number of tokens used by a single request
+type TokenUsage struct {
+	InputTokens  int64 `json:"input_tokens"`
+	OutputTokens int64 `json:"output_tokens"`
+}
+
+func (u *TokenUsage) Add(other *TokenUsage) {
+	if other == nil {
+		return
+	}
+
+	u.InputTokens += other.InputTokens
+	u.OutputTokens += other.OutputTokens
+}
+
+// ModelPricing contains cost of the model in USD per one million tokens
+type ModelPricing struct {
+	InputPerMillion  float64
+	OutputPerMillion float64
+}
+
+func (p ModelPricing) Cost(usage TokenUsage) float64 {
+	return (float64(usage.InputTokens)*p.InputPerMillion + float64(usage.OutputTokens)*p.OutputPerMillion) / 1_000_000
+}
+
+// UsageTotals contains summed usage of a single day, adapter, feature and user
+type UsageTotals struct {
+	Day      string  `json:"day"`
+	Adapter  string  `json:"adapter"`
+	Feature  Feature `json:"feature"`
+	UserID   string  `json:"user_id"`
+	Requests int64   `json:"requests"`
+	TokenUsage
+	// Cost is an estimated cost in USD
+	Cost float64 `json:"cost"`
+}
+
+func (t *UsageTotals) Add(other UsageTotals) {
+	t.Requests += other.Requests
+	t.TokenUsage.Add(&other.TokenUsage)
+	t.Cost += other.Cost
+}
+
+type usageContextKey struct{}
+
+type usageContext struct {
+	feature Feature
+	userID  string
+}
+
+// WithUsageContext tags requests sent with the returned context with the feature and Discord user that caused them
+func WithUsageContext(ctx context.Context, feature Feature, userID string) context.Context {
+	return context.WithValue(ctx, usageContextKey{}, usageContext{feature: feature, userID: userID})
+}
+
+// withUsageFeature tags requests with the feature, keeping the user that caused them
+func withUsageFeature(ctx context.Context, feature Feature) context.Context {
+	return WithUsageContext(ctx, feature, usageContextFrom(ctx).userID)
+}
+
+func usageContextFrom(ctx context.Context) usageContext {
+	value, ok := ctx.Value(usageContextKey{}).(usageContext)
+	if !ok {
+		return usageContext{feature: FeatureUnknown}
+	}
+
+	return value
+}
+
+// UsageTracker persists token usage and estimated cost of LLM requests, summed per day
+type UsageTracker struct {
+	store *storage.Bucket[UsageTotals]
+	// pricing by adapter or backend name
+	pricing map[string]ModelPricing
+	log     *zap.Logger
+}
+
+func NewUsageTracker(db *storage.DB, pricing map[string]ModelPricing) (*UsageTracker, error) {
+	store, err := storage.NewBucket[UsageTotals](db, usageBucketName)
+	if err != nil {
+		return nil, err
+	}
+
+	return &UsageTracker{
+		store:   store,
+		pricing: pricing,
+		log:     logger.Named("usage"),
+	}, nil
+}
+
+// Record adds usage of a single request. Backend name, if set by FailoverAdapter, takes precedence over the adapter name.
+func (t *UsageTracker) Record(ctx context.Context, adapter string, backend string, usage TokenUsage) {
+	if backend != "" {
+		adapter = backend
+	}
+
+	usageCtx := usageContextFrom(ctx)
+	day := time.Now().Format(usageDayLayout)
+	record := UsageTotals{
+		Day:        day,
+		Adapter:    adapter,
+		Feature:    usageCtx.feature,
+		UserID:     usageCtx.userID,
+		Requests:   1,
+		TokenUsage: usage,
+		Cost:       t.pricing[adapter].Cost(usage),
+	}
+
+	key := strings.Join([]string{day, adapter, string(usageCtx.feature), usageCtx.userID}, "/")
+	err := t.store.Update(key, func(totals *UsageTotals) UsageTotals {
+		if totals == nil {
+			return record
+		}
+
+		totals.Add(record)
+		return *totals
+	})
+	if err != nil {
+		t.log.Error("failed to record usage", zap.Error(err), zap.Any("record", record))
+	}
+}
+
+// Totals returns usage of all days between from and to, inclusive
+func (t *UsageTracker) Totals(from time.Time, to time.Time) ([]UsageTotals, error) {
+	fromDay := from.Format(usageDayLayout)
+	toDay := to.Format(usageDayLayout)
+
+	var result []UsageTotals
+	err := t.store.ForEach(func(key string, totals UsageTotals) error {
+		if totals.Day > toDay {
+			// Keys are sorted by day
+			return storage.ErrStop
+		}
+
+		if totals.Day >= fromDay {
+			result = append(result, totals)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// estimateChatUsage counts tokens of the chat and the reply, for adapters that do not report usage
+func estimateChatUsage(chat *Chat, reply *ChatMessage) TokenUsage {
+	var input strings.Builder
+	for _, message := range chat.Messages {
+		if message == reply {
+			continue
+		}
+
+		input.WriteString(message.ChatMessage())
+	}
+
+	return TokenUsage{
+		InputTokens:  estimateTokens(input.String()),
+		OutputTokens: estimateTokens(reply.Contents),
+	}
+}
+
+// estimatePromptUsage counts tokens of the prompt and the reply, for adapters that do not report usage
+func estimatePromptUsage(prompt Prompt, reply string) TokenUsage {
+	return TokenUsage{
+		InputTokens:  estimateTokens(fmt.Sprintf("%s%s", prompt.Traits, prompt.Phrase)),
+		OutputTokens: estimateTokens(reply),
+	}
+}
+
+func estimateTokens(contents string) int64 {
+	tokens, err := openaiutil.CountTokens(contents, usageEstimateEncoding)
+	if err != nil {
+		logger.Error("failed to count tokens", zap.Error(err))
+		return 0
+	}
+
+	return int64(tokens)
+}
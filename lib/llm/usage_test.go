@@ -0,0 +1,79 @@
+package llm_test
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"lib/llm"
+	"lib/storage"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestModelPricing(t *testing.T) {
+	pricing := llm.ModelPricing{InputPerMillion: 2.5, OutputPerMillion: 10}
+
+	assert.InDelta(t, 0.0125, pricing.Cost(llm.TokenUsage{InputTokens: 1000, OutputTokens: 1000}), 1e-9)
+	assert.Zero(t, llm.ModelPricing{}.Cost(llm.TokenUsage{InputTokens: 1000, OutputTokens: 1000}))
+}
+
+func TestUsageTracker(t *testing.T) {
+	db, err := storage.Open(filepath.Join(t.TempDir(), "test.db"))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	tracker, err := llm.NewUsageTracker(db, map[string]llm.ModelPricing{
+		"openai": {InputPerMillion: 1, OutputPerMillion: 2},
+	})
+	assert.NoError(t, err)
+
+	chatCtx := llm.WithUsageContext(context.Background(), llm.FeatureChat, "wojtek")
+	tracker.Record(chatCtx, "openai", "", llm.TokenUsage{InputTokens: 1_000_000, OutputTokens: 500_000})
+	tracker.Record(chatCtx, "failover", "openai", llm.TokenUsage{InputTokens: 1_000_000, OutputTokens: 0})
+	tracker.Record(chatCtx, "ollama", "", llm.TokenUsage{InputTokens: 10, OutputTokens: 5})
+	tracker.Record(context.Background(), "openai", "", llm.TokenUsage{InputTokens: 10, OutputTokens: 5})
+
+	now := time.Now()
+	totals, err := tracker.Totals(now, now)
+	assert.NoError(t, err)
+
+	byKey := map[string]llm.UsageTotals{}
+	for _, total := range totals {
+		byKey[total.Adapter+"/"+string(total.Feature)+"/"+total.UserID] = total
+	}
+	assert.Len(t, byKey, 3)
+
+	t.Run("sums requests of the same adapter, feature and user", func(t *testing.T) {
+		chat := byKey["openai/chat/wojtek"]
+		assert.Equal(t, int64(2), chat.Requests)
+		assert.Equal(t, llm.TokenUsage{InputTokens: 2_000_000, OutputTokens: 500_000}, chat.TokenUsage)
+		assert.InDelta(t, 3.0, chat.Cost, 1e-9)
+	})
+
+	t.Run("estimates no cost without pricing", func(t *testing.T) {
+		assert.Equal(t, int64(1), byKey["ollama/chat/wojtek"].Requests)
+		assert.Zero(t, byKey["ollama/chat/wojtek"].Cost)
+	})
+
+	t.Run("counts untagged requests as unknown", func(t *testing.T) {
+		unknown := byKey["openai/unknown/"]
+		assert.Equal(t, int64(1), unknown.Requests)
+		assert.InDelta(t, 0.00002, unknown.Cost, 1e-12)
+	})
+
+	t.Run("returns only the given days", func(t *testing.T) {
+		totals, err := tracker.Totals(now.AddDate(0, 0, -2), now.AddDate(0, 0, -1))
+		assert.NoError(t, err)
+		assert.Empty(t, totals)
+	})
+
+	t.Run("adds totals", func(t *testing.T) {
+		var sum llm.UsageTotals
+		for _, total := range totals {
+			sum.Add(total)
+		}
+		assert.Equal(t, int64(4), sum.Requests)
+		assert.Equal(t, llm.TokenUsage{InputTokens: 2_000_020, OutputTokens: 500_010}, sum.TokenUsage)
+		assert.InDelta(t, 3.00002, sum.Cost, 1e-9)
+	})
+}
@@ -0,0 +1,219 @@
+package storage
+
+import (
+	goerrors "errors"
+	"github.com/goccy/go-json"
+	"go.etcd.io/bbolt"
+	"lib/errors"
+)
+
+// ErrStop can be returned from ForEach callback to stop the iteration without an error
+var ErrStop = goerrors.New("stop iteration")
+
+// Bucket is a named collection of JSON encoded values of type T
+type Bucket[T any] struct {
+	db   *DB
+	name []byte
+}
+
+// NewBucket returns the bucket with the given name, creating it if needed
+func NewBucket[T any](db *DB, name string) (*Bucket[T], error) {
+	err := db.bolt.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(name))
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create bucket "+name)
+	}
+
+	return &Bucket[T]{db: db, name: []byte(name)}, nil
+}
+
+// Get returns the value stored under the key, or nil if there is none
+func (b *Bucket[T]) Get(key string) (*T, error) {
+	var value *T
+
+	err := b.db.bolt.View(func(tx *bbolt.Tx) error {
+		data := tx.Bucket(b.name).Get([]byte(key))
+		if data == nil {
+			return nil
+		}
+
+		value = new(T)
+		return json.Unmarshal(data, value)
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get "+key)
+	}
+
+	return value, nil
+}
+
+// Put stores the value under the key, replacing the existing one
+func (b *Bucket[T]) Put(key string, value T) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode "+key)
+	}
+
+	return b.db.bolt.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(b.name).Put([]byte(key), data)
+	})
+}
+
+// PutAll stores all the values under their keys in a single transaction
+func (b *Bucket[T]) PutAll(values map[string]T) error {
+	encoded := make(map[string][]byte, len(values))
+	for key, value := range values {
+		data, err := json.Marshal(value)
+		if err != nil {
+			return errors.Wrap(err, "failed to encode "+key)
+		}
+		encoded[key] = data
+	}
+
+	return b.db.bolt.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(b.name)
+		for key, data := range encoded {
+			err := bucket.Put([]byte(key), data)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Update atomically replaces the value stored under the key with the one returned by fn. Value passed to fn is nil, if the key is missing.
+func (b *Bucket[T]) Update(key string, fn func(value *T) T) error {
+	return b.db.bolt.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(b.name)
+
+		var current *T
+		if data := bucket.Get([]byte(key)); data != nil {
+			current = new(T)
+			err := json.Unmarshal(data, current)
+			if err != nil {
+				return errors.Wrap(err, "failed to decode "+key)
+			}
+		}
+
+		data, err := json.Marshal(fn(current))
+		if err != nil {
+			return errors.Wrap(err, "failed to encode "+key)
+		}
+
+		return bucket.Put([]byte(key), data)
+	})
+}
+
+// UpdateExisting atomically replaces the value stored under the key with the one returned by fn, and returns it.
+// Missing keys are not created, nil is returned for them instead.
+func (b *Bucket[T]) UpdateExisting(key string, fn func(value T) T) (*T, error) {
+	var updated *T
+
+	err := b.db.bolt.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(b.name)
+
+		data := bucket.Get([]byte(key))
+		if data == nil {
+			return nil
+		}
+
+		var current T
+		err := json.Unmarshal(data, &current)
+		if err != nil {
+			return errors.Wrap(err, "failed to decode "+key)
+		}
+
+		value := fn(current)
+		data, err = json.Marshal(value)
+		if err != nil {
+			return errors.Wrap(err, "failed to encode "+key)
+		}
+
+		updated = &value
+		return bucket.Put([]byte(key), data)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return updated, nil
+}
+
+// Take atomically removes the value stored under the key, and returns it. Nil is returned if there is none,
+// so that only one of concurrent callers gets the value.
+func (b *Bucket[T]) Take(key string) (*T, error) {
+	var value *T
+
+	err := b.db.bolt.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(b.name)
+
+		data := bucket.Get([]byte(key))
+		if data == nil {
+			return nil
+		}
+
+		value = new(T)
+		err := json.Unmarshal(data, value)
+		if err != nil {
+			return errors.Wrap(err, "failed to decode "+key)
+		}
+
+		return bucket.Delete([]byte(key))
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to take "+key)
+	}
+
+	return value, nil
+}
+
+// Delete removes values stored under the keys. Deleting a missing key is not an error.
+func (b *Bucket[T]) Delete(keys ...string) error {
+	return b.db.bolt.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(b.name)
+		for _, key := range keys {
+			err := bucket.Delete([]byte(key))
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// ForEach calls fn for every value in the bucket, ordered by key
+func (b *Bucket[T]) ForEach(fn func(key string, value T) error) error {
+	err := b.db.bolt.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(b.name).ForEach(func(k, data []byte) error {
+			var value T
+			err := json.Unmarshal(data, &value)
+			if err != nil {
+				return errors.Wrap(err, "failed to decode "+string(k))
+			}
+
+			return fn(string(k), value)
+		})
+	})
+	if goerrors.Is(err, ErrStop) {
+		return nil
+	}
+
+	return err
+}
+
+// Count returns the number of values in the bucket
+func (b *Bucket[T]) Count() (int, error) {
+	count := 0
+
+	err := b.db.bolt.View(func(tx *bbolt.Tx) error {
+		count = tx.Bucket(b.name).Stats().KeyN
+		return nil
+	})
+
+	return count, err
+}
@@ -0,0 +1,127 @@
+package storage_test
+
+import (
+	"github.com/stretchr/testify/assert"
+	"lib/storage"
+	"path/filepath"
+	"testing"
+)
+
+type item struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+func TestBucket(t *testing.T) {
+	db, err := storage.Open(filepath.Join(t.TempDir(), "nested", "test.db"))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	bucket, err := storage.NewBucket[item](db, "items")
+	assert.NoError(t, err)
+
+	t.Run("returns nil for missing keys", func(t *testing.T) {
+		value, err := bucket.Get("missing")
+		assert.NoError(t, err)
+		assert.Nil(t, value)
+	})
+
+	t.Run("puts and gets values", func(t *testing.T) {
+		assert.NoError(t, bucket.Put("b", item{Name: "b", Count: 2}))
+		assert.NoError(t, bucket.Put("a", item{Name: "a", Count: 1}))
+
+		value, err := bucket.Get("a")
+		assert.NoError(t, err)
+		assert.Equal(t, &item{Name: "a", Count: 1}, value)
+
+		count, err := bucket.Count()
+		assert.NoError(t, err)
+		assert.Equal(t, 2, count)
+	})
+
+	t.Run("iterates in order of keys", func(t *testing.T) {
+		var keys []string
+		err := bucket.ForEach(func(key string, value item) error {
+			keys = append(keys, key)
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"a", "b"}, keys)
+	})
+
+	t.Run("stops iteration without an error", func(t *testing.T) {
+		var keys []string
+		err := bucket.ForEach(func(key string, value item) error {
+			keys = append(keys, key)
+			return storage.ErrStop
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"a"}, keys)
+	})
+
+	t.Run("updates values", func(t *testing.T) {
+		increment := func(value *item) item {
+			if value == nil {
+				return item{Name: "c", Count: 1}
+			}
+
+			value.Count++
+			return *value
+		}
+		assert.NoError(t, bucket.Update("a", increment))
+		assert.NoError(t, bucket.Update("c", increment))
+
+		a, err := bucket.Get("a")
+		assert.NoError(t, err)
+		assert.Equal(t, 2, a.Count)
+
+		c, err := bucket.Get("c")
+		assert.NoError(t, err)
+		assert.Equal(t, 1, c.Count)
+	})
+
+	t.Run("updates only existing values", func(t *testing.T) {
+		rename := func(value item) item {
+			value.Name = "renamed"
+			return value
+		}
+
+		updated, err := bucket.UpdateExisting("c", rename)
+		assert.NoError(t, err)
+		assert.Equal(t, &item{Name: "renamed", Count: 1}, updated)
+
+		missing, err := bucket.UpdateExisting("missing", rename)
+		assert.NoError(t, err)
+		assert.Nil(t, missing)
+
+		value, err := bucket.Get("missing")
+		assert.NoError(t, err)
+		assert.Nil(t, value)
+	})
+
+	t.Run("takes values only once", func(t *testing.T) {
+		value, err := bucket.Take("c")
+		assert.NoError(t, err)
+		assert.Equal(t, &item{Name: "renamed", Count: 1}, value)
+
+		value, err = bucket.Take("c")
+		assert.NoError(t, err)
+		assert.Nil(t, value)
+	})
+
+	t.Run("puts many values at once", func(t *testing.T) {
+		assert.NoError(t, bucket.PutAll(map[string]item{"d": {Name: "dee"}, "e": {Name: "ee", Count: 5}}))
+
+		value, err := bucket.Get("e")
+		assert.NoError(t, err)
+		assert.Equal(t, &item{Name: "ee", Count: 5}, value)
+	})
+
+	t.Run("deletes values", func(t *testing.T) {
+		assert.NoError(t, bucket.Delete("a", "missing"))
+
+		value, err := bucket.Get("a")
+		assert.NoError(t, err)
+		assert.Nil(t, value)
+	})
+}
@@ -0,0 +1,33 @@
+package storage
+
+import (
+	"go.etcd.io/bbolt"
+	"lib/errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// DB is an embedded key-value database, persisted in a single file
+type DB struct {
+	bolt *bbolt.DB
+}
+
+// Open opens the database at the given path, creating it and its directory if needed
+func Open(path string) (*DB, error) {
+	err := os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create database directory")
+	}
+
+	bolt, err := bbolt.Open(path, 0o600, &bbolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open database")
+	}
+
+	return &DB{bolt: bolt}, nil
+}
+
+func (db *DB) Close() error {
+	return db.bolt.Close()
+}
@@ -0,0 +1,162 @@
+// memory-backup exports memories of Wojciech to a JSON archive, and imports archives into the OpenAI or the local store.
+// Importing an export of the other store migrates memories between them. The bot must be stopped while the local store
+// is used, since the database can't be opened twice. The database must already exist, so that a wrong path is not
+// mistaken for an empty store.
+//
+//	go run ./memory-backup export -store openai -file memories.json
+//	go run ./memory-backup import -store local -db ../wojciech-bot/data/wojciech.db -file memories.json
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/joho/godotenv"
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+	"go.uber.org/zap"
+	"lib/llm"
+	"lib/logging"
+	"lib/storage"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+	"wojciech-bot/env"
+	"wojciech-bot/memory"
+)
+
+var logger = logging.Get()
+
+func main() {
+	if len(os.Args) < 2 || (os.Args[1] != "export" && os.Args[1] != "import") {
+		fmt.Fprintln(os.Stderr, "usage: memory-backup export|import [-env ../.env] [-store openai|local] [-db path] -file memories.json")
+		os.Exit(2)
+	}
+
+	command := os.Args[1]
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	envPath := flags.String("env", "../.env", "path of the .env file of the bot")
+	kind := flags.String("store", "", "store to export from or import into, openai or local, defaults to the store of the bot")
+	dbPath := flags.String("db", "", "path of the database of the local store, defaults to the database of the bot")
+	path := flags.String("file", fmt.Sprintf("memories-%d.json", time.Now().Unix()), "path of the archive")
+	_ = flags.Parse(os.Args[2:])
+
+	err := godotenv.Load(*envPath)
+	if err != nil {
+		logger.Warn("error loading .env file", zap.String("path", *envPath), zap.Error(err))
+	}
+
+	env.Init()
+
+	if *kind == "" {
+		*kind = env.Env.MemoryStore
+	}
+	if *dbPath == "" {
+		*dbPath = env.Env.DatabasePath
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+
+	store, closeStore, err := newStore(*kind, *dbPath)
+	if err != nil {
+		logger.Fatal("failed to create memory store", zap.String("store", *kind), zap.Error(err))
+	}
+	defer closeStore()
+
+	if command == "export" {
+		err = exportArchive(ctx, store, *path)
+	} else {
+		err = importArchive(ctx, store, *path)
+	}
+	if err != nil {
+		logger.Fatal("memory backup failed", zap.String("command", command), zap.Error(err))
+	}
+}
+
+func exportArchive(ctx context.Context, store memory.Store, path string) error {
+	archive, err := memory.Export(ctx, store)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	err = archive.Write(file)
+	if err != nil {
+		return err
+	}
+
+	logger.Info("exported memories", zap.Int("memories", len(archive.Memories)), zap.String("file", path))
+
+	return nil
+}
+
+func importArchive(ctx context.Context, store memory.Store, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	archive, err := memory.ReadArchive(file)
+	if err != nil {
+		return err
+	}
+
+	result, err := memory.Import(ctx, store, archive)
+	logger.Info("imported memories", zap.Int("imported", result.Imported), zap.Int("skipped", result.Skipped), zap.String("file", path))
+
+	return err
+}
+
+// newStore configures the store the same way as the bot does, from the environment
+func newStore(kind string, dbPath string) (memory.Store, func(), error) {
+	switch kind {
+	case "openai":
+		client := openai.NewClient(option.WithAPIKey(env.Env.OpenAIApiKey))
+
+		return memory.NewOpenAIStore(&client, env.Env.OpenAIAssistantVectorStoreID), func() {}, nil
+
+	case "local":
+		ollamaURL, err := url.Parse(env.Env.OllamaHost)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// Opening a missing database would create an empty one, instead of using memories of the bot
+		_, err = os.Stat(dbPath)
+		if err != nil {
+			return nil, nil, fmt.Errorf("database %q of the local store must exist: %w", dbPath, err)
+		}
+
+		db, err := storage.Open(dbPath)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		ollamaAdapter := llm.NewOllamaAdapter(env.Env.OllamaModel, ollamaURL, &http.Client{Timeout: 5 * time.Minute})
+		memoryStore, err := memory.NewOllamaMemoryStore(db, ollamaAdapter, env.Env.OllamaEmbeddingModel)
+		if err != nil {
+			_ = db.Close()
+
+			return nil, nil, err
+		}
+
+		localStore, err := memory.NewLocalStore(db, memoryStore)
+		if err != nil {
+			_ = db.Close()
+
+			return nil, nil, err
+		}
+
+		return localStore, func() { _ = db.Close() }, nil
+	}
+
+	return nil, nil, fmt.Errorf("unknown memory store %q", kind)
+}
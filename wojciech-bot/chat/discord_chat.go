@@ -14,6 +14,8 @@ import (
 	"lib/util/arrayutil"
 	"sync"
 	"time"
+	"wojciech-bot/env"
+	"wojciech-bot/memory"
 	"wojciech-bot/messages"
 )
 
@@ -30,33 +32,60 @@ type DiscordChat struct {
 	log *zap.Logger
 	// thread in which chat takes place
 	thread *discordgo.Channel
-	// llmContainer provides access to the LLM (Large Language Model) API for handling chat-related operations in the DiscordChat struct.
-	llmContainer *llm.Container
+	// llmRouter provides LLM APIs for tasks of the chat, e.g. replies and thread titles
+	llmRouter *llm.Router
 	// firstMessage contains content of the first message that started the thread
 	firstMessage *discordgo.Message
 	// isFinished indicates if the chat discussion is finished
 	isFinished bool
-	// TODO When message is deleted, delete it from here
-	// chat is the underlying chat used by llm
+	// chat is the underlying chat used by llm, edited and deleted Discord messages are synced into it
 	chat *llm.Chat
 	// onDiscussionEnded is called after discussion is ended
 	onDiscussionEnded *func(chat *DiscordChat)
-	//memory            *DiscordChatMemory
+	// store saves the chat after every message, so that the discussion survives restarts of the bot
+	store ChatStore
+	// memory extracts details worth remembering from the discussion, nil if memory review is not configured
+	memory *DiscordChatMemory
 }
 
-func NewDiscordChat(bot *libdiscord.Bot, cid string, llmContainer *llm.Container) *DiscordChat {
+func NewDiscordChat(bot *libdiscord.Bot, cid string, llmRouter *llm.Router, tools *llm.ToolRegistry, store ChatStore, memories memory.Store) *DiscordChat {
 	logger := logging.Get().Named("chat").With(zap.String("parentCid", cid), zap.String("bot", bot.State.User.Username))
 
+	chat := llm.NewChat()
+	chat.Tools = tools
+
+	// Extracted memories must be approved by a moderator, so they are extracted only if there is one
+	var chatMemory *DiscordChatMemory
+	if env.Env.MemoryReviewChannelID != "" {
+		chatMemory = NewDiscordChatMemory(bot.Session, llmRouter, memories)
+	}
+
 	return &DiscordChat{
-		bot:          bot,
-		parentCid:    cid,
-		log:          logger,
-		llmContainer: llmContainer,
-		chat:         llm.NewChat(),
-		//memory:       NewDiscordChatMemory(bot, llmContainer),
+		bot:       bot,
+		parentCid: cid,
+		log:       logger,
+		llmRouter: llmRouter,
+		chat:      chat,
+		store:     store,
+		memory:    chatMemory,
 	}
 }
 
+// restoreDiscordChat continues the discussion saved in the store before a restart
+func restoreDiscordChat(bot *libdiscord.Bot, state *ChatState, llmRouter *llm.Router, tools *llm.ToolRegistry, store ChatStore, memories memory.Store) *DiscordChat {
+	chat := NewDiscordChat(bot, state.ParentCid, llmRouter, tools, store, memories)
+	chat.log = chat.log.With(zap.String("threadID", state.ThreadID))
+	// Channel is fetched again with the next message, only its ID is needed to find the chat
+	chat.thread = &discordgo.Channel{ID: state.ThreadID, ParentID: state.ParentCid}
+	chat.isFinished = state.IsFinished
+	if state.Chat != nil {
+		state.Chat.Tools = tools
+		chat.chat = state.Chat
+	}
+
+	return chat
+}
+
 func (c *DiscordChat) HandleNewMessage(message *discordgo.Message) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -69,9 +98,12 @@ func (c *DiscordChat) HandleNewMessage(message *discordgo.Message) error {
 
 		return nil
 	}
+	defer c.save()
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
 	defer cancel()
+	ctx = llm.WithUsageContext(ctx, llm.FeatureChat, message.Author.ID)
+	ctx = llm.WithPriority(ctx, llm.PriorityInteractive)
 	err := c.ensureThread(ctx, message)
 	if err != nil {
 		return err
@@ -87,13 +119,20 @@ func (c *DiscordChat) HandleNewMessage(message *discordgo.Message) error {
 	chatMessage := llm.NewDiscordChatMessage(message)
 	chat.AddMessages(chatMessage)
 
-	chat, newMessage, newMessageMetadata, err := c.llmContainer.AssistantAPI.Chat(ctx, chat)
+	// Reply is sent as soon as first part of it is generated, and then edited while the rest arrives
+	reply := newStreamingReply(c.bot, c.thread.ID, log)
+	// Tools called by the LLM need to know who they are acting for
+	toolCtx := withToolMessage(ctx, message)
+	chat, newMessage, newMessageMetadata, err := c.llmRouter.API(llm.TaskChat).ChatStream(toolCtx, chat, func(delta string) error {
+		return reply.Write(ctx, delta)
+	})
 	if err != nil {
-		log.Error("failed to get new chat from llmContainer", zap.Error(err))
+		log.Error("failed to get new chat from llmRouter", zap.Error(err))
+		reply.Discard(ctx)
 
 		var tooLongError llm.ErrPromptTooLong
 		if goerrors.As(err, &tooLongError) {
-			// DiscordChat got too long for llm to handle, finish the discussion
+			// DiscordChat got too long for llm to handle even after compaction, finish the discussion
 			return c.EndDiscussion(ctx, message)
 		}
 
@@ -104,36 +143,95 @@ func (c *DiscordChat) HandleNewMessage(message *discordgo.Message) error {
 	// Keep the updated chat
 	c.chat = chat
 
-	sentMessage, err := c.bot.ChannelMessageSend(c.thread.ID, newMessage.Contents, discordgo.WithContext(ctx))
+	sentMessage, err := reply.Finish(ctx, newMessage.Contents)
 	if err != nil {
 		log.Error("failed to send new message", zap.Error(err))
 		return errors.Wrap(err, "failed to send new message")
 	}
 	newMessage.ID = sentMessage.ID
 
-	if newMessageMetadata.IsGoodbye {
+	if hasReasoning(newMessage) {
+		components := ReasoningMessageComponent()
+		_, err = c.bot.ChannelMessageEditComplex(&discordgo.MessageEdit{
+			ID:         sentMessage.ID,
+			Channel:    sentMessage.ChannelID,
+			Components: &components,
+		}, discordgo.WithContext(ctx))
+		if err != nil {
+			log.Error("failed to add reasoning button", zap.Error(err))
+		}
+	}
+
+	if newMessageMetadata != nil && newMessageMetadata.Backend != "" {
+		log.Info("reply generated", zap.String("backend", newMessageMetadata.Backend))
+	}
+
+	if newMessageMetadata != nil && newMessageMetadata.IsGoodbye {
 		log.Info("bot said goodbye, ending discussion")
 
 		return c.EndDiscussion(ctx, message)
 	}
 
-	//go c.memory.AddMessage(message)
+	if c.memory != nil {
+		go c.memory.AddMessage(message)
+	}
 
 	return nil
 }
 
+// HandleMessageUpdate replaces contents of the edited user message in the chat
+func (c *DiscordChat) HandleMessageUpdate(message *discordgo.Message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	chatMessage, ok := c.chat.FindMessage(message.ID)
+	if !ok || chatMessage.Role != llm.ChatRoleUser {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	updated, err := c.llmRouter.API(llm.TaskChat).UpdateMessage(ctx, c.chat, message.ID, message.ContentWithMentionsReplaced())
+	if updated {
+		c.log.Info("message edited", zap.String("messageID", message.ID))
+		c.save()
+	}
+
+	return err
+}
+
+// HandleMessageDelete removes the deleted message from the chat
+func (c *DiscordChat) HandleMessageDelete(messageID string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	deleted, err := c.llmRouter.API(llm.TaskChat).DeleteMessage(ctx, c.chat, messageID)
+	if deleted {
+		c.log.Info("message deleted", zap.String("messageID", messageID))
+		c.save()
+	}
+
+	return err
+}
+
 // EndDiscussion ends the current DiscordChat discussion by reacting to a specified message and marking it as finished.
 func (c *DiscordChat) EndDiscussion(ctx context.Context, message *discordgo.Message) error {
 	if c.thread == nil {
 		return goerrors.New("unable to end discussion, no thread exists")
 	}
 
-	/*go func() {
-		err := c.memory.ForceRemember()
-		if err != nil {
-			log.Error("failed to force remember messages", zap.Error(err))
-		}
-	}()*/
+	if c.memory != nil {
+		go func() {
+			err := c.memory.ForceRemember()
+			if err != nil {
+				log.Error("failed to force remember messages", zap.Error(err))
+			}
+		}()
+	}
 
 	err := c.bot.MessageReactionAdd(c.thread.ID, message.ID, libdiscord.ReactionBye, discordgo.WithContext(ctx))
 	if err != nil {
@@ -141,7 +239,9 @@ func (c *DiscordChat) EndDiscussion(ctx context.Context, message *discordgo.Mess
 	}
 
 	c.isFinished = true
-	//c.memory.StopTick()
+	if c.memory != nil {
+		c.memory.StopTick()
+	}
 
 	if c.onDiscussionEnded != nil {
 		(*c.onDiscussionEnded)(c)
@@ -150,6 +250,29 @@ func (c *DiscordChat) EndDiscussion(ctx context.Context, message *discordgo.Mess
 	return nil
 }
 
+// isDirectMessage returns true if the chat takes place in direct messages, instead of a thread
+func (c *DiscordChat) isDirectMessage() bool {
+	return c.thread != nil && c.thread.ID == c.parentCid
+}
+
+// save stores the chat. Failure is only logged, since the discussion can go on without it.
+func (c *DiscordChat) save() {
+	if c.thread == nil {
+		return
+	}
+
+	err := c.store.Save(ChatState{
+		ThreadID:   c.thread.ID,
+		ParentCid:  c.parentCid,
+		IsFinished: c.isFinished,
+		Chat:       c.chat,
+		UpdatedAt:  time.Now(),
+	})
+	if err != nil {
+		c.log.Error("failed to save chat", zap.Error(err))
+	}
+}
+
 // ensureThread ensures a message thread is created for the given message. If the thread does not exist, it creates one.
 func (c *DiscordChat) ensureThread(ctx context.Context, message *discordgo.Message) error {
 	log := c.log.With(zap.String("messageID", message.ID))
@@ -185,7 +308,8 @@ func (c *DiscordChat) ensureThread(ctx context.Context, message *discordgo.Messa
 		// Add the first message to the chat
 		c.chat.AddMessages(llm.NewDiscordChatMessage(message))
 
-		threadSummary, err := prompts.SummarizeDiscordThread(ctx, c.llmContainer.AssistantAPI, message.Content)
+		summaryCtx := llm.WithUsageContext(ctx, llm.FeatureSummary, message.Author.ID)
+		threadSummary, err := prompts.SummarizeDiscordThread(summaryCtx, c.llmRouter.API(llm.TaskThreadTitle), message.Content)
 		if err != nil {
 			log.Error("failed to get thread summary", zap.Error(err))
 			return errors.Wrap(err, "failed to summarize this message")
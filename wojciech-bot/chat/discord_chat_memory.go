@@ -10,22 +10,31 @@ import (
 	llm2 "lib/llm"
 	"lib/logging"
 	"lib/util/arrayutil"
+	"slices"
 	"strings"
 	"sync"
 	"time"
 	chatevents "wojciech-bot/chat/events"
+	"wojciech-bot/memory"
 )
 
 const batchCount = 10
 
+// knownMemoriesPerFact is how many similar memories are shown to the filter for every extracted fact
+const knownMemoriesPerFact = 3
+
 var log = logging.Get().Named("chat").Named("memory")
 
 type DiscordChatMemory struct {
 	// messages store the current batch of messages that will be parsed and remembered when length is > batchCount
 	messages           []*discordgo.Message
 	session            *discordgo.Session
-	llmContainer       *llm2.Container
+	llmRouter          *llm2.Router
 	handledMessagesIds []string
+	// memories are searched for facts similar to the extracted ones, so that the filter knows what is already remembered
+	memories memory.Store
+	// authorID is an ID of the first user, who wrote in the discussion, that is the author of extracted memories
+	authorID string
 
 	inactivityTimer    *time.Timer
 	inactivityDuration time.Duration
@@ -33,11 +42,12 @@ type DiscordChatMemory struct {
 }
 
 // TODO also trigger after last message was sent ~30 minutes ago
-func NewDiscordChatMemory(session *discordgo.Session, llmContainer *llm2.Container) *DiscordChatMemory {
+func NewDiscordChatMemory(session *discordgo.Session, llmRouter *llm2.Router, memories memory.Store) *DiscordChatMemory {
 	return &DiscordChatMemory{
 		session:            session,
 		messages:           []*discordgo.Message{},
-		llmContainer:       llmContainer,
+		llmRouter:          llmRouter,
+		memories:           memories,
 		inactivityDuration: 30 * time.Minute,
 		handledMessagesIds: make([]string, 0),
 	}
@@ -131,12 +141,16 @@ func (m *DiscordChatMemory) remember() error {
 	// Create context with timeout
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
 	defer cancel()
+	// Memory is extracted from messages of many users, so usage is not assigned to any of them
+	ctx = llm2.WithUsageContext(ctx, llm2.FeatureMemory, "")
+	ctx = llm2.WithPriority(ctx, llm2.PriorityBackground)
 
 	// Get the thread ID from the first message (they should all be from the same thread)
 	threadID := m.messages[0].ChannelID
 
-	// Prepare user messages for extraction
+	// Prepare user messages for extraction, and remember IDs of participants, so that facts can be linked to users they are about
 	userMessages := make([]string, 0, len(m.messages))
+	participants := map[string]string{}
 	for _, msg := range m.messages {
 		// Skip bot messages, or ours
 		if msg.Author.Bot || msg.Author.ID == m.session.State.User.ID {
@@ -152,6 +166,10 @@ func (m *DiscordChatMemory) remember() error {
 		} else {
 			username = msg.Author.Username
 		}
+		participants[username] = msg.Author.ID
+		if m.authorID == "" {
+			m.authorID = msg.Author.ID
+		}
 		userMessages = append(userMessages, fmt.Sprintf("%s wrote: %s", username, msg.Content))
 	}
 
@@ -172,24 +190,32 @@ func (m *DiscordChatMemory) remember() error {
 		return nil
 	}
 
-	filteredDetails, err := m.filterDetails(ctx, details, threadID)
+	facts, err := m.filterDetails(ctx, details, threadID, participants)
 	if err != nil {
 		log.Error("failed to filter details", zap.Error(err))
 		return err
 	}
-	if filteredDetails == "" {
+	if len(facts) == 0 {
 		log.Info("no details after filtering")
 		m.resetMessages()
 		return nil
 	}
 
-	err = events.Dispatch(ctx, chatevents.MemoryDetailsExtracted{
-		Details:         filteredDetails,
-		DiscordThreadID: threadID,
-	})
-	if err != nil {
-		log.Error("failed to dispatch MemoryDetailsExtracted event", zap.Error(err), zap.String("threadID", threadID))
-		// Don't return here, we still want to clear the messages
+	// Each fact is dispatched separately, so that it keeps its own subjects and confidence
+	now := time.Now()
+	for _, fact := range facts {
+		err = events.Dispatch(ctx, chatevents.MemoryDetailsExtracted{
+			Details:         fact.Fact,
+			DiscordThreadID: threadID,
+			AuthorID:        m.authorID,
+			SubjectIDs:      subjectIDs(fact.Subjects, participants),
+			Confidence:      min(max(fact.Confidence, 0), 1),
+			CreatedAt:       now,
+		})
+		if err != nil {
+			log.Error("failed to dispatch MemoryDetailsExtracted event", zap.Error(err), zap.String("threadID", threadID))
+			// Don't return here, we still want to clear the messages
+		}
 	}
 
 	m.resetMessages()
@@ -197,75 +223,143 @@ func (m *DiscordChatMemory) remember() error {
 	return nil
 }
 
+type memoryExtractVars struct {
+	Messages string
+}
+
+type memoryFilterVars struct {
+	ThreadID     string
+	Details      string
+	Participants []string
+	// Known are remembered facts similar to the details
+	Known []string
+}
+
+type memoryFilterReply struct {
+	NewDetails []memoryFact `json:"new_details" description:"details that are not known yet, one fact per item"`
+}
+
+type memoryFact struct {
+	Fact       string   `json:"fact" description:"a single new fact"`
+	Subjects   []string `json:"subjects" description:"names of people the fact is about, empty if it is not about anyone"`
+	Confidence float64  `json:"confidence" description:"from 0 to 1, how sure you are that the fact is true"`
+}
+
 // filterDetails checks if the extracted details contain information that is already known
-// and filters out any duplicate information, returning only new details.
-func (m *DiscordChatMemory) filterDetails(ctx context.Context, details string, threadID string) (string, error) {
+// and filters out any duplicate information, returning only new facts.
+func (m *DiscordChatMemory) filterDetails(ctx context.Context, details string, threadID string, participants map[string]string) ([]memoryFact, error) {
 	// If details are empty, no need to filter
 	if details == "" {
-		return "", nil
+		return nil, nil
 	}
 
 	log.Info("filtering details for duplicates", zap.String("threadID", threadID), zap.String("details", details))
 
-	// Create system prompt for filtering
-	systemPrompt := "You are a memory management assistant that identifies new vs. already known information. " +
-		"Your task is to analyze details extracted from a conversation and determine which details are genuinely new. " +
-		"If you already know certain information from your knowledge base, remove it from the output. " +
-		"Return ONLY the details that appear to be new information. " +
-		"If all details are already known, return an empty string. " +
-		"If all details are new, return them in their original form. " +
-		"Format your response as a list of facts separated by newlines." +
-		"Please filter out any information you already have in your knowledge base and return only new details" +
-		"If there are no new details, return ONLY an empty string"
-
-	// Create a prompt for the LLM
-	prompt := llm2.Prompt{
-		Traits: systemPrompt,
-		Phrase: "These details were extracted from a Discord conversation in thread " + threadID + ":\n\n" + details,
+	known, err := m.knownMemories(ctx, details)
+	if err != nil {
+		log.Error("failed to search known memories", zap.Error(err), zap.String("threadID", threadID))
+		return nil, err
+	}
+
+	vars := memoryFilterVars{ThreadID: threadID, Details: details, Known: known}
+	for name := range participants {
+		vars.Participants = append(vars.Participants, name)
 	}
+	slices.Sort(vars.Participants)
 
-	// Call the expensive API to filter details
-	response, _, err := m.llmContainer.ExpensiveAPI.Prompt(ctx, prompt)
+	prompt, err := llm2.RenderPrompt("memory-filter", vars)
 	if err != nil {
-		log.Error("failed to filter details", zap.Error(err), zap.String("threadID", threadID))
-		return details, err // Return original details on error
+		return nil, err
 	}
 
-	// If the response is empty or nil, assume no new details
-	if response == nil || response.Reply == "" {
-		log.Info("no new details after filtering", zap.String("threadID", threadID))
-		return "", nil
+	// Call the API routed for filtering memories
+	reply, err := llm2.PromptJSON[memoryFilterReply](ctx, m.llmRouter.API(llm2.TaskMemoryFilter), prompt)
+	if err != nil {
+		log.Error("failed to filter details", zap.Error(err), zap.String("threadID", threadID))
+		return nil, err
 	}
 
-	// Trim and clean up the response
-	filteredDetails := strings.TrimSpace(response.Reply)
+	facts := arrayutil.Filter(reply.NewDetails, func(fact memoryFact) bool {
+		return strings.TrimSpace(fact.Fact) != ""
+	})
 
-	// If the filtered response indicates all details are known, return empty string
-	if strings.Contains(strings.ToLower(filteredDetails), "already known") ||
-		strings.Contains(strings.ToLower(filteredDetails), "no new details") {
+	// If there are no new details, all of them are already known
+	if len(facts) == 0 {
 		log.Info("all details already known", zap.String("threadID", threadID))
-		return "", nil
+		return nil, nil
 	}
 
-	log.Info("filtered details", zap.String("filteredDetails", filteredDetails), zap.String("threadID", threadID))
-	return filteredDetails, nil
+	log.Info("filtered details", zap.Int("facts", len(facts)), zap.String("threadID", threadID))
+	return facts, nil
+}
+
+// knownMemories searches the store for memories similar to every extracted fact, one fact per line of the details
+func (m *DiscordChatMemory) knownMemories(ctx context.Context, details string) ([]string, error) {
+	if m.memories == nil {
+		return nil, nil
+	}
+
+	var known []string
+	seen := map[string]bool{}
+	for _, line := range strings.Split(details, "\n") {
+		fact := strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(line), "-*"))
+		if fact == "" {
+			continue
+		}
+
+		entries, err := m.memories.Search(ctx, fact, "", knownMemoriesPerFact)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range entries {
+			if !seen[entry.ID] {
+				seen[entry.ID] = true
+				known = append(known, entry.Content)
+			}
+		}
+	}
+
+	return known, nil
+}
+
+// subjectIDs maps names of subjects to IDs of users, first participants of the discussion, then known friends.
+// Names that match nobody are skipped.
+func subjectIDs(subjects []string, participants map[string]string) []string {
+	var ids []string
+	for _, subject := range subjects {
+		id, ok := subjectID(strings.TrimSpace(subject), participants)
+		if ok && !slices.Contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+
+	return ids
+}
+
+func subjectID(name string, participants map[string]string) (string, bool) {
+	for participant, id := range participants {
+		if strings.EqualFold(participant, name) {
+			return id, true
+		}
+	}
+
+	for id, friend := range discord.Friends {
+		if strings.EqualFold(friend.FirstName, name) || strings.EqualFold(friend.Nickname, name) {
+			return id, true
+		}
+	}
+
+	return "", false
 }
 
 func (m *DiscordChatMemory) extractDetails(ctx context.Context, userMessages string, threadID string) (string, error) {
-	systemPrompt := "You are a memory management assistant that parses conversation messages and extracts useful details for memory. " +
-		"Extract specific, factual details about people, preferences, or important information mentioned ONLY in these messages. " +
-		"Focus on details that answer WHO and WHAT questions. " +
-		"Return ONLY the most important details in concise, factual statements separated by newlines. " +
-		"If there are no important details worth remembering, return ONLY an empty string." +
-		"Do NOT return any details that you already remember." +
-		"Do NOT return any additional details, only these extracted from given messages."
-
-	prompt := llm2.Prompt{
-		Traits: systemPrompt,
-		Phrase: "Messages from Discord chat:\n" + userMessages,
+	prompt, err := llm2.RenderPrompt("memory-extract", memoryExtractVars{Messages: userMessages})
+	if err != nil {
+		return "", err
 	}
 
-	response, _, err := m.llmContainer.ExpensiveAPI.Prompt(ctx, prompt)
+	response, _, err := m.llmRouter.API(llm2.TaskMemoryExtract).Prompt(ctx, prompt)
 	if err != nil {
 		log.Error("failed to extract details from messages", zap.Error(err), zap.String("threadID", threadID))
 		return "", err
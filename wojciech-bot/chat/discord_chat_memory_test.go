@@ -0,0 +1,43 @@
+package chat_test
+
+import (
+	"context"
+	"github.com/bwmarrin/discordgo"
+	"github.com/stretchr/testify/assert"
+	"lib/llm"
+	"lib/storage"
+	"path/filepath"
+	"testing"
+	"wojciech-bot/chat"
+	"wojciech-bot/memory"
+)
+
+func TestDiscordChatMemoryFilter(t *testing.T) {
+	db, err := storage.Open(filepath.Join(t.TempDir(), "test.db"))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	memoryStore, err := llm.NewMemoryStore(db, llm.NewAPI(llm.NewScriptedAdapter(), "scripted"), llm.MemoryStoreOptions{})
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+	store, err := memory.NewLocalStore(db, memoryStore)
+	assert.NoError(t, err)
+	_, err = store.Remember(ctx, memory.Entry{Content: "Artur plays the electric guitar"})
+	assert.NoError(t, err)
+
+	adapter := llm.NewScriptedAdapter(llm.ScriptedRule{
+		Reply: `{"new_details": [{"fact": "Kasia lives in Kraków", "subjects": ["Kasia"], "confidence": 0.9}]}`,
+	})
+	chatMemory := chat.NewDiscordChatMemory(&discordgo.Session{}, llm.NewSingleAdapterRouter(adapter), store)
+
+	facts, err := chatMemory.FilterDetails(ctx, "- Artur plays the guitar\n- Kasia lives in Kraków")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Kasia lives in Kraków"}, facts)
+
+	t.Run("shows remembered facts to the filter", func(t *testing.T) {
+		requests := adapter.Requests()
+		assert.Len(t, requests, 1)
+		assert.Contains(t, requests[0], "- Artur plays the electric guitar\n")
+	})
+}
@@ -1,9 +1,27 @@
 package chatevents
 
+import "time"
+
 // MemoryDetailsExtracted represents details extracted from a chat message thread for memory and storage purposes.
 // Details contains the extracted relevant information.
 // DiscordThreadID is the ID of the Discord thread associated with the memory.
+// SubjectIDs are IDs of users the details are about, and Confidence, from 0 to 1, is how sure the model is of them.
 type MemoryDetailsExtracted struct {
 	Details         string
 	DiscordThreadID string
+	// AuthorID is an ID of the user, who started the discussion the details were extracted from
+	AuthorID   string
+	SubjectIDs []string
+	Confidence float64
+	CreatedAt  time.Time
+}
+
+// MemoryApproved is dispatched when a moderator approves extracted details, only approved details are remembered.
+type MemoryApproved struct {
+	Details         string
+	DiscordThreadID string
+	AuthorID        string
+	SubjectIDs      []string
+	Confidence      float64
+	CreatedAt       time.Time
 }
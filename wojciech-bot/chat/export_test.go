@@ -0,0 +1,38 @@
+package chat
+
+import (
+	"context"
+	"go.uber.org/zap"
+	"lib/discord"
+)
+
+var ParseMemoryReviewID = parseMemoryReviewID
+var SplitMessage = splitMessage
+
+const MaxMessageLength = maxMessageLength
+
+type StreamingReply = streamingReply
+
+func NewStreamingReply(bot *discord.Bot, channelID string) *StreamingReply {
+	return newStreamingReply(bot, channelID, zap.NewNop())
+}
+
+// Queue stores the memory for review, without sending it to the moderation channel
+func (q *MemoryReviewQueue) Queue(memory PendingMemory) error {
+	return q.pending.Put(memory.ID, memory)
+}
+
+// FilterDetails returns facts, that the filter considers new
+func (m *DiscordChatMemory) FilterDetails(ctx context.Context, details string) ([]string, error) {
+	facts, err := m.filterDetails(ctx, details, "thread", map[string]string{})
+	if err != nil {
+		return nil, err
+	}
+
+	var contents []string
+	for _, fact := range facts {
+		contents = append(contents, fact.Fact)
+	}
+
+	return contents, nil
+}
@@ -8,16 +8,21 @@ import (
 	"go.uber.org/zap"
 	"lib/discord"
 	apperrors "lib/errors"
+	"lib/llm"
 	"lib/logging"
+	"strings"
 )
 
 type ForgetComponentHandler struct {
 	openaiClient *openai.Client
+	// memoryStore is set when memories are kept in the local store
+	memoryStore *llm.MemoryStore
 }
 
-func NewForgetComponentHandler(openaiClient *openai.Client) ForgetComponentHandler {
+func NewForgetComponentHandler(openaiClient *openai.Client, memoryStore *llm.MemoryStore) ForgetComponentHandler {
 	return ForgetComponentHandler{
 		openaiClient: openaiClient,
+		memoryStore:  memoryStore,
 	}
 }
 
@@ -31,6 +36,7 @@ func (f ForgetComponentHandler) Handle(ctx context.Context, interaction *discord
 	var vectorFileID string
 	var vectorStoreID string
 	var fileID string
+	var memoryIDs string
 
 	for _, embed := range interaction.Message.Embeds {
 		if len(embed.Fields) > 0 {
@@ -44,11 +50,29 @@ func (f ForgetComponentHandler) Handle(ctx context.Context, interaction *discord
 
 				case MemoryEmbedFileID.String():
 					fileID = field.Value
+
+				case MemoryEmbedFieldMemoryIDs.String():
+					memoryIDs = field.Value
 				}
 			}
 		}
 	}
 
+	if memoryIDs != "" {
+		if f.memoryStore == nil {
+			return errors.New("local memory store is not enabled")
+		}
+
+		err := f.memoryStore.Forget(strings.Split(memoryIDs, "\n")...)
+		if err != nil {
+			return apperrors.Wrap(err, "failed to delete memories")
+		}
+
+		log.Info("memory forgotten", zap.String("memoryIDs", memoryIDs))
+
+		return disableForgetButton(interaction, bot)
+	}
+
 	if vectorFileID == "" {
 		return errors.New("no vector file id found")
 	}
@@ -76,8 +100,14 @@ func (f ForgetComponentHandler) Handle(ctx context.Context, interaction *discord
 		return apperrors.Wrap(err, "failed to delete file")
 	}
 
+	log.Info("memory forgotten", zap.String("vectorFileID", vectorFileID), zap.String("vectorStoreID", vectorStoreID), zap.String("fileID", fileID))
+
+	return disableForgetButton(interaction, bot)
+}
+
+func disableForgetButton(interaction *discordgo.InteractionCreate, bot *discord.Bot) error {
 	components := ForgetMessageComponent(true)
-	_, err = bot.ChannelMessageEditComplex(&discordgo.MessageEdit{
+	_, err := bot.ChannelMessageEditComplex(&discordgo.MessageEdit{
 		ID:         interaction.Message.ID,
 		Channel:    interaction.ChannelID,
 		Embeds:     &interaction.Message.Embeds,
@@ -87,8 +117,6 @@ func (f ForgetComponentHandler) Handle(ctx context.Context, interaction *discord
 		return apperrors.Wrap(err, "failed to edit message")
 	}
 
-	log.Info("memory forgotten", zap.String("vectorFileID", vectorFileID), zap.String("vectorStoreID", vectorStoreID), zap.String("fileID", fileID))
-
 	return nil
 }
 
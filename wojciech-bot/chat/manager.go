@@ -7,24 +7,34 @@ import (
 	"lib/logging"
 	"lib/util/arrayutil"
 	"sync"
+	"wojciech-bot/memory"
 )
 
 type Manager struct {
-	mu           sync.Mutex
-	chats        []*DiscordChat
-	bot          *discord.Bot
-	log          *zap.Logger
-	llmContainer *llm.Container
+	mu        sync.Mutex
+	chats     []*DiscordChat
+	bot       *discord.Bot
+	log       *zap.Logger
+	llmRouter *llm.Router
+	// tools are passed to every chat, so that LLM can use them while replying
+	tools *llm.ToolRegistry
+	// store persists chats, that are restored when a message arrives in their thread after a restart
+	store ChatStore
+	// memories are searched while extracting new ones, so that known facts are not extracted again
+	memories memory.Store
 }
 
-func NewManager(bot *discord.Bot, llm *llm.Container) *Manager {
+func NewManager(bot *discord.Bot, llm *llm.Router, tools *llm.ToolRegistry, store ChatStore, memories memory.Store) *Manager {
 	log := logging.Get().Named("chat").Named("manager").With(zap.String("bot", bot.State.User.Username))
 
 	return &Manager{
-		bot:          bot,
-		log:          log,
-		chats:        make([]*DiscordChat, 0),
-		llmContainer: llm,
+		bot:       bot,
+		log:       log,
+		chats:     make([]*DiscordChat, 0),
+		llmRouter: llm,
+		tools:     tools,
+		store:     store,
+		memories:  memories,
 	}
 }
 
@@ -48,6 +58,27 @@ func (m *Manager) DeleteChat(cid string) {
 	}
 }
 
+// GetReasoning returns reasoning hidden from the reply with the given ID, if its chat is still going on
+func (m *Manager) GetReasoning(cid string, messageID string) (string, bool) {
+	m.mu.Lock()
+	chat := m.GetChat(cid)
+	if chat == nil {
+		chat = m.loadChat(cid)
+	}
+	m.mu.Unlock()
+
+	if chat == nil {
+		return "", false
+	}
+
+	message, ok := chat.chat.FindMessage(messageID)
+	if !ok || !hasReasoning(message) {
+		return "", false
+	}
+
+	return message.Metadata[llm.ThinkingMetadataKey], true
+}
+
 func (m *Manager) HasChat(cid string) bool {
 	chat := m.GetChat(cid)
 	return chat != nil
@@ -58,20 +89,115 @@ func (m *Manager) GetOrCreateChat(cid string) *DiscordChat {
 	defer m.mu.Unlock()
 
 	chat := m.GetChat(cid)
+	if chat != nil {
+		m.log.Info("using existing chat", zap.String("parentCid", cid))
+
+		return chat
+	}
+
+	chat = m.loadChat(cid)
+	if chat != nil && chat.isFinished {
+		if !chat.isDirectMessage() {
+			// Finished chat is not kept in memory, it only ignores new messages in its thread
+			return chat
+		}
+
+		// Direct messages have no threads, so a new discussion starts once the previous one has finished
+		chat = nil
+	}
 	if chat == nil {
 		m.log.Info("creating new chat", zap.String("parentCid", cid))
-		chat = NewDiscordChat(m.bot, cid, m.llmContainer)
-		onDiscussionEnd := func(chat *DiscordChat) {
-			m.mu.Lock()
-			defer m.mu.Unlock()
-			m.log.Info("discussion ended, removing chat", zap.String("parentCid", chat.parentCid))
-			m.DeleteChat(chat.thread.ID)
-		}
-		chat.onDiscussionEnded = &onDiscussionEnd
-		m.chats = append(m.chats, chat)
-	} else {
-		m.log.Info("using existing chat", zap.String("parentCid", cid))
+		chat = NewDiscordChat(m.bot, cid, m.llmRouter, m.tools, m.store, m.memories)
+	}
+
+	onDiscussionEnd := func(chat *DiscordChat) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.log.Info("discussion ended, removing chat", zap.String("parentCid", chat.parentCid))
+		m.DeleteChat(chat.thread.ID)
 	}
+	chat.onDiscussionEnded = &onDiscussionEnd
+	m.chats = append(m.chats, chat)
 
 	return chat
 }
+
+// FindChatWithMessage returns the chat that contains the message, e.g. to sync its edits
+func (m *Manager) FindChatWithMessage(channelID string, messageID string) *DiscordChat {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	chat := m.GetChat(channelID)
+	if chat == nil {
+		chat = m.loadChat(channelID)
+	}
+	if chat != nil {
+		return chat
+	}
+
+	// Besides threads, only parent channels contain messages of chats, since the first message of the thread is sent there
+	if !m.isParentChannel(channelID) {
+		return nil
+	}
+
+	for _, c := range m.chats {
+		if c.parentCid != channelID {
+			continue
+		}
+		if _, ok := c.chat.FindMessage(messageID); ok {
+			return c
+		}
+	}
+
+	// Chat of the thread may be saved only, after a restart
+	state, err := m.store.FindByMessage(messageID)
+	if err != nil {
+		m.log.Error("failed to find chat with message", zap.String("messageID", messageID), zap.Error(err))
+
+		return nil
+	}
+	if state == nil {
+		return nil
+	}
+
+	return m.restoreChat(state)
+}
+
+// isParentChannel tells whether a thread of a kept or saved chat was started in the channel
+func (m *Manager) isParentChannel(channelID string) bool {
+	for _, c := range m.chats {
+		if c.parentCid == channelID {
+			return true
+		}
+	}
+
+	isParent, err := m.store.IsParent(channelID)
+	if err != nil {
+		m.log.Error("failed to check parent channel", zap.String("channelID", channelID), zap.Error(err))
+
+		return false
+	}
+
+	return isParent
+}
+
+// loadChat restores the chat of the thread from the store, if it was saved before a restart
+func (m *Manager) loadChat(threadID string) *DiscordChat {
+	state, err := m.store.Load(threadID)
+	if err != nil {
+		m.log.Error("failed to load chat", zap.String("threadID", threadID), zap.Error(err))
+
+		return nil
+	}
+	if state == nil {
+		return nil
+	}
+
+	return m.restoreChat(state)
+}
+
+func (m *Manager) restoreChat(state *ChatState) *DiscordChat {
+	m.log.Info("restored chat", zap.String("threadID", state.ThreadID), zap.Bool("isFinished", state.IsFinished))
+
+	return restoreDiscordChat(m.bot, state, m.llmRouter, m.tools, m.store, m.memories)
+}
@@ -0,0 +1,219 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"github.com/bwmarrin/discordgo"
+	"go.uber.org/zap"
+	"lib/discord"
+	"lib/errors"
+	"lib/events"
+	"lib/logging"
+	"lib/storage"
+	"lib/util"
+	"lib/util/arrayutil"
+	"strings"
+	"time"
+	chatevents "wojciech-bot/chat/events"
+)
+
+const memoryReviewBucketName = "memory_reviews"
+
+// PendingMemory contains details extracted from a discussion, that wait for approval of a moderator
+type PendingMemory struct {
+	ID              string    `json:"id"`
+	Details         string    `json:"details"`
+	DiscordThreadID string    `json:"discord_thread_id"`
+	AuthorID        string    `json:"author_id,omitempty"`
+	CreatedAt       time.Time `json:"created_at"`
+
+	SubjectIDs []string `json:"subject_ids"`
+	Confidence float64  `json:"confidence"`
+	// MessageID is an ID of the message with review buttons in the moderation channel
+	MessageID string `json:"message_id"`
+}
+
+// MemoryReviewQueue keeps extracted details until a moderator approves or rejects them in the moderation channel.
+// Only approved details are dispatched as MemoryApproved, and remembered.
+type MemoryReviewQueue struct {
+	bot *discord.Bot
+	// channelID is the moderation channel, where details are sent for review
+	channelID string
+	pending   *storage.Bucket[PendingMemory]
+	log       *zap.Logger
+}
+
+func NewMemoryReviewQueue(bot *discord.Bot, db *storage.DB, channelID string) (*MemoryReviewQueue, error) {
+	pending, err := storage.NewBucket[PendingMemory](db, memoryReviewBucketName)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MemoryReviewQueue{
+		bot:       bot,
+		channelID: channelID,
+		pending:   pending,
+		log:       logging.Get().Named("chat").Named("memory-review"),
+	}, nil
+}
+
+// HandleMemoryDetailsExtracted queues the details, and asks moderators to review them
+func (q *MemoryReviewQueue) HandleMemoryDetailsExtracted(ctx context.Context, event chatevents.MemoryDetailsExtracted) error {
+	memory := PendingMemory{
+		ID:              util.UUIDv4(),
+		Details:         event.Details,
+		DiscordThreadID: event.DiscordThreadID,
+		AuthorID:        event.AuthorID,
+		CreatedAt:       event.CreatedAt,
+		SubjectIDs:      event.SubjectIDs,
+		Confidence:      event.Confidence,
+	}
+	if memory.CreatedAt.IsZero() {
+		memory.CreatedAt = time.Now()
+	}
+
+	// Details are saved before they are sent, so that they are not lost if sending fails
+	err := q.pending.Put(memory.ID, memory)
+	if err != nil {
+		return errors.Wrap(err, "failed to queue memory")
+	}
+
+	message, err := q.bot.ChannelMessageSendComplex(q.channelID, &discordgo.MessageSend{
+		Embeds:     []*discordgo.MessageEmbed{newPendingMemoryEmbed(memory)},
+		Components: MemoryReviewMessageComponent(memory.ID),
+	}, discordgo.WithContext(ctx))
+	if err != nil {
+		return errors.Wrap(err, "failed to send memory for review")
+	}
+
+	memory.MessageID = message.ID
+	err = q.pending.Put(memory.ID, memory)
+	if err != nil {
+		return errors.Wrap(err, "failed to save memory review message")
+	}
+
+	q.log.Info("memory queued for review", zap.String("memoryID", memory.ID), zap.String("threadID", memory.DiscordThreadID))
+
+	return nil
+}
+
+// Get returns nil, if the memory was already reviewed
+func (q *MemoryReviewQueue) Get(id string) (*PendingMemory, error) {
+	return q.pending.Get(id)
+}
+
+// Approve removes the memory from the queue, and dispatches MemoryApproved. The memory is claimed before it is dispatched,
+// so that it is not remembered twice, when moderators approve it at the same time. It is queued back, if remembering fails.
+// Handlers of MemoryApproved return only failures to remember, failures of notifications are logged by them.
+func (q *MemoryReviewQueue) Approve(ctx context.Context, id string) (*PendingMemory, error) {
+	memory, err := q.take(id)
+	if err != nil {
+		return nil, err
+	}
+
+	err = events.Dispatch(ctx, chatevents.MemoryApproved{
+		Details:         memory.Details,
+		DiscordThreadID: memory.DiscordThreadID,
+		AuthorID:        memory.AuthorID,
+		SubjectIDs:      memory.SubjectIDs,
+		Confidence:      memory.Confidence,
+		CreatedAt:       memory.CreatedAt,
+	})
+	if err != nil {
+		putErr := q.pending.Put(id, *memory)
+		if putErr != nil {
+			q.log.Error("failed to queue back memory", zap.String("memoryID", id), zap.Error(putErr))
+		}
+
+		return nil, errors.Wrap(err, "failed to remember approved memory")
+	}
+
+	q.log.Info("memory approved", zap.String("memoryID", id))
+
+	return memory, nil
+}
+
+// Reject removes the memory from the queue, without remembering it
+func (q *MemoryReviewQueue) Reject(id string) (*PendingMemory, error) {
+	memory, err := q.take(id)
+	if err != nil {
+		return nil, err
+	}
+
+	q.log.Info("memory rejected", zap.String("memoryID", id))
+
+	return memory, nil
+}
+
+// Edit replaces details of the memory, that is still waiting for review
+func (q *MemoryReviewQueue) Edit(id string, details string) (*PendingMemory, error) {
+	memory, err := q.pending.UpdateExisting(id, func(memory PendingMemory) PendingMemory {
+		memory.Details = details
+		return memory
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to save edited memory")
+	}
+
+	if memory == nil {
+		return nil, notWaitingForReviewError(id)
+	}
+
+	q.log.Info("memory edited", zap.String("memoryID", id))
+
+	return memory, nil
+}
+
+// take removes the memory from the queue, so that only one review of it succeeds
+func (q *MemoryReviewQueue) take(id string) (*PendingMemory, error) {
+	memory, err := q.pending.Take(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if memory == nil {
+		return nil, notWaitingForReviewError(id)
+	}
+
+	return memory, nil
+}
+
+func notWaitingForReviewError(id string) error {
+	return fmt.Errorf("memory %s is not waiting for review", id)
+}
+
+func newPendingMemoryEmbed(memory PendingMemory) *discordgo.MessageEmbed {
+	fields := []*discordgo.MessageEmbedField{
+		{
+			Name:   "Wątek",
+			Value:  fmt.Sprintf("<#%s>", memory.DiscordThreadID),
+			Inline: true,
+		},
+		{
+			Name:   "Pewność",
+			Value:  fmt.Sprintf("%.0f%%", memory.Confidence*100),
+			Inline: true,
+		},
+	}
+	if memory.AuthorID != "" {
+		fields = append(fields, &discordgo.MessageEmbedField{
+			Name:   "Autor",
+			Value:  discord.Mention(memory.AuthorID),
+			Inline: true,
+		})
+	}
+	if len(memory.SubjectIDs) > 0 {
+		fields = append(fields, &discordgo.MessageEmbedField{
+			Name:   "Dotyczy",
+			Value:  strings.Join(arrayutil.Map(memory.SubjectIDs, discord.Mention), ", "),
+			Inline: true,
+		})
+	}
+
+	return &discordgo.MessageEmbed{
+		Title:       "Nowe wspomnienie do zatwierdzenia",
+		Description: memory.Details,
+		Fields:      fields,
+		Timestamp:   memory.CreatedAt.Format(time.RFC3339),
+	}
+}
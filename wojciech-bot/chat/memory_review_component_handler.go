@@ -0,0 +1,246 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"github.com/bwmarrin/discordgo"
+	"go.uber.org/zap"
+	"lib/discord"
+	apperrors "lib/errors"
+	"strings"
+	"wojciech-bot/messages"
+)
+
+// MemoryReviewButtonPrefix starts custom IDs of review buttons, which are followed by the action and ID of the memory,
+// e.g. "memory-review:approve:<id>". IDs are kept in the buttons, so that reviews work after restarts of the bot.
+const MemoryReviewButtonPrefix = "memory-review"
+
+// memoryReviewDetailsInputID is a custom ID of the text input in the edit modal
+const memoryReviewDetailsInputID = "details"
+
+// maxMemoryDetailsLength is a limit of the text input in modals
+const maxMemoryDetailsLength = 4000
+
+const (
+	memoryReviewActionApprove = "approve"
+	memoryReviewActionEdit    = "edit"
+	memoryReviewActionReject  = "reject"
+)
+
+// MemoryReviewComponentHandler handles review buttons in the moderation channel.
+// Only moderators may review memories, even if others can see the channel.
+type MemoryReviewComponentHandler struct {
+	queue *MemoryReviewQueue
+}
+
+func NewMemoryReviewComponentHandler(queue *MemoryReviewQueue) MemoryReviewComponentHandler {
+	return MemoryReviewComponentHandler{
+		queue: queue,
+	}
+}
+
+func (h MemoryReviewComponentHandler) Handle(ctx context.Context, interaction *discordgo.InteractionCreate, bot *discord.Bot) error {
+	action, id, _ := parseMemoryReviewID(interaction.MessageComponentData().CustomID)
+	log := logger.With(zap.String("interactionID", interaction.ID), zap.String("memoryID", id), zap.String("action", action))
+	log.Info("handling memory review")
+
+	if !isModerator(interaction) {
+		return apperrors.NewErrPublic(messages.Messages.Chat.ReviewForbidden)
+	}
+
+	switch action {
+	case memoryReviewActionApprove:
+		memory, err := h.queue.Approve(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		return finishMemoryReview(ctx, bot, interaction, *memory, "Wspomnienie zatwierdzone", 0x2ecc71)
+
+	case memoryReviewActionReject:
+		memory, err := h.queue.Reject(id)
+		if err != nil {
+			return err
+		}
+
+		return finishMemoryReview(ctx, bot, interaction, *memory, "Wspomnienie odrzucone", 0xe74c3c)
+
+	case memoryReviewActionEdit:
+		memory, err := h.queue.Get(id)
+		if err != nil {
+			return err
+		}
+		if memory == nil {
+			return notWaitingForReviewError(id)
+		}
+
+		return bot.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseModal,
+			Data: &discordgo.InteractionResponseData{
+				CustomID: memoryReviewID(memoryReviewActionEdit, id),
+				Title:    "Edytuj wspomnienie",
+				Components: []discordgo.MessageComponent{
+					discordgo.ActionsRow{
+						Components: []discordgo.MessageComponent{
+							discordgo.TextInput{
+								CustomID:  memoryReviewDetailsInputID,
+								Label:     "Treść",
+								Style:     discordgo.TextInputParagraph,
+								Value:     memory.Details,
+								Required:  true,
+								MaxLength: maxMemoryDetailsLength,
+							},
+						},
+					},
+				},
+			},
+		}, discordgo.WithContext(ctx))
+	}
+
+	return fmt.Errorf("unknown memory review action %q", action)
+}
+
+func (h MemoryReviewComponentHandler) ShouldHandle(interaction *discordgo.InteractionCreate) bool {
+	_, _, ok := parseMemoryReviewID(interaction.MessageComponentData().CustomID)
+
+	return ok && interaction.Message != nil
+}
+
+// OpensModal implements discord.ModalOpener, the edit button responds with a modal
+func (h MemoryReviewComponentHandler) OpensModal(interaction *discordgo.InteractionCreate) bool {
+	action, _, _ := parseMemoryReviewID(interaction.MessageComponentData().CustomID)
+
+	return action == memoryReviewActionEdit
+}
+
+// MemoryReviewModalHandler saves details edited in the modal opened by MemoryReviewComponentHandler
+type MemoryReviewModalHandler struct {
+	queue *MemoryReviewQueue
+}
+
+func NewMemoryReviewModalHandler(queue *MemoryReviewQueue) MemoryReviewModalHandler {
+	return MemoryReviewModalHandler{
+		queue: queue,
+	}
+}
+
+func (h MemoryReviewModalHandler) Handle(ctx context.Context, interaction *discordgo.InteractionCreate, bot *discord.Bot) error {
+	data := interaction.ModalSubmitData()
+	_, id, _ := parseMemoryReviewID(data.CustomID)
+
+	if !isModerator(interaction) {
+		return apperrors.NewErrPublic(messages.Messages.Chat.ReviewForbidden)
+	}
+
+	var details string
+	for _, row := range data.Components {
+		actionsRow, ok := row.(*discordgo.ActionsRow)
+		if !ok {
+			continue
+		}
+
+		for _, component := range actionsRow.Components {
+			input, ok := component.(*discordgo.TextInput)
+			if ok && input.CustomID == memoryReviewDetailsInputID {
+				details = strings.TrimSpace(input.Value)
+			}
+		}
+	}
+
+	if details == "" {
+		return fmt.Errorf("edited memory %s is empty", id)
+	}
+
+	memory, err := h.queue.Edit(id, details)
+	if err != nil {
+		return err
+	}
+
+	components := MemoryReviewMessageComponent(id)
+	_, err = bot.ChannelMessageEditComplex(&discordgo.MessageEdit{
+		ID:         interaction.Message.ID,
+		Channel:    interaction.ChannelID,
+		Embeds:     &[]*discordgo.MessageEmbed{newPendingMemoryEmbed(*memory)},
+		Components: &components,
+	}, discordgo.WithContext(ctx))
+	if err != nil {
+		return apperrors.Wrap(err, "failed to edit memory review message")
+	}
+
+	return nil
+}
+
+func (h MemoryReviewModalHandler) ShouldHandle(interaction *discordgo.InteractionCreate) bool {
+	action, _, ok := parseMemoryReviewID(interaction.ModalSubmitData().CustomID)
+
+	return ok && action == memoryReviewActionEdit && interaction.Message != nil
+}
+
+// isModerator checks if the member may manage the server, the same as other moderation commands
+func isModerator(interaction *discordgo.InteractionCreate) bool {
+	return interaction.Member != nil &&
+		interaction.Member.Permissions&(discordgo.PermissionAdministrator|discordgo.PermissionManageServer) != 0
+}
+
+// finishMemoryReview replaces the review buttons with the result of the review
+func finishMemoryReview(ctx context.Context, bot *discord.Bot, interaction *discordgo.InteractionCreate, memory PendingMemory, title string, color int) error {
+	embed := newPendingMemoryEmbed(memory)
+	embed.Title = title
+	embed.Color = color
+	if interaction.Member != nil && interaction.Member.User != nil {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:  "Moderator",
+			Value: discord.Mention(interaction.Member.User.ID),
+		})
+	}
+
+	components := []discordgo.MessageComponent{}
+	_, err := bot.ChannelMessageEditComplex(&discordgo.MessageEdit{
+		ID:         interaction.Message.ID,
+		Channel:    interaction.ChannelID,
+		Embeds:     &[]*discordgo.MessageEmbed{embed},
+		Components: &components,
+	}, discordgo.WithContext(ctx))
+	if err != nil {
+		return apperrors.Wrap(err, "failed to edit memory review message")
+	}
+
+	return nil
+}
+
+func MemoryReviewMessageComponent(id string) []discordgo.MessageComponent {
+	return []discordgo.MessageComponent{
+		discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.Button{
+					Style:    discordgo.SuccessButton,
+					Label:    messages.Messages.Chat.ButtonLabelApprove,
+					CustomID: memoryReviewID(memoryReviewActionApprove, id),
+				},
+				discordgo.Button{
+					Style:    discordgo.SecondaryButton,
+					Label:    messages.Messages.Chat.ButtonLabelEdit,
+					CustomID: memoryReviewID(memoryReviewActionEdit, id),
+				},
+				discordgo.Button{
+					Style:    discordgo.DangerButton,
+					Label:    messages.Messages.Chat.ButtonLabelReject,
+					CustomID: memoryReviewID(memoryReviewActionReject, id),
+				},
+			},
+		},
+	}
+}
+
+func memoryReviewID(action string, id string) string {
+	return strings.Join([]string{MemoryReviewButtonPrefix, action, id}, ":")
+}
+
+func parseMemoryReviewID(customID string) (action string, id string, ok bool) {
+	parts := strings.SplitN(customID, ":", 3)
+	if len(parts) != 3 || parts[0] != MemoryReviewButtonPrefix {
+		return "", "", false
+	}
+
+	return parts[1], parts[2], true
+}
@@ -0,0 +1,212 @@
+package chat_test
+
+import (
+	"context"
+	goerrors "errors"
+	"github.com/bwmarrin/discordgo"
+	"github.com/stretchr/testify/assert"
+	"lib/errors"
+	"lib/events"
+	"lib/storage"
+	"path/filepath"
+	"testing"
+	"time"
+	"wojciech-bot/chat"
+	chatevents "wojciech-bot/chat/events"
+)
+
+func newMemoryReviewQueue(t *testing.T, memories ...chat.PendingMemory) *chat.MemoryReviewQueue {
+	db, err := storage.Open(filepath.Join(t.TempDir(), "test.db"))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	queue, err := chat.NewMemoryReviewQueue(nil, db, "moderation")
+	assert.NoError(t, err)
+
+	for _, memory := range memories {
+		assert.NoError(t, queue.Queue(memory))
+	}
+
+	return queue
+}
+
+func TestMemoryReviewQueue(t *testing.T) {
+	var approved []chatevents.MemoryApproved
+	var approveErr error
+	events.Handle(func(ctx context.Context, event chatevents.MemoryApproved) error {
+		if approveErr != nil {
+			return approveErr
+		}
+
+		approved = append(approved, event)
+		return nil
+	})
+
+	memory := chat.PendingMemory{
+		ID:              "1",
+		Details:         "Wojtek likes pizza",
+		DiscordThreadID: "thread",
+		CreatedAt:       time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		SubjectIDs:      []string{"2"},
+		Confidence:      0.8,
+	}
+
+	t.Run("approves memory only once", func(t *testing.T) {
+		approved = nil
+		queue := newMemoryReviewQueue(t, memory)
+
+		result, err := queue.Approve(context.Background(), "1")
+		assert.NoError(t, err)
+		assert.Equal(t, &memory, result)
+
+		_, err = queue.Approve(context.Background(), "1")
+		assert.Error(t, err)
+
+		assert.Equal(t, []chatevents.MemoryApproved{{
+			Details:         memory.Details,
+			DiscordThreadID: memory.DiscordThreadID,
+			SubjectIDs:      memory.SubjectIDs,
+			Confidence:      memory.Confidence,
+			CreatedAt:       memory.CreatedAt,
+		}}, approved)
+
+		pending, err := queue.Get("1")
+		assert.NoError(t, err)
+		assert.Nil(t, pending)
+	})
+
+	t.Run("keeps memory in the queue when remembering fails", func(t *testing.T) {
+		approveErr = goerrors.New("failed")
+		defer func() {
+			approveErr = nil
+		}()
+		queue := newMemoryReviewQueue(t, memory)
+
+		_, err := queue.Approve(context.Background(), "1")
+		assert.Error(t, err)
+
+		pending, err := queue.Get("1")
+		assert.NoError(t, err)
+		assert.Equal(t, &memory, pending)
+	})
+
+	t.Run("rejects memory without remembering it", func(t *testing.T) {
+		approved = nil
+		queue := newMemoryReviewQueue(t, memory)
+
+		result, err := queue.Reject("1")
+		assert.NoError(t, err)
+		assert.Equal(t, &memory, result)
+		assert.Empty(t, approved)
+
+		_, err = queue.Reject("1")
+		assert.Error(t, err)
+
+		_, err = queue.Approve(context.Background(), "1")
+		assert.Error(t, err)
+		assert.Empty(t, approved)
+	})
+
+	t.Run("edits details of pending memory", func(t *testing.T) {
+		queue := newMemoryReviewQueue(t, memory)
+
+		result, err := queue.Edit("1", "Wojtek loves pizza")
+		assert.NoError(t, err)
+		assert.Equal(t, "Wojtek loves pizza", result.Details)
+
+		pending, err := queue.Get("1")
+		assert.NoError(t, err)
+		assert.Equal(t, "Wojtek loves pizza", pending.Details)
+		assert.Equal(t, memory.SubjectIDs, pending.SubjectIDs)
+	})
+
+	t.Run("does not edit reviewed memory", func(t *testing.T) {
+		queue := newMemoryReviewQueue(t, memory)
+
+		_, err := queue.Reject("1")
+		assert.NoError(t, err)
+
+		_, err = queue.Edit("1", "Wojtek loves pizza")
+		assert.Error(t, err)
+
+		pending, err := queue.Get("1")
+		assert.NoError(t, err)
+		assert.Nil(t, pending)
+	})
+}
+
+func TestMemoryReviewHandlers(t *testing.T) {
+	memory := chat.PendingMemory{ID: "1", Details: "Wojtek likes pizza"}
+	member := &discordgo.Member{User: &discordgo.User{ID: "2"}}
+
+	t.Run("buttons are forbidden to members without moderator permissions", func(t *testing.T) {
+		queue := newMemoryReviewQueue(t, memory)
+		interaction := &discordgo.InteractionCreate{Interaction: &discordgo.Interaction{
+			Type:    discordgo.InteractionMessageComponent,
+			Data:    discordgo.MessageComponentInteractionData{CustomID: "memory-review:approve:1"},
+			Member:  member,
+			Message: &discordgo.Message{ID: "3"},
+		}}
+
+		err := chat.NewMemoryReviewComponentHandler(queue).Handle(context.Background(), interaction, nil)
+
+		var publicErr *errors.ErrPublic
+		assert.ErrorAs(t, err, &publicErr)
+
+		pending, err := queue.Get("1")
+		assert.NoError(t, err)
+		assert.NotNil(t, pending)
+	})
+
+	t.Run("modal is forbidden to members without moderator permissions", func(t *testing.T) {
+		queue := newMemoryReviewQueue(t, memory)
+		interaction := &discordgo.InteractionCreate{Interaction: &discordgo.Interaction{
+			Type: discordgo.InteractionModalSubmit,
+			Data: discordgo.ModalSubmitInteractionData{
+				CustomID: "memory-review:edit:1",
+				Components: []discordgo.MessageComponent{
+					&discordgo.ActionsRow{Components: []discordgo.MessageComponent{
+						&discordgo.TextInput{CustomID: "details", Value: "Wojtek hates pizza"},
+					}},
+				},
+			},
+			Member:  member,
+			Message: &discordgo.Message{ID: "3"},
+		}}
+
+		err := chat.NewMemoryReviewModalHandler(queue).Handle(context.Background(), interaction, nil)
+
+		var publicErr *errors.ErrPublic
+		assert.ErrorAs(t, err, &publicErr)
+
+		pending, err := queue.Get("1")
+		assert.NoError(t, err)
+		assert.Equal(t, memory.Details, pending.Details)
+	})
+}
+
+func TestParseMemoryReviewID(t *testing.T) {
+	tests := []struct {
+		customID string
+		action   string
+		id       string
+		ok       bool
+	}{
+		{customID: "memory-review:approve:1", action: "approve", id: "1", ok: true},
+		{customID: "memory-review:edit:a:b", action: "edit", id: "a:b", ok: true},
+		{customID: "memory-review:approve"},
+		{customID: "forget:approve:1"},
+		{customID: ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.customID, func(t *testing.T) {
+			action, id, ok := chat.ParseMemoryReviewID(test.customID)
+			assert.Equal(t, test.action, action)
+			assert.Equal(t, test.id, id)
+			assert.Equal(t, test.ok, ok)
+		})
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"lib/discord"
 	"lib/errors"
 	"lib/util/arrayutil"
+	"strings"
 	"wojciech-bot/messages"
 	"wojciech-bot/openai"
 )
@@ -16,6 +17,7 @@ var (
 	MemoryEmbedFieldVectorStoreID = MemoryEmbedField("Vector Store ID")
 	MemoryEmbedFieldVectorFileID  = MemoryEmbedField("Vector File ID")
 	MemoryEmbedFileID             = MemoryEmbedField("File ID")
+	MemoryEmbedFieldMemoryIDs     = MemoryEmbedField("Memory IDs")
 )
 
 func (f MemoryEmbedField) String() string {
@@ -26,6 +28,29 @@ func (f MemoryEmbedField) String() string {
 func HandleMemoryUpdated(ctx context.Context, vectorStoreID string, bot *discord.Bot, event openai.MemoryUpdated) error {
 	session := bot.Session
 
+	fields := []*discordgo.MessageEmbedField{
+		{
+			Name:  MemoryEmbedFieldVectorStoreID.String(),
+			Value: vectorStoreID,
+		},
+		{
+			Name:  MemoryEmbedFieldVectorFileID.String(),
+			Value: event.VectorFileID,
+		},
+		{
+			Name:  MemoryEmbedFileID.String(),
+			Value: event.FileID,
+		},
+	}
+	if len(event.MemoryIDs) > 0 {
+		fields = []*discordgo.MessageEmbedField{
+			{
+				Name:  MemoryEmbedFieldMemoryIDs.String(),
+				Value: strings.Join(event.MemoryIDs, "\n"),
+			},
+		}
+	}
+
 	_, err := session.ChannelMessageSendComplex(event.DiscordThreadID, &discordgo.MessageSend{
 		Content: arrayutil.RandomElement(messages.Messages.Chat.NewMemory),
 		Embeds: []*discordgo.MessageEmbed{
@@ -34,20 +59,7 @@ func HandleMemoryUpdated(ctx context.Context, vectorStoreID string, bot *discord
 					Name: bot.Name,
 				},
 				Description: event.Content,
-				Fields: []*discordgo.MessageEmbedField{
-					{
-						Name:  MemoryEmbedFieldVectorStoreID.String(),
-						Value: vectorStoreID,
-					},
-					{
-						Name:  MemoryEmbedFieldVectorFileID.String(),
-						Value: event.VectorFileID,
-					},
-					{
-						Name:  MemoryEmbedFileID.String(),
-						Value: event.FileID,
-					},
-				},
+				Fields:      fields,
 			},
 		},
 		Components: ForgetMessageComponent(false),
@@ -0,0 +1,43 @@
+package chat
+
+import (
+	"github.com/bwmarrin/discordgo"
+	"go.uber.org/zap"
+	"lib/discord"
+)
+
+// HandleMessageUpdate updates the edited message in its chat, so that the old contents are no longer used as context
+func HandleMessageUpdate(bot *discord.Bot, manager *Manager, update *discordgo.MessageUpdate) {
+	// Partial updates, e.g. with embeds of links, don't contain the message contents
+	if update.Author == nil || update.Content == "" {
+		return
+	}
+
+	// Replies are edited by us while they are streamed
+	if update.Author.ID == bot.State.User.ID {
+		return
+	}
+
+	chat := manager.FindChatWithMessage(update.ChannelID, update.ID)
+	if chat == nil {
+		return
+	}
+
+	err := chat.HandleMessageUpdate(update.Message)
+	if err != nil {
+		chatLog.Error("failed to update chat message", zap.String("messageID", update.ID), zap.Error(err))
+	}
+}
+
+// HandleMessageDelete removes the deleted message from its chat
+func HandleMessageDelete(manager *Manager, deleted *discordgo.MessageDelete) {
+	chat := manager.FindChatWithMessage(deleted.ChannelID, deleted.ID)
+	if chat == nil {
+		return
+	}
+
+	err := chat.HandleMessageDelete(deleted.ID)
+	if err != nil {
+		chatLog.Error("failed to delete chat message", zap.String("messageID", deleted.ID), zap.Error(err))
+	}
+}
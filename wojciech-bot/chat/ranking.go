@@ -0,0 +1,99 @@
+package chat
+
+import (
+	"context"
+	"github.com/bwmarrin/discordgo"
+	"go.uber.org/zap"
+	llm2 "lib/llm"
+	"wojciech-bot/env"
+)
+
+// MaxRankingScore is the score of messages, that are the most worthy of reply
+const MaxRankingScore = 10
+
+// RankedMessage is a message scored by LLM, the higher the score, the more worthy of reply it is
+type RankedMessage struct {
+	Message *discordgo.Message
+	Score   int
+	Reason  string
+}
+
+// RankMessages scores all messages in a single prompt. Messages, that LLM didn't score, get 0.
+func RankMessages(ctx context.Context, llmClient *llm2.API, messages []*discordgo.Message) ([]RankedMessage, error) {
+	ranked := make([]RankedMessage, len(messages))
+	for i, message := range messages {
+		ranked[i] = RankedMessage{Message: message}
+	}
+
+	if len(messages) == 0 {
+		return ranked, nil
+	}
+
+	if env.Env.AreAllMessagesReplyWorthy() {
+		chatLog.Info("messages are worthy of reply because env.AreAllMessagesReplyWorthy is true")
+		for i := range ranked {
+			ranked[i].Score = MaxRankingScore
+		}
+
+		return ranked, nil
+	}
+
+	// Messages are numbered from 1, so that LLM doesn't have to repeat long Discord IDs
+	vars := rankingVars{}
+	var files []llm2.File
+	for i, message := range messages {
+		vars.Messages = append(vars.Messages, rankingCandidate{
+			Index:       i + 1,
+			Author:      message.Author.Username,
+			Content:     message.Content,
+			Attachments: len(message.Attachments),
+		})
+		files = append(files, llm2.HandleDiscordMessageAttachments(message)...)
+	}
+
+	ctx = llm2.WithUsageContext(ctx, llm2.FeatureScanner, "")
+	ctx = llm2.WithPriority(ctx, llm2.PriorityBackground)
+	prompt, err := llm2.RenderPrompt("chat-ranking", vars)
+	if err != nil {
+		return nil, err
+	}
+	prompt.Files = files
+
+	reply, err := llm2.PromptJSON[rankingReply](ctx, llmClient, prompt)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, score := range reply.Messages {
+		if score.Index < 1 || score.Index > len(ranked) {
+			chatLog.Warn("ranking of unknown message", zap.Int("index", score.Index))
+			continue
+		}
+
+		ranked[score.Index-1].Score = min(max(score.Score, 0), MaxRankingScore)
+		ranked[score.Index-1].Reason = score.Reason
+	}
+
+	return ranked, nil
+}
+
+type rankingCandidate struct {
+	Index       int
+	Author      string
+	Content     string
+	Attachments int
+}
+
+type rankingVars struct {
+	Messages []rankingCandidate
+}
+
+type rankingReply struct {
+	Messages []messageScore `json:"messages" description:"scores of all the messages"`
+}
+
+type messageScore struct {
+	Index  int    `json:"index" description:"number of the message"`
+	Score  int    `json:"score" description:"from 0 to 10, how interesting the message is to reply"`
+	Reason string `json:"reason" description:"short reason of the score"`
+}
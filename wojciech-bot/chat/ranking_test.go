@@ -0,0 +1,58 @@
+package chat_test
+
+import (
+	"context"
+	"github.com/bwmarrin/discordgo"
+	"github.com/stretchr/testify/assert"
+	"lib/llm"
+	"lib/storage"
+	"path/filepath"
+	"testing"
+	"time"
+	"wojciech-bot/chat"
+)
+
+func TestRankMessages(t *testing.T) {
+	author := &discordgo.User{ID: "2", Username: "wojtek"}
+	messages := []*discordgo.Message{
+		{ID: "10", ChannelID: "1", Content: "what do you think about the new Kubernetes release?", Author: author},
+		{ID: "11", ChannelID: "1", Content: "anyone up for some pizza tonight, or too late?", Author: author},
+	}
+
+	adapter := llm.NewScriptedAdapter(llm.ScriptedRule{
+		Reply: `{"messages": [{"index": 1, "score": 8, "reason": "technical topic"}, {"index": 7, "score": 10, "reason": "unknown"}]}`,
+	})
+	ranked, err := chat.RankMessages(context.Background(), llm.NewAPI(adapter, "scripted"), messages)
+	assert.NoError(t, err)
+
+	t.Run("ranks all messages in a single prompt", func(t *testing.T) {
+		assert.Len(t, adapter.Requests(), 1)
+		assert.Equal(t, 8, ranked[0].Score)
+		assert.Equal(t, "technical topic", ranked[0].Reason)
+		assert.Equal(t, 0, ranked[1].Score)
+	})
+
+	t.Run("remembers evaluated messages", func(t *testing.T) {
+		db, err := storage.Open(filepath.Join(t.TempDir(), "test.db"))
+		assert.NoError(t, err)
+		defer db.Close()
+
+		store, err := chat.NewScannerEvaluationStore(db)
+		assert.NoError(t, err)
+
+		now := time.Now()
+		assert.NoError(t, store.Save(ranked, now.Add(-48*time.Hour)))
+
+		evaluation, err := store.Get("10")
+		assert.NoError(t, err)
+		assert.Equal(t, 8, evaluation.Score)
+
+		pruned, err := store.Prune(now.Add(-24 * time.Hour))
+		assert.NoError(t, err)
+		assert.Equal(t, 2, pruned)
+
+		evaluation, err = store.Get("10")
+		assert.NoError(t, err)
+		assert.Nil(t, evaluation)
+	})
+}
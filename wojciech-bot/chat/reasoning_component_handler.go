@@ -0,0 +1,81 @@
+package chat
+
+import (
+	"context"
+	"github.com/bwmarrin/discordgo"
+	"go.uber.org/zap"
+	"lib/discord"
+	"lib/llm"
+	"wojciech-bot/messages"
+)
+
+const ReasoningButtonID = "reasoning"
+
+// maxReasoningLength is a limit of embed description, with some room for the ellipsis
+const maxReasoningLength = 4000
+
+// ReasoningComponentHandler shows reasoning of the model behind the reply, only to the person who asked for it
+type ReasoningComponentHandler struct {
+	manager *Manager
+}
+
+func NewReasoningComponentHandler(manager *Manager) ReasoningComponentHandler {
+	return ReasoningComponentHandler{
+		manager: manager,
+	}
+}
+
+func (r ReasoningComponentHandler) Handle(ctx context.Context, interaction *discordgo.InteractionCreate, bot *discord.Bot) error {
+	log := logger.With(zap.String("interactionID", interaction.ID), zap.String("messageID", interaction.Message.ID))
+
+	reasoning, ok := r.manager.GetReasoning(interaction.ChannelID, interaction.Message.ID)
+	if !ok {
+		// Chat has already ended, and its messages are gone
+		log.Info("reasoning not found")
+		bot.FollowupInteractionMessageAndForget(interaction.Interaction, &discord.InteractionReply{
+			Content:   messages.Messages.Chat.NoReasoning,
+			Ephemeral: true,
+		})
+
+		return nil
+	}
+
+	if runes := []rune(reasoning); len(runes) > maxReasoningLength {
+		reasoning = string(runes[:maxReasoningLength]) + "…"
+	}
+
+	bot.FollowupInteractionMessageAndForget(interaction.Interaction, &discord.InteractionReply{
+		Embeds: []*discordgo.MessageEmbed{
+			{
+				Title:       "Rozumowanie",
+				Description: reasoning,
+			},
+		},
+		Ephemeral: true,
+	})
+
+	return nil
+}
+
+func (r ReasoningComponentHandler) ShouldHandle(interaction *discordgo.InteractionCreate) bool {
+	return interaction.MessageComponentData().CustomID == ReasoningButtonID && interaction.Message != nil
+}
+
+func ReasoningMessageComponent() []discordgo.MessageComponent {
+	return []discordgo.MessageComponent{
+		discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.Button{
+					Style:    discordgo.SecondaryButton,
+					Label:    messages.Messages.Chat.ButtonLabelReasoning,
+					CustomID: ReasoningButtonID,
+				},
+			},
+		},
+	}
+}
+
+// hasReasoning returns true if the reply has reasoning hidden from it
+func hasReasoning(message *llm.ChatMessage) bool {
+	return message.Metadata[llm.ThinkingMetadataKey] != ""
+}
@@ -9,6 +9,8 @@ import (
 	"lib/logging"
 	util2 "lib/util"
 	"lib/util/arrayutil"
+	"slices"
+	"sort"
 	"time"
 )
 
@@ -18,6 +20,12 @@ const foundMessagesLimit = 2
 const minDuration = 15 * time.Minute
 const maxDuration = 120 * time.Minute
 
+// maxRankedMessages limits number of messages ranked in a single prompt
+const maxRankedMessages = 30
+
+// minReplyScore is the lowest score of a message, that the bot replies to
+const minReplyScore = 7
+
 type OnMessageFoundFn func(message *discordgo.Message)
 
 // DiscordChannelScanner is responsible for scanning Discord channels for messages that bot can interact with.
@@ -28,6 +36,10 @@ type DiscordChannelScanner struct {
 	onMessageFoundFn OnMessageFoundFn
 	// llmApi is an instance of the LLM API used to interact with the large language model for chat and prompt operations.
 	llmApi *llm.API
+	// evaluations remember scores of messages, that were already ranked
+	evaluations *ScannerEvaluationStore
+	// policies decide which channels are scanned, and how often the bot may reply
+	policies *ScannerPolicyStore
 	// stopChan is used to signal the scanner to stop
 	stopChan chan struct{}
 	// log is the logger instance for the scanner
@@ -35,11 +47,13 @@ type DiscordChannelScanner struct {
 	tickerDuration time.Duration
 }
 
-func NewDiscordChannelScanner(bot *discord.Bot, llmApi *llm.API, onMessageFoundFn OnMessageFoundFn) *DiscordChannelScanner {
+func NewDiscordChannelScanner(bot *discord.Bot, llmApi *llm.API, policies *ScannerPolicyStore, evaluations *ScannerEvaluationStore, onMessageFoundFn OnMessageFoundFn) *DiscordChannelScanner {
 	return &DiscordChannelScanner{
 		onMessageFoundFn: onMessageFoundFn,
 		bot:              bot,
 		llmApi:           llmApi,
+		policies:         policies,
+		evaluations:      evaluations,
 		stopChan:         make(chan struct{}),
 		log:              logging.Get().Named("Chat").Named("DiscordChannelScanner"),
 	}
@@ -91,33 +105,87 @@ func (d *DiscordChannelScanner) scanChannels() {
 
 	d.log.Info("scanning channels for messages")
 
+	// Older messages are not fresh enough to be scanned anyway
+	pruned, err := d.evaluations.Prune(time.Now().Add(-freshMessageDuration))
+	if err != nil {
+		d.log.Error("failed to prune message evaluations", zap.Error(err))
+	} else {
+		d.log.Info("pruned message evaluations", zap.Int("pruned", pruned))
+	}
+
 	for _, guild := range d.bot.State.Guilds {
+		policy, err := d.policies.Policy(guild.ID)
+		if err != nil {
+			d.log.Error("failed to get scanner policy", zap.String("guildID", guild.ID), zap.Error(err))
+			continue
+		}
+
+		activity, err := d.policies.Activity(guild.ID)
+		if err != nil {
+			d.log.Error("failed to get scanner activity", zap.String("guildID", guild.ID), zap.Error(err))
+			continue
+		}
+
+		now := time.Now()
+		if policy.IsQuiet(now) {
+			d.log.Info("skipping guild in quiet hours", zap.String("guildID", guild.ID))
+			continue
+		}
+
 		channels, err := d.bot.GuildChannels(guild.ID, discordgo.WithContext(ctx))
 		if err != nil {
 			d.log.Error("failed to get channels for guild", zap.String("guildID", guild.ID), zap.Error(err))
 			continue
 		}
 
-		// Filter for text channels only
+		// Filter for text channels, that the policy allows to reply in
 		textChannels := arrayutil.Filter(channels, func(channel *discordgo.Channel) bool {
-			return channel.Type == discordgo.ChannelTypeGuildText
+			return channel.Type == discordgo.ChannelTypeGuildText &&
+				policy.IsChannelEnabled(channel.ID) &&
+				activity.CanReplyInChannel(policy, channel.ID, now)
 		})
 
-		messages := util2.ParallelWithValue(textChannels, func(channel *discordgo.Channel) *discordgo.Message {
-			return d.scanChannel(ctx, channel)
+		messages := util2.ParallelWithValue(textChannels, func(channel *discordgo.Channel) *RankedMessage {
+			return d.scanChannel(ctx, policy, activity, channel)
 		}, 10)
 
-		if len(messages) > 0 {
-			randomMessages := arrayutil.RandomElements(messages, foundMessagesLimit)
-			for _, message := range randomMessages {
-				d.onMessageFoundFn(&message)
-			}
+		d.replyToFoundMessages(policy, &activity, messages)
+	}
+}
+
+// replyToFoundMessages passes the best scored messages to the callback, as long as limits of the policy allow it
+func (d *DiscordChannelScanner) replyToFoundMessages(policy GuildScannerPolicy, activity *ScannerActivity, messages []RankedMessage) {
+	// Messages with the same score are chosen randomly
+	arrayutil.Shuffle(messages)
+	sort.SliceStable(messages, func(a, b int) bool {
+		return messages[a].Score > messages[b].Score
+	})
+
+	found := 0
+	for _, ranked := range messages {
+		if found == foundMessagesLimit {
+			return
+		}
+
+		message := ranked.Message
+		now := time.Now()
+		if !activity.CanReplyInChannel(policy, message.ChannelID, now) || !activity.CanReplyToUser(policy, message.Author.ID, now) {
+			continue
+		}
+
+		activity.Record(policy, message.ChannelID, message.Author.ID, now)
+		err := d.policies.SaveActivity(*activity)
+		if err != nil {
+			d.log.Error("failed to save scanner activity", zap.String("guildID", policy.GuildID), zap.Error(err))
 		}
+
+		d.onMessageFoundFn(message)
+		found++
 	}
 }
 
-// scanChannel scans a single channel for messages that are worthy of reply
-func (d *DiscordChannelScanner) scanChannel(ctx context.Context, channel *discordgo.Channel) *discordgo.Message {
+// scanChannel scans a single channel for the message that is the most worthy of reply
+func (d *DiscordChannelScanner) scanChannel(ctx context.Context, policy GuildScannerPolicy, activity ScannerActivity, channel *discordgo.Channel) *RankedMessage {
 	d.log.Info("scanning channel for messages", zap.String("channelID", channel.ID), zap.String("channelName", channel.Name))
 
 	messages, err := d.bot.ChannelMessages(channel.ID, messagesLimit, "", "", "", discordgo.WithContext(ctx))
@@ -126,43 +194,100 @@ func (d *DiscordChannelScanner) scanChannel(ctx context.Context, channel *discor
 		return nil
 	}
 
-	// Filter messages based on criteria
-	worthyMessages := d.filterWorthyMessages(ctx, messages)
+	// Give the channel a break, if the bot has been talking there recently
+	lastBotMessage, ok := arrayutil.Find(messages, func(message *discordgo.Message) bool {
+		return message.Author != nil && message.Author.ID == d.bot.State.User.ID
+	})
+	if ok && time.Since(lastBotMessage.Timestamp) < policy.BotMessageGap {
+		d.log.Info("skipping channel with recent bot message", zap.String("channelID", channel.ID))
+		return nil
+	}
+
+	worthyMessages := d.rankWorthyMessages(ctx, policy, activity, arrayutil.Filter(messages, func(message *discordgo.Message) bool {
+		return isScanCandidate(policy, activity, message)
+	}))
+	if len(worthyMessages) == 0 {
+		return nil
+	}
+
+	// Choose the best message, or a random one of the best if there are more of them
+	bestScore := slices.MaxFunc(worthyMessages, func(a, b RankedMessage) int {
+		return a.Score - b.Score
+	}).Score
+	best := arrayutil.RandomElement(arrayutil.Filter(worthyMessages, func(ranked RankedMessage) bool {
+		return ranked.Score == bestScore
+	}))
+	d.log.Info("found worthy message", zap.String("messageID", best.Message.ID), zap.Int("score", best.Score), zap.String("reason", best.Reason))
+
+	return &best
+}
+
+// isScanCandidate filters out messages, that must not be replied to without asking LLM
+func isScanCandidate(policy GuildScannerPolicy, activity ScannerActivity, message *discordgo.Message) bool {
+	// Ignore messages sent by bots (including us :P)
+	if message.Author.Bot {
+		return false
+	}
+
+	// Ignore messages that have threads
+	if message.Thread != nil {
+		return false
+	}
 
-	// If we found worthy messages, choose one randomly and pass it to the callback
-	if len(worthyMessages) > 0 {
-		// Choose a random message
-		chosenMessage := arrayutil.RandomElement(worthyMessages)
-		d.log.Info("found worthy message", zap.String("messageID", chosenMessage.ID), zap.String("content", chosenMessage.Content))
+	// Check if the message is fresh enough
+	if time.Since(message.Timestamp) > freshMessageDuration {
+		return false
+	}
 
-		return chosenMessage
+	// Don't bother users, that the bot has replied to recently
+	if !activity.CanReplyToUser(policy, message.Author.ID, time.Now()) {
+		return false
 	}
 
-	return nil
+	// Check if the message is long enough
+	return isReplyCandidate(message)
 }
 
-// filterWorthyMessages filters messages based on the specified criteria
-func (d *DiscordChannelScanner) filterWorthyMessages(ctx context.Context, messages []*discordgo.Message) []*discordgo.Message {
-	// Filter messages based on criteria
-	worthyMessages := arrayutil.Filter(messages, func(message *discordgo.Message) bool {
-		// Ignore messages sent by bots (including us :P)
-		if message.Author.Bot {
-			return false
+// rankWorthyMessages scores candidates, that were not scored on previous scans, in a single prompt.
+// It returns candidates with a score high enough to reply.
+func (d *DiscordChannelScanner) rankWorthyMessages(ctx context.Context, policy GuildScannerPolicy, activity ScannerActivity, candidates []*discordgo.Message) []RankedMessage {
+	var ranked []RankedMessage
+	var unevaluated []*discordgo.Message
+	for _, message := range candidates {
+		evaluation, err := d.evaluations.Get(message.ID)
+		if err != nil {
+			d.log.Error("failed to get message evaluation", zap.String("messageID", message.ID), zap.Error(err))
+			continue
 		}
 
-		// Ignore messages that have threads
-		if message.Thread != nil {
-			return false
+		if evaluation != nil {
+			ranked = append(ranked, RankedMessage{Message: message, Score: evaluation.Score, Reason: evaluation.Reason})
+			continue
 		}
 
-		// Check if the message is fresh enough
-		if time.Since(message.Timestamp) > freshMessageDuration {
-			return false
+		unevaluated = append(unevaluated, message)
+	}
+
+	// Messages are sorted from the newest, the older ones will be ranked on the next scans
+	if len(unevaluated) > maxRankedMessages {
+		unevaluated = unevaluated[:maxRankedMessages]
+	}
+
+	if len(unevaluated) > 0 {
+		newlyRanked, err := RankMessages(ctx, d.llmApi, unevaluated)
+		if err != nil {
+			d.log.Error("failed to rank messages", zap.Int("messages", len(unevaluated)), zap.Error(err))
+		} else {
+			err = d.evaluations.Save(newlyRanked, time.Now())
+			if err != nil {
+				d.log.Error("failed to save message evaluations", zap.Error(err))
+			}
+
+			ranked = append(ranked, newlyRanked...)
 		}
+	}
 
-		// Check if the message is long enough and worthy of reply
-		return IsWorthyOfReply(ctx, d.llmApi, message)
+	return arrayutil.Filter(ranked, func(message RankedMessage) bool {
+		return message.Score >= minReplyScore
 	})
-
-	return worthyMessages
 }
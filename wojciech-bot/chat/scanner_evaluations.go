@@ -0,0 +1,74 @@
+package chat
+
+import (
+	"lib/storage"
+	"time"
+)
+
+const scannerEvaluationBucketName = "scanner_evaluations"
+
+// ScannerEvaluation is a score of a message, that was already ranked by LLM
+type ScannerEvaluation struct {
+	MessageID   string    `json:"message_id"`
+	ChannelID   string    `json:"channel_id"`
+	Score       int       `json:"score"`
+	Reason      string    `json:"reason"`
+	EvaluatedAt time.Time `json:"evaluated_at"`
+}
+
+// ScannerEvaluationStore remembers scores of messages, so that the scanner doesn't judge the same messages on every tick
+type ScannerEvaluationStore struct {
+	evaluations *storage.Bucket[ScannerEvaluation]
+}
+
+func NewScannerEvaluationStore(db *storage.DB) (*ScannerEvaluationStore, error) {
+	evaluations, err := storage.NewBucket[ScannerEvaluation](db, scannerEvaluationBucketName)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ScannerEvaluationStore{evaluations: evaluations}, nil
+}
+
+// Get returns nil, if the message was not evaluated yet
+func (s *ScannerEvaluationStore) Get(messageID string) (*ScannerEvaluation, error) {
+	return s.evaluations.Get(messageID)
+}
+
+func (s *ScannerEvaluationStore) Save(ranked []RankedMessage, now time.Time) error {
+	for _, message := range ranked {
+		err := s.evaluations.Put(message.Message.ID, ScannerEvaluation{
+			MessageID:   message.Message.ID,
+			ChannelID:   message.Message.ChannelID,
+			Score:       message.Score,
+			Reason:      message.Reason,
+			EvaluatedAt: now,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Prune forgets evaluations older than the given time, and returns how many of them were removed
+func (s *ScannerEvaluationStore) Prune(before time.Time) (int, error) {
+	var stale []string
+	err := s.evaluations.ForEach(func(key string, evaluation ScannerEvaluation) error {
+		if evaluation.EvaluatedAt.Before(before) {
+			stale = append(stale, key)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if len(stale) == 0 {
+		return 0, nil
+	}
+
+	return len(stale), s.evaluations.Delete(stale...)
+}
@@ -0,0 +1,202 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"github.com/bwmarrin/discordgo"
+	"lib/discord"
+	"lib/errors"
+	"lib/util"
+	"sort"
+	"strings"
+	"time"
+	"wojciech-bot/messages"
+)
+
+// ScannerPolicyUpdate contains changes of the policy requested by an admin, nil fields are left unchanged
+type ScannerPolicyUpdate struct {
+	OptIn           *bool
+	Timezone        *string
+	QuietHoursStart *int
+	QuietHoursEnd   *int
+	DailyLimit      *int
+	UserCooldown    *time.Duration
+	BotMessageGap   *time.Duration
+	// ChannelID selects the channel, that the channel fields are applied to
+	ChannelID         string
+	ChannelEnabled    *bool
+	ChannelDailyLimit *int
+}
+
+type ScannerInteractions struct {
+	bot      *discord.Bot
+	policies *ScannerPolicyStore
+}
+
+func NewScannerInteractions(bot *discord.Bot, policies *ScannerPolicyStore) *ScannerInteractions {
+	return &ScannerInteractions{
+		bot:      bot,
+		policies: policies,
+	}
+}
+
+// Configure applies the update to the policy of the guild, and replies with the resulting policy
+func (s *ScannerInteractions) Configure(ctx context.Context, interaction *discordgo.Interaction, update ScannerPolicyUpdate) error {
+	if interaction.GuildID == "" || interaction.Member == nil ||
+		interaction.Member.Permissions&(discordgo.PermissionAdministrator|discordgo.PermissionManageServer) == 0 {
+		return errors.NewErrPublic(messages.Messages.Scanner.Forbidden)
+	}
+
+	policy, err := s.policies.Policy(interaction.GuildID)
+	if err != nil {
+		return err
+	}
+
+	err = applyScannerPolicyUpdate(&policy, update)
+	if err != nil {
+		return err
+	}
+
+	err = s.policies.SavePolicy(policy)
+	if err != nil {
+		return err
+	}
+
+	s.bot.FollowupInteractionMessageAndForget(interaction, &discord.InteractionReply{
+		Ephemeral: true,
+		Embeds:    []*discordgo.MessageEmbed{newScannerPolicyEmbed(policy)},
+	})
+
+	return nil
+}
+
+func applyScannerPolicyUpdate(policy *GuildScannerPolicy, update ScannerPolicyUpdate) error {
+	if update.Timezone != nil {
+		_, err := time.LoadLocation(*update.Timezone)
+		if err != nil {
+			return errors.NewErrPublicCause(messages.Messages.Scanner.InvalidTimezone, err)
+		}
+
+		policy.Timezone = *update.Timezone
+	}
+
+	for _, hour := range []*int{update.QuietHoursStart, update.QuietHoursEnd} {
+		if hour != nil && (*hour < 0 || *hour > 23) {
+			return errors.NewErrPublic(messages.Messages.Scanner.InvalidHour)
+		}
+	}
+
+	for _, limit := range []*int{update.DailyLimit, update.ChannelDailyLimit} {
+		if limit != nil && *limit < 0 {
+			return errors.NewErrPublic(messages.Messages.Scanner.InvalidValue)
+		}
+	}
+
+	for _, duration := range []*time.Duration{update.UserCooldown, update.BotMessageGap} {
+		if duration != nil && *duration < 0 {
+			return errors.NewErrPublic(messages.Messages.Scanner.InvalidValue)
+		}
+	}
+
+	if update.OptIn != nil {
+		policy.OptIn = *update.OptIn
+	}
+	if update.QuietHoursStart != nil {
+		policy.QuietHoursStart = *update.QuietHoursStart
+	}
+	if update.QuietHoursEnd != nil {
+		policy.QuietHoursEnd = *update.QuietHoursEnd
+	}
+	if update.DailyLimit != nil {
+		policy.DailyLimit = *update.DailyLimit
+	}
+	if update.UserCooldown != nil {
+		policy.UserCooldown = *update.UserCooldown
+	}
+	if update.BotMessageGap != nil {
+		policy.BotMessageGap = *update.BotMessageGap
+	}
+
+	if update.ChannelID == "" {
+		if update.ChannelEnabled != nil || update.ChannelDailyLimit != nil {
+			return errors.NewErrPublic(messages.Messages.Scanner.MissingChannel)
+		}
+
+		return nil
+	}
+
+	channel := policy.Channels[update.ChannelID]
+	if update.ChannelEnabled != nil {
+		channel.Enabled = update.ChannelEnabled
+	}
+	if update.ChannelDailyLimit != nil {
+		channel.DailyLimit = *update.ChannelDailyLimit
+	}
+	policy.Channels[update.ChannelID] = channel
+
+	return nil
+}
+
+func newScannerPolicyEmbed(policy GuildScannerPolicy) *discordgo.MessageEmbed {
+	mode := messages.Messages.Scanner.ModeOptOut
+	if policy.OptIn {
+		mode = messages.Messages.Scanner.ModeOptIn
+	}
+
+	quietHours := messages.Messages.Scanner.None
+	if policy.QuietHoursStart != policy.QuietHoursEnd {
+		quietHours = fmt.Sprintf("%d:00–%d:00", policy.QuietHoursStart, policy.QuietHoursEnd)
+	}
+
+	return &discordgo.MessageEmbed{
+		Title: messages.Messages.Scanner.PolicyTitle,
+		Fields: []*discordgo.MessageEmbedField{
+			{Name: messages.Messages.Scanner.ModeField, Value: mode},
+			{Name: messages.Messages.Scanner.TimezoneField, Value: policy.Timezone, Inline: true},
+			{Name: messages.Messages.Scanner.QuietHoursField, Value: quietHours, Inline: true},
+			{Name: messages.Messages.Scanner.DailyLimitField, Value: formatLimit(policy.DailyLimit), Inline: true},
+			{Name: messages.Messages.Scanner.UserCooldownField, Value: policy.UserCooldown.String(), Inline: true},
+			{Name: messages.Messages.Scanner.BotMessageGapField, Value: policy.BotMessageGap.String(), Inline: true},
+			{Name: messages.Messages.Scanner.ChannelsField, Value: formatChannelPolicies(policy.Channels)},
+		},
+	}
+}
+
+func formatChannelPolicies(channels map[string]ChannelScannerPolicy) string {
+	if len(channels) == 0 {
+		return "-"
+	}
+
+	ids := make([]string, 0, len(channels))
+	for id := range channels {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	lines := make([]string, 0, len(ids))
+	for _, id := range ids {
+		channel := channels[id]
+		state := messages.Messages.Scanner.ChannelDefault
+		if channel.Enabled != nil && *channel.Enabled {
+			state = messages.Messages.Scanner.ChannelEnabled
+		} else if channel.Enabled != nil {
+			state = messages.Messages.Scanner.ChannelDisabled
+		}
+
+		lines = append(lines, util.ApplyTokens(messages.Messages.Scanner.ChannelPolicy, map[string]string{
+			"CHANNEL_ID": id,
+			"STATE":      state,
+			"LIMIT":      formatLimit(channel.DailyLimit),
+		}))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func formatLimit(limit int) string {
+	if limit <= 0 {
+		return messages.Messages.Scanner.None
+	}
+
+	return fmt.Sprintf("%d", limit)
+}
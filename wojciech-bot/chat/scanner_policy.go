@@ -0,0 +1,219 @@
+package chat
+
+import (
+	"lib/storage"
+	"time"
+)
+
+const scannerPolicyBucketName = "scanner_policies"
+const scannerActivityBucketName = "scanner_activity"
+
+// defaultScannerTimezone is used for quiet hours and daily limits, if the guild has no timezone set
+const defaultScannerTimezone = "Europe/Warsaw"
+
+// ChannelScannerPolicy overrides the guild policy for a single channel
+type ChannelScannerPolicy struct {
+	// Enabled allows or forbids scanning of the channel, the guild mode decides if nil
+	Enabled *bool `json:"enabled"`
+	// DailyLimit of unsolicited replies in the channel, 0 means no limit
+	DailyLimit int `json:"daily_limit"`
+}
+
+// GuildScannerPolicy decides where and when the scanner may reply to messages nobody asked it about
+type GuildScannerPolicy struct {
+	GuildID string `json:"guild_id"`
+	// OptIn makes the scanner skip channels, that were not enabled explicitly
+	OptIn bool `json:"opt_in"`
+	// Timezone is an IANA name, e.g. "Europe/Warsaw", of the time used for quiet hours and daily limits
+	Timezone string `json:"timezone"`
+	// QuietHoursStart and QuietHoursEnd are full hours, the scanner is silent if they are different
+	QuietHoursStart int `json:"quiet_hours_start"`
+	QuietHoursEnd   int `json:"quiet_hours_end"`
+	// DailyLimit of unsolicited replies in the whole guild, 0 means no limit
+	DailyLimit int `json:"daily_limit"`
+	// UserCooldown is the minimum time between unsolicited replies to the same user
+	UserCooldown time.Duration `json:"user_cooldown"`
+	// BotMessageGap is the minimum time since the last message of the bot in the channel
+	BotMessageGap time.Duration `json:"bot_message_gap"`
+	// Channels override the policy of single channels, by their ID
+	Channels map[string]ChannelScannerPolicy `json:"channels"`
+}
+
+// NewGuildScannerPolicy returns the policy of a guild, that has not been configured yet
+func NewGuildScannerPolicy(guildID string) GuildScannerPolicy {
+	return GuildScannerPolicy{
+		GuildID:  guildID,
+		Timezone: defaultScannerTimezone,
+		Channels: make(map[string]ChannelScannerPolicy),
+	}
+}
+
+// Location returns the timezone of the guild, or the default one if it is invalid
+func (p GuildScannerPolicy) Location() *time.Location {
+	location, err := time.LoadLocation(p.Timezone)
+	if err != nil {
+		location, err = time.LoadLocation(defaultScannerTimezone)
+		if err != nil {
+			return time.UTC
+		}
+	}
+
+	return location
+}
+
+// IsQuiet reports whether the scanner must not reply at the given time. Quiet hours may span midnight, e.g. 23-8.
+func (p GuildScannerPolicy) IsQuiet(now time.Time) bool {
+	if p.QuietHoursStart == p.QuietHoursEnd {
+		return false
+	}
+
+	hour := now.In(p.Location()).Hour()
+	if p.QuietHoursStart < p.QuietHoursEnd {
+		return hour >= p.QuietHoursStart && hour < p.QuietHoursEnd
+	}
+
+	return hour >= p.QuietHoursStart || hour < p.QuietHoursEnd
+}
+
+// IsChannelEnabled reports whether the channel may be scanned
+func (p GuildScannerPolicy) IsChannelEnabled(channelID string) bool {
+	channel, ok := p.Channels[channelID]
+	if !ok || channel.Enabled == nil {
+		return !p.OptIn
+	}
+
+	return *channel.Enabled
+}
+
+// ScannerActivity counts unsolicited replies in a guild, so that limits of its policy are kept across restarts
+type ScannerActivity struct {
+	GuildID string `json:"guild_id"`
+	// Day in the timezone of the guild, that the counters below are for
+	Day            string         `json:"day"`
+	GuildReplies   int            `json:"guild_replies"`
+	ChannelReplies map[string]int `json:"channel_replies"`
+	// UserReplies contains time of the last unsolicited reply to the user
+	UserReplies map[string]time.Time `json:"user_replies"`
+}
+
+func newScannerActivity(guildID string) ScannerActivity {
+	return ScannerActivity{
+		GuildID:        guildID,
+		ChannelReplies: make(map[string]int),
+		UserReplies:    make(map[string]time.Time),
+	}
+}
+
+// CanReplyInChannel reports whether daily limits of the guild and the channel allow another reply
+func (a ScannerActivity) CanReplyInChannel(policy GuildScannerPolicy, channelID string, now time.Time) bool {
+	if a.Day != scannerDay(policy, now) {
+		return true
+	}
+
+	if policy.DailyLimit > 0 && a.GuildReplies >= policy.DailyLimit {
+		return false
+	}
+
+	channelLimit := policy.Channels[channelID].DailyLimit
+
+	return channelLimit <= 0 || a.ChannelReplies[channelID] < channelLimit
+}
+
+// CanReplyToUser reports whether the cooldown of the user has passed
+func (a ScannerActivity) CanReplyToUser(policy GuildScannerPolicy, userID string, now time.Time) bool {
+	lastReply, ok := a.UserReplies[userID]
+
+	return !ok || now.Sub(lastReply) >= policy.UserCooldown
+}
+
+// Record counts the reply, counters are reset when the day of the guild changes
+func (a *ScannerActivity) Record(policy GuildScannerPolicy, channelID string, userID string, now time.Time) {
+	day := scannerDay(policy, now)
+	if a.Day != day {
+		a.Day = day
+		a.GuildReplies = 0
+		a.ChannelReplies = make(map[string]int)
+	}
+
+	a.GuildReplies++
+	a.ChannelReplies[channelID]++
+
+	// Users, whose cooldown has passed, don't need to be remembered anymore
+	for id, lastReply := range a.UserReplies {
+		if now.Sub(lastReply) >= policy.UserCooldown {
+			delete(a.UserReplies, id)
+		}
+	}
+	a.UserReplies[userID] = now
+}
+
+func scannerDay(policy GuildScannerPolicy, now time.Time) string {
+	return now.In(policy.Location()).Format(time.DateOnly)
+}
+
+// ScannerPolicyStore keeps policies of guilds and their activity in the bot database
+type ScannerPolicyStore struct {
+	policies *storage.Bucket[GuildScannerPolicy]
+	activity *storage.Bucket[ScannerActivity]
+}
+
+func NewScannerPolicyStore(db *storage.DB) (*ScannerPolicyStore, error) {
+	policies, err := storage.NewBucket[GuildScannerPolicy](db, scannerPolicyBucketName)
+	if err != nil {
+		return nil, err
+	}
+
+	activity, err := storage.NewBucket[ScannerActivity](db, scannerActivityBucketName)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ScannerPolicyStore{policies: policies, activity: activity}, nil
+}
+
+// Policy returns the policy of the guild, or the default one if it was never changed
+func (s *ScannerPolicyStore) Policy(guildID string) (GuildScannerPolicy, error) {
+	policy, err := s.policies.Get(guildID)
+	if err != nil {
+		return GuildScannerPolicy{}, err
+	}
+
+	if policy == nil {
+		return NewGuildScannerPolicy(guildID), nil
+	}
+
+	if policy.Channels == nil {
+		policy.Channels = make(map[string]ChannelScannerPolicy)
+	}
+
+	return *policy, nil
+}
+
+func (s *ScannerPolicyStore) SavePolicy(policy GuildScannerPolicy) error {
+	return s.policies.Put(policy.GuildID, policy)
+}
+
+// Activity returns counters of unsolicited replies in the guild
+func (s *ScannerPolicyStore) Activity(guildID string) (ScannerActivity, error) {
+	activity, err := s.activity.Get(guildID)
+	if err != nil {
+		return ScannerActivity{}, err
+	}
+
+	if activity == nil {
+		return newScannerActivity(guildID), nil
+	}
+
+	if activity.ChannelReplies == nil {
+		activity.ChannelReplies = make(map[string]int)
+	}
+	if activity.UserReplies == nil {
+		activity.UserReplies = make(map[string]time.Time)
+	}
+
+	return *activity, nil
+}
+
+func (s *ScannerPolicyStore) SaveActivity(activity ScannerActivity) error {
+	return s.activity.Put(activity.GuildID, activity)
+}
@@ -0,0 +1,50 @@
+package chat_test
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+	"wojciech-bot/chat"
+)
+
+func TestGuildScannerPolicy(t *testing.T) {
+	enabled := true
+	policy := chat.NewGuildScannerPolicy("1")
+	policy.Timezone = "UTC"
+	policy.QuietHoursStart, policy.QuietHoursEnd = 23, 8
+	policy.DailyLimit = 3
+	policy.UserCooldown = time.Hour
+	policy.Channels["10"] = chat.ChannelScannerPolicy{Enabled: &enabled, DailyLimit: 1}
+
+	t.Run("quiet hours span midnight", func(t *testing.T) {
+		assert.True(t, policy.IsQuiet(time.Date(2025, 1, 1, 23, 30, 0, 0, time.UTC)))
+		assert.True(t, policy.IsQuiet(time.Date(2025, 1, 1, 7, 59, 0, 0, time.UTC)))
+		assert.False(t, policy.IsQuiet(time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)))
+	})
+
+	t.Run("opt-in skips channels that were not enabled", func(t *testing.T) {
+		policy.OptIn = true
+		assert.True(t, policy.IsChannelEnabled("10"))
+		assert.False(t, policy.IsChannelEnabled("11"))
+		policy.OptIn = false
+		assert.True(t, policy.IsChannelEnabled("11"))
+	})
+
+	t.Run("limits and cooldowns", func(t *testing.T) {
+		activity := chat.ScannerActivity{GuildID: "1", ChannelReplies: map[string]int{}, UserReplies: map[string]time.Time{}}
+		now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+		activity.Record(policy, "10", "100", now)
+		assert.False(t, activity.CanReplyInChannel(policy, "10", now))
+		assert.True(t, activity.CanReplyInChannel(policy, "11", now))
+		assert.False(t, activity.CanReplyToUser(policy, "100", now.Add(30*time.Minute)))
+		assert.True(t, activity.CanReplyToUser(policy, "100", now.Add(time.Hour)))
+
+		activity.Record(policy, "11", "101", now)
+		activity.Record(policy, "11", "102", now)
+		assert.False(t, activity.CanReplyInChannel(policy, "11", now))
+
+		// Counters start again on the next day
+		assert.True(t, activity.CanReplyInChannel(policy, "10", now.Add(24*time.Hour)))
+	})
+}
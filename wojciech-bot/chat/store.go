@@ -0,0 +1,269 @@
+package chat
+
+import (
+	"go.uber.org/zap"
+	"lib/llm"
+	"lib/logging"
+	"lib/storage"
+	"sync"
+	"time"
+)
+
+const (
+	chatBucketName = "chats"
+	// messageIndexBucketName maps IDs of messages to IDs of threads, whose chats contain them
+	messageIndexBucketName = "chat_messages"
+	// parentIndexBucketName maps IDs of parent channels to IDs of their last saved threads
+	parentIndexBucketName = "chat_parents"
+)
+
+// ChatState contains everything needed to continue the discussion after a restart of the bot
+type ChatState struct {
+	ThreadID   string `json:"thread_id"`
+	ParentCid  string `json:"parent_cid"`
+	IsFinished bool   `json:"is_finished"`
+	// Chat contains messages and metadata, including ID of the assistant thread
+	Chat      *llm.Chat `json:"chat"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ChatStore persists chats, so that discussions survive restarts of the bot
+type ChatStore interface {
+	Save(state ChatState) error
+	// Load returns nil, if the thread has no saved chat
+	Load(threadID string) (*ChatState, error)
+	// FindByMessage returns the saved chat, that contains the message, or nil
+	FindByMessage(messageID string) (*ChatState, error)
+	// IsParent tells whether a saved chat has its thread started in the channel
+	IsParent(channelID string) (bool, error)
+}
+
+// chatPruneInterval is how often expired chats are deleted, while chats are saved
+const chatPruneInterval = time.Hour
+
+// BoltChatStore keeps chats in the bot database, by ID of their thread. Chats not updated for longer than TTL are
+// no longer restored, and are deleted from time to time.
+type BoltChatStore struct {
+	chats    *storage.Bucket[ChatState]
+	messages *storage.Bucket[string]
+	parents  *storage.Bucket[string]
+	// ttl is how long chats are kept after their last update, 0 keeps them forever
+	ttl time.Duration
+
+	mu         sync.Mutex
+	lastPruned time.Time
+	log        *zap.Logger
+}
+
+func NewBoltChatStore(db *storage.DB, ttl time.Duration) (*BoltChatStore, error) {
+	chats, err := storage.NewBucket[ChatState](db, chatBucketName)
+	if err != nil {
+		return nil, err
+	}
+
+	messages, err := storage.NewBucket[string](db, messageIndexBucketName)
+	if err != nil {
+		return nil, err
+	}
+	parents, err := storage.NewBucket[string](db, parentIndexBucketName)
+	if err != nil {
+		return nil, err
+	}
+
+	store := &BoltChatStore{
+		chats:    chats,
+		messages: messages,
+		parents:  parents,
+		ttl:      ttl,
+		log:      logging.Get().Named("chat").Named("store"),
+	}
+
+	err = store.indexIfMissing()
+	if err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// Save persists a snapshot of the chat, taken under its lock, without data of the attached files
+func (s *BoltChatStore) Save(state ChatState) error {
+	if state.Chat != nil {
+		state.Chat = state.Chat.Snapshot()
+	}
+
+	err := s.chats.Put(state.ThreadID, state)
+	if err != nil {
+		return err
+	}
+
+	err = s.index(state)
+	if err != nil {
+		return err
+	}
+
+	s.pruneIfDue(state.UpdatedAt)
+
+	return nil
+}
+
+func (s *BoltChatStore) Load(threadID string) (*ChatState, error) {
+	state, err := s.chats.Get(threadID)
+	if err != nil || state == nil {
+		return state, err
+	}
+
+	if s.isExpired(*state, time.Now()) {
+		return nil, nil
+	}
+
+	return state, nil
+}
+
+// FindByMessage looks the message up in the index, and returns its chat, if it didn't expire
+func (s *BoltChatStore) FindByMessage(messageID string) (*ChatState, error) {
+	threadID, err := s.messages.Get(messageID)
+	if err != nil || threadID == nil {
+		return nil, err
+	}
+
+	return s.Load(*threadID)
+}
+
+func (s *BoltChatStore) IsParent(channelID string) (bool, error) {
+	threadID, err := s.parents.Get(channelID)
+
+	return threadID != nil, err
+}
+
+// index maps messages and the parent channel of the chat to its thread
+func (s *BoltChatStore) index(state ChatState) error {
+	if state.ParentCid != "" {
+		err := s.parents.Put(state.ParentCid, state.ThreadID)
+		if err != nil {
+			return err
+		}
+	}
+
+	if state.Chat == nil {
+		return nil
+	}
+
+	messages := make(map[string]string, len(state.Chat.Messages))
+	for _, message := range state.Chat.Messages {
+		if message.ID != "" {
+			messages[message.ID] = state.ThreadID
+		}
+	}
+
+	return s.messages.PutAll(messages)
+}
+
+// indexIfMissing indexes chats saved before the index existed
+func (s *BoltChatStore) indexIfMissing() error {
+	indexed, err := s.messages.Count()
+	if err != nil || indexed > 0 {
+		return err
+	}
+
+	// Index is written outside of the iteration, since bolt doesn't allow to update within it
+	var states []ChatState
+	err = s.chats.ForEach(func(_ string, state ChatState) error {
+		states = append(states, state)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, state := range states {
+		err = s.index(state)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Prune deletes chats, that expired before now, together with their index. Returns the number of deleted chats.
+func (s *BoltChatStore) Prune(now time.Time) (int, error) {
+	if s.ttl == 0 {
+		return 0, nil
+	}
+
+	var expired, expiredMessages []string
+	parents := map[string]bool{}
+	err := s.chats.ForEach(func(threadID string, state ChatState) error {
+		if !s.isExpired(state, now) {
+			parents[state.ParentCid] = true
+			return nil
+		}
+
+		expired = append(expired, threadID)
+		if state.Chat != nil {
+			for _, message := range state.Chat.Messages {
+				if message.ID != "" {
+					expiredMessages = append(expiredMessages, message.ID)
+				}
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var expiredParents []string
+	err = s.parents.ForEach(func(parentCid string, _ string) error {
+		if !parents[parentCid] {
+			expiredParents = append(expiredParents, parentCid)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	err = s.chats.Delete(expired...)
+	if err != nil {
+		return 0, err
+	}
+	err = s.messages.Delete(expiredMessages...)
+	if err != nil {
+		return 0, err
+	}
+	err = s.parents.Delete(expiredParents...)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(expired), nil
+}
+
+// pruneIfDue prunes chats at most once per chatPruneInterval. Failure is only logged, since the chat was saved.
+func (s *BoltChatStore) pruneIfDue(now time.Time) {
+	s.mu.Lock()
+	if now.Sub(s.lastPruned) < chatPruneInterval {
+		s.mu.Unlock()
+		return
+	}
+	s.lastPruned = now
+	s.mu.Unlock()
+
+	pruned, err := s.Prune(now)
+	if err != nil {
+		s.log.Error("failed to prune chats", zap.Error(err))
+		return
+	}
+
+	if pruned > 0 {
+		s.log.Info("pruned expired chats", zap.Int("chats", pruned))
+	}
+}
+
+func (s *BoltChatStore) isExpired(state ChatState, now time.Time) bool {
+	return s.ttl > 0 && now.Sub(state.UpdatedAt) > s.ttl
+}
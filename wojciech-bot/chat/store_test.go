@@ -0,0 +1,195 @@
+package chat_test
+
+import (
+	"github.com/bwmarrin/discordgo"
+	"github.com/stretchr/testify/assert"
+	"lib/discord"
+	"lib/llm"
+	"lib/storage"
+	"path/filepath"
+	"testing"
+	"time"
+	"wojciech-bot/chat"
+)
+
+func newChatStore(t *testing.T, ttl time.Duration) *chat.BoltChatStore {
+	db, err := storage.Open(filepath.Join(t.TempDir(), "test.db"))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	store, err := chat.NewBoltChatStore(db, ttl)
+	assert.NoError(t, err)
+
+	return store
+}
+
+func TestBoltChatStore(t *testing.T) {
+	store := newChatStore(t, 24*time.Hour)
+
+	llmChat := llm.NewChat()
+	llmChat.AddMetadata("openAIThreadID", "thread_abc")
+	userMessage := llm.NewUserChatMessage("hej", "1", "Artur")
+	userMessage.Files = []llm.File{*llm.NewFile([]byte("image"), "cat.png", "image/png")}
+	llmChat.AddMessages(userMessage, llm.NewAssistantChatMessage("siema", "2"))
+
+	err := store.Save(chat.ChatState{ThreadID: "10", ParentCid: "20", IsFinished: true, Chat: llmChat, UpdatedAt: time.Now()})
+	assert.NoError(t, err)
+
+	t.Run("restores chat with its metadata", func(t *testing.T) {
+		state, err := store.Load("10")
+		assert.NoError(t, err)
+		assert.Equal(t, "20", state.ParentCid)
+		assert.True(t, state.IsFinished)
+		assert.Equal(t, "thread_abc", state.Chat.Metadata["openAIThreadID"])
+		assert.Len(t, state.Chat.Messages, 2)
+
+		// Messages already in the chat are not added again
+		state.Chat.AddMessages(llm.NewUserChatMessage("hej", "1", "Artur"))
+		assert.Len(t, state.Chat.Messages, 2)
+	})
+
+	t.Run("doesn't persist files", func(t *testing.T) {
+		state, err := store.Load("10")
+		assert.NoError(t, err)
+		assert.Empty(t, state.Chat.Messages[0].Files)
+		// Chat itself keeps its files
+		assert.Len(t, llmChat.Messages[0].Files, 1)
+	})
+
+	t.Run("finds chat by its message", func(t *testing.T) {
+		state, err := store.FindByMessage("2")
+		assert.NoError(t, err)
+		assert.Equal(t, "10", state.ThreadID)
+
+		state, err = store.FindByMessage("3")
+		assert.NoError(t, err)
+		assert.Nil(t, state)
+	})
+
+	t.Run("knows parent channels of chats", func(t *testing.T) {
+		isParent, err := store.IsParent("20")
+		assert.NoError(t, err)
+		assert.True(t, isParent)
+
+		isParent, err = store.IsParent("10")
+		assert.NoError(t, err)
+		assert.False(t, isParent)
+	})
+
+	t.Run("returns nil for unknown thread", func(t *testing.T) {
+		state, err := store.Load("11")
+		assert.NoError(t, err)
+		assert.Nil(t, state)
+	})
+
+	t.Run("expires chats after TTL", func(t *testing.T) {
+		expiredChat := llm.NewChat()
+		expiredChat.AddMessages(llm.NewUserChatMessage("dawno", "5", "Artur"))
+		err := store.Save(chat.ChatState{ThreadID: "12", ParentCid: "21", Chat: expiredChat, UpdatedAt: time.Now().Add(-48 * time.Hour)})
+		assert.NoError(t, err)
+
+		state, err := store.Load("12")
+		assert.NoError(t, err)
+		assert.Nil(t, state)
+		state, err = store.FindByMessage("5")
+		assert.NoError(t, err)
+		assert.Nil(t, state)
+
+		pruned, err := store.Prune(time.Now())
+		assert.NoError(t, err)
+		assert.Equal(t, 1, pruned)
+
+		isParent, err := store.IsParent("21")
+		assert.NoError(t, err)
+		assert.False(t, isParent)
+		isParent, err = store.IsParent("20")
+		assert.NoError(t, err)
+		assert.True(t, isParent)
+
+		state, err = store.Load("10")
+		assert.NoError(t, err)
+		assert.NotNil(t, state)
+	})
+}
+
+func TestManagerRestoresChats(t *testing.T) {
+	store := newChatStore(t, 0)
+
+	reasoningMessage := llm.NewAssistantChatMessage("siema", "2")
+	reasoningMessage.AddMetadata(llm.ThinkingMetadataKey, "Artur says hi")
+	for threadID, finished := range map[string]bool{"10": false, "11": true} {
+		llmChat := llm.NewChat()
+		llmChat.AddMessages(llm.NewUserChatMessage("hej", "1", "Artur"), reasoningMessage)
+
+		err := store.Save(chat.ChatState{ThreadID: threadID, ParentCid: "20", IsFinished: finished, Chat: llmChat, UpdatedAt: time.Now()})
+		assert.NoError(t, err)
+	}
+
+	state := discordgo.NewState()
+	state.User = &discordgo.User{Username: "wojciech"}
+	bot := &discord.Bot{Session: &discordgo.Session{State: state}}
+	manager := chat.NewManager(bot, llm.NewSingleAdapterRouter(llm.NewScriptedAdapter()), llm.NewToolRegistry(), store, nil)
+
+	t.Run("restores reasoning of a saved chat", func(t *testing.T) {
+		reasoning, ok := manager.GetReasoning("10", "2")
+		assert.True(t, ok)
+		assert.Equal(t, "Artur says hi", reasoning)
+	})
+
+	t.Run("keeps restored chat going", func(t *testing.T) {
+		assert.False(t, manager.HasChat("10"))
+		assert.NotNil(t, manager.GetOrCreateChat("10"))
+		assert.True(t, manager.HasChat("10"))
+	})
+
+	t.Run("doesn't keep finished chat", func(t *testing.T) {
+		assert.NotNil(t, manager.GetOrCreateChat("11"))
+		assert.False(t, manager.HasChat("11"))
+	})
+
+	t.Run("finds restored chat with its message", func(t *testing.T) {
+		assert.NotNil(t, manager.FindChatWithMessage("11", "1"))
+		assert.Nil(t, manager.FindChatWithMessage("12", "99"))
+	})
+
+	t.Run("finds saved chat with the first message in the parent channel", func(t *testing.T) {
+		llmChat := llm.NewChat()
+		llmChat.AddMessages(llm.NewUserChatMessage("pierwsza", "30", "Artur"))
+		err := store.Save(chat.ChatState{ThreadID: "13", ParentCid: "20", Chat: llmChat, UpdatedAt: time.Now()})
+		assert.NoError(t, err)
+
+		assert.NotNil(t, manager.FindChatWithMessage("20", "30"))
+	})
+
+	t.Run("ignores messages outside of threads and their parent channels", func(t *testing.T) {
+		assert.Nil(t, manager.FindChatWithMessage("21", "30"))
+	})
+}
+
+func TestBoltChatStoreIndexesSavedChats(t *testing.T) {
+	db, err := storage.Open(filepath.Join(t.TempDir(), "test.db"))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	// Chat saved before the index existed
+	chats, err := storage.NewBucket[chat.ChatState](db, "chats")
+	assert.NoError(t, err)
+	llmChat := llm.NewChat()
+	llmChat.AddMessages(llm.NewUserChatMessage("hej", "1", "Artur"))
+	assert.NoError(t, chats.Put("10", chat.ChatState{ThreadID: "10", ParentCid: "20", Chat: llmChat, UpdatedAt: time.Now()}))
+
+	store, err := chat.NewBoltChatStore(db, 0)
+	assert.NoError(t, err)
+
+	state, err := store.FindByMessage("1")
+	assert.NoError(t, err)
+	assert.Equal(t, "10", state.ThreadID)
+
+	isParent, err := store.IsParent("20")
+	assert.NoError(t, err)
+	assert.True(t, isParent)
+}
@@ -0,0 +1,152 @@
+package chat
+
+import (
+	"context"
+	"github.com/bwmarrin/discordgo"
+	"go.uber.org/zap"
+	libdiscord "lib/discord"
+	"lib/errors"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// streamEditInterval is the minimal duration between edits of the streamed reply, so that we don't hit Discord rate limits
+const streamEditInterval = 1500 * time.Millisecond
+
+// maxMessageLength is the Discord limit of characters in a single message. Longer replies are split in many messages.
+const maxMessageLength = 2000
+
+// streamingReply sends a reply as soon as first part of it is generated, and keeps editing it while next parts arrive.
+type streamingReply struct {
+	bot       *libdiscord.Bot
+	channelID string
+	log       *zap.Logger
+	// contents contains all parts of the reply received so far
+	contents strings.Builder
+	// messages are the Discord messages with parts of the reply, empty until first part is sent
+	messages []*discordgo.Message
+	// sentParts contain parts that are currently visible in messages
+	sentParts  []string
+	lastEditAt time.Time
+}
+
+func newStreamingReply(bot *libdiscord.Bot, channelID string, log *zap.Logger) *streamingReply {
+	return &streamingReply{
+		bot:       bot,
+		channelID: channelID,
+		log:       log,
+	}
+}
+
+// Write appends delta to the reply and sends, or edits the message if enough time has passed since last edit.
+// Failures are only logged, since the final reply is sent by Finish anyway.
+func (r *streamingReply) Write(ctx context.Context, delta string) error {
+	r.contents.WriteString(delta)
+
+	contents := strings.TrimSpace(r.contents.String())
+	if contents == "" || time.Since(r.lastEditAt) < streamEditInterval {
+		return nil
+	}
+
+	err := r.update(ctx, contents)
+	if err != nil {
+		r.log.Error("failed to update streamed reply", zap.Error(err))
+	}
+
+	return nil
+}
+
+// Finish makes sure that the messages contain final contents of the reply, and returns the last one.
+func (r *streamingReply) Finish(ctx context.Context, contents string) (*discordgo.Message, error) {
+	err := r.update(ctx, contents)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.messages[len(r.messages)-1], nil
+}
+
+// Discard deletes the partial reply, if it was already sent
+func (r *streamingReply) Discard(ctx context.Context) {
+	r.deleteFrom(ctx, 0)
+}
+
+// update splits contents in parts that fit in a message, edits messages with changed parts, and sends the new ones
+func (r *streamingReply) update(ctx context.Context, contents string) error {
+	r.lastEditAt = time.Now()
+
+	parts := splitMessage(contents, maxMessageLength)
+	for i, part := range parts {
+		if i < len(r.messages) {
+			if part == r.sentParts[i] {
+				continue
+			}
+
+			message, err := r.bot.ChannelMessageEdit(r.channelID, r.messages[i].ID, part, discordgo.WithContext(ctx))
+			if err != nil {
+				return errors.Wrap(err, "failed to edit reply")
+			}
+
+			r.messages[i] = message
+			r.sentParts[i] = part
+
+			continue
+		}
+
+		message, err := r.bot.ChannelMessageSend(r.channelID, part, discordgo.WithContext(ctx))
+		if err != nil {
+			return errors.Wrap(err, "failed to send reply")
+		}
+
+		r.messages = append(r.messages, message)
+		r.sentParts = append(r.sentParts, part)
+	}
+
+	// Final contents can be shorter than the streamed ones, e.g. after filters
+	r.deleteFrom(ctx, len(parts))
+
+	return nil
+}
+
+// deleteFrom deletes messages starting with the given index
+func (r *streamingReply) deleteFrom(ctx context.Context, index int) {
+	if index >= len(r.messages) {
+		return
+	}
+
+	for _, message := range r.messages[index:] {
+		err := r.bot.ChannelMessageDelete(r.channelID, message.ID, discordgo.WithContext(ctx))
+		if err != nil {
+			r.log.Error("failed to delete part of reply", zap.Error(err))
+		}
+	}
+
+	r.messages = r.messages[:index]
+	r.sentParts = r.sentParts[:index]
+}
+
+// splitMessage splits contents in parts of at most limit characters. Parts are split at the last line break,
+// or space that fits in the limit, and words longer than the limit are cut.
+func splitMessage(contents string, limit int) []string {
+	contents = strings.TrimSpace(contents)
+
+	var parts []string
+	for utf8.RuneCountInString(contents) > limit {
+		// Byte offset of the first character over the limit
+		cut := len(string([]rune(contents)[:limit]))
+
+		end := strings.LastIndex(contents[:cut+1], "\n")
+		if end <= 0 {
+			end = strings.LastIndex(contents[:cut+1], " ")
+		}
+		if end <= 0 {
+			end = cut
+		}
+
+		parts = append(parts, strings.TrimRight(contents[:end], " \n"))
+		contents = strings.TrimLeft(contents[end:], " \n")
+	}
+
+	return append(parts, contents)
+}
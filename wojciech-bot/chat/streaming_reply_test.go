@@ -0,0 +1,154 @@
+package chat_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/bwmarrin/discordgo"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"lib/discord"
+	"lib/llm"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"unicode/utf8"
+	"wojciech-bot/chat"
+)
+
+type discordRequest struct {
+	Method  string
+	Content string
+}
+
+// roundTripper records requests sent to Discord. Sent messages get consecutive IDs, and edited ones keep theirs.
+type roundTripper struct {
+	mu       sync.Mutex
+	requests []discordRequest
+}
+
+func (r *roundTripper) RoundTrip(request *http.Request) (*http.Response, error) {
+	var body struct {
+		Content string `json:"content"`
+	}
+	if request.Body != nil {
+		data, _ := io.ReadAll(request.Body)
+		_ = json.Unmarshal(data, &body)
+	}
+
+	r.mu.Lock()
+	r.requests = append(r.requests, discordRequest{Method: request.Method, Content: body.Content})
+	id := fmt.Sprint(len(r.requests))
+	r.mu.Unlock()
+
+	if request.Method != http.MethodPost {
+		id = path.Base(request.URL.Path)
+	}
+
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(fmt.Sprintf(`{"id": "%s", "channel_id": "thread"}`, id))),
+		Request:    request,
+	}, nil
+}
+
+func (r *roundTripper) Requests() []discordRequest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.requests
+}
+
+func newTestBot(t *testing.T) (*discord.Bot, *roundTripper) {
+	session, err := discordgo.New("Bot token")
+	assert.NoError(t, err)
+
+	transport := &roundTripper{}
+	session.Client = &http.Client{Transport: transport}
+
+	return &discord.Bot{Session: session}, transport
+}
+
+// streamReply streams the scripted reply to the channel, like DiscordChat does
+func streamReply(t *testing.T, reply *chat.StreamingReply, contents string) *discordgo.Message {
+	ctx := context.Background()
+	api := llm.NewSingleAdapterRouter(llm.NewScriptedAdapter(llm.ScriptedRule{Reply: contents})).API(llm.TaskChat)
+	history := llm.NewChat()
+	history.AddMessages(llm.NewUserChatMessage("hej", "1", "Wojtek"))
+
+	_, message, _, err := api.ChatStream(ctx, history, func(delta string) error {
+		return reply.Write(ctx, delta)
+	})
+	assert.NoError(t, err)
+
+	sent, err := reply.Finish(ctx, message.Contents)
+	assert.NoError(t, err)
+
+	return sent
+}
+
+func TestStreamingReply(t *testing.T) {
+	t.Run("throttles edits, and flushes the final reply", func(t *testing.T) {
+		bot, transport := newTestBot(t)
+
+		sent := streamReply(t, chat.NewStreamingReply(bot, "thread"), "Kasia lives in Kraków")
+
+		assert.Equal(t, []discordRequest{
+			{Method: http.MethodPost, Content: "Kasia"},
+			{Method: http.MethodPatch, Content: "Kasia lives in Kraków"},
+		}, transport.Requests())
+		assert.Equal(t, "1", sent.ID)
+	})
+
+	t.Run("splits replies longer than the limit", func(t *testing.T) {
+		bot, transport := newTestBot(t)
+		reply := chat.NewStreamingReply(bot, "thread")
+
+		sent := streamReply(t, reply, strings.Repeat("żółw ", 500))
+
+		requests := transport.Requests()
+		assert.Len(t, requests, 3)
+		assert.Equal(t, http.MethodPost, requests[2].Method)
+		assert.Equal(t, "3", sent.ID)
+		for _, request := range requests {
+			assert.LessOrEqual(t, utf8.RuneCountInString(request.Content), chat.MaxMessageLength)
+		}
+		assert.Equal(t, strings.TrimSpace(strings.Repeat("żółw ", 500)), requests[1].Content+" "+requests[2].Content)
+
+		t.Run("deletes parts, that the final reply doesn't need", func(t *testing.T) {
+			sent, err := reply.Finish(context.Background(), "żółw")
+			assert.NoError(t, err)
+			assert.Equal(t, "1", sent.ID)
+
+			assert.Equal(t, []discordRequest{
+				{Method: http.MethodPatch, Content: "żółw"},
+				{Method: http.MethodDelete},
+			}, transport.Requests()[3:])
+		})
+	})
+}
+
+func TestSplitMessage(t *testing.T) {
+	t.Run("keeps short messages", func(t *testing.T) {
+		assert.Equal(t, []string{"hej"}, chat.SplitMessage(" hej\n", 10))
+	})
+
+	t.Run("splits at the last line break", func(t *testing.T) {
+		assert.Equal(t, []string{"ala ma", "kota i psa"}, chat.SplitMessage("ala ma\nkota i psa", 12))
+	})
+
+	t.Run("splits at the last space", func(t *testing.T) {
+		assert.Equal(t, []string{"ala ma", "kota i", "psa"}, chat.SplitMessage("ala ma kota i psa", 6))
+	})
+
+	t.Run("counts characters, not bytes", func(t *testing.T) {
+		assert.Equal(t, []string{"żółw żółw"}, chat.SplitMessage("żółw żółw", 9))
+	})
+
+	t.Run("cuts words longer than the limit", func(t *testing.T) {
+		assert.Equal(t, []string{"żółwż", "ółw"}, chat.SplitMessage("żółwżółw", 5))
+	})
+}
@@ -0,0 +1,152 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	goerrors "errors"
+	"fmt"
+	"github.com/bwmarrin/discordgo"
+	"lib/discord"
+	"lib/errors"
+	"lib/llm"
+	"strings"
+	"wojciech-bot/env"
+	"wojciech-bot/player"
+)
+
+const ToolQueueSong = "queue_song"
+const ToolFindFriend = "find_friend"
+
+type toolMessageContextKey struct{}
+
+// withToolMessage stores the message that LLM is replying to, so that tools know who asked for them
+func withToolMessage(ctx context.Context, message *discordgo.Message) context.Context {
+	return context.WithValue(ctx, toolMessageContextKey{}, message)
+}
+
+func toolMessage(ctx context.Context) (*discordgo.Message, error) {
+	message, ok := ctx.Value(toolMessageContextKey{}).(*discordgo.Message)
+	if !ok || message == nil {
+		return nil, goerrors.New("tool was called outside of discord message")
+	}
+
+	return message, nil
+}
+
+// NewTools creates tools that Wojciech can use while chatting
+func NewTools(bot *discord.Bot, playerManager *player.ChannelPlayerManager) *llm.ToolRegistry {
+	return llm.NewToolRegistry(
+		newQueueSongTool(bot, playerManager),
+		newFindFriendTool(),
+	)
+}
+
+type queueSongArguments struct {
+	Url string `json:"url"`
+}
+
+func newQueueSongTool(bot *discord.Bot, playerManager *player.ChannelPlayerManager) llm.Tool {
+	return llm.Tool{
+		Name:        ToolQueueSong,
+		Description: "Adds a song from YouTube to the queue of the music player in the voice channel of the person that asked for it.",
+		Parameters: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"url": map[string]any{
+					"type":        "string",
+					"description": "YouTube URL of the song",
+				},
+			},
+			"required": []string{"url"},
+		},
+		Handler: func(ctx context.Context, arguments json.RawMessage) (string, error) {
+			var args queueSongArguments
+			err := json.Unmarshal(arguments, &args)
+			if err != nil {
+				return "", errors.Wrap(err, "invalid arguments")
+			}
+
+			if args.Url == "" {
+				return "", player.ErrSongUrlEmpty
+			}
+
+			message, err := toolMessage(ctx)
+			if err != nil {
+				return "", err
+			}
+
+			voiceState, err := bot.State.VoiceState(env.Env.GuildId, message.Author.ID)
+			if err != nil || voiceState.ChannelID == "" {
+				return "", goerrors.New("person that asked for the song is not in a voice channel")
+			}
+
+			channelPlayer, err := playerManager.GetOrCreate(bot, voiceState.ChannelID)
+			if err != nil {
+				return "", errors.Wrap(err, "failed to get channel player")
+			}
+
+			index, err := channelPlayer.AddToQueue(args.Url, message.Author.ID)
+			if err != nil {
+				return "", errors.Wrap(err, "failed to add song to queue")
+			}
+
+			return fmt.Sprintf("song added to the queue at position %d", index+1), nil
+		},
+	}
+}
+
+type findFriendArguments struct {
+	Name string `json:"name"`
+}
+
+type findFriendResult struct {
+	Nickname  string `json:"nickname"`
+	FirstName string `json:"first_name"`
+	Mention   string `json:"mention"`
+}
+
+func newFindFriendTool() llm.Tool {
+	return llm.Tool{
+		Name:        ToolFindFriend,
+		Description: "Finds a friend from the Discord server by their first name or nickname. Returns their details, including a mention that can be used to ping them.",
+		Parameters: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"name": map[string]any{
+					"type":        "string",
+					"description": "First name or nickname of the friend",
+				},
+			},
+			"required": []string{"name"},
+		},
+		Handler: func(ctx context.Context, arguments json.RawMessage) (string, error) {
+			var args findFriendArguments
+			err := json.Unmarshal(arguments, &args)
+			if err != nil {
+				return "", errors.Wrap(err, "invalid arguments")
+			}
+
+			var result []findFriendResult
+			for _, friend := range discord.Friends {
+				if strings.EqualFold(friend.FirstName, args.Name) || strings.EqualFold(friend.Nickname, args.Name) {
+					result = append(result, findFriendResult{
+						Nickname:  friend.Nickname,
+						FirstName: friend.FirstName,
+						Mention:   friend.Mention(),
+					})
+				}
+			}
+
+			if len(result) == 0 {
+				return "no friend found", nil
+			}
+
+			resultJson, err := json.Marshal(result)
+			if err != nil {
+				return "", err
+			}
+
+			return string(resultJson), nil
+		},
+	}
+}
@@ -4,11 +4,28 @@ import (
 	"context"
 	"github.com/bwmarrin/discordgo"
 	"lib/discord"
+	"time"
+	"wojciech-bot/chat"
+	"wojciech-bot/memory"
 	"wojciech-bot/player"
+	"wojciech-bot/usage"
 )
 
 const DjQueueOptionSong = "piosenka"
 
+const (
+	ScannerOptionOptIn             = "tylko-wlaczone"
+	ScannerOptionTimezone          = "strefa"
+	ScannerOptionQuietHoursStart   = "cisza-od"
+	ScannerOptionQuietHoursEnd     = "cisza-do"
+	ScannerOptionDailyLimit        = "limit-dzienny"
+	ScannerOptionUserCooldown      = "odstep-uzytkownika"
+	ScannerOptionBotMessageGap     = "odstep-od-bota"
+	ScannerOptionChannel           = "kanal"
+	ScannerOptionChannelEnabled    = "kanal-wlaczony"
+	ScannerOptionChannelDailyLimit = "kanal-limit-dzienny"
+)
+
 func NewDJCommand(interactions *player.Interactions) discord.Command {
 	return discord.Command{
 		Name:        "dj",
@@ -75,3 +92,244 @@ func NewDJCommand(interactions *player.Interactions) discord.Command {
 		},
 	}
 }
+
+const (
+	MemoryOptionPage    = "strona"
+	MemoryOptionQuery   = "zapytanie"
+	MemoryOptionID      = "id"
+	MemoryOptionContent = "tresc"
+	MemoryOptionSubject = "osoba"
+)
+
+func NewMemoryCommand(interactions *memory.Interactions) discord.Command {
+	return discord.Command{
+		Name:        "pamiec",
+		Description: "Zarządzaj tym, co pamięta Wojciech",
+		SubCommands: []discord.SubCommand{
+			{
+				Name:        "lista",
+				Description: "Pokaż zapamiętane rzeczy, od najnowszych",
+				Options: []discord.CommandOption{
+					{
+						Name:        MemoryOptionPage,
+						Description: "Numer strony",
+						Type:        discordgo.ApplicationCommandOptionInteger,
+					},
+					{
+						Name:        MemoryOptionSubject,
+						Description: "Pokaż tylko rzeczy o tej osobie",
+						Type:        discordgo.ApplicationCommandOptionUser,
+					},
+				},
+				Handler: func(ctx context.Context, options discord.CommandInteractionOptions, interaction *discordgo.InteractionCreate) error {
+					return interactions.List(ctx, interaction.Interaction, options.Option(MemoryOptionSubject).String(), options.Option(MemoryOptionPage).Int())
+				},
+			},
+			{
+				Name:        "szukaj",
+				Description: "Znajdź zapamiętane rzeczy podobne do zapytania",
+				Options: []discord.CommandOption{
+					{
+						Name:        MemoryOptionQuery,
+						Description: "Czego szukasz, np. co lubi Artur",
+						Type:        discordgo.ApplicationCommandOptionString,
+						Required:    true,
+					},
+					{
+						Name:        MemoryOptionSubject,
+						Description: "Szukaj tylko rzeczy o tej osobie",
+						Type:        discordgo.ApplicationCommandOptionUser,
+					},
+				},
+				Handler: func(ctx context.Context, options discord.CommandInteractionOptions, interaction *discordgo.InteractionCreate) error {
+					return interactions.Search(ctx, interaction.Interaction, options.Option(MemoryOptionQuery).String(), options.Option(MemoryOptionSubject).String())
+				},
+			},
+			{
+				Name:        "o-mnie",
+				Description: "Pokaż, co Wojciech pamięta o tobie",
+				Options: []discord.CommandOption{
+					{
+						Name:        MemoryOptionPage,
+						Description: "Numer strony",
+						Type:        discordgo.ApplicationCommandOptionInteger,
+					},
+				},
+				Handler: func(ctx context.Context, options discord.CommandInteractionOptions, interaction *discordgo.InteractionCreate) error {
+					return interactions.ListMine(ctx, interaction.Interaction, options.Option(MemoryOptionPage).Int())
+				},
+			},
+			{
+				Name:        "zapomnij-mnie",
+				Description: "Zapomnij wszystko, co Wojciech pamięta o tobie",
+				Handler: func(ctx context.Context, _ discord.CommandInteractionOptions, interaction *discordgo.InteractionCreate) error {
+					return interactions.ForgetMine(ctx, interaction.Interaction)
+				},
+			},
+			{
+				Name:        "edytuj",
+				Description: "Zastąp treść zapamiętanej rzeczy",
+				Options: []discord.CommandOption{
+					{
+						Name:        MemoryOptionID,
+						Description: "ID z listy lub wyszukiwania",
+						Type:        discordgo.ApplicationCommandOptionString,
+						Required:    true,
+					},
+					{
+						Name:        MemoryOptionContent,
+						Description: "Nowa treść",
+						Type:        discordgo.ApplicationCommandOptionString,
+						Required:    true,
+					},
+				},
+				Handler: func(ctx context.Context, options discord.CommandInteractionOptions, interaction *discordgo.InteractionCreate) error {
+					return interactions.Edit(ctx, interaction.Interaction, options.Option(MemoryOptionID).String(), options.Option(MemoryOptionContent).String())
+				},
+			},
+			{
+				Name:        "zapomnij",
+				Description: "Zapomnij zapamiętaną rzecz",
+				Options: []discord.CommandOption{
+					{
+						Name:        MemoryOptionID,
+						Description: "ID z listy lub wyszukiwania",
+						Type:        discordgo.ApplicationCommandOptionString,
+						Required:    true,
+					},
+				},
+				Handler: func(ctx context.Context, options discord.CommandInteractionOptions, interaction *discordgo.InteractionCreate) error {
+					return interactions.Forget(ctx, interaction.Interaction, options.Option(MemoryOptionID).String())
+				},
+			},
+			{
+				Name:        "eksport",
+				Description: "Wyślij kopię wszystkich wspomnień, tylko dla adminów",
+				Handler: func(ctx context.Context, _ discord.CommandInteractionOptions, interaction *discordgo.InteractionCreate) error {
+					return interactions.Export(ctx, interaction.Interaction)
+				},
+			},
+		},
+	}
+}
+
+func NewWojciechCommand(usageInteractions *usage.Interactions, scannerInteractions *chat.ScannerInteractions) discord.Command {
+	return discord.Command{
+		Name:        "wojciech",
+		Description: "Zarządzaj Wojciechem",
+		SubCommands: []discord.SubCommand{
+			{
+				Name:        "zuzycie",
+				Description: "Pokaż zużycie tokenów i koszty LLM z dziś i tego miesiąca",
+				Handler: func(ctx context.Context, options discord.CommandInteractionOptions, interaction *discordgo.InteractionCreate) error {
+					return usageInteractions.Report(ctx, interaction.Interaction)
+				},
+			},
+			{
+				Name:        "skaner",
+				Description: "Pokaż lub zmień, gdzie i kiedy Wojciech sam odpowiada na wiadomości",
+				Options: []discord.CommandOption{
+					{
+						Name:        ScannerOptionOptIn,
+						Description: "Skanuj tylko kanały włączone ręcznie",
+						Type:        discordgo.ApplicationCommandOptionBoolean,
+					},
+					{
+						Name:        ScannerOptionTimezone,
+						Description: "Strefa czasowa ciszy nocnej i limitów, np. Europe/Warsaw",
+						Type:        discordgo.ApplicationCommandOptionString,
+					},
+					{
+						Name:        ScannerOptionQuietHoursStart,
+						Description: "Godzina początku ciszy nocnej (0-23)",
+						Type:        discordgo.ApplicationCommandOptionInteger,
+					},
+					{
+						Name:        ScannerOptionQuietHoursEnd,
+						Description: "Godzina końca ciszy nocnej (0-23), równa początkowi wyłącza ciszę",
+						Type:        discordgo.ApplicationCommandOptionInteger,
+					},
+					{
+						Name:        ScannerOptionDailyLimit,
+						Description: "Maksymalna liczba odpowiedzi dziennie na serwerze, 0 to brak limitu",
+						Type:        discordgo.ApplicationCommandOptionInteger,
+					},
+					{
+						Name:        ScannerOptionUserCooldown,
+						Description: "Minuty między odpowiedziami do tego samego użytkownika",
+						Type:        discordgo.ApplicationCommandOptionInteger,
+					},
+					{
+						Name:        ScannerOptionBotMessageGap,
+						Description: "Minuty od ostatniej wiadomości Wojciecha na kanale",
+						Type:        discordgo.ApplicationCommandOptionInteger,
+					},
+					{
+						Name:        ScannerOptionChannel,
+						Description: "Kanał, którego dotyczą ustawienia kanału",
+						Type:        discordgo.ApplicationCommandOptionChannel,
+					},
+					{
+						Name:        ScannerOptionChannelEnabled,
+						Description: "Czy skanować kanał",
+						Type:        discordgo.ApplicationCommandOptionBoolean,
+					},
+					{
+						Name:        ScannerOptionChannelDailyLimit,
+						Description: "Maksymalna liczba odpowiedzi dziennie na kanale, 0 to brak limitu",
+						Type:        discordgo.ApplicationCommandOptionInteger,
+					},
+				},
+				Handler: func(ctx context.Context, options discord.CommandInteractionOptions, interaction *discordgo.InteractionCreate) error {
+					return scannerInteractions.Configure(ctx, interaction.Interaction, newScannerPolicyUpdate(options))
+				},
+			},
+		},
+	}
+}
+
+// newScannerPolicyUpdate reads the options of the scanner command, options that were not provided are not changed
+func newScannerPolicyUpdate(options discord.CommandInteractionOptions) chat.ScannerPolicyUpdate {
+	update := chat.ScannerPolicyUpdate{
+		ChannelID: options.Option(ScannerOptionChannel).String(),
+	}
+
+	if option := options.Option(ScannerOptionOptIn); option.IsSet() {
+		optIn := option.Bool()
+		update.OptIn = &optIn
+	}
+	if option := options.Option(ScannerOptionTimezone); option.IsSet() {
+		timezone := option.String()
+		update.Timezone = &timezone
+	}
+	if option := options.Option(ScannerOptionQuietHoursStart); option.IsSet() {
+		hour := option.Int()
+		update.QuietHoursStart = &hour
+	}
+	if option := options.Option(ScannerOptionQuietHoursEnd); option.IsSet() {
+		hour := option.Int()
+		update.QuietHoursEnd = &hour
+	}
+	if option := options.Option(ScannerOptionDailyLimit); option.IsSet() {
+		limit := option.Int()
+		update.DailyLimit = &limit
+	}
+	if option := options.Option(ScannerOptionUserCooldown); option.IsSet() {
+		cooldown := time.Duration(option.Int()) * time.Minute
+		update.UserCooldown = &cooldown
+	}
+	if option := options.Option(ScannerOptionBotMessageGap); option.IsSet() {
+		gap := time.Duration(option.Int()) * time.Minute
+		update.BotMessageGap = &gap
+	}
+	if option := options.Option(ScannerOptionChannelEnabled); option.IsSet() {
+		enabled := option.Bool()
+		update.ChannelEnabled = &enabled
+	}
+	if option := options.Option(ScannerOptionChannelDailyLimit); option.IsSet() {
+		limit := option.Int()
+		update.ChannelDailyLimit = &limit
+	}
+
+	return update
+}
@@ -3,6 +3,7 @@ package env
 import (
 	"github.com/caarlos0/env/v11"
 	"strconv"
+	"time"
 )
 
 type appEnv struct {
@@ -23,6 +24,32 @@ type appEnv struct {
 	OpenAIAssistantID            string `env:"OPENAI_ASSISTANT_ID"`
 	OpenAIAssistantVectorStoreID string `env:"OPENAI_ASSISTANT_VECTOR_STORE_ID"`
 	AllMessagesReplyWorthy       string `env:"ALL_MESSAGES_REPLY_WORTHY"`
+	DatabasePath                 string `env:"DATABASE_PATH" envDefault:"data/wojciech.db"`
+	PromptLocale                 string `env:"PROMPT_LOCALE" envDefault:"en"`
+
+	// OpenAICompatibleBaseURL replaces Ollama in the free tier with an OpenAI-compatible server, e.g. llama.cpp or vLLM
+	OpenAICompatibleBaseURL       string            `env:"OPENAI_COMPATIBLE_BASE_URL"`
+	OpenAICompatibleModel         string            `env:"OPENAI_COMPATIBLE_MODEL"`
+	OpenAICompatibleAPIKey        string            `env:"OPENAI_COMPATIBLE_API_KEY"`
+	OpenAICompatibleHeaders       map[string]string `env:"OPENAI_COMPATIBLE_HEADERS"`
+	OpenAICompatibleContextWindow int32             `env:"OPENAI_COMPATIBLE_CONTEXT_WINDOW"`
+
+	// MemoryStore is either "openai" for the OpenAI vector store, or "local" for the store in the database with Ollama embeddings
+	MemoryStore          string `env:"MEMORY_STORE" envDefault:"openai"`
+	OllamaEmbeddingModel string `env:"OLLAMA_EMBEDDING_MODEL" envDefault:"nomic-embed-text"`
+
+	// MemoryReviewChannelID is a moderation channel, where memories extracted from chats wait for approval.
+	// Memories are not extracted from chats, if it is not set.
+	MemoryReviewChannelID string `env:"MEMORY_REVIEW_CHANNEL_ID"`
+	// MemoryTTL is how long memories are kept before the consolidation forgets them, e.g. "8760h", 0 keeps them forever
+	MemoryTTL time.Duration `env:"MEMORY_TTL"`
+
+	// LLMConfigPath is a JSON file that routes LLM tasks to adapters and models, see llm.json for the default one
+	LLMConfigPath string `env:"LLM_CONFIG_PATH"`
+}
+
+func (e *appEnv) IsLocalMemoryStore() bool {
+	return e.MemoryStore == "local"
 }
 
 func (e *appEnv) AreAllMessagesReplyWorthy() bool {
@@ -0,0 +1,18 @@
+package env
+
+import (
+	_ "embed"
+	libllm "lib/llm"
+)
+
+//go:embed llm.json
+var defaultLLMConfig []byte
+
+// LLMConfig returns routing of LLM tasks from the file set in LLM_CONFIG_PATH, or the default one
+func LLMConfig() (libllm.RouterConfig, error) {
+	if Env.LLMConfigPath == "" {
+		return libllm.ParseRouterConfig(defaultLLMConfig)
+	}
+
+	return libllm.LoadRouterConfig(Env.LLMConfigPath)
+}
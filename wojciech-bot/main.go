@@ -16,15 +16,18 @@ import (
 	"lib/logging"
 	"lib/metadata"
 	"lib/server"
+	"lib/storage"
 	"net/http"
 	"net/url"
 	"time"
 	"wojciech-bot/chat"
 	"wojciech-bot/env"
+	"wojciech-bot/memory"
 	"wojciech-bot/messages"
 	openaidomain "wojciech-bot/openai"
 	"wojciech-bot/player"
 	"wojciech-bot/scheduler"
+	"wojciech-bot/usage"
 )
 
 var log = logging.Get().Named("wojciech-bot")
@@ -71,31 +74,201 @@ func main() {
 		ollamaAdapter.WithVision(env.Env.OllamaVisionModel)
 	}
 
-	ollamaApi := libllm.NewAPI(ollamaAdapter, "ollama")
+	libllm.Templates.SetDefaultLocale(libllm.Locale(env.Env.PromptLocale))
+
+	db, err := storage.Open(env.Env.DatabasePath)
+	if err != nil {
+		log.Fatal("failed to open database", zap.Error(err))
+	}
+	defer db.Close()
+
+	// Free tier runs on Ollama, unless another local inference server is configured
+	var freeAdapter libllm.Adapter = ollamaAdapter
+	freeBackend, freeModel := "ollama", env.Env.OllamaModel
+	if env.Env.OpenAICompatibleBaseURL != "" {
+		freeAdapter = libllm.NewOpenAICompatibleAdapter(libllm.OpenAICompatibleDefinition{
+			BaseURL:       env.Env.OpenAICompatibleBaseURL,
+			APIKey:        env.Env.OpenAICompatibleAPIKey,
+			Headers:       env.Env.OpenAICompatibleHeaders,
+			Model:         env.Env.OpenAICompatibleModel,
+			ContextWindow: env.Env.OpenAICompatibleContextWindow,
+		}, httpClient)
+		freeBackend, freeModel = "openai-compatible", env.Env.OpenAICompatibleModel
+	}
+
+	// Free tier is used mostly for classification prompts, that are often repeated with the same input
+	cachedFreeAdapter, err := libllm.NewCachingAdapter(freeAdapter, db, libllm.CacheOptions{
+		Model:      freeModel,
+		TTL:        7 * 24 * time.Hour,
+		MaxEntries: 10_000,
+	})
+	if err != nil {
+		log.Fatal("failed to create llm cache", zap.Error(err))
+	}
+
+	// Models of the adapters, and which of them handle which tasks, are configured in a file
+	llmConfig, err := env.LLMConfig()
+	if err != nil {
+		log.Fatal("failed to load llm config", zap.Error(err))
+	}
+	assistantConfig, err := llmConfig.Adapter("assistant")
+	if err != nil {
+		log.Fatal("invalid llm config", zap.Error(err))
+	}
+	openAIConfig, err := llmConfig.Adapter("openai")
+	if err != nil || openAIConfig.Model == "" {
+		log.Fatal("invalid llm config, openai adapter needs a model and context window", zap.Error(err))
+	}
+
+	freeApi := libllm.NewAPI(cachedFreeAdapter, freeBackend)
 	openAIClient := openai.NewClient(option.WithAPIKey(env.Env.OpenAIApiKey))
 	openAIAssistantDefinition := libllm.OpenAIAssistantDefinition{
 		ID:            env.Env.OpenAIAssistantID,
 		Encoding:      tiktoken.MODEL_O200K_BASE,
-		ContextWindow: 128_000,
+		ContextWindow: assistantConfig.ContextWindow,
+	}
+	// File search is used only when memories are kept in the vector store, the local store injects them into the chat instead
+	vectorStoreID := env.Env.OpenAIAssistantVectorStoreID
+	if env.Env.IsLocalMemoryStore() {
+		vectorStoreID = ""
 	}
-	openAIAssistantAdapter := libllm.NewOpenAIAssistantAdapter(&openAIClient, openAIAssistantDefinition, env.Env.OpenAIAssistantVectorStoreID)
-	assistantApi := libllm.NewAPI(openAIAssistantAdapter, "openai")
+	openAIAssistantAdapter := libllm.NewOpenAIAssistantAdapter(&openAIClient, openAIAssistantDefinition, vectorStoreID)
 
 	openAIAdapter := libllm.NewOpenAIAdapter(&openAIClient, libllm.OpenAIModelDefinition{
-		Model:         "gpt-4.1-mini",
-		ContextWindow: 128_000,
-		Encoding:      tiktoken.MODEL_O200K_BASE,
-	}, env.Env.OpenAIAssistantVectorStoreID)
+		Model:          openAIConfig.Model,
+		ContextWindow:  openAIConfig.ContextWindow,
+		Encoding:       tiktoken.MODEL_O200K_BASE,
+		SupportsImages: true,
+	}, vectorStoreID)
 	openAIApi := libllm.NewAPI(openAIAdapter, "openai")
 
-	llmContainer := &libllm.Container{
-		AssistantAPI: assistantApi,
-		FreeAPI:      ollamaApi,
-		ExpensiveAPI: openAIApi,
+	// Background scanning may use only part of the slots, so that replies in threads are never stuck behind it
+	freeScheduler := libllm.NewScheduler(freeBackend, libllm.SchedulerOptions{
+		MaxInFlight:           4,
+		MaxBackgroundInFlight: 2,
+		QueueTimeout:          2 * time.Minute,
+	})
+	openAIScheduler := libllm.NewScheduler("openai", libllm.SchedulerOptions{
+		MaxInFlight:           8,
+		MaxBackgroundInFlight: 4,
+		QueueTimeout:          time.Minute,
+	})
+	schedulers := []*libllm.Scheduler{freeScheduler, openAIScheduler}
+	// Ollama serves the assistant failover too, so it shares the scheduler with the free tier, if it runs on Ollama
+	ollamaScheduler := freeScheduler
+	if freeBackend != "ollama" {
+		ollamaScheduler = libllm.NewScheduler("ollama", libllm.SchedulerOptions{
+			MaxInFlight:  2,
+			QueueTimeout: time.Minute,
+		})
+		schedulers = append(schedulers, ollamaScheduler)
+	}
+
+	// Assistant falls back to the plain OpenAI model, and then to Ollama if OpenAI is unavailable
+	assistantAdapter := libllm.NewFailoverAdapter(
+		libllm.FailoverBackend{
+			Name:             "openai-assistant",
+			Adapter:          openAIAssistantAdapter,
+			Timeout:          2 * time.Minute,
+			HasReplyMetadata: true,
+		},
+		libllm.FailoverBackend{
+			Name:    "openai",
+			Adapter: openAIAdapter,
+			Timeout: time.Minute,
+		},
+		libllm.FailoverBackend{
+			Name:      "ollama",
+			Adapter:   ollamaAdapter,
+			Timeout:   2 * time.Minute,
+			Scheduler: ollamaScheduler,
+		},
+	)
+	assistantApi := libllm.NewAPI(assistantAdapter, "assistant")
+
+	// Prices in USD per million tokens, Ollama is self-hosted and free
+	usageTracker, err := libllm.NewUsageTracker(db, map[string]libllm.ModelPricing{
+		"openai":           {InputPerMillion: 0.4, OutputPerMillion: 1.6},
+		"openai-assistant": {InputPerMillion: 0.4, OutputPerMillion: 1.6},
+	})
+	if err != nil {
+		log.Fatal("failed to create llm usage tracker", zap.Error(err))
+	}
+	freeApi.WithUsageTracker(usageTracker)
+	openAIApi.WithUsageTracker(usageTracker)
+	assistantApi.WithUsageTracker(usageTracker)
+	assistantAdapter.WithUsageTracker(usageTracker)
+
+	freeApi.WithScheduler(freeScheduler)
+	openAIApi.WithScheduler(openAIScheduler)
+	assistantApi.WithScheduler(openAIScheduler)
+
+	// Personal data and secrets never leave the bot, and replies can't ping the whole server
+	for _, api := range []*libllm.API{freeApi, openAIApi, assistantApi} {
+		api.WithRequestFilters(libllm.NewSecretFilter(), libllm.NewPIIFilter(), libllm.NewSurnameFilter(discord.Friends))
+		api.WithReplyFilters(libllm.NewMentionFilter(), libllm.NewInviteLinkFilter())
+	}
+
+	// Long discussions are kept going, by summarizing their oldest messages with the plain OpenAI model
+	assistantApi.WithCompaction(libllm.NewCompactor(openAIApi, libllm.CompactionOptions{
+		TokenBudget:        96_000,
+		KeepRecentMessages: 20,
+		Encoding:           tiktoken.MODEL_O200K_BASE,
+	}))
+
+	// Local memory store makes memory possible without the OpenAI vector store
+	var memoryStore *libllm.MemoryStore
+	if env.Env.IsLocalMemoryStore() {
+		memoryStore, err = memory.NewOllamaMemoryStore(db, ollamaAdapter, env.Env.OllamaEmbeddingModel)
+		if err != nil {
+			log.Fatal("failed to create memory store", zap.Error(err))
+		}
+		assistantApi.WithMemory(memoryStore)
 	}
 
-	chatManager := chat.NewManager(bot, llmContainer)
-	chatScanner := chat.NewDiscordChannelScanner(bot, llmContainer.FreeAPI, func(message *discordgo.Message) {
+	llmRouter, err := libllm.NewRouter(llmConfig, map[string]*libllm.API{
+		"free":      freeApi,
+		"openai":    openAIApi,
+		"assistant": assistantApi,
+	})
+	if err != nil {
+		log.Fatal("failed to create llm router", zap.Error(err))
+	}
+
+	var memories memory.Store
+	var minClusterScore float64
+	if memoryStore != nil {
+		localStore, err := memory.NewLocalStore(db, memoryStore)
+		if err != nil {
+			log.Fatal("failed to create local memory store", zap.Error(err))
+		}
+		memory.Init(localStore)
+		memories = localStore
+		minClusterScore = memory.LocalMinClusterScore
+	} else {
+		openaidomain.Init(&openAIClient, env.Env.OpenAIAssistantVectorStoreID)
+		memories = memory.NewOpenAIStore(&openAIClient, env.Env.OpenAIAssistantVectorStoreID)
+		minClusterScore = memory.OpenAIMinClusterScore
+	}
+
+	// Threads are archived long before, so older chats are not worth restoring
+	chatStore, err := chat.NewBoltChatStore(db, 30*24*time.Hour)
+	if err != nil {
+		log.Fatal("failed to create chat store", zap.Error(err))
+	}
+
+	chatManager := chat.NewManager(bot, llmRouter, chat.NewTools(bot, channelPlayerManager), chatStore, memories)
+	scannerPolicies, err := chat.NewScannerPolicyStore(db)
+	if err != nil {
+		log.Fatal("failed to create scanner policy store", zap.Error(err))
+	}
+
+	scannerEvaluations, err := chat.NewScannerEvaluationStore(db)
+	if err != nil {
+		log.Fatal("failed to create scanner evaluation store", zap.Error(err))
+	}
+
+	chatScanner := chat.NewDiscordChannelScanner(bot, llmRouter.API(libllm.TaskWorthiness), scannerPolicies, scannerEvaluations, func(message *discordgo.Message) {
 		err := bot.MessageReactionAdd(message.ChannelID, message.ID, discord.ReactionSeen)
 		if err != nil {
 			log.Error("failed to add seen reaction", zap.Error(err))
@@ -116,16 +289,35 @@ func main() {
 		return nil
 	})
 
-	openaidomain.Init(&openAIClient, env.Env.OpenAIAssistantVectorStoreID)
+	// Memories extracted from chats are remembered only after a moderator approves them
+	var modalSubmitHandlers []discord.ComponentInteractionHandler
+	var memoryReviewHandlers []discord.ComponentInteractionHandler
+	if env.Env.MemoryReviewChannelID != "" {
+		memoryReviewQueue, err := chat.NewMemoryReviewQueue(bot, db, env.Env.MemoryReviewChannelID)
+		if err != nil {
+			log.Fatal("failed to create memory review queue", zap.Error(err))
+		}
+
+		events.Handle(memoryReviewQueue.HandleMemoryDetailsExtracted)
+		memoryReviewHandlers = append(memoryReviewHandlers, chat.NewMemoryReviewComponentHandler(memoryReviewQueue))
+		modalSubmitHandlers = append(modalSubmitHandlers, chat.NewMemoryReviewModalHandler(memoryReviewQueue))
+	}
+
+	usageInteractions := usage.NewInteractions(bot, usageTracker, schedulers...)
+	usageInteractions.WithCache(freeBackend, cachedFreeAdapter)
 
 	commands := []discord.Command{
 		NewDJCommand(playerDomain),
+		NewWojciechCommand(usageInteractions, chat.NewScannerInteractions(bot, scannerPolicies)),
+		NewMemoryCommand(memory.NewInteractions(bot, memories)),
 	}
 	discord.RegisterCommands(bot, env.Env.GuildId, commands...)
 	componentInteractionHandlers := []discord.ComponentInteractionHandler{
-		chat.NewForgetComponentHandler(&openAIClient),
+		chat.NewForgetComponentHandler(&openAIClient, memoryStore),
+		chat.NewReasoningComponentHandler(chatManager),
 		player.NewComponentHandler(channelPlayerManager),
 	}
+	componentInteractionHandlers = append(componentInteractionHandlers, memoryReviewHandlers...)
 	bot.AddHandler(func(s *discordgo.Session, i *discordgo.InteractionCreate) {
 		if i.Type == discordgo.InteractionMessageComponent {
 			discord.HandleComponentInteraction(componentInteractionHandlers, bot, i)
@@ -133,6 +325,12 @@ func main() {
 			return
 		}
 
+		if i.Type == discordgo.InteractionModalSubmit {
+			discord.HandleModalSubmitInteraction(modalSubmitHandlers, bot, i)
+
+			return
+		}
+
 		discord.HandleInteraction(bot, commands, i)
 	})
 	bot.AddHandler(func(s *discordgo.Session, r *discordgo.Ready) {
@@ -141,8 +339,22 @@ func main() {
 	bot.AddHandler(func(s *discordgo.Session, m *discordgo.MessageCreate) {
 		chat.HandleMessageCreate(bot, chatManager, m)
 	})
+	bot.AddHandler(func(s *discordgo.Session, m *discordgo.MessageUpdate) {
+		chat.HandleMessageUpdate(bot, chatManager, m)
+	})
+	bot.AddHandler(func(s *discordgo.Session, m *discordgo.MessageDelete) {
+		chat.HandleMessageDelete(chatManager, m)
+	})
+
+	consolidator, err := memory.NewConsolidator(memories, llmRouter.API(libllm.TaskMemoryConsolidate), db, memory.ConsolidatorOptions{
+		TTL:             env.Env.MemoryTTL,
+		MinClusterScore: minClusterScore,
+	})
+	if err != nil {
+		log.Fatal("failed to create memory consolidator", zap.Error(err))
+	}
 
-	err = scheduler.Init(bot)
+	err = scheduler.Init(bot, consolidator)
 	if err != nil {
 		log.Fatal("failed to init scheduler", zap.Error(err))
 	}
@@ -0,0 +1,146 @@
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"lib/errors"
+	"strings"
+	"time"
+)
+
+// ArchiveVersion is increased, whenever the archive format changes in a way, that older imports can't read
+const ArchiveVersion = 1
+
+// Archive is a backup of all memories, that can be imported into any Store
+type Archive struct {
+	Version    int              `json:"version"`
+	ExportedAt time.Time        `json:"exported_at"`
+	Memories   []ArchivedMemory `json:"memories"`
+}
+
+// ArchivedMemory keeps the content and provenance of the memory. ID is informational, stores assign new IDs on import.
+type ArchivedMemory struct {
+	ID         string    `json:"id"`
+	Content    string    `json:"content"`
+	Source     string    `json:"source,omitempty"`
+	AuthorID   string    `json:"author_id,omitempty"`
+	SubjectIDs []string  `json:"subject_ids,omitempty"`
+	Confidence float64   `json:"confidence"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// ImportResult counts memories, that were imported, and these skipped as duplicates
+type ImportResult struct {
+	Imported int
+	Skipped  int
+}
+
+// Export reads all memories of the store, from the oldest
+func Export(ctx context.Context, store Store) (*Archive, error) {
+	entries, err := listAll(ctx, store)
+	if err != nil {
+		return nil, err
+	}
+
+	archive := &Archive{
+		Version:    ArchiveVersion,
+		ExportedAt: time.Now(),
+		Memories:   make([]ArchivedMemory, 0, len(entries)),
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		archive.Memories = append(archive.Memories, ArchivedMemory{
+			ID:         entry.ID,
+			Content:    entry.Content,
+			Source:     entry.Source,
+			AuthorID:   entry.AuthorID,
+			SubjectIDs: entry.SubjectIDs,
+			Confidence: entry.Confidence,
+			CreatedAt:  entry.CreatedAt,
+		})
+	}
+
+	return archive, nil
+}
+
+// Import remembers memories of the archive in the store. Memories, that the store already has, are skipped,
+// so that the same archive can be imported again after a failure.
+func Import(ctx context.Context, store Store, archive *Archive) (ImportResult, error) {
+	var result ImportResult
+	entries, err := listAll(ctx, store)
+	if err != nil {
+		return result, err
+	}
+
+	known := make(map[string]bool, len(entries)+len(archive.Memories))
+	for _, entry := range entries {
+		known[normalizeContent(entry.Content)] = true
+	}
+
+	for _, memory := range archive.Memories {
+		key := normalizeContent(memory.Content)
+		if key == "" || known[key] {
+			result.Skipped++
+			continue
+		}
+
+		_, err = store.Remember(ctx, Entry{
+			Content:    memory.Content,
+			Source:     memory.Source,
+			AuthorID:   memory.AuthorID,
+			SubjectIDs: memory.SubjectIDs,
+			Confidence: memory.Confidence,
+			CreatedAt:  memory.CreatedAt,
+		})
+		if err != nil {
+			return result, errors.Wrap(err, fmt.Sprintf("failed to import memory %s", memory.ID))
+		}
+
+		known[key] = true
+		result.Imported++
+	}
+
+	return result, nil
+}
+
+// ReadArchive decodes the archive, and rejects versions it doesn't know
+func ReadArchive(reader io.Reader) (*Archive, error) {
+	var archive Archive
+	err := json.NewDecoder(reader).Decode(&archive)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode memory archive")
+	}
+
+	if archive.Version < 1 || archive.Version > ArchiveVersion {
+		return nil, fmt.Errorf("unsupported memory archive version %d, expected at most %d", archive.Version, ArchiveVersion)
+	}
+
+	return &archive, nil
+}
+
+// Write encodes the archive as indented JSON, so that it can be reviewed before the import
+func (a *Archive) Write(writer io.Writer) error {
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+
+	err := encoder.Encode(a)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode memory archive")
+	}
+
+	return nil
+}
+
+// listAll reads all memories in a single pass, from the newest
+func listAll(ctx context.Context, store Store) ([]Entry, error) {
+	entries, _, err := store.List(ctx, "", 0, NoLimit)
+
+	return entries, err
+}
+
+// normalizeContent ignores case and whitespace, so that memories differing only in them are duplicates
+func normalizeContent(content string) string {
+	return strings.Join(strings.Fields(strings.ToLower(content)), " ")
+}
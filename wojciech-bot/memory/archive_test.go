@@ -0,0 +1,74 @@
+package memory_test
+
+import (
+	"bytes"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"wojciech-bot/memory"
+)
+
+func TestArchive(t *testing.T) {
+	ctx := context.Background()
+	source := newLocalStore(t)
+	_, err := source.Remember(ctx, memory.Entry{Content: "Artur plays the guitar", Source: "thread", SubjectIDs: []string{"artur"}, Confidence: 0.9})
+	assert.NoError(t, err)
+	_, err = source.Remember(ctx, memory.Entry{Content: "Kasia lives in Kraków"})
+	assert.NoError(t, err)
+
+	archive, err := memory.Export(ctx, source)
+	assert.NoError(t, err)
+
+	var buffer bytes.Buffer
+	assert.NoError(t, archive.Write(&buffer))
+
+	t.Run("imports into another store, skipping duplicates", func(t *testing.T) {
+		target := newLocalStore(t)
+		_, err := target.Remember(ctx, memory.Entry{Content: "kasia  lives in KRAKÓW"})
+		assert.NoError(t, err)
+
+		read, err := memory.ReadArchive(bytes.NewReader(buffer.Bytes()))
+		assert.NoError(t, err)
+
+		result, err := memory.Import(ctx, target, read)
+		assert.NoError(t, err)
+		assert.Equal(t, memory.ImportResult{Imported: 1, Skipped: 1}, result)
+
+		entries, _, err := target.List(ctx, "artur", 0, 10)
+		assert.NoError(t, err)
+		assert.Len(t, entries, 1)
+		assert.Equal(t, "thread", entries[0].Source)
+		assert.Equal(t, 0.9, entries[0].Confidence)
+	})
+
+	t.Run("skips duplicates differing in case and whitespace, also within the archive", func(t *testing.T) {
+		target := newLocalStore(t)
+		_, err := target.Remember(ctx, memory.Entry{Content: "  ARTUR plays\tthe  guitar\n"})
+		assert.NoError(t, err)
+		_, err = target.Remember(ctx, memory.Entry{Content: "Wojtek likes pizza"})
+		assert.NoError(t, err)
+
+		result, err := memory.Import(ctx, target, &memory.Archive{
+			Version: memory.ArchiveVersion,
+			Memories: []memory.ArchivedMemory{
+				{ID: "1", Content: "Artur plays the guitar"},
+				{ID: "2", Content: "wojtek LIKES pizza "},
+				{ID: "3", Content: "Kasia lives in Kraków"},
+				{ID: "4", Content: "kasia lives\nin kraków"},
+				{ID: "5", Content: "   "},
+			},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, memory.ImportResult{Imported: 1, Skipped: 4}, result)
+
+		_, total, err := target.List(ctx, "", 0, memory.NoLimit)
+		assert.NoError(t, err)
+		assert.Equal(t, 3, total)
+	})
+
+	t.Run("rejects unknown versions", func(t *testing.T) {
+		_, err := memory.ReadArchive(strings.NewReader(`{"version": 99, "memories": []}`))
+		assert.Error(t, err)
+	})
+}
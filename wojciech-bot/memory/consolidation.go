@@ -0,0 +1,327 @@
+package memory
+
+import (
+	"context"
+	"go.uber.org/zap"
+	"lib/llm"
+	"lib/logging"
+	"lib/storage"
+	"slices"
+	"strings"
+	"time"
+)
+
+const contradictionBucketName = "memory_contradictions"
+
+// maxClusterSize limits how many memories are merged in a single prompt
+const maxClusterSize = 8
+
+var consolidationLog = logging.Get().Named("memory").Named("consolidation")
+
+// Merge replaces similar memories with consolidated ones
+type Merge struct {
+	From []Entry
+	Into []Entry
+}
+
+// Contradiction is a cluster of memories, that contradict each other, and need a review of a moderator
+type Contradiction struct {
+	Entries []Entry
+	Reason  string
+}
+
+// Changelog describes what Consolidate changed
+type Changelog struct {
+	Expired        []Entry
+	Merged         []Merge
+	Contradictions []Contradiction
+}
+
+func (c Changelog) IsEmpty() bool {
+	return len(c.Expired) == 0 && len(c.Merged) == 0 && len(c.Contradictions) == 0
+}
+
+// ConsolidatorOptions configure what is consolidated
+type ConsolidatorOptions struct {
+	// TTL is how long memories are kept, 0 keeps them forever
+	TTL time.Duration
+	// MinClusterScore is a score of search results, above which memories are considered to be about the same thing.
+	// Stores score differently, see LocalMinClusterScore and OpenAIMinClusterScore.
+	MinClusterScore float64
+}
+
+// reportedContradiction is kept, so that the same contradiction is not reported every time
+type reportedContradiction struct {
+	Reason     string    `json:"reason"`
+	ReportedAt time.Time `json:"reported_at"`
+}
+
+// Consolidator keeps the store concise, by merging similar memories, and forgetting the old ones
+type Consolidator struct {
+	store   Store
+	api     *llm.API
+	options ConsolidatorOptions
+	// contradictions are keyed by IDs of memories in the cluster, a changed cluster is checked again
+	contradictions *storage.Bucket[reportedContradiction]
+}
+
+func NewConsolidator(store Store, api *llm.API, db *storage.DB, options ConsolidatorOptions) (*Consolidator, error) {
+	contradictions, err := storage.NewBucket[reportedContradiction](db, contradictionBucketName)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Consolidator{
+		store:          store,
+		api:            api,
+		options:        options,
+		contradictions: contradictions,
+	}, nil
+}
+
+// Consolidate forgets expired memories, and merges clusters of similar ones. Contradicting memories are left as they are,
+// and reported only once, until their cluster changes.
+// Failure of a single cluster doesn't stop the consolidation, so that the changelog lists everything that was changed.
+func (c *Consolidator) Consolidate(ctx context.Context, now time.Time) (Changelog, error) {
+	ctx = llm.WithUsageContext(ctx, llm.FeatureMemory, "")
+	ctx = llm.WithPriority(ctx, llm.PriorityBackground)
+
+	var changelog Changelog
+	entries, err := listAll(ctx, c.store)
+	if err != nil {
+		return changelog, err
+	}
+
+	var remaining []Entry
+	for _, entry := range entries {
+		if c.options.TTL == 0 || entry.CreatedAt.After(now.Add(-c.options.TTL)) {
+			remaining = append(remaining, entry)
+			continue
+		}
+
+		err = c.store.Forget(ctx, entry.ID)
+		if err != nil {
+			consolidationLog.Error("failed to forget expired memory", zap.String("memoryID", entry.ID), zap.Error(err))
+			continue
+		}
+
+		changelog.Expired = append(changelog.Expired, entry)
+	}
+
+	clusters, err := c.clusters(ctx, remaining)
+	if err != nil {
+		return changelog, err
+	}
+
+	var contradictionKeys []string
+	for _, cluster := range clusters {
+		key := clusterKey(cluster)
+		reported, err := c.contradictions.Get(key)
+		if err != nil {
+			return changelog, err
+		}
+		if reported != nil {
+			contradictionKeys = append(contradictionKeys, key)
+			continue
+		}
+
+		reply, err := c.consolidate(ctx, cluster)
+		if err != nil {
+			consolidationLog.Error("failed to consolidate memories", zap.Int("memories", len(cluster)), zap.Error(err))
+			continue
+		}
+
+		if reply.Contradiction {
+			err = c.contradictions.Put(key, reportedContradiction{Reason: reply.Reason, ReportedAt: now})
+			if err != nil {
+				return changelog, err
+			}
+
+			contradictionKeys = append(contradictionKeys, key)
+			changelog.Contradictions = append(changelog.Contradictions, Contradiction{Entries: cluster, Reason: reply.Reason})
+			continue
+		}
+
+		// Nothing was merged, if the model returned as many facts as it got
+		if len(reply.Facts) == 0 || len(reply.Facts) >= len(cluster) {
+			continue
+		}
+
+		merge, err := c.merge(ctx, cluster, reply.Facts)
+		if err != nil {
+			consolidationLog.Error("failed to merge memories", zap.Int("memories", len(cluster)), zap.Error(err))
+			continue
+		}
+
+		changelog.Merged = append(changelog.Merged, merge)
+	}
+
+	err = c.forgetContradictionsExcept(contradictionKeys)
+	if err != nil {
+		consolidationLog.Error("failed to forget resolved contradictions", zap.Error(err))
+	}
+
+	consolidationLog.Info("consolidated memories",
+		zap.Int("memories", len(entries)),
+		zap.Int("expired", len(changelog.Expired)),
+		zap.Int("merged", len(changelog.Merged)),
+		zap.Int("contradictions", len(changelog.Contradictions)),
+	)
+
+	return changelog, nil
+}
+
+// clusters groups memories with their most similar ones, every memory belongs to at most one cluster
+func (c *Consolidator) clusters(ctx context.Context, entries []Entry) ([][]Entry, error) {
+	known := make(map[string]Entry, len(entries))
+	for _, entry := range entries {
+		known[entry.ID] = entry
+	}
+
+	clustered := map[string]bool{}
+	var clusters [][]Entry
+	for _, entry := range entries {
+		if clustered[entry.ID] {
+			continue
+		}
+
+		similar, err := c.store.Search(ctx, entry.Content, "", maxClusterSize)
+		if err != nil {
+			return nil, err
+		}
+
+		cluster := []Entry{entry}
+		for _, candidate := range similar {
+			_, ok := known[candidate.ID]
+			if ok && candidate.ID != entry.ID && !clustered[candidate.ID] && candidate.Score >= c.options.MinClusterScore {
+				cluster = append(cluster, known[candidate.ID])
+			}
+		}
+
+		if len(cluster) < 2 {
+			continue
+		}
+
+		for _, member := range cluster {
+			clustered[member.ID] = true
+		}
+
+		slices.SortFunc(cluster, func(a, b Entry) int {
+			return a.CreatedAt.Compare(b.CreatedAt)
+		})
+		clusters = append(clusters, cluster)
+	}
+
+	return clusters, nil
+}
+
+// clusterKey identifies the cluster by IDs of its memories, regardless of their order
+func clusterKey(cluster []Entry) string {
+	ids := make([]string, 0, len(cluster))
+	for _, entry := range cluster {
+		ids = append(ids, entry.ID)
+	}
+	slices.Sort(ids)
+
+	return strings.Join(ids, ",")
+}
+
+// forgetContradictionsExcept removes contradictions, that were resolved by moderators, or whose clusters changed
+func (c *Consolidator) forgetContradictionsExcept(keys []string) error {
+	var resolved []string
+	err := c.contradictions.ForEach(func(key string, _ reportedContradiction) error {
+		if !slices.Contains(keys, key) {
+			resolved = append(resolved, key)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.contradictions.Delete(resolved...)
+}
+
+type consolidationVars struct {
+	Facts []string
+}
+
+type consolidationReply struct {
+	Contradiction bool     `json:"contradiction" description:"true if some of the facts contradict each other"`
+	Reason        string   `json:"reason" description:"short description of the contradiction, empty if there is none"`
+	Facts         []string `json:"facts" description:"merged facts, one fact per item, empty if there is a contradiction"`
+}
+
+func (c *Consolidator) consolidate(ctx context.Context, cluster []Entry) (*consolidationReply, error) {
+	vars := consolidationVars{}
+	for _, entry := range cluster {
+		vars.Facts = append(vars.Facts, entry.Content)
+	}
+
+	prompt, err := llm.RenderPrompt("memory-consolidate", vars)
+	if err != nil {
+		return nil, err
+	}
+
+	return llm.PromptJSON[consolidationReply](ctx, c.api, prompt)
+}
+
+// merge remembers the facts with provenance of the whole cluster, and forgets memories of the cluster.
+// Merged memories are as old as the newest memory of the cluster, so that repeated facts expire later.
+// If the merge fails before any memory of the cluster is forgotten, remembered facts are forgotten again, so that the cluster is left as it was.
+func (c *Consolidator) merge(ctx context.Context, cluster []Entry, facts []string) (Merge, error) {
+	newest := cluster[len(cluster)-1]
+	provenance := Entry{
+		Source:    newest.Source,
+		AuthorID:  newest.AuthorID,
+		CreatedAt: newest.CreatedAt,
+	}
+	for _, entry := range cluster {
+		provenance.Confidence = max(provenance.Confidence, entry.Confidence)
+		for _, id := range entry.SubjectIDs {
+			if !slices.Contains(provenance.SubjectIDs, id) {
+				provenance.SubjectIDs = append(provenance.SubjectIDs, id)
+			}
+		}
+	}
+	slices.Sort(provenance.SubjectIDs)
+
+	merge := Merge{From: cluster}
+	for _, fact := range facts {
+		entry := provenance
+		entry.Content = fact
+		remembered, err := c.store.Remember(ctx, entry)
+		if err != nil {
+			c.rollback(ctx, merge.Into)
+			return Merge{}, err
+		}
+
+		merge.Into = append(merge.Into, remembered)
+	}
+
+	// Memories of the cluster are forgotten only when all merged facts are remembered, so that nothing is lost
+	for i, entry := range cluster {
+		err := c.store.Forget(ctx, entry.ID)
+		if err != nil {
+			// Once some memories are forgotten, merged facts are the only copy of them, and must be kept
+			if i == 0 {
+				c.rollback(ctx, merge.Into)
+			}
+
+			return Merge{}, err
+		}
+	}
+
+	return merge, nil
+}
+
+// rollback forgets facts remembered by the failed merge. Facts that can't be forgotten are merged again in the next consolidation.
+func (c *Consolidator) rollback(ctx context.Context, remembered []Entry) {
+	for _, entry := range remembered {
+		err := c.store.Forget(ctx, entry.ID)
+		if err != nil {
+			consolidationLog.Error("failed to forget memory of the failed merge", zap.String("memoryID", entry.ID), zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package memory_test
+
+import (
+	"context"
+	goerrors "errors"
+	"github.com/stretchr/testify/assert"
+	"lib/llm"
+	"lib/storage"
+	"path/filepath"
+	"testing"
+	"time"
+	"wojciech-bot/memory"
+)
+
+// failingStore fails to forget the given memories
+type failingStore struct {
+	memory.Store
+	failing map[string]bool
+}
+
+func (s *failingStore) Forget(ctx context.Context, id string) error {
+	if s.failing[id] {
+		return goerrors.New("failed to forget")
+	}
+
+	return s.Store.Forget(ctx, id)
+}
+
+func newConsolidator(t *testing.T, store memory.Store, adapter llm.Adapter) *memory.Consolidator {
+	db, err := storage.Open(filepath.Join(t.TempDir(), "consolidation.db"))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	consolidator, err := memory.NewConsolidator(store, llm.NewAPI(adapter, "scripted"), db, memory.ConsolidatorOptions{
+		TTL:             365 * 24 * time.Hour,
+		MinClusterScore: memory.LocalMinClusterScore,
+	})
+	assert.NoError(t, err)
+
+	return consolidator
+}
+
+func rememberAll(t *testing.T, store memory.Store, entries ...memory.Entry) []memory.Entry {
+	var remembered []memory.Entry
+	for _, entry := range entries {
+		entry, err := store.Remember(context.Background(), entry)
+		assert.NoError(t, err)
+		remembered = append(remembered, entry)
+	}
+
+	return remembered
+}
+
+func TestConsolidator(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+
+	t.Run("forgets expired memories, and merges similar ones", func(t *testing.T) {
+		adapter := llm.NewScriptedAdapter(llm.ScriptedRule{
+			Contains: "guitar",
+			Reply:    `{"contradiction": false, "reason": "", "facts": ["Artur plays the guitar well"]}`,
+		})
+		store := newLocalStore(t)
+		rememberAll(t, store,
+			memory.Entry{Content: "Wojtek likes pizza", CreatedAt: now.AddDate(-2, 0, 0)},
+			memory.Entry{Content: "Artur plays the guitar", SubjectIDs: []string{"artur"}, Confidence: 0.6, CreatedAt: now.AddDate(0, -1, 0)},
+			memory.Entry{Content: "Kasia lives in Kraków", CreatedAt: now.AddDate(0, -1, 0)},
+			memory.Entry{Content: "Artur plays the guitar well", Source: "thread", Confidence: 0.8, CreatedAt: now},
+		)
+
+		changelog, err := newConsolidator(t, store, adapter).Consolidate(ctx, now)
+		assert.NoError(t, err)
+
+		assert.Len(t, changelog.Expired, 1)
+		assert.Equal(t, "Wojtek likes pizza", changelog.Expired[0].Content)
+		assert.Empty(t, changelog.Contradictions)
+		assert.Len(t, changelog.Merged, 1)
+		assert.Len(t, changelog.Merged[0].From, 2)
+
+		merged := changelog.Merged[0].Into[0]
+		assert.Equal(t, "Artur plays the guitar well", merged.Content)
+		assert.Equal(t, "thread", merged.Source)
+		assert.Equal(t, []string{"artur"}, merged.SubjectIDs)
+		assert.Equal(t, 0.8, merged.Confidence)
+
+		entries, total, err := store.List(ctx, "", 0, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, total)
+		assert.ElementsMatch(t, []string{"Artur plays the guitar well", "Kasia lives in Kraków"}, []string{entries[0].Content, entries[1].Content})
+	})
+
+	t.Run("reports contradiction only once, until its cluster changes", func(t *testing.T) {
+		adapter := llm.NewScriptedAdapter(llm.ScriptedRule{
+			Contains: "guitar",
+			Reply:    `{"contradiction": true, "reason": "does he play or not?", "facts": []}`,
+		})
+		store := newLocalStore(t)
+		rememberAll(t, store,
+			memory.Entry{Content: "Artur plays the guitar", CreatedAt: now},
+			memory.Entry{Content: "Artur never plays the guitar", CreatedAt: now},
+		)
+		consolidator := newConsolidator(t, store, adapter)
+
+		changelog, err := consolidator.Consolidate(ctx, now)
+		assert.NoError(t, err)
+		assert.Len(t, changelog.Contradictions, 1)
+		assert.Equal(t, "does he play or not?", changelog.Contradictions[0].Reason)
+
+		changelog, err = consolidator.Consolidate(ctx, now)
+		assert.NoError(t, err)
+		assert.True(t, changelog.IsEmpty())
+		assert.Len(t, adapter.Requests(), 1)
+
+		rememberAll(t, store, memory.Entry{Content: "Artur plays the guitar again", CreatedAt: now})
+
+		changelog, err = consolidator.Consolidate(ctx, now)
+		assert.NoError(t, err)
+		assert.Len(t, changelog.Contradictions, 1)
+		assert.Len(t, adapter.Requests(), 2)
+	})
+
+	t.Run("rolls back merged facts, when the cluster can't be forgotten", func(t *testing.T) {
+		adapter := llm.NewScriptedAdapter(llm.ScriptedRule{
+			Contains: "guitar",
+			Reply:    `{"contradiction": false, "reason": "", "facts": ["Artur plays the guitar well"]}`,
+		})
+		store := &failingStore{Store: newLocalStore(t), failing: map[string]bool{}}
+		for _, entry := range rememberAll(t, store,
+			memory.Entry{Content: "Artur plays the guitar", CreatedAt: now.AddDate(0, -1, 0)},
+			memory.Entry{Content: "Artur plays the guitar well", CreatedAt: now},
+		) {
+			store.failing[entry.ID] = true
+		}
+
+		changelog, err := newConsolidator(t, store, adapter).Consolidate(ctx, now)
+		assert.NoError(t, err)
+		assert.Empty(t, changelog.Merged)
+
+		entries, total, err := store.List(ctx, "", 0, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, total)
+		for _, entry := range entries {
+			assert.True(t, store.failing[entry.ID])
+		}
+	})
+}
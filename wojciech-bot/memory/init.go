@@ -0,0 +1,53 @@
+package memory
+
+import (
+	"context"
+	goerrors "errors"
+	"go.uber.org/zap"
+	"lib/errors"
+	"lib/events"
+	"lib/logging"
+	chatevents "wojciech-bot/chat/events"
+	"wojciech-bot/openai"
+)
+
+var log = logging.Get().Named("memory")
+
+// Init remembers approved details in the local memory store, instead of the OpenAI vector store.
+// Only failures to remember are returned, so that the review queue doesn't queue remembered details again.
+func Init(store *LocalStore) {
+	events.Handle(func(ctx context.Context, event chatevents.MemoryApproved) error {
+		entry, err := store.Remember(ctx, Entry{
+			Content:    event.Details,
+			Source:     event.DiscordThreadID,
+			AuthorID:   event.AuthorID,
+			SubjectIDs: event.SubjectIDs,
+			Confidence: event.Confidence,
+			CreatedAt:  event.CreatedAt,
+		})
+		if goerrors.Is(err, ErrNothingToRemember) {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "local remember failed")
+		}
+
+		// Chunks are listed, so that the forget button of the notification removes all of them
+		chunkIDs, err := store.chunkIDs(entry.ID)
+		if err != nil {
+			log.Error("failed to read chunks of remembered memory", zap.String("memoryID", entry.ID), zap.Error(err))
+			chunkIDs = []string{entry.ID}
+		}
+
+		err = events.Dispatch(ctx, openai.MemoryUpdated{
+			DiscordThreadID: event.DiscordThreadID,
+			Content:         event.Details,
+			MemoryIDs:       chunkIDs,
+		})
+		if err != nil {
+			log.Error("failed to notify about remembered memory", zap.String("threadID", event.DiscordThreadID), zap.Error(err))
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,41 @@
+package memory_test
+
+import (
+	"context"
+	goerrors "errors"
+	"github.com/stretchr/testify/assert"
+	"lib/events"
+	"lib/llm"
+	"lib/storage"
+	"path/filepath"
+	"testing"
+	chatevents "wojciech-bot/chat/events"
+	"wojciech-bot/memory"
+	"wojciech-bot/openai"
+)
+
+func TestInit(t *testing.T) {
+	db, err := storage.Open(filepath.Join(t.TempDir(), "test.db"))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	memoryStore, err := llm.NewMemoryStore(db, llm.NewAPI(llm.NewScriptedAdapter(), "scripted"), llm.MemoryStoreOptions{})
+	assert.NoError(t, err)
+
+	store, err := memory.NewLocalStore(db, memoryStore)
+	assert.NoError(t, err)
+
+	memory.Init(store)
+	events.Handle(func(ctx context.Context, event openai.MemoryUpdated) error {
+		return goerrors.New("failed to send message")
+	})
+
+	t.Run("remembered memory is approved, even if the notification fails", func(t *testing.T) {
+		err := events.Dispatch(context.Background(), chatevents.MemoryApproved{Details: "Kasia lives in Kraków", DiscordThreadID: "thread"})
+		assert.NoError(t, err)
+
+		memories, err := memoryStore.List()
+		assert.NoError(t, err)
+		assert.Len(t, memories, 1)
+	})
+}
@@ -0,0 +1,258 @@
+package memory
+
+import (
+	"bytes"
+	"context"
+	goerrors "errors"
+	"fmt"
+	"github.com/bwmarrin/discordgo"
+	"lib/discord"
+	"lib/errors"
+	"lib/util/arrayutil"
+	"strconv"
+	"strings"
+	"time"
+	"wojciech-bot/messages"
+)
+
+// pageSize is a number of memories in a single embed
+const pageSize = 10
+
+// maxEntryLength keeps the embed within Discord limits, the full memory can be seen in search results or with the edit
+const maxEntryLength = 300
+
+type Interactions struct {
+	bot   *discord.Bot
+	store Store
+}
+
+func NewInteractions(bot *discord.Bot, store Store) *Interactions {
+	return &Interactions{
+		bot:   bot,
+		store: store,
+	}
+}
+
+// List replies with the given page of memories, numbered from 1. Empty subjectID lists memories about everyone.
+func (i *Interactions) List(ctx context.Context, interaction *discordgo.Interaction, subjectID string, pageNumber int) error {
+	pageNumber = max(pageNumber, 1)
+	entries, total, err := i.store.List(ctx, subjectID, (pageNumber-1)*pageSize, pageSize)
+	if err != nil {
+		return err
+	}
+
+	if total == 0 {
+		i.reply(interaction, messages.Messages.Memory.NoMemories)
+
+		return nil
+	}
+
+	pages := (total + pageSize - 1) / pageSize
+	embed := newEntriesEmbed("Wspomnienia", entries)
+	if subjectID != "" {
+		embed.Description = fmt.Sprintf("O %s", discord.Mention(subjectID))
+	}
+	embed.Footer = &discordgo.MessageEmbedFooter{
+		Text: fmt.Sprintf("Strona %d z %d, razem %d", min(pageNumber, pages), pages, total),
+	}
+
+	i.bot.FollowupInteractionMessageAndForget(interaction, &discord.InteractionReply{
+		Embeds:    []*discordgo.MessageEmbed{embed},
+		Ephemeral: true,
+	})
+
+	return nil
+}
+
+// Search replies with memories the most similar to the query, optionally only these about the subject
+func (i *Interactions) Search(ctx context.Context, interaction *discordgo.Interaction, query string, subjectID string) error {
+	entries, err := i.store.Search(ctx, query, subjectID, pageSize)
+	if err != nil {
+		return err
+	}
+
+	if len(entries) == 0 {
+		i.reply(interaction, messages.Messages.Memory.NoMemories)
+
+		return nil
+	}
+
+	embed := newEntriesEmbed("Wyszukane wspomnienia", entries)
+	embed.Description = query
+	if subjectID != "" {
+		embed.Description = fmt.Sprintf("%s\nO %s", query, discord.Mention(subjectID))
+	}
+	i.bot.FollowupInteractionMessageAndForget(interaction, &discord.InteractionReply{
+		Embeds:    []*discordgo.MessageEmbed{embed},
+		Ephemeral: true,
+	})
+
+	return nil
+}
+
+// Edit replaces content of the memory, only for moderators, since memories can be about anyone
+func (i *Interactions) Edit(ctx context.Context, interaction *discordgo.Interaction, id string, content string) error {
+	if !isModerator(interaction) {
+		return errors.NewErrPublic(messages.Messages.Memory.ModeratorsOnly)
+	}
+
+	entry, err := i.store.Edit(ctx, id, strings.TrimSpace(content))
+	if err != nil {
+		return publicNotFound(err)
+	}
+
+	i.bot.FollowupInteractionMessageAndForget(interaction, &discord.InteractionReply{
+		Content:   messages.Messages.Memory.Edited,
+		Embeds:    []*discordgo.MessageEmbed{newEntriesEmbed("Poprawione wspomnienie", []Entry{entry})},
+		Ephemeral: true,
+	})
+
+	return nil
+}
+
+// Forget deletes the memory with the given ID, only for moderators. Others can forget only memories about themselves with ForgetMine.
+func (i *Interactions) Forget(ctx context.Context, interaction *discordgo.Interaction, id string) error {
+	if !isModerator(interaction) {
+		return errors.NewErrPublic(messages.Messages.Memory.ModeratorsOnly)
+	}
+
+	err := i.store.Forget(ctx, strings.TrimSpace(id))
+	if err != nil {
+		return publicNotFound(err)
+	}
+
+	i.reply(interaction, messages.Messages.Memory.Forgotten)
+
+	return nil
+}
+
+// ListMine replies with memories about the user, that invoked the command
+func (i *Interactions) ListMine(ctx context.Context, interaction *discordgo.Interaction, pageNumber int) error {
+	return i.List(ctx, interaction, interactionUserID(interaction), pageNumber)
+}
+
+// ForgetMine forgets all memories about the user, that invoked the command
+func (i *Interactions) ForgetMine(ctx context.Context, interaction *discordgo.Interaction) error {
+	forgotten, err := ForgetAbout(ctx, i.store, interactionUserID(interaction))
+	if err != nil {
+		return err
+	}
+
+	if forgotten == 0 {
+		i.reply(interaction, messages.Messages.Memory.NoMemories)
+
+		return nil
+	}
+
+	i.bot.FollowupInteractionMessageAndForget(interaction, &discord.InteractionReply{
+		Content: messages.Messages.Memory.ForgottenAboutYou,
+		Embeds: []*discordgo.MessageEmbed{{
+			Title:       "Zapomniane wspomnienia",
+			Description: strconv.Itoa(forgotten),
+		}},
+		Ephemeral: true,
+	})
+
+	return nil
+}
+
+// Export replies with the archive of all memories, only to admins, since it contains memories about everyone
+func (i *Interactions) Export(ctx context.Context, interaction *discordgo.Interaction) error {
+	if interaction.Member == nil || interaction.Member.Permissions&discordgo.PermissionAdministrator == 0 {
+		return errors.NewErrPublic(messages.Messages.Memory.Forbidden)
+	}
+
+	archive, err := Export(ctx, i.store)
+	if err != nil {
+		return err
+	}
+
+	var buffer bytes.Buffer
+	err = archive.Write(&buffer)
+	if err != nil {
+		return err
+	}
+
+	i.bot.FollowupInteractionMessageAndForget(interaction, &discord.InteractionReply{
+		Content: messages.Messages.Memory.Exported,
+		Files: []*discordgo.File{{
+			Name:        fmt.Sprintf("wojciech-memories-%s.json", archive.ExportedAt.Format("2006-01-02")),
+			ContentType: "application/json",
+			Reader:      &buffer,
+		}},
+		Ephemeral: true,
+	})
+
+	return nil
+}
+
+func (i *Interactions) reply(interaction *discordgo.Interaction, content string) {
+	i.bot.FollowupInteractionMessageAndForget(interaction, &discord.InteractionReply{
+		Content:   content,
+		Ephemeral: true,
+	})
+}
+
+// interactionUserID returns ID of the user, that invoked the command, in a guild or in direct messages
+func interactionUserID(interaction *discordgo.Interaction) string {
+	if interaction.Member != nil && interaction.Member.User != nil {
+		return interaction.Member.User.ID
+	}
+
+	if interaction.User != nil {
+		return interaction.User.ID
+	}
+
+	return ""
+}
+
+// isModerator checks if the member may manage the server, direct messages have no member
+func isModerator(interaction *discordgo.Interaction) bool {
+	return interaction.Member != nil &&
+		interaction.Member.Permissions&(discordgo.PermissionAdministrator|discordgo.PermissionManageServer) != 0
+}
+
+func publicNotFound(err error) error {
+	if goerrors.Is(err, ErrMemoryNotFound) {
+		return errors.NewErrPublicCause(messages.Messages.Memory.NotFound, err)
+	}
+
+	return err
+}
+
+func newEntriesEmbed(title string, entries []Entry) *discordgo.MessageEmbed {
+	fields := make([]*discordgo.MessageEmbedField, 0, len(entries))
+	for _, entry := range entries {
+		content := entry.Content
+		if runes := []rune(content); len(runes) > maxEntryLength {
+			content = string(runes[:maxEntryLength]) + "…"
+		}
+
+		details := []string{entry.CreatedAt.Format(time.DateOnly)}
+		if entry.AuthorID != "" {
+			details = append(details, "autor "+discord.Mention(entry.AuthorID))
+		}
+		if entry.Source != "" {
+			details = append(details, fmt.Sprintf("<#%s>", entry.Source))
+		}
+		if len(entry.SubjectIDs) > 0 {
+			details = append(details, "o "+strings.Join(arrayutil.Map(entry.SubjectIDs, discord.Mention), ", "))
+		}
+		if entry.Confidence > 0 {
+			details = append(details, fmt.Sprintf("pewność %.0f%%", entry.Confidence*100))
+		}
+		if entry.Score > 0 {
+			details = append(details, fmt.Sprintf("trafność %.2f", entry.Score))
+		}
+
+		fields = append(fields, &discordgo.MessageEmbedField{
+			Name:  entry.ID,
+			Value: fmt.Sprintf("%s\n*%s*", content, strings.Join(details, " · ")),
+		})
+	}
+
+	return &discordgo.MessageEmbed{
+		Title:  title,
+		Fields: fields,
+	}
+}
@@ -0,0 +1,168 @@
+package memory_test
+
+import (
+	"context"
+	"github.com/bwmarrin/discordgo"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"lib/discord"
+	"lib/errors"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"wojciech-bot/memory"
+)
+
+// roundTripper records bodies of requests sent to Discord, and replies with an empty message
+type roundTripper struct {
+	mu     sync.Mutex
+	bodies []string
+}
+
+func (r *roundTripper) RoundTrip(request *http.Request) (*http.Response, error) {
+	var body []byte
+	if request.Body != nil {
+		body, _ = io.ReadAll(request.Body)
+	}
+
+	r.mu.Lock()
+	r.bodies = append(r.bodies, string(body))
+	r.mu.Unlock()
+
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(`{"id": "1"}`)),
+		Request:    request,
+	}, nil
+}
+
+func (r *roundTripper) Bodies() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.bodies
+}
+
+func newTestBot(t *testing.T) (*discord.Bot, *roundTripper) {
+	session, err := discordgo.New("Bot token")
+	assert.NoError(t, err)
+
+	transport := &roundTripper{}
+	session.Client = &http.Client{Transport: transport}
+
+	return &discord.Bot{Session: session}, transport
+}
+
+func newInteraction(permissions int64) *discordgo.Interaction {
+	return &discordgo.Interaction{
+		AppID: "app",
+		Token: "token",
+		Member: &discordgo.Member{
+			User:        &discordgo.User{ID: "user"},
+			Permissions: permissions,
+		},
+	}
+}
+
+func TestInteractionsModeration(t *testing.T) {
+	ctx := context.Background()
+	store := newLocalStore(t)
+	entry, err := store.Remember(ctx, memory.Entry{Content: "Kasia lives in Kraków", SubjectIDs: []string{"kasia"}})
+	assert.NoError(t, err)
+
+	bot, transport := newTestBot(t)
+	interactions := memory.NewInteractions(bot, store)
+
+	t.Run("members cannot edit memories", func(t *testing.T) {
+		err := interactions.Edit(ctx, newInteraction(0), entry.ID, "Kasia lives in Warszawa")
+
+		var publicErr *errors.ErrPublic
+		assert.ErrorAs(t, err, &publicErr)
+
+		entries, _, err := store.List(ctx, "", 0, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, "Kasia lives in Kraków", entries[0].Content)
+	})
+
+	t.Run("members cannot forget memories", func(t *testing.T) {
+		err := interactions.Forget(ctx, newInteraction(0), entry.ID)
+
+		var publicErr *errors.ErrPublic
+		assert.ErrorAs(t, err, &publicErr)
+
+		_, total, err := store.List(ctx, "", 0, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, total)
+	})
+
+	t.Run("server managers edit memories", func(t *testing.T) {
+		err := interactions.Edit(ctx, newInteraction(discordgo.PermissionManageServer), entry.ID, "Kasia lives in Warszawa")
+		assert.NoError(t, err)
+
+		entries, _, err := store.List(ctx, "", 0, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, "Kasia lives in Warszawa", entries[0].Content)
+		assert.Len(t, transport.Bodies(), 1)
+	})
+
+	t.Run("admins forget memories", func(t *testing.T) {
+		entries, _, err := store.List(ctx, "", 0, 10)
+		assert.NoError(t, err)
+
+		err = interactions.Forget(ctx, newInteraction(discordgo.PermissionAdministrator), entries[0].ID)
+		assert.NoError(t, err)
+
+		_, total, err := store.List(ctx, "", 0, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, total)
+	})
+}
+
+func TestInteractionsExport(t *testing.T) {
+	ctx := context.Background()
+	store := newLocalStore(t)
+	_, err := store.Remember(ctx, memory.Entry{Content: "Kasia lives in Kraków", SubjectIDs: []string{"kasia"}})
+	assert.NoError(t, err)
+
+	t.Run("exports only to admins", func(t *testing.T) {
+		bot, transport := newTestBot(t)
+
+		err := memory.NewInteractions(bot, store).Export(ctx, newInteraction(discordgo.PermissionManageServer))
+
+		var publicErr *errors.ErrPublic
+		assert.ErrorAs(t, err, &publicErr)
+		assert.Empty(t, transport.Bodies())
+	})
+
+	t.Run("attaches the archive", func(t *testing.T) {
+		bot, transport := newTestBot(t)
+
+		err := memory.NewInteractions(bot, store).Export(ctx, newInteraction(discordgo.PermissionAdministrator))
+		assert.NoError(t, err)
+
+		bodies := transport.Bodies()
+		assert.Len(t, bodies, 1)
+		assert.Contains(t, bodies[0], `filename="wojciech-memories-`)
+		assert.Contains(t, bodies[0], `"content": "Kasia lives in Kraków"`)
+		assert.Contains(t, bodies[0], `"version": 1`)
+	})
+}
+
+func TestInteractionsList(t *testing.T) {
+	ctx := context.Background()
+	store := newLocalStore(t)
+	_, err := store.Remember(ctx, memory.Entry{Content: "Kasia lives in Kraków", Source: "thread", AuthorID: "wojtek", SubjectIDs: []string{"kasia"}})
+	assert.NoError(t, err)
+
+	bot, transport := newTestBot(t)
+
+	err = memory.NewInteractions(bot, store).List(ctx, newInteraction(0), "", 1)
+	assert.NoError(t, err)
+
+	bodies := transport.Bodies()
+	assert.Len(t, bodies, 1)
+	assert.Contains(t, bodies[0], "Kasia lives in Kraków")
+	assert.Contains(t, bodies[0], "autor \\u003c@wojtek\\u003e")
+}
@@ -0,0 +1,298 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"lib/errors"
+	"lib/llm"
+	"lib/storage"
+	"lib/util/arrayutil"
+	"strings"
+)
+
+// LocalMinClusterScore is a cosine similarity of embeddings, above which memories are consolidated
+const LocalMinClusterScore = 0.85
+
+const localMemoryBucketName = "local_memories"
+
+// localMemory groups chunks, that content of the memory was split into by llm.MemoryStore.
+// It is stored under ID of the first chunk, which is the ID of the memory.
+type localMemory struct {
+	ChunkIDs []string `json:"chunk_ids"`
+}
+
+// LocalStore keeps memories in llm.MemoryStore. Chunks of a memory are listed, edited and forgotten together.
+type LocalStore struct {
+	store  *llm.MemoryStore
+	groups *storage.Bucket[localMemory]
+}
+
+func NewLocalStore(db *storage.DB, store *llm.MemoryStore) (*LocalStore, error) {
+	groups, err := storage.NewBucket[localMemory](db, localMemoryBucketName)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LocalStore{store: store, groups: groups}, nil
+}
+
+// NewOllamaMemoryStore creates llm.MemoryStore of the bot, that embeds memories with the Ollama model.
+// The bot and the backup tool share it, so that memories are searched the same way.
+func NewOllamaMemoryStore(db *storage.DB, ollamaAdapter *llm.OllamaAdapter, embeddingModel string) (*llm.MemoryStore, error) {
+	ollamaAdapter.WithEmbeddingModel(embeddingModel)
+
+	return llm.NewMemoryStore(db, llm.NewAPI(ollamaAdapter, "ollama-embeddings"), llm.MemoryStoreOptions{
+		TopK:           5,
+		MinScore:       0.6,
+		MaxChunkLength: 500,
+	})
+}
+
+func (s *LocalStore) List(_ context.Context, subjectID string, offset int, limit int) ([]Entry, int, error) {
+	entries, err := s.entries(subjectID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return page(entries, offset, limit), len(entries), nil
+}
+
+func (s *LocalStore) IDs(_ context.Context, subjectID string) ([]string, error) {
+	entries, err := s.entries(subjectID)
+	if err != nil {
+		return nil, err
+	}
+
+	return arrayutil.Map(entries, func(entry Entry) string {
+		return entry.ID
+	}), nil
+}
+
+// Search returns the most similar chunks, each as the memory it belongs to
+func (s *LocalStore) Search(ctx context.Context, query string, subjectID string, limit int) ([]Entry, error) {
+	var found []llm.ScoredMemory
+	var err error
+	if subjectID != "" {
+		found, err = s.store.SearchAbout(ctx, query, subjectID, limit)
+	} else {
+		found, err = s.store.Search(ctx, query, limit)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	memoryIDs, err := s.memoryIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []Entry
+	seen := map[string]bool{}
+	for _, chunk := range found {
+		entry := localEntry(chunk.Memory)
+		if memoryID, ok := memoryIDs[chunk.ID]; ok {
+			entry.ID = memoryID
+		}
+		// Chunks are ordered by score, so only the most similar one of the memory is kept
+		if seen[entry.ID] {
+			continue
+		}
+		seen[entry.ID] = true
+
+		entry.Score = float64(chunk.Score)
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// Remember stores all chunks of the content as a single memory
+func (s *LocalStore) Remember(ctx context.Context, entry Entry) (Entry, error) {
+	chunks, err := s.store.Remember(ctx, entry.Content, llm.MemoryProvenance{
+		Source:     entry.Source,
+		Author:     entry.AuthorID,
+		Subjects:   entry.SubjectIDs,
+		Confidence: entry.Confidence,
+		CreatedAt:  entry.CreatedAt,
+	})
+	if err != nil {
+		return Entry{}, err
+	}
+
+	if len(chunks) == 0 {
+		return Entry{}, fmt.Errorf("%w in %q", ErrNothingToRemember, entry.Content)
+	}
+
+	chunkIDs := arrayutil.Map(chunks, func(chunk llm.Memory) string {
+		return chunk.ID
+	})
+	err = s.groups.Put(chunkIDs[0], localMemory{ChunkIDs: chunkIDs})
+	if err != nil {
+		// Chunks without their group would be listed as separate memories
+		_ = s.store.Forget(chunkIDs...)
+
+		return Entry{}, errors.Wrap(err, "failed to store chunks of the memory")
+	}
+
+	return groupEntry(chunkIDs[0], chunks), nil
+}
+
+// Edit replaces content of the memory with a single chunk, forgetting the other ones
+func (s *LocalStore) Edit(ctx context.Context, id string, content string) (Entry, error) {
+	memory, err := s.store.Get(id)
+	if err != nil {
+		return Entry{}, err
+	}
+
+	if memory == nil {
+		return Entry{}, ErrMemoryNotFound
+	}
+
+	chunkIDs, err := s.chunkIDs(id)
+	if err != nil {
+		return Entry{}, err
+	}
+
+	memory, err = s.store.Edit(ctx, id, content)
+	if err != nil {
+		return Entry{}, err
+	}
+
+	if len(chunkIDs) > 1 {
+		err = s.store.Forget(chunkIDs[1:]...)
+		if err != nil {
+			return Entry{}, errors.Wrap(err, "failed to forget chunks of the edited memory")
+		}
+
+		err = s.groups.Put(id, localMemory{ChunkIDs: []string{id}})
+		if err != nil {
+			return Entry{}, errors.Wrap(err, "failed to store chunks of the memory")
+		}
+	}
+
+	return localEntry(*memory), nil
+}
+
+func (s *LocalStore) Forget(_ context.Context, id string) error {
+	memory, err := s.store.Get(id)
+	if err != nil {
+		return err
+	}
+
+	if memory == nil {
+		return ErrMemoryNotFound
+	}
+
+	chunkIDs, err := s.chunkIDs(id)
+	if err != nil {
+		return err
+	}
+
+	err = s.store.Forget(chunkIDs...)
+	if err != nil {
+		return err
+	}
+
+	return s.groups.Delete(id)
+}
+
+// chunkIDs returns IDs of all chunks of the memory. Memories remembered before chunks were grouped have only one.
+func (s *LocalStore) chunkIDs(id string) ([]string, error) {
+	group, err := s.groups.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if group == nil {
+		return []string{id}, nil
+	}
+
+	return group.ChunkIDs, nil
+}
+
+// memoryIDs maps IDs of grouped chunks to IDs of their memories
+func (s *LocalStore) memoryIDs() (map[string]string, error) {
+	memoryIDs := map[string]string{}
+	err := s.groups.ForEach(func(id string, group localMemory) error {
+		for _, chunkID := range group.ChunkIDs {
+			memoryIDs[chunkID] = id
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read chunks of memories")
+	}
+
+	return memoryIDs, nil
+}
+
+// entries returns memories about the subject from the newest, with their chunks joined
+func (s *LocalStore) entries(subjectID string) ([]Entry, error) {
+	chunks, err := s.memories(subjectID)
+	if err != nil {
+		return nil, err
+	}
+
+	memoryIDs, err := s.memoryIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	var order []string
+	groups := map[string][]llm.Memory{}
+	for _, chunk := range chunks {
+		memoryID, ok := memoryIDs[chunk.ID]
+		if !ok {
+			memoryID = chunk.ID
+		}
+
+		if _, ok := groups[memoryID]; !ok {
+			order = append(order, memoryID)
+		}
+		// Chunks are listed from the newest, so the earlier ones are prepended
+		groups[memoryID] = append([]llm.Memory{chunk}, groups[memoryID]...)
+	}
+
+	return arrayutil.Map(order, func(memoryID string) Entry {
+		return groupEntry(memoryID, groups[memoryID])
+	}), nil
+}
+
+func (s *LocalStore) memories(subjectID string) ([]llm.Memory, error) {
+	memories, err := s.store.List()
+	if err != nil {
+		return nil, err
+	}
+
+	if subjectID == "" {
+		return memories, nil
+	}
+
+	return arrayutil.Filter(memories, func(memory llm.Memory) bool {
+		return memory.IsAbout(subjectID)
+	}), nil
+}
+
+// groupEntry joins chunks of the memory into a single entry
+func groupEntry(id string, chunks []llm.Memory) Entry {
+	entry := localEntry(chunks[0])
+	entry.ID = id
+	entry.Content = strings.Join(arrayutil.Map(chunks, func(chunk llm.Memory) string {
+		return chunk.Content
+	}), "\n")
+
+	return entry
+}
+
+func localEntry(memory llm.Memory) Entry {
+	return Entry{
+		ID:         memory.ID,
+		Content:    memory.Content,
+		Source:     memory.Source,
+		AuthorID:   memory.Author,
+		CreatedAt:  memory.CreatedAt,
+		SubjectIDs: memory.Subjects,
+		Confidence: memory.Confidence,
+	}
+}
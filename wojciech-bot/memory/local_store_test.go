@@ -0,0 +1,135 @@
+package memory_test
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"lib/llm"
+	"lib/storage"
+	"path/filepath"
+	"testing"
+	"wojciech-bot/memory"
+)
+
+func newLocalStore(t *testing.T) *memory.LocalStore {
+	db, err := storage.Open(filepath.Join(t.TempDir(), "test.db"))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	memoryStore, err := llm.NewMemoryStore(db, llm.NewAPI(llm.NewScriptedAdapter(), "scripted"), llm.MemoryStoreOptions{})
+	assert.NoError(t, err)
+
+	store, err := memory.NewLocalStore(db, memoryStore)
+	assert.NoError(t, err)
+
+	return store
+}
+
+func TestLocalStore(t *testing.T) {
+	ctx := context.Background()
+	store := newLocalStore(t)
+
+	// Content is stored in many chunks, that make a single memory
+	chunked, err := store.Remember(ctx, memory.Entry{Content: "- Artur plays the guitar\n- Wojtek likes pizza\n- Kasia lives in Kraków", Source: "thread"})
+	assert.NoError(t, err)
+	assert.Equal(t, "Artur plays the guitar\nWojtek likes pizza\nKasia lives in Kraków", chunked.Content)
+	_, err = store.Remember(ctx, memory.Entry{Content: "Artur has a cat", Source: "thread", AuthorID: "wojtek", SubjectIDs: []string{"artur"}, Confidence: 0.9})
+	assert.NoError(t, err)
+
+	t.Run("refuses to remember nothing", func(t *testing.T) {
+		_, err := store.Remember(ctx, memory.Entry{Content: " \n- "})
+		assert.ErrorIs(t, err, memory.ErrNothingToRemember)
+	})
+
+	t.Run("lists pages from the newest", func(t *testing.T) {
+		entries, total, err := store.List(ctx, "", 1, 2)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, total)
+		assert.Len(t, entries, 1)
+		assert.Equal(t, chunked.ID, entries[0].ID)
+		assert.Equal(t, chunked.Content, entries[0].Content)
+		assert.Equal(t, "thread", entries[0].Source)
+	})
+
+	t.Run("lists memories about the subject", func(t *testing.T) {
+		entries, total, err := store.List(ctx, "artur", 0, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, total)
+		assert.Equal(t, "Artur has a cat", entries[0].Content)
+		assert.Equal(t, "wojtek", entries[0].AuthorID)
+		assert.Equal(t, []string{"artur"}, entries[0].SubjectIDs)
+		assert.Equal(t, 0.9, entries[0].Confidence)
+	})
+
+	t.Run("lists all memories without a limit", func(t *testing.T) {
+		entries, total, err := store.List(ctx, "", 1, memory.NoLimit)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, total)
+		assert.Len(t, entries, 1)
+	})
+
+	t.Run("lists IDs of memories about the subject", func(t *testing.T) {
+		entries, _, err := store.List(ctx, "artur", 0, 10)
+		assert.NoError(t, err)
+
+		ids, err := store.IDs(ctx, "artur")
+		assert.NoError(t, err)
+		assert.Equal(t, []string{entries[0].ID}, ids)
+
+		ids, err = store.IDs(ctx, "")
+		assert.NoError(t, err)
+		assert.Equal(t, []string{entries[0].ID, chunked.ID}, ids)
+	})
+
+	t.Run("finds the memory of the chunk", func(t *testing.T) {
+		found, err := store.Search(ctx, "Kasia lives in Kraków", "", 10)
+		assert.NoError(t, err)
+
+		var ids []string
+		for _, entry := range found {
+			ids = append(ids, entry.ID)
+		}
+		assert.Contains(t, ids, chunked.ID)
+		assert.Len(t, ids, len(found))
+	})
+
+	t.Run("forgets memories about the subject", func(t *testing.T) {
+		forgotten, err := memory.ForgetAbout(ctx, store, "artur")
+		assert.NoError(t, err)
+		assert.Equal(t, 1, forgotten)
+
+		_, total, err := store.List(ctx, "", 0, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, total)
+	})
+
+	t.Run("edits all chunks of the memory", func(t *testing.T) {
+		edited, err := store.Edit(ctx, chunked.ID, "Kasia lives in Warszawa")
+		assert.NoError(t, err)
+		assert.Equal(t, chunked.ID, edited.ID)
+
+		entries, total, err := store.List(ctx, "", 0, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, total)
+		assert.Equal(t, "Kasia lives in Warszawa", entries[0].Content)
+	})
+
+	t.Run("forgets all chunks of the memory", func(t *testing.T) {
+		remembered, err := store.Remember(ctx, memory.Entry{Content: "- Artur plays the drums\n- Wojtek likes pasta"})
+		assert.NoError(t, err)
+
+		assert.NoError(t, store.Forget(ctx, remembered.ID))
+		assert.ErrorIs(t, store.Forget(ctx, remembered.ID), memory.ErrMemoryNotFound)
+
+		found, err := store.Search(ctx, "Wojtek likes pasta", "", 10)
+		assert.NoError(t, err)
+		for _, entry := range found {
+			assert.NotContains(t, entry.Content, "pasta")
+		}
+
+		_, total, err := store.List(ctx, "", 0, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, total)
+	})
+}
@@ -0,0 +1,225 @@
+package memory
+
+import (
+	"bytes"
+	"context"
+	goerrors "errors"
+	"fmt"
+	"github.com/openai/openai-go"
+	"lib/errors"
+	"net/http"
+	"strings"
+	"time"
+	openaidomain "wojciech-bot/openai"
+)
+
+// OpenAIMinClusterScore is a score of the vector store ranker, above which memories are consolidated.
+// The ranker mixes keyword and semantic search, so near duplicates score lower than with cosine similarity.
+const OpenAIMinClusterScore = 0.7
+
+// OpenAIStore keeps memories as files in the OpenAI vector store, that the assistant searches
+type OpenAIStore struct {
+	client        *openai.Client
+	vectorStoreID string
+}
+
+func NewOpenAIStore(client *openai.Client, vectorStoreID string) *OpenAIStore {
+	return &OpenAIStore{
+		client:        client,
+		vectorStoreID: vectorStoreID,
+	}
+}
+
+// List reads IDs of all files, since the API pages only with cursors and can't filter by attributes,
+// and contents of files on the requested page
+func (s *OpenAIStore) List(ctx context.Context, subjectID string, offset int, limit int) ([]Entry, int, error) {
+	files, err := s.files(ctx, subjectID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var entries []Entry
+	for _, file := range page(files, offset, limit) {
+		content, err := s.content(ctx, file.ID)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		entries = append(entries, openAIEntry(file.ID, content, openaidomain.ParseMemoryAttributes(file.Attributes), file.CreatedAt))
+	}
+
+	return entries, len(files), nil
+}
+
+// IDs lists files without downloading their contents
+func (s *OpenAIStore) IDs(ctx context.Context, subjectID string) ([]string, error) {
+	files, err := s.files(ctx, subjectID)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(files))
+	for _, file := range files {
+		ids = append(ids, file.ID)
+	}
+
+	return ids, nil
+}
+
+func (s *OpenAIStore) Search(ctx context.Context, query string, subjectID string, limit int) ([]Entry, error) {
+	params := openai.VectorStoreSearchParams{
+		Query:         openai.VectorStoreSearchParamsQueryUnion{OfString: openai.String(query)},
+		MaxNumResults: openai.Int(int64(limit)),
+	}
+	if subjectID != "" {
+		params.Filters = openaidomain.SubjectFilter(subjectID)
+	}
+
+	results, err := s.client.VectorStores.Search(ctx, s.vectorStoreID, params)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to search vector store")
+	}
+
+	entries := make([]Entry, 0, len(results.Data))
+	for _, result := range results.Data {
+		attributes := openaidomain.ParseMemoryAttributes(result.Attributes)
+
+		// Files remembered before attributes were introduced have only the time of upload
+		var uploadedAt int64
+		if attributes.CreatedAt.IsZero() {
+			file, err := s.client.VectorStores.Files.Get(ctx, s.vectorStoreID, result.FileID)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to get vector file")
+			}
+			uploadedAt = file.CreatedAt
+		}
+
+		texts := make([]string, 0, len(result.Content))
+		for _, content := range result.Content {
+			texts = append(texts, content.Text)
+		}
+
+		entry := openAIEntry(result.FileID, strings.Join(texts, "\n"), attributes, uploadedAt)
+		entry.Score = result.Score
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+func (s *OpenAIStore) Remember(ctx context.Context, entry Entry) (Entry, error) {
+	attributes := openaidomain.MemoryAttributes{
+		Source:     entry.Source,
+		AuthorID:   entry.AuthorID,
+		SubjectIDs: entry.SubjectIDs,
+		Confidence: entry.Confidence,
+		CreatedAt:  entry.CreatedAt,
+	}
+
+	now := time.Now()
+	file := openai.File(bytes.NewReader([]byte(entry.Content)), fmt.Sprintf("memory_%d.txt", now.Unix()), "text/plain")
+	vectorFile, _, err := openaidomain.Remember(ctx, file, s.client, s.vectorStoreID, attributes)
+	if err != nil {
+		return Entry{}, errors.Wrap(err, "failed to remember memory")
+	}
+
+	return openAIEntry(vectorFile.ID, entry.Content, attributes, vectorFile.CreatedAt), nil
+}
+
+// Edit uploads the new content as a new file with attributes of the old one, and deletes the old one,
+// since contents of files can't be replaced
+func (s *OpenAIStore) Edit(ctx context.Context, id string, content string) (Entry, error) {
+	old, err := s.client.VectorStores.Files.Get(ctx, s.vectorStoreID, id)
+	if err != nil {
+		return Entry{}, notFound(err)
+	}
+
+	edited, err := s.Remember(ctx, openAIEntry(id, content, openaidomain.ParseMemoryAttributes(old.Attributes), old.CreatedAt))
+	if err != nil {
+		return Entry{}, err
+	}
+
+	err = s.Forget(ctx, id)
+	if err != nil {
+		return Entry{}, err
+	}
+
+	return edited, nil
+}
+
+// Forget removes the file from the vector store, and deletes it. IDs of vector files are the same as IDs of their files.
+func (s *OpenAIStore) Forget(ctx context.Context, id string) error {
+	_, err := s.client.VectorStores.Files.Delete(ctx, s.vectorStoreID, id)
+	if err != nil {
+		return notFound(errors.Wrap(err, "failed to delete vector file"))
+	}
+
+	_, err = s.client.Files.Delete(ctx, id)
+	if err != nil {
+		return errors.Wrap(err, "failed to delete file")
+	}
+
+	return nil
+}
+
+// files returns all vector files from the newest, optionally only these about the subject
+func (s *OpenAIStore) files(ctx context.Context, subjectID string) ([]openai.VectorStoreFile, error) {
+	var files []openai.VectorStoreFile
+	pager := s.client.VectorStores.Files.ListAutoPaging(ctx, s.vectorStoreID, openai.VectorStoreFileListParams{
+		Limit: openai.Int(100),
+		Order: openai.VectorStoreFileListParamsOrderDesc,
+	})
+	for pager.Next() {
+		file := pager.Current()
+		if subjectID == "" || openaidomain.ParseMemoryAttributes(file.Attributes).IsAbout(subjectID) {
+			files = append(files, file)
+		}
+	}
+	if pager.Err() != nil {
+		return nil, errors.Wrap(pager.Err(), "failed to list vector files")
+	}
+
+	return files, nil
+}
+
+func (s *OpenAIStore) content(ctx context.Context, id string) (string, error) {
+	contents, err := s.client.VectorStores.Files.Content(ctx, s.vectorStoreID, id)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to get vector file content")
+	}
+
+	texts := make([]string, 0, len(contents.Data))
+	for _, content := range contents.Data {
+		texts = append(texts, content.Text)
+	}
+
+	return strings.Join(texts, "\n"), nil
+}
+
+// openAIEntry prefers the creation time from attributes, over the time the file was uploaded
+func openAIEntry(id string, content string, attributes openaidomain.MemoryAttributes, uploadedAt int64) Entry {
+	createdAt := attributes.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = time.Unix(uploadedAt, 0)
+	}
+
+	return Entry{
+		ID:         id,
+		Content:    content,
+		Source:     attributes.Source,
+		AuthorID:   attributes.AuthorID,
+		CreatedAt:  createdAt,
+		SubjectIDs: attributes.SubjectIDs,
+		Confidence: attributes.Confidence,
+	}
+}
+
+// notFound replaces the error with ErrMemoryNotFound, if OpenAI doesn't know the file
+func notFound(err error) error {
+	var apiErr *openai.Error
+	if goerrors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+		return ErrMemoryNotFound
+	}
+
+	return err
+}
@@ -0,0 +1,82 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var ErrMemoryNotFound = errors.New("memory not found")
+
+// ErrNothingToRemember is returned by Remember, when the content has no facts, e.g. it is blank
+var ErrNothingToRemember = errors.New("nothing to remember")
+
+// NoLimit lists all memories from the offset
+const NoLimit = -1
+
+// Entry is a single memory, regardless of the store that keeps it
+type Entry struct {
+	ID      string
+	Content string
+	// Source is an ID of the Discord thread the memory comes from, empty if unknown
+	Source string
+	// AuthorID is an ID of the user, who started the discussion the memory comes from, empty if unknown
+	AuthorID  string
+	CreatedAt time.Time
+	// SubjectIDs are IDs of users the memory is about
+	SubjectIDs []string
+	// Confidence of the model, that extracted the memory, from 0 to 1
+	Confidence float64
+	// Score is a similarity to the query, set only in search results
+	Score float64
+}
+
+// Store manages memories of the bot, either in the OpenAI vector store or in the local llm.MemoryStore.
+// Empty subjectID matches all memories, otherwise only memories about the user.
+type Store interface {
+	// List returns a page of memories from the newest, and the total number of them. Limit can be NoLimit.
+	List(ctx context.Context, subjectID string, offset int, limit int) ([]Entry, int, error)
+	// IDs returns IDs of all memories, without reading their contents
+	IDs(ctx context.Context, subjectID string) ([]string, error)
+	// Search returns up to limit memories the most similar to the query
+	Search(ctx context.Context, query string, subjectID string, limit int) ([]Entry, error)
+	// Remember stores a new memory with provenance of the entry, ID and Score of the entry are ignored
+	Remember(ctx context.Context, entry Entry) (Entry, error)
+	// Edit replaces content of the memory, the edited memory may get a new ID
+	Edit(ctx context.Context, id string, content string) (Entry, error)
+	Forget(ctx context.Context, id string) error
+}
+
+// ForgetAbout forgets all memories about the user, and returns how many of them were forgotten
+func ForgetAbout(ctx context.Context, store Store, userID string) (int, error) {
+	if userID == "" {
+		return 0, errors.New("user ID is required to forget memories about the user")
+	}
+
+	ids, err := store.IDs(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, id := range ids {
+		err = store.Forget(ctx, id)
+		if err != nil && !errors.Is(err, ErrMemoryNotFound) {
+			return i, err
+		}
+	}
+
+	return len(ids), nil
+}
+
+// page returns entries between offset and offset+limit, or all entries from the offset with NoLimit
+func page[T any](entries []T, offset int, limit int) []T {
+	if offset >= len(entries) {
+		return nil
+	}
+
+	if limit == NoLimit {
+		return entries[offset:]
+	}
+
+	return entries[offset:min(offset+limit, len(entries))]
+}
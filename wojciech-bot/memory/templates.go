@@ -0,0 +1,13 @@
+package memory
+
+import (
+	"embed"
+	"lib/llm"
+)
+
+//go:embed templates/*.tmpl
+var templateFiles embed.FS
+
+func init() {
+	llm.Templates.MustLoad(templateFiles, "templates")
+}
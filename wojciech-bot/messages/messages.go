@@ -8,11 +8,71 @@ import (
 )
 
 type Chat struct {
-	RefuseToReply     []string `json:"refuseToReply"`
-	FailedToReply     []string `json:"failedToReply"`
-	EndDiscussion     []string `json:"endDiscussion"`
-	NewMemory         []string `json:"newMemory"`
-	ButtonLabelForget string   `json:"buttonLabelForget"`
+	RefuseToReply        []string `json:"refuseToReply"`
+	FailedToReply        []string `json:"failedToReply"`
+	EndDiscussion        []string `json:"endDiscussion"`
+	NewMemory            []string `json:"newMemory"`
+	ButtonLabelForget    string   `json:"buttonLabelForget"`
+	ButtonLabelReasoning string   `json:"buttonLabelReasoning"`
+	NoReasoning          string   `json:"noReasoning"`
+	ButtonLabelApprove   string   `json:"buttonLabelApprove"`
+	ButtonLabelEdit      string   `json:"buttonLabelEdit"`
+	ButtonLabelReject    string   `json:"buttonLabelReject"`
+	ReviewForbidden      string   `json:"reviewForbidden"`
+}
+
+type Usage struct {
+	NoUsage       string `json:"noUsage"`
+	TodayTitle    string `json:"todayTitle"`
+	MonthTitle    string `json:"monthTitle"`
+	QueuesTitle   string `json:"queuesTitle"`
+	CachesTitle   string `json:"cachesTitle"`
+	Total         string `json:"total"`
+	FeaturesField string `json:"featuresField"`
+	ModelsField   string `json:"modelsField"`
+	UsersField    string `json:"usersField"`
+	NoUser        string `json:"noUser"`
+	// Totals, PriorityStats and CacheStats contain {{TOKENS}} replaced with values of the line
+	Totals        string `json:"totals"`
+	PriorityStats string `json:"priorityStats"`
+	CacheStats    string `json:"cacheStats"`
+}
+
+type Memory struct {
+	NoMemories string `json:"noMemories"`
+	NotFound   string `json:"notFound"`
+	Edited     string `json:"edited"`
+	Forgotten  string `json:"forgotten"`
+
+	ForgottenAboutYou string `json:"forgottenAboutYou"`
+	Forbidden         string `json:"forbidden"`
+	ModeratorsOnly    string `json:"moderatorsOnly"`
+	Exported          string `json:"exported"`
+}
+
+type Scanner struct {
+	Forbidden          string `json:"forbidden"`
+	InvalidTimezone    string `json:"invalidTimezone"`
+	InvalidHour        string `json:"invalidHour"`
+	InvalidValue       string `json:"invalidValue"`
+	MissingChannel     string `json:"missingChannel"`
+	PolicyTitle        string `json:"policyTitle"`
+	ModeField          string `json:"modeField"`
+	ModeOptOut         string `json:"modeOptOut"`
+	ModeOptIn          string `json:"modeOptIn"`
+	TimezoneField      string `json:"timezoneField"`
+	QuietHoursField    string `json:"quietHoursField"`
+	DailyLimitField    string `json:"dailyLimitField"`
+	UserCooldownField  string `json:"userCooldownField"`
+	BotMessageGapField string `json:"botMessageGapField"`
+	ChannelsField      string `json:"channelsField"`
+	// None is shown instead of disabled quiet hours and limits
+	None            string `json:"none"`
+	ChannelDefault  string `json:"channelDefault"`
+	ChannelEnabled  string `json:"channelEnabled"`
+	ChannelDisabled string `json:"channelDisabled"`
+	// ChannelPolicy contains {{CHANNEL_ID}}, {{STATE}} and {{LIMIT}} of the channel
+	ChannelPolicy string `json:"channelPolicy"`
 }
 
 type DailyReportReminder struct {
@@ -68,6 +128,9 @@ type messages struct {
 	Greetings            [][]string          `json:"greetings"`
 	DailyReportReplies   DailyReportReplies  `json:"dailyReportReplies"`
 	Chat                 Chat                `json:"chat"`
+	Usage                Usage               `json:"usage"`
+	Scanner              Scanner             `json:"scanner"`
+	Memory               Memory              `json:"memory"`
 }
 
 var Messages messages
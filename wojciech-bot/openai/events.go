@@ -6,4 +6,6 @@ type MemoryUpdated struct {
 	VectorFileID    string
 	Content         string
 	FileID          string
+	// MemoryIDs are set instead of the vector file, when memories are kept in the local llm.MemoryStore
+	MemoryIDs []string
 }
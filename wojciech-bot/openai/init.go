@@ -5,29 +5,43 @@ import (
 	"context"
 	"fmt"
 	"github.com/openai/openai-go"
+	"go.uber.org/zap"
 	"lib/errors"
 	"lib/events"
 	"time"
 	"wojciech-bot/chat/events"
 )
 
+// Init remembers approved details in the OpenAI vector store.
+// Only failures to remember are returned, so that the review queue doesn't queue remembered details again.
 func Init(client *openai.Client, vectorStoreID string) {
-	events.Handle(func(ctx context.Context, event chatevents.MemoryDetailsExtracted) error {
+	events.Handle(func(ctx context.Context, event chatevents.MemoryApproved) error {
 		reader := bytes.NewReader([]byte(event.Details))
 
 		now := time.Now()
 		file := openai.File(reader, fmt.Sprintf("memory_%d.txt", now.Unix()), "text/plain")
 
-		vectorFile, openAIFile, err := Remember(ctx, file, client, vectorStoreID)
+		vectorFile, openAIFile, err := Remember(ctx, file, client, vectorStoreID, MemoryAttributes{
+			Source:     event.DiscordThreadID,
+			AuthorID:   event.AuthorID,
+			SubjectIDs: event.SubjectIDs,
+			Confidence: event.Confidence,
+			CreatedAt:  event.CreatedAt,
+		})
 		if err != nil {
 			return errors.Wrap(err, "openai remember failed")
 		}
 
-		return events.Dispatch(ctx, MemoryUpdated{
+		err = events.Dispatch(ctx, MemoryUpdated{
 			DiscordThreadID: event.DiscordThreadID,
 			Content:         event.Details,
 			VectorFileID:    vectorFile.ID,
 			FileID:          openAIFile.ID,
 		})
+		if err != nil {
+			log.Error("failed to notify about remembered memory", zap.String("threadID", event.DiscordThreadID), zap.Error(err))
+		}
+
+		return nil
 	})
 }
@@ -0,0 +1,114 @@
+package openai
+
+import (
+	"github.com/openai/openai-go"
+	"go.uber.org/zap"
+	"slices"
+	"strings"
+	"time"
+)
+
+// OpenAI allows up to 16 attributes per vector file, the rest is left for subjects
+const maxMemorySubjects = 16 - 4
+
+const (
+	attributeSource     = "source"
+	attributeAuthor     = "author"
+	attributeCreatedAt  = "created_at"
+	attributeConfidence = "confidence"
+	// attributeSubjectPrefix is followed by ID of the user, since attributes can't hold lists, and filters compare whole values
+	attributeSubjectPrefix = "subject_"
+)
+
+// MemoryAttributes describe where the memory comes from, and who it is about. They are kept as attributes of vector files.
+type MemoryAttributes struct {
+	// Source is an ID of the Discord thread the memory comes from
+	Source string
+	// AuthorID is an ID of the user, who started the discussion the memory comes from
+	AuthorID   string
+	SubjectIDs []string
+	Confidence float64
+	CreatedAt  time.Time
+}
+
+// NewParams returns attributes of a new vector file, CreatedAt defaults to now
+func (a MemoryAttributes) NewParams() map[string]openai.VectorStoreFileNewParamsAttributeUnion {
+	createdAt := a.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = time.Now()
+	}
+
+	params := map[string]openai.VectorStoreFileNewParamsAttributeUnion{
+		attributeCreatedAt:  {OfFloat: openai.Float(float64(createdAt.Unix()))},
+		attributeConfidence: {OfFloat: openai.Float(a.Confidence)},
+	}
+	if a.Source != "" {
+		params[attributeSource] = openai.VectorStoreFileNewParamsAttributeUnion{OfString: openai.String(a.Source)}
+	}
+	if a.AuthorID != "" {
+		params[attributeAuthor] = openai.VectorStoreFileNewParamsAttributeUnion{OfString: openai.String(a.AuthorID)}
+	}
+	for i, id := range a.SubjectIDs {
+		if i == maxMemorySubjects {
+			log.Warn("too many subjects of memory, the rest is skipped", zap.Strings("subjectIDs", a.SubjectIDs))
+			break
+		}
+
+		params[attributeSubjectPrefix+id] = openai.VectorStoreFileNewParamsAttributeUnion{OfBool: openai.Bool(true)}
+	}
+
+	return params
+}
+
+// IsAbout reports whether the user is one of subjects of the memory
+func (a MemoryAttributes) IsAbout(userID string) bool {
+	return slices.Contains(a.SubjectIDs, userID)
+}
+
+// attributeValue is implemented by attribute unions of vector files and search results
+type attributeValue interface {
+	openai.VectorStoreFileAttributeUnion | openai.VectorStoreSearchResponseAttributeUnion
+}
+
+// ParseMemoryAttributes reads attributes of a vector file or a search result, unknown attributes are ignored
+func ParseMemoryAttributes[T attributeValue](attributes map[string]T) MemoryAttributes {
+	var parsed MemoryAttributes
+	for key, value := range attributes {
+		// Both unions have the same fields, but generics can't access fields of type sets
+		var union openai.VectorStoreFileAttributeUnion
+		switch v := any(value).(type) {
+		case openai.VectorStoreFileAttributeUnion:
+			union = v
+		case openai.VectorStoreSearchResponseAttributeUnion:
+			union = openai.VectorStoreFileAttributeUnion{OfString: v.OfString, OfFloat: v.OfFloat, OfBool: v.OfBool}
+		}
+
+		switch {
+		case key == attributeSource:
+			parsed.Source = union.OfString
+		case key == attributeAuthor:
+			parsed.AuthorID = union.OfString
+		case key == attributeCreatedAt:
+			parsed.CreatedAt = time.Unix(int64(union.OfFloat), 0)
+		case key == attributeConfidence:
+			parsed.Confidence = union.OfFloat
+		case strings.HasPrefix(key, attributeSubjectPrefix) && union.OfBool:
+			parsed.SubjectIDs = append(parsed.SubjectIDs, strings.TrimPrefix(key, attributeSubjectPrefix))
+		}
+	}
+	// Attributes are a map, so subjects are sorted to keep their order stable
+	slices.Sort(parsed.SubjectIDs)
+
+	return parsed
+}
+
+// SubjectFilter matches vector files about the user
+func SubjectFilter(userID string) openai.VectorStoreSearchParamsFiltersUnion {
+	return openai.VectorStoreSearchParamsFiltersUnion{
+		OfComparisonFilter: &openai.ComparisonFilterParam{
+			Key:   attributeSubjectPrefix + userID,
+			Type:  openai.ComparisonFilterTypeEq,
+			Value: openai.ComparisonFilterValueUnionParam{OfBool: openai.Bool(true)},
+		},
+	}
+}
@@ -0,0 +1,86 @@
+package openai_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/openai/openai-go"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+	openaidomain "wojciech-bot/openai"
+)
+
+// roundTrip sends the attributes the way OpenAI stores them, and reads them back from a vector file
+func roundTrip(t *testing.T, attributes openaidomain.MemoryAttributes) (map[string]openai.VectorStoreFileAttributeUnion, openaidomain.MemoryAttributes) {
+	data, err := json.Marshal(attributes.NewParams())
+	assert.NoError(t, err)
+
+	var stored map[string]openai.VectorStoreFileAttributeUnion
+	assert.NoError(t, json.Unmarshal(data, &stored))
+
+	return stored, openaidomain.ParseMemoryAttributes(stored)
+}
+
+func TestMemoryAttributes(t *testing.T) {
+	t.Run("keeps attributes of the memory", func(t *testing.T) {
+		attributes := openaidomain.MemoryAttributes{
+			Source:     "thread",
+			AuthorID:   "3",
+			SubjectIDs: []string{"2", "1"},
+			Confidence: 0.75,
+			CreatedAt:  time.Date(2024, 1, 2, 3, 4, 5, 0, time.Local),
+		}
+
+		stored, parsed := roundTrip(t, attributes)
+		assert.Len(t, stored, 6)
+		assert.Equal(t, "thread", parsed.Source)
+		assert.Equal(t, "3", parsed.AuthorID)
+		assert.Equal(t, []string{"1", "2"}, parsed.SubjectIDs)
+		assert.Equal(t, 0.75, parsed.Confidence)
+		assert.True(t, attributes.CreatedAt.Equal(parsed.CreatedAt))
+		assert.True(t, parsed.IsAbout("2"))
+		assert.False(t, parsed.IsAbout("3"))
+	})
+
+	t.Run("defaults creation time to now, and skips empty source and author", func(t *testing.T) {
+		before := time.Now().Truncate(time.Second)
+
+		stored, parsed := roundTrip(t, openaidomain.MemoryAttributes{})
+		assert.NotContains(t, stored, "source")
+		assert.NotContains(t, stored, "author")
+		assert.Empty(t, parsed.Source)
+		assert.Empty(t, parsed.SubjectIDs)
+		assert.False(t, parsed.CreatedAt.Before(before))
+	})
+
+	t.Run("keeps at most 12 subjects, within the limit of 16 attributes", func(t *testing.T) {
+		var subjectIDs []string
+		for i := range 20 {
+			subjectIDs = append(subjectIDs, fmt.Sprintf("%02d", i))
+		}
+
+		stored, parsed := roundTrip(t, openaidomain.MemoryAttributes{Source: "thread", AuthorID: "author", SubjectIDs: subjectIDs})
+		assert.Len(t, stored, 16)
+		assert.Equal(t, subjectIDs[:12], parsed.SubjectIDs)
+	})
+
+	t.Run("parses search results, ignoring unknown attributes and false subjects", func(t *testing.T) {
+		parsed := openaidomain.ParseMemoryAttributes(map[string]openai.VectorStoreSearchResponseAttributeUnion{
+			"source":     {OfString: "thread"},
+			"author":     {OfString: "3"},
+			"created_at": {OfFloat: 1700000000},
+			"confidence": {OfFloat: 0.5},
+			"subject_1":  {OfBool: true},
+			"subject_2":  {OfBool: false},
+			"unknown":    {OfString: "value"},
+		})
+
+		assert.Equal(t, openaidomain.MemoryAttributes{
+			Source:     "thread",
+			AuthorID:   "3",
+			SubjectIDs: []string{"1"},
+			Confidence: 0.5,
+			CreatedAt:  time.Unix(1700000000, 0),
+		}, parsed)
+	})
+}
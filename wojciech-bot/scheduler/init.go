@@ -7,9 +7,11 @@ import (
 	"go.uber.org/zap"
 	"lib/discord"
 	"lib/logging"
+	"wojciech-bot/env"
+	"wojciech-bot/memory"
 )
 
-func Init(bot *discord.Bot) error {
+func Init(bot *discord.Bot, consolidator *memory.Consolidator) error {
 	c := cron.New()
 
 	err := schedule(c, "DailyGreeting", "0 9 * * *", func() {
@@ -19,6 +21,14 @@ func Init(bot *discord.Bot) error {
 		return err
 	}
 
+	// Weekly, at night, when nobody chats, since every memory is compared with the others
+	err = schedule(c, "MemoryConsolidation", "0 4 * * 1", func() {
+		MemoryConsolidation(bot, consolidator, env.Env.MemoryReviewChannelID)
+	})
+	if err != nil {
+		return err
+	}
+
 	c.Start()
 
 	return nil
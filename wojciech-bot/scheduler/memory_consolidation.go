@@ -0,0 +1,122 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"github.com/bwmarrin/discordgo"
+	"go.uber.org/zap"
+	"lib/discord"
+	"lib/util/arrayutil"
+	"strings"
+	"time"
+	"unicode/utf8"
+	"wojciech-bot/memory"
+)
+
+// Discord limits of embeds. Long values are cut, and the changelog is split in many messages to fit the rest.
+const (
+	maxChangelogFields     = 25
+	maxChangelogFieldValue = 1024
+	// maxChangelogLength is a limit of all texts in embeds of a single message
+	maxChangelogLength = 6000
+)
+
+const changelogTitle = "Porządki we wspomnieniach"
+
+// MemoryConsolidation merges similar memories, forgets expired ones, and sends the changelog to the channel
+func MemoryConsolidation(bot *discord.Bot, consolidator *memory.Consolidator, channelID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+
+	changelog, err := consolidator.Consolidate(ctx, time.Now())
+	if err != nil {
+		log.Error("failed to consolidate memories", zap.Error(err))
+	}
+
+	if changelog.IsEmpty() || channelID == "" {
+		return
+	}
+
+	// Each embed is sent in its own message, since the length limit applies to all embeds of the message
+	for _, embed := range newChangelogEmbeds(changelog, time.Now()) {
+		_, err = bot.ChannelMessageSendEmbed(channelID, embed, discordgo.WithContext(ctx))
+		if err != nil {
+			log.Error("failed to send memory changelog", zap.Error(err))
+			return
+		}
+	}
+}
+
+// newChangelogEmbeds lists all changes, in as many embeds as needed to stay within limits of Discord
+func newChangelogEmbeds(changelog memory.Changelog, now time.Time) []*discordgo.MessageEmbed {
+	var fields []*discordgo.MessageEmbedField
+	for _, merge := range changelog.Merged {
+		fields = append(fields, &discordgo.MessageEmbedField{
+			Name:  "Połączone",
+			Value: changelogValue(fmt.Sprintf("%s\n→ %s", entryList(merge.From), entryList(merge.Into))),
+		})
+	}
+
+	for _, contradiction := range changelog.Contradictions {
+		fields = append(fields, &discordgo.MessageEmbedField{
+			Name:  "Sprzeczność do sprawdzenia",
+			Value: changelogValue(fmt.Sprintf("%s\n%s", contradiction.Reason, entryList(contradiction.Entries))),
+		})
+	}
+
+	if len(changelog.Expired) > 0 {
+		fields = append(fields, &discordgo.MessageEmbedField{
+			Name:  fmt.Sprintf("Wygasłe (%d)", len(changelog.Expired)),
+			Value: changelogValue(entryList(changelog.Expired)),
+		})
+	}
+
+	embeds := []*discordgo.MessageEmbed{{
+		Title:       changelogTitle,
+		Description: fmt.Sprintf("Połączone: %d, sprzeczne: %d, wygasłe: %d", len(changelog.Merged), len(changelog.Contradictions), len(changelog.Expired)),
+		Timestamp:   now.Format(time.RFC3339),
+	}}
+	for _, field := range fields {
+		embed := embeds[len(embeds)-1]
+		if len(embed.Fields) == maxChangelogFields || embedLength(embed)+fieldLength(field) > maxChangelogLength {
+			embed = &discordgo.MessageEmbed{
+				Title:     changelogTitle + " (ciąg dalszy)",
+				Timestamp: now.Format(time.RFC3339),
+			}
+			embeds = append(embeds, embed)
+		}
+
+		embed.Fields = append(embed.Fields, field)
+	}
+
+	return embeds
+}
+
+// embedLength counts characters of the embed, the same as Discord does for the limit of the message
+func embedLength(embed *discordgo.MessageEmbed) int {
+	length := utf8.RuneCountInString(embed.Title) + utf8.RuneCountInString(embed.Description)
+	for _, field := range embed.Fields {
+		length += fieldLength(field)
+	}
+
+	return length
+}
+
+func fieldLength(field *discordgo.MessageEmbedField) int {
+	return utf8.RuneCountInString(field.Name) + utf8.RuneCountInString(field.Value)
+}
+
+// entryList lists IDs and contents of memories, so that moderators can edit or forget them with /pamiec
+func entryList(entries []memory.Entry) string {
+	return strings.Join(arrayutil.Map(entries, func(entry memory.Entry) string {
+		return fmt.Sprintf("`%s` %s", entry.ID, entry.Content)
+	}), "\n")
+}
+
+func changelogValue(value string) string {
+	if runes := []rune(value); len(runes) > maxChangelogFieldValue {
+		return string(runes[:maxChangelogFieldValue-1]) + "…"
+	}
+
+	return value
+}
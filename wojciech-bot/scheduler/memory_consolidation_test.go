@@ -0,0 +1,65 @@
+package scheduler_test
+
+import (
+	"fmt"
+	"github.com/bwmarrin/discordgo"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+	"wojciech-bot/memory"
+	"wojciech-bot/scheduler"
+)
+
+func embedLength(embed *discordgo.MessageEmbed) int {
+	length := utf8.RuneCountInString(embed.Title) + utf8.RuneCountInString(embed.Description)
+	for _, field := range embed.Fields {
+		length += utf8.RuneCountInString(field.Name) + utf8.RuneCountInString(field.Value)
+	}
+
+	return length
+}
+
+func TestNewChangelogEmbeds(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("fits a short changelog in a single embed", func(t *testing.T) {
+		embeds := scheduler.NewChangelogEmbeds(memory.Changelog{
+			Expired: []memory.Entry{{ID: "1", Content: "Kasia lives in Kraków"}},
+			Merged: []memory.Merge{{
+				From: []memory.Entry{{ID: "2", Content: "Artur has a cat"}, {ID: "3", Content: "Artur has a cat named Filemon"}},
+				Into: []memory.Entry{{ID: "4", Content: "Artur has a cat named Filemon"}},
+			}},
+		}, now)
+
+		assert.Len(t, embeds, 1)
+		assert.Equal(t, "Połączone: 1, sprzeczne: 0, wygasłe: 1", embeds[0].Description)
+		assert.Len(t, embeds[0].Fields, 2)
+	})
+
+	t.Run("splits a long changelog within limits of Discord", func(t *testing.T) {
+		var changelog memory.Changelog
+		for i := range 40 {
+			changelog.Merged = append(changelog.Merged, memory.Merge{
+				From: []memory.Entry{{ID: fmt.Sprintf("from-%d", i), Content: strings.Repeat("ą", 600)}},
+				Into: []memory.Entry{{ID: fmt.Sprintf("into-%d", i), Content: strings.Repeat("ę", 600)}},
+			})
+		}
+
+		embeds := scheduler.NewChangelogEmbeds(changelog, now)
+		assert.Greater(t, len(embeds), 1)
+
+		fields := 0
+		for _, embed := range embeds {
+			assert.LessOrEqual(t, embedLength(embed), 6000)
+			assert.LessOrEqual(t, len(embed.Fields), 25)
+			for _, field := range embed.Fields {
+				assert.LessOrEqual(t, utf8.RuneCountInString(field.Value), 1024)
+			}
+
+			fields += len(embed.Fields)
+		}
+		assert.Equal(t, 40, fields)
+	})
+}
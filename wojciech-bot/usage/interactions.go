@@ -0,0 +1,258 @@
+package usage
+
+import (
+	"context"
+	"fmt"
+	"github.com/bwmarrin/discordgo"
+	"lib/discord"
+	"lib/llm"
+	"lib/util"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"wojciech-bot/messages"
+)
+
+// maxFieldLines limits number of lines in a single embed field, so that it fits Discord limits
+const maxFieldLines = 10
+
+type Interactions struct {
+	bot     *discord.Bot
+	tracker *llm.UsageTracker
+	// schedulers are reported with the usage, so that it's visible if requests wait for the backends
+	schedulers []*llm.Scheduler
+	// caches are reported with the usage, so that it's visible how many prompts are not sent to the backends
+	caches []namedCache
+}
+
+type namedCache struct {
+	name  string
+	cache *llm.CachingAdapter
+}
+
+func NewInteractions(bot *discord.Bot, tracker *llm.UsageTracker, schedulers ...*llm.Scheduler) *Interactions {
+	return &Interactions{
+		bot:        bot,
+		tracker:    tracker,
+		schedulers: schedulers,
+	}
+}
+
+// WithCache reports hits and misses of the cache with the usage
+func (i *Interactions) WithCache(name string, cache *llm.CachingAdapter) {
+	i.caches = append(i.caches, namedCache{name: name, cache: cache})
+}
+
+// Report replies with LLM usage of today and the current month, queues of the backends, and hits of caches
+func (i *Interactions) Report(ctx context.Context, interaction *discordgo.Interaction) error {
+	now := time.Now()
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+
+	monthTotals, err := i.tracker.Totals(monthStart, now)
+	if err != nil {
+		return err
+	}
+
+	if len(monthTotals) == 0 {
+		i.bot.FollowupInteractionMessageAndForget(interaction, &discord.InteractionReply{
+			Content:   messages.Messages.Usage.NoUsage,
+			Ephemeral: true,
+		})
+
+		return nil
+	}
+
+	today := now.Format(time.DateOnly)
+	var todayTotals []llm.UsageTotals
+	for _, totals := range monthTotals {
+		if totals.Day == today {
+			todayTotals = append(todayTotals, totals)
+		}
+	}
+
+	embeds := []*discordgo.MessageEmbed{
+		newUsageEmbed(messages.Messages.Usage.TodayTitle, todayTotals),
+		newUsageEmbed(messages.Messages.Usage.MonthTitle, monthTotals),
+	}
+	if len(i.schedulers) > 0 {
+		embeds = append(embeds, newSchedulerEmbed(i.schedulers))
+	}
+	if len(i.caches) > 0 {
+		embeds = append(embeds, newCacheEmbed(i.caches))
+	}
+
+	i.bot.FollowupInteractionMessageAndForget(interaction, &discord.InteractionReply{
+		Ephemeral: true,
+		Embeds:    embeds,
+	})
+
+	return nil
+}
+
+// newSchedulerEmbed lists requests of every scheduler since start, by priority
+func newSchedulerEmbed(schedulers []*llm.Scheduler) *discordgo.MessageEmbed {
+	embed := &discordgo.MessageEmbed{Title: messages.Messages.Usage.QueuesTitle}
+	for _, scheduler := range schedulers {
+		stats := scheduler.Stats()
+
+		var lines []string
+		for _, priority := range []llm.Priority{llm.PriorityInteractive, llm.PriorityCommand, llm.PriorityBackground} {
+			priorityStats := stats.Priorities[priority]
+			if priorityStats.Requests == 0 && priorityStats.Timeouts == 0 {
+				continue
+			}
+
+			lines = append(lines, formatPriorityStats(priority, priorityStats))
+		}
+		if len(lines) == 0 {
+			lines = append(lines, "-")
+		}
+
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:  stats.Name,
+			Value: strings.Join(lines, "\n"),
+		})
+	}
+
+	return embed
+}
+
+// newCacheEmbed lists hits and misses of every cache since start
+func newCacheEmbed(caches []namedCache) *discordgo.MessageEmbed {
+	embed := &discordgo.MessageEmbed{Title: messages.Messages.Usage.CachesTitle}
+	for _, cache := range caches {
+		stats := cache.cache.Stats()
+
+		var hitRate float64
+		if requests := stats.Hits + stats.Misses; requests > 0 {
+			hitRate = float64(stats.Hits) / float64(requests)
+		}
+
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name: cache.name,
+			Value: util.ApplyTokens(messages.Messages.Usage.CacheStats, map[string]string{
+				"HITS":     strconv.FormatInt(stats.Hits, 10),
+				"MISSES":   strconv.FormatInt(stats.Misses, 10),
+				"HIT_RATE": fmt.Sprintf("%.0f", hitRate*100),
+			}),
+		})
+	}
+
+	return embed
+}
+
+func formatPriorityStats(priority llm.Priority, stats llm.PriorityStats) string {
+	var averageQueueTime time.Duration
+	if stats.Requests > 0 {
+		averageQueueTime = stats.QueueTime / time.Duration(stats.Requests)
+	}
+
+	return util.ApplyTokens(messages.Messages.Usage.PriorityStats, map[string]string{
+		"PRIORITY":           priority.String(),
+		"REQUESTS":           fmt.Sprint(stats.Requests),
+		"IN_FLIGHT":          fmt.Sprint(stats.InFlight),
+		"QUEUED":             fmt.Sprint(stats.Queued),
+		"AVERAGE_QUEUE_TIME": averageQueueTime.Round(time.Millisecond).String(),
+		"MAX_QUEUE_TIME":     stats.MaxQueueTime.Round(time.Millisecond).String(),
+		"TIMEOUTS":           fmt.Sprint(stats.Timeouts),
+	})
+}
+
+func newUsageEmbed(title string, totals []llm.UsageTotals) *discordgo.MessageEmbed {
+	var sum llm.UsageTotals
+	for _, t := range totals {
+		sum.Add(t)
+	}
+
+	return &discordgo.MessageEmbed{
+		Title:       title,
+		Description: formatTotals(messages.Messages.Usage.Total, sum),
+		Fields: []*discordgo.MessageEmbedField{
+			{
+				Name: messages.Messages.Usage.FeaturesField,
+				Value: formatGroups(totals, func(t llm.UsageTotals) string {
+					return string(t.Feature)
+				}),
+			},
+			{
+				Name: messages.Messages.Usage.ModelsField,
+				Value: formatGroups(totals, func(t llm.UsageTotals) string {
+					return t.Adapter
+				}),
+			},
+			{
+				Name: messages.Messages.Usage.UsersField,
+				Value: formatGroups(totals, func(t llm.UsageTotals) string {
+					return formatUser(t.UserID)
+				}),
+			},
+		},
+	}
+}
+
+// formatGroups sums totals grouped by the given key, and formats them from the most expensive
+func formatGroups(totals []llm.UsageTotals, key func(t llm.UsageTotals) string) string {
+	groups := make(map[string]*llm.UsageTotals)
+	for _, t := range totals {
+		group, ok := groups[key(t)]
+		if !ok {
+			group = &llm.UsageTotals{}
+			groups[key(t)] = group
+		}
+
+		group.Add(t)
+	}
+
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+
+	sort.Slice(names, func(a, b int) bool {
+		groupA, groupB := groups[names[a]], groups[names[b]]
+		if groupA.Cost != groupB.Cost {
+			return groupA.Cost > groupB.Cost
+		}
+
+		return groupA.InputTokens+groupA.OutputTokens > groupB.InputTokens+groupB.OutputTokens
+	})
+
+	if len(names) == 0 {
+		return "-"
+	}
+
+	if len(names) > maxFieldLines {
+		names = names[:maxFieldLines]
+	}
+
+	lines := make([]string, 0, len(names))
+	for _, name := range names {
+		lines = append(lines, formatTotals(name, *groups[name]))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func formatTotals(name string, totals llm.UsageTotals) string {
+	return util.ApplyTokens(messages.Messages.Usage.Totals, map[string]string{
+		"NAME":          name,
+		"INPUT_TOKENS":  fmt.Sprint(totals.InputTokens),
+		"OUTPUT_TOKENS": fmt.Sprint(totals.OutputTokens),
+		"COST":          fmt.Sprintf("%.4f", totals.Cost),
+		"REQUESTS":      fmt.Sprint(totals.Requests),
+	})
+}
+
+func formatUser(userID string) string {
+	if userID == "" {
+		return messages.Messages.Usage.NoUser
+	}
+
+	friend, ok := discord.GetFriend(userID)
+	if ok {
+		return friend.FirstName
+	}
+
+	return discord.Mention(userID)
+}
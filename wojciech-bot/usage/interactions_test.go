@@ -0,0 +1,89 @@
+package usage_test
+
+import (
+	"context"
+	"github.com/bwmarrin/discordgo"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"lib/discord"
+	"lib/llm"
+	"lib/storage"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"wojciech-bot/messages"
+	"wojciech-bot/usage"
+)
+
+// roundTripper records bodies of requests sent to Discord, and replies with an empty message
+type roundTripper struct {
+	mu     sync.Mutex
+	bodies []string
+}
+
+func (r *roundTripper) RoundTrip(request *http.Request) (*http.Response, error) {
+	var body []byte
+	if request.Body != nil {
+		body, _ = io.ReadAll(request.Body)
+	}
+
+	r.mu.Lock()
+	r.bodies = append(r.bodies, string(body))
+	r.mu.Unlock()
+
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(`{"id": "1"}`)),
+		Request:    request,
+	}, nil
+}
+
+func (r *roundTripper) Bodies() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.bodies
+}
+
+func TestInteractionsReport(t *testing.T) {
+	messages.Init()
+	ctx := context.Background()
+	db, err := storage.Open(filepath.Join(t.TempDir(), "test.db"))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	tracker, err := llm.NewUsageTracker(db, nil)
+	assert.NoError(t, err)
+	tracker.Record(llm.WithUsageContext(ctx, llm.FeatureChat, "1"), "openai", "", llm.TokenUsage{InputTokens: 10, OutputTokens: 5})
+
+	cache, err := llm.NewCachingAdapter(llm.NewScriptedAdapter(llm.ScriptedRule{Reply: "no"}), db, llm.CacheOptions{
+		Model:      "free",
+		TTL:        time.Hour,
+		MaxEntries: 10,
+	})
+	assert.NoError(t, err)
+	for range 2 {
+		_, _, err = cache.Prompt(ctx, llm.Prompt{Phrase: "is it a goodbye?"})
+		assert.NoError(t, err)
+	}
+
+	session, err := discordgo.New("Bot token")
+	assert.NoError(t, err)
+	transport := &roundTripper{}
+	session.Client = &http.Client{Transport: transport}
+
+	interactions := usage.NewInteractions(&discord.Bot{Session: session}, tracker)
+	interactions.WithCache("free", cache)
+
+	err = interactions.Report(ctx, &discordgo.Interaction{AppID: "app", Token: "token"})
+	assert.NoError(t, err)
+
+	bodies := transport.Bodies()
+	assert.Len(t, bodies, 1)
+	assert.Contains(t, bodies[0], "**openai**: 10 → 5 tokenów")
+	assert.Contains(t, bodies[0], "1 trafień, 1 chybień, 50% z pamięci")
+}